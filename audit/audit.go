@@ -0,0 +1,280 @@
+// package for the audit trail, GORM callbacks record every create, update and delete
+package audit
+
+import (
+	"daijai/models"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const beforeKey = "audit:before"
+
+// ignoredTables change too often to be worth a history, or keep their own.
+var ignoredTables = map[string]bool{
+	"audit_logs": true,
+	"sessions":   true,
+	"sluggers":   true,
+	"app_logs":   true,
+}
+
+// redactedColumns never reach the audit log.
+var redactedColumns = map[string]bool{
+	"password":            true,
+	"refresh_token_hash":  true,
+	"previous_token_hash": true,
+	"key_hash":            true,
+}
+
+// ignoredColumns alone do not make an update worth logging.
+var ignoredColumns = map[string]bool{
+	"updated_at":   true,
+	"last_used_at": true,
+	"last_used_ip": true,
+}
+
+// Register adds the audit callbacks to db. The actor is read from the statement context,
+// handlers pass the request with db.WithContext(c).
+func Register(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register("audit:after_create", afterCreate); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("audit:before_update", beforeChange); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("audit:after_update", afterUpdate); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("audit:before_delete", beforeChange); err != nil {
+		return err
+	}
+	return cb.Delete().After("gorm:delete").Register("audit:after_delete", afterDelete)
+}
+
+type row = map[string]interface{}
+
+func auditable(db *gorm.DB) bool {
+	stmt := db.Statement
+	return db.Error == nil &&
+		stmt.Schema != nil &&
+		stmt.Schema.PrioritizedPrimaryField != nil &&
+		!ignoredTables[stmt.Table]
+}
+
+// beforeChange keeps the rows an update or delete is about to change.
+func beforeChange(db *gorm.DB) {
+	if !auditable(db) {
+		return
+	}
+	query := session(db)
+	ids := modelIDs(db)
+	where, hasWhere := db.Statement.Clauses["WHERE"].Expression.(clause.Where)
+	if len(ids) == 0 && !hasWhere {
+		return
+	}
+	if len(ids) > 0 {
+		query = query.Where(clause.IN{Column: primaryColumn(db), Values: ids})
+	}
+	if hasWhere {
+		query = query.Clauses(clause.Where{Exprs: where.Exprs})
+	}
+	if !db.Statement.Unscoped && db.Statement.Schema.LookUpField("deleted_at") != nil {
+		query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "deleted_at"}, Value: nil})
+	}
+	var rows []row
+	if err := query.Find(&rows).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	db.InstanceSet(beforeKey, rows)
+}
+
+func afterCreate(db *gorm.DB) {
+	if !auditable(db) || db.RowsAffected == 0 {
+		return
+	}
+	ids := modelIDs(db)
+	if len(ids) == 0 {
+		return
+	}
+	after, err := rowsByID(db, ids)
+	if err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	var logs []models.AuditLog
+	for id, r := range after {
+		logs = append(logs, entry(db, models.AuditAction_Create, id, nil, r, nil))
+	}
+	write(db, logs)
+}
+
+func afterUpdate(db *gorm.DB) {
+	before, ok := savedRows(db)
+	if !ok {
+		return
+	}
+	ids := make([]interface{}, 0, len(before))
+	for _, r := range before {
+		ids = append(ids, r[db.Statement.Schema.PrioritizedPrimaryField.DBName])
+	}
+	after, err := rowsByID(db, ids)
+	if err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	var logs []models.AuditLog
+	for _, b := range before {
+		id := rowID(db, b)
+		a, ok := after[id]
+		if !ok {
+			continue
+		}
+		changes := diff(b, a)
+		if len(changes) == 0 {
+			continue
+		}
+		logs = append(logs, entry(db, models.AuditAction_Update, id, b, a, changes))
+	}
+	write(db, logs)
+}
+
+func afterDelete(db *gorm.DB) {
+	before, ok := savedRows(db)
+	if !ok {
+		return
+	}
+	var logs []models.AuditLog
+	for _, b := range before {
+		logs = append(logs, entry(db, models.AuditAction_Delete, rowID(db, b), b, nil, nil))
+	}
+	write(db, logs)
+}
+
+func savedRows(db *gorm.DB) ([]row, bool) {
+	if !auditable(db) || db.RowsAffected == 0 {
+		return nil, false
+	}
+	v, ok := db.InstanceGet(beforeKey)
+	if !ok {
+		return nil, false
+	}
+	rows, ok := v.([]row)
+	return rows, ok && len(rows) > 0
+}
+
+// session is a new query on the statement's table, in the statement's transaction. It has
+// no model so rows keep the values stored in the database, serialized columns included.
+func session(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).Table(db.Statement.Table)
+}
+
+func primaryColumn(db *gorm.DB) clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: db.Statement.Schema.PrioritizedPrimaryField.DBName}
+}
+
+func rowsByID(db *gorm.DB, ids []interface{}) (map[uint]row, error) {
+	var rows []row
+	if err := session(db).
+		Where(clause.IN{Column: primaryColumn(db), Values: ids}).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]row, len(rows))
+	for _, r := range rows {
+		byID[rowID(db, r)] = r
+	}
+	return byID, nil
+}
+
+// modelIDs returns the primary keys of the statement's model values that have one.
+func modelIDs(db *gorm.DB) []interface{} {
+	stmt := db.Statement
+	field := stmt.Schema.PrioritizedPrimaryField
+	var ids []interface{}
+	value := reflect.Indirect(stmt.ReflectValue)
+	switch value.Kind() {
+	case reflect.Struct:
+		if id, zero := field.ValueOf(stmt.Context, value); !zero {
+			ids = append(ids, id)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if id, zero := field.ValueOf(stmt.Context, reflect.Indirect(value.Index(i))); !zero {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func rowID(db *gorm.DB, r row) uint {
+	id, _ := strconv.ParseUint(fmt.Sprint(r[db.Statement.Schema.PrioritizedPrimaryField.DBName]), 10, 64)
+	return uint(id)
+}
+
+// diff returns the columns whose value changed, without the ignored ones.
+func diff(before, after row) map[string]models.AuditChange {
+	changes := make(map[string]models.AuditChange)
+	for column, to := range after {
+		from := before[column]
+		if ignoredColumns[column] || reflect.DeepEqual(from, to) {
+			continue
+		}
+		if redactedColumns[column] {
+			changes[column] = models.AuditChange{From: "[redacted]", To: "[redacted]"}
+			continue
+		}
+		changes[column] = models.AuditChange{From: from, To: to}
+	}
+	return changes
+}
+
+func redact(r row) row {
+	if r == nil {
+		return nil
+	}
+	for column := range r {
+		if redactedColumns[column] {
+			r[column] = "[redacted]"
+		}
+	}
+	return r
+}
+
+// entry is an audit log of the statement, the actor comes from the request in its context.
+func entry(db *gorm.DB, action string, id uint, before, after row, changes map[string]models.AuditChange) models.AuditLog {
+	log := models.AuditLog{
+		Entity:   db.Statement.Table,
+		EntityID: id,
+		Action:   action,
+		Before:   redact(before),
+		After:    redact(after),
+		Changes:  changes,
+	}
+	if c, ok := db.Statement.Context.(*gin.Context); ok {
+		if uid := c.GetUint("uid"); uid != 0 {
+			log.ActorID = &uid
+		}
+		if apiKeyID := c.GetUint("apiKeyID"); apiKeyID != 0 {
+			log.APIKeyID = &apiKeyID
+		}
+		log.IP = c.ClientIP()
+		log.Route = c.Request.Method + " " + c.FullPath()
+	}
+	return log
+}
+
+func write(db *gorm.DB, logs []models.AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	if err := db.Session(&gorm.Session{NewDB: true}).Create(&logs).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+	}
+}
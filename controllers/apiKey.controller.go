@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"daijai/middlewares"
+	"daijai/models"
+	"daijai/token"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type APIKeyController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewAPIKeyController(db *gorm.DB) *APIKeyController {
+	return &APIKeyController{
+		DB: db,
+	}
+}
+
+type apiKeyRequest struct {
+	Name      string                   `json:"Name" binding:"required"`
+	UserID    uint                     `json:"UserID"`
+	Scopes    []middlewares.Permission `json:"Scopes"`
+	ExpiresAt *time.Time               `json:"ExpiresAt"`
+}
+
+// get the API keys, of one user with ?userId
+func (kc *APIKeyController) GetAPIKeys(c *gin.Context) {
+	query := kc.DB.WithContext(c).Preload("User").Preload("CreatedBy").Preload("RevokedBy").Preload("Scopes")
+	if userID := c.Query("userId"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	var keys []models.APIKey
+	if err := query.Order("id desc").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func (kc *APIKeyController) GetAPIKey(c *gin.Context) {
+	var key models.APIKey
+	if err := kc.DB.WithContext(c).
+		Preload("User").
+		Preload("CreatedBy").
+		Preload("RevokedBy").
+		Preload("Scopes").
+		First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// CreateAPIKey issues a key acting as a user, the key itself is only in this response.
+func (kc *APIKeyController) CreateAPIKey(c *gin.Context) {
+	var request apiKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var uid uint
+	if err := kc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := kc.DB.WithContext(c).First(&user, request.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+		return
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ExpiresAt must be in the future"})
+		return
+	}
+	plain, prefix, hash, err := token.NewAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	key := models.APIKey{
+		Name:        request.Name,
+		Prefix:      prefix,
+		KeyHash:     hash,
+		UserID:      user.ID,
+		CreatedByID: uid,
+		ExpiresAt:   request.ExpiresAt,
+	}
+	allowed := middlewares.EffectivePermissions(user.Role)
+	if err := kc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&key).Error; err != nil {
+			return err
+		}
+		return setAPIKeyScopes(tx, &key, user.Role, allowed, request.Scopes)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create API key", "detail": err.Error()})
+		return
+	}
+
+	kc.DB.WithContext(c).Preload("User").Preload("CreatedBy").Preload("Scopes").First(&key, key.ID)
+	c.JSON(http.StatusCreated, gin.H{"key": plain, "apiKey": key})
+}
+
+// UpdateAPIKey replaces the name, scopes and expiry of a key that is not revoked.
+func (kc *APIKeyController) UpdateAPIKey(c *gin.Context) {
+	var request apiKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var key models.APIKey
+	if err := kc.DB.WithContext(c).Preload("User").First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if key.RevokedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API key is revoked"})
+		return
+	}
+
+	allowed := middlewares.EffectivePermissions(key.User.Role)
+	if err := kc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&key).Updates(map[string]interface{}{
+			"name":       request.Name,
+			"expires_at": request.ExpiresAt,
+		}).Error; err != nil {
+			return err
+		}
+		return setAPIKeyScopes(tx, &key, key.User.Role, allowed, request.Scopes)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update API key", "detail": err.Error()})
+		return
+	}
+
+	kc.DB.WithContext(c).Preload("User").Preload("CreatedBy").Preload("Scopes").First(&key, key.ID)
+	c.JSON(http.StatusOK, key)
+}
+
+// RevokeAPIKey stops a key from working, the row stays for its history.
+func (kc *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	var uid uint
+	if err := kc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var key models.APIKey
+	if err := kc.DB.WithContext(c).First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if key.RevokedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API key is already revoked"})
+		return
+	}
+	if err := kc.DB.WithContext(c).Model(&key).Updates(map[string]interface{}{
+		"revoked_at":    time.Now(),
+		"revoked_by_id": uid,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// setAPIKeyScopes replaces the scopes of a key, each one must be in the permissions of the
+// user's role. The permissions are loaded by the caller, before its transaction, as loading
+// them reads the roles outside of it.
+func setAPIKeyScopes(tx *gorm.DB, key *models.APIKey, role string, permissions []middlewares.Permission, scopes []middlewares.Permission) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("an API key needs at least one scope")
+	}
+	allowed := make(map[middlewares.Permission]bool)
+	for _, p := range permissions {
+		allowed[p] = true
+	}
+	seen := make(map[middlewares.Permission]bool)
+	for _, p := range scopes {
+		if !middlewares.IsKnownPermission(p) {
+			return fmt.Errorf("unknown permission %s/%s", p.Resource, p.Action)
+		}
+		// password and session routes belong to a signed in user
+		if p.Resource == middlewares.Resource_Session {
+			return fmt.Errorf("permission %s/%s cannot be given to an API key", p.Resource, p.Action)
+		}
+		if !allowed[p] {
+			return fmt.Errorf("role %s is not allowed %s/%s", role, p.Resource, p.Action)
+		}
+		if seen[p] {
+			return fmt.Errorf("permission %s/%s is given twice", p.Resource, p.Action)
+		}
+		seen[p] = true
+	}
+
+	if err := tx.Unscoped().Where("api_key_id = ?", key.ID).Delete(&models.APIKeyScope{}).Error; err != nil {
+		return err
+	}
+	for p := range seen {
+		if err := tx.Create(&models.APIKeyScope{APIKeyID: key.ID, Resource: p.Resource, Action: p.Action}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,607 @@
+package controllers
+
+import (
+	"daijai/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ApprovalController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewApprovalController(db *gorm.DB) *ApprovalController {
+	return &ApprovalController{
+		DB: db,
+	}
+}
+
+// approvalDocument describes a document for rule matching.
+type approvalDocument struct {
+	Type        string
+	ID          uint
+	Amount      int64 // document total, as printed on the document
+	CategoryIDs []uint
+	ProjectID   *uint
+	RequestedBy *uint
+}
+
+// approvalStep is the step a member is about to approve.
+type approvalStep struct {
+	Request *models.ApprovalRequest
+	Step    models.ApprovalRuleStep
+	IsFinal bool
+}
+
+var errApprovalForbidden = errors.New("you are not an approver of this step")
+
+// errApprovalConflict is returned when another approval moved the request first.
+var errApprovalConflict = errors.New("the approval request was changed, reload the document and try again")
+
+// defaultApprovalStep applies when no rule matches a document, admins and managers approve in one step.
+var defaultApprovalStep = models.ApprovalRuleStep{Sequence: 1, Name: "default"}
+
+// newApprovalRequest starts the approval request of a document at its first step, with the
+// chain of the rule that matches it.
+func newApprovalRequest(db *gorm.DB, doc approvalDocument) (*models.ApprovalRequest, error) {
+	request := models.ApprovalRequest{
+		DocumentType:  doc.Type,
+		DocumentID:    doc.ID,
+		Status:        models.ApprovalStatus_Pending,
+		RequestedByID: doc.RequestedBy,
+	}
+	if err := matchApprovalRequest(db, doc, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// matchApprovalRequest gives a request the amount of the document and the chain of its rule.
+func matchApprovalRequest(db *gorm.DB, doc approvalDocument, request *models.ApprovalRequest) error {
+	rule, err := matchApprovalRule(db, doc)
+	if err != nil {
+		return err
+	}
+	request.Amount = doc.Amount
+	request.ApprovalRuleID = nil
+	request.StepCount = 1
+	if rule != nil {
+		request.ApprovalRuleID = &rule.ID
+		request.StepCount = len(rule.Steps)
+	}
+	return nil
+}
+
+// openApprovalRequest stores the pending approval request of a new document, in the
+// transaction that creates it, so the document waits in the inbox of its first approver
+// and can be rejected before anyone approved it.
+func openApprovalRequest(tx *gorm.DB, doc approvalDocument) error {
+	request, err := newApprovalRequest(tx, doc)
+	if err != nil {
+		return err
+	}
+	return tx.Create(request).Error
+}
+
+// dropApprovalRequests deletes the pending approval requests of deleted documents.
+func dropApprovalRequests(tx *gorm.DB, documentType string, documentIDs ...uint) error {
+	if len(documentIDs) == 0 {
+		return nil
+	}
+	return tx.
+		Where("document_type = ? AND document_id IN ? AND status = ?", documentType, documentIDs, models.ApprovalStatus_Pending).
+		Delete(&models.ApprovalRequest{}).Error
+}
+
+// nextApproval finds the step the member may approve now. Until the first step is approved
+// the rule is matched again, documents may change while they wait. Documents created before
+// requests were opened with them get a new request, recordApproval stores it.
+func nextApproval(db *gorm.DB, doc approvalDocument, member *models.Member) (*approvalStep, error) {
+	var request models.ApprovalRequest
+	err := db.
+		Where("document_type = ? AND document_id = ? AND status = ?", doc.Type, doc.ID, models.ApprovalStatus_Pending).
+		First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		created, err := newApprovalRequest(db, doc)
+		if err != nil {
+			return nil, err
+		}
+		request = *created
+	} else if err != nil {
+		return nil, err
+	} else if request.CurrentStep == 0 {
+		if err := matchApprovalRequest(db, doc, &request); err != nil {
+			return nil, err
+		}
+	}
+
+	steps, err := approvalSteps(db, &request)
+	if err != nil {
+		return nil, err
+	}
+	if request.CurrentStep >= len(steps) {
+		return nil, errors.New("approval request has no step left")
+	}
+	step := steps[request.CurrentStep]
+	if !canApproveStep(&step, member) {
+		return nil, errApprovalForbidden
+	}
+
+	// one person approves one step of a chain
+	if request.ID != 0 {
+		var count int64
+		if err := db.
+			Model(&models.ApprovalHistory{}).
+			Where("approval_request_id = ? AND actor_id = ? AND action = ?", request.ID, member.ID, models.ApprovalAction_Approved).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errors.New("you already approved a step of this document")
+		}
+	}
+
+	return &approvalStep{
+		Request: &request,
+		Step:    step,
+		IsFinal: request.CurrentStep == len(steps)-1,
+	}, nil
+}
+
+// recordApproval writes the approval of a step and moves the request to the next one, in the
+// transaction of the document. The request only moves from the step it was read at, so of two
+// approvals of the same step one fails with errApprovalConflict.
+func recordApproval(tx *gorm.DB, step *approvalStep, member *models.Member, comment string) error {
+	if step.Request.ID == 0 {
+		if err := tx.Create(step.Request).Error; err != nil {
+			return err
+		}
+	}
+	history := models.ApprovalHistory{
+		ApprovalRequestID: step.Request.ID,
+		Step:              step.Request.CurrentStep + 1,
+		StepName:          step.Step.Name,
+		Action:            models.ApprovalAction_Approved,
+		Comment:           comment,
+		ActorID:           member.ID,
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		return err
+	}
+	status := step.Request.Status
+	if step.IsFinal {
+		status = models.ApprovalStatus_Approved
+	}
+	result := tx.
+		Model(&models.ApprovalRequest{}).
+		Where("id = ? AND current_step = ? AND status = ?", step.Request.ID, step.Request.CurrentStep, models.ApprovalStatus_Pending).
+		Updates(map[string]interface{}{
+			"current_step":     step.Request.CurrentStep + 1,
+			"status":           status,
+			"amount":           step.Request.Amount,
+			"approval_rule_id": step.Request.ApprovalRuleID,
+			"step_count":       step.Request.StepCount,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errApprovalConflict
+	}
+	step.Request.CurrentStep++
+	step.Request.Status = status
+	return nil
+}
+
+// approveOrRespond runs the approval chain in a handler. It returns the step when the member
+// gives the final approval and the document may be approved; otherwise it records the step
+// or the error, responds and returns nil.
+func approveOrRespond(c *gin.Context, db *gorm.DB, doc approvalDocument, member *models.Member) *approvalStep {
+	step, err := nextApproval(db, doc, member)
+	if errors.Is(err, errApprovalForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil
+	}
+	if step.IsFinal {
+		return step
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return recordApproval(tx, step, member, c.Query("comment"))
+	}); errors.Is(err, errApprovalConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return nil
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record approval"})
+		return nil
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":         fmt.Sprintf("Step %d of %d approved, waiting for the next approver", step.Request.CurrentStep, step.Request.StepCount),
+		"approvalRequest": step.Request,
+	})
+	return nil
+}
+
+func matchApprovalRule(db *gorm.DB, doc approvalDocument) (*models.ApprovalRule, error) {
+	var rules []models.ApprovalRule
+	if err := db.
+		Preload("Steps").
+		Where("document_type = ? AND is_disabled = ?", doc.Type, false).
+		Order("priority desc, id asc").
+		Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if len(rule.Steps) == 0 {
+			continue
+		}
+		if rule.MinAmount != nil && doc.Amount < *rule.MinAmount {
+			continue
+		}
+		if rule.MaxAmount != nil && doc.Amount >= *rule.MaxAmount {
+			continue
+		}
+		if rule.ProjectID != nil && (doc.ProjectID == nil || *doc.ProjectID != *rule.ProjectID) {
+			continue
+		}
+		if rule.CategoryID != nil && !containsUint(doc.CategoryIDs, *rule.CategoryID) {
+			continue
+		}
+		return rule, nil
+	}
+	return nil, nil
+}
+
+// approvalSteps returns the ordered steps of a request, the default step when it has no rule.
+func approvalSteps(db *gorm.DB, request *models.ApprovalRequest) ([]models.ApprovalRuleStep, error) {
+	if request.ApprovalRuleID == nil {
+		return []models.ApprovalRuleStep{defaultApprovalStep}, nil
+	}
+	var steps []models.ApprovalRuleStep
+	if err := db.
+		Where("approval_rule_id = ?", *request.ApprovalRuleID).
+		Order("sequence asc, id asc").
+		Find(&steps).Error; err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+func canApproveStep(step *models.ApprovalRuleStep, member *models.Member) bool {
+	if step.ApproverID != nil && *step.ApproverID != member.ID {
+		return false
+	}
+	if step.Role != "" {
+		return member.Role == step.Role
+	}
+	if step.ApproverID != nil {
+		return true
+	}
+	return member.Role == models.ROLE_Admin || member.Role == models.ROLE_Manager
+}
+
+func containsUint(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// get approval rules, ?documentType=
+func (ac *ApprovalController) GetRules(c *gin.Context) {
+	var rules []models.ApprovalRule
+	q := ac.DB.WithContext(c).
+		Preload("Steps", func(db *gorm.DB) *gorm.DB {
+			return db.Order("sequence asc")
+		}).
+		Preload("Steps.Approver").
+		Preload("Category").
+		Preload("Project").
+		Order("document_type asc, priority desc")
+	if documentType := c.Query("documentType"); documentType != "" {
+		q = q.Where("document_type = ?", documentType)
+	}
+	if err := q.Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get approval rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func (ac *ApprovalController) CreateRule(c *gin.Context) {
+	var rule models.ApprovalRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateApprovalRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ac.DB.WithContext(c).Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create approval rule"})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateRule replaces a rule and its steps, refused while requests of the rule are pending.
+func (ac *ApprovalController) UpdateRule(c *gin.Context) {
+	var rule models.ApprovalRule
+	if err := ac.DB.WithContext(c).First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval rule not found"})
+		return
+	}
+	var request models.ApprovalRule
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateApprovalRule(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ac.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var openCount int64
+		if err := tx.
+			Model(&models.ApprovalRequest{}).
+			Where("approval_rule_id = ? AND status = ?", rule.ID, models.ApprovalStatus_Pending).
+			Count(&openCount).Error; err != nil {
+			return err
+		}
+		if openCount > 0 {
+			return errors.New("rule has pending approval requests")
+		}
+
+		if err := tx.Where("approval_rule_id = ?", rule.ID).Delete(&models.ApprovalRuleStep{}).Error; err != nil {
+			return err
+		}
+		rule.Name = request.Name
+		rule.DocumentType = request.DocumentType
+		rule.Priority = request.Priority
+		rule.MinAmount = request.MinAmount
+		rule.MaxAmount = request.MaxAmount
+		rule.CategoryID = request.CategoryID
+		rule.ProjectID = request.ProjectID
+		rule.IsDisabled = request.IsDisabled
+		if err := tx.Save(&rule).Error; err != nil {
+			return err
+		}
+		for _, step := range request.Steps {
+			step.ID = 0
+			step.ApprovalRuleID = rule.ID
+			if err := tx.Create(&step).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update approval rule", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Approval rule updated successfully"})
+}
+
+func (ac *ApprovalController) DeleteRule(c *gin.Context) {
+	var rule models.ApprovalRule
+	if err := ac.DB.WithContext(c).First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval rule not found"})
+		return
+	}
+	var openCount int64
+	if err := ac.DB.WithContext(c).
+		Model(&models.ApprovalRequest{}).
+		Where("approval_rule_id = ? AND status = ?", rule.ID, models.ApprovalStatus_Pending).
+		Count(&openCount).Error; err != nil || openCount > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rule has pending approval requests, disable it instead"})
+		return
+	}
+	if err := ac.DB.WithContext(c).Delete(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete approval rule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Approval rule deleted successfully"})
+}
+
+// get approval requests and their history of a document
+func (ac *ApprovalController) GetDocumentApprovals(c *gin.Context) {
+	var requests []models.ApprovalRequest
+	if err := ac.DB.WithContext(c).
+		Preload("ApprovalRule.Steps", func(db *gorm.DB) *gorm.DB {
+			return db.Order("sequence asc")
+		}).
+		Preload("RequestedBy").
+		Preload("History.Actor").
+		Where("document_type = ? AND document_id = ?", c.Param("type"), c.Param("id")).
+		Order("id desc").
+		Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get approvals"})
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// get pending approval requests whose current step the user may approve
+func (ac *ApprovalController) GetMyPendingApprovals(c *gin.Context) {
+	var uid uint
+	if err := ac.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := ac.getUserDataByUserID(ac.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var requests []models.ApprovalRequest
+	if err := ac.DB.WithContext(c).
+		Preload("RequestedBy").
+		Preload("History.Actor").
+		Where("status = ?", models.ApprovalStatus_Pending).
+		Order("id asc").
+		Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get approvals"})
+		return
+	}
+	pending := []models.ApprovalRequest{}
+	for i := range requests {
+		steps, err := approvalSteps(ac.DB.WithContext(c), &requests[i])
+		if err != nil || requests[i].CurrentStep >= len(steps) {
+			continue
+		}
+		if canApproveStep(&steps[requests[i].CurrentStep], &member) {
+			pending = append(pending, requests[i])
+		}
+	}
+	c.JSON(http.StatusOK, pending)
+}
+
+// reject the pending approval of a document, a rejected document can not be approved anymore
+func (ac *ApprovalController) RejectDocument(c *gin.Context) {
+	var uid uint
+	if err := ac.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := ac.getUserDataByUserID(ac.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var body struct {
+		Comment string `json:"Comment" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request models.ApprovalRequest
+	if err := ac.DB.WithContext(c).
+		Where("document_type = ? AND document_id = ? AND status = ?", c.Param("type"), c.Param("id"), models.ApprovalStatus_Pending).
+		First(&request).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending approval for document"})
+		return
+	}
+	steps, err := approvalSteps(ac.DB.WithContext(c), &request)
+	if err != nil || request.CurrentStep >= len(steps) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get approval steps"})
+		return
+	}
+	step := steps[request.CurrentStep]
+	if !canApproveStep(&step, &member) {
+		c.JSON(http.StatusForbidden, gin.H{"error": errApprovalForbidden.Error()})
+		return
+	}
+
+	if err := ac.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		history := models.ApprovalHistory{
+			ApprovalRequestID: request.ID,
+			Step:              request.CurrentStep + 1,
+			StepName:          step.Name,
+			Action:            models.ApprovalAction_Rejected,
+			Comment:           body.Comment,
+			ActorID:           member.ID,
+		}
+		if err := tx.Create(&history).Error; err != nil {
+			return err
+		}
+		result := tx.
+			Model(&models.ApprovalRequest{}).
+			Where("id = ? AND current_step = ? AND status = ?", request.ID, request.CurrentStep, models.ApprovalStatus_Pending).
+			Update("status", models.ApprovalStatus_Rejected)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errApprovalConflict
+		}
+		return rejectApprovalDocument(tx, request.DocumentType, request.DocumentID)
+	}); errors.Is(err, errApprovalConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject document", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Document rejected"})
+}
+
+// rejectApprovalDocument marks a rejected document, its approve handler refuses it afterwards.
+func rejectApprovalDocument(tx *gorm.DB, documentType string, documentID uint) error {
+	switch documentType {
+	case models.ApprovalDocumentType_Purchase:
+		return tx.
+			Model(&models.Purchase{}).
+			Where("id = ? AND is_approve = ?", documentID, false).
+			Update("is_rejected", true).Error
+	case models.ApprovalDocumentType_Receipt:
+		return tx.
+			Model(&models.Receipt{}).
+			Where("id = ? AND is_approved = ?", documentID, false).
+			Update("is_rejected", true).Error
+	case models.ApprovalDocumentType_Adjustment:
+		return tx.Model(&models.Adjustment{}).Where("id = ?", documentID).Update("status", models.DocumentStatus_Rejected).Error
+	case models.ApprovalDocumentType_TransferOrder:
+		return tx.
+			Model(&models.TransferOrder{}).
+			Where("id = ? AND status = ?", documentID, models.TransferOrderStatus_Pending).
+			Update("status", models.TransferOrderStatus_Rejected).Error
+	case models.ApprovalDocumentType_Withdrawal:
+		if err := tx.
+			Model(&models.MaterialSerial{}).
+			Where("withdrawal_approvement_id = ?", documentID).
+			Update("withdrawal_approvement_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.
+			Model(&models.WithdrawalApprovement{}).
+			Where("id = ? AND withdrawal_approvement_status = ?", documentID, models.WithdrawalApprovementStatus_Pending).
+			Update("withdrawal_approvement_status", models.WithdrawalApprovementStatus_Rejected).Error
+	}
+	return fmt.Errorf("unknown document type %q", documentType)
+}
+
+func validateApprovalRule(rule *models.ApprovalRule) error {
+	switch rule.DocumentType {
+	case models.ApprovalDocumentType_Purchase,
+		models.ApprovalDocumentType_Receipt,
+		models.ApprovalDocumentType_Withdrawal,
+		models.ApprovalDocumentType_Adjustment,
+		models.ApprovalDocumentType_TransferOrder:
+	default:
+		return fmt.Errorf("unknown document type %q", rule.DocumentType)
+	}
+	if len(rule.Steps) == 0 {
+		return errors.New("rule needs at least one step")
+	}
+	if rule.MinAmount != nil && rule.MaxAmount != nil && *rule.MinAmount >= *rule.MaxAmount {
+		return errors.New("min amount must be below max amount")
+	}
+	for i := range rule.Steps {
+		if rule.Steps[i].Role == "" && rule.Steps[i].ApproverID == nil {
+			return fmt.Errorf("step %d needs a role or an approver", i+1)
+		}
+	}
+	sort.SliceStable(rule.Steps, func(i, j int) bool {
+		return rule.Steps[i].Sequence < rule.Steps[j].Sequence
+	})
+	for i := range rule.Steps {
+		rule.Steps[i].Sequence = i + 1
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"daijai/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AuditController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewAuditController(db *gorm.DB) *AuditController {
+	return &AuditController{
+		DB: db,
+	}
+}
+
+// GetEntityAudit returns the history of a row, newest first. The entity is its table name,
+// e.g. /audit/withdrawals/12.
+func (ac *AuditController) GetEntityAudit(c *gin.Context) {
+	var logs []models.AuditLog
+	if err := ac.
+		DB.
+		WithContext(c).
+		Preload("Actor").
+		Where("entity = ? AND entity_id = ?", c.Param("entity"), c.Param("id")).
+		Order("id desc").
+		Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit logs"})
+		return
+	}
+	c.JSON(http.StatusOK, logs)
+}
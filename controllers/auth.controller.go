@@ -1,10 +1,18 @@
 package controllers
 
 import (
+	"daijai/identity"
+	"daijai/middlewares"
 	"daijai/models"
 	"daijai/token"
+	"daijai/utils/password"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -31,7 +39,7 @@ func (uc *AuthController) Session(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := uc.getUserDataByUserID(uc.DB, uid, &member); err != nil {
+	if err := uc.getUserDataByUserID(uc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		log.Printf("🔥Session-getUserDataByUserID: %+v\n", err)
 		return
@@ -40,6 +48,12 @@ func (uc *AuthController) Session(c *gin.Context) {
 	c.JSON(http.StatusCreated, member)
 }
 
+// get the permissions of the signed in user's role
+func (uc *AuthController) GetPermissions(c *gin.Context) {
+	role := c.GetString("role")
+	c.JSON(http.StatusOK, gin.H{"role": role, "permissions": middlewares.EffectivePermissions(role)})
+}
+
 // Register creates a new user.
 func (uc *AuthController) Register(c *gin.Context) {
 	var user models.User
@@ -48,19 +62,32 @@ func (uc *AuthController) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := checkRoleExists(uc.DB.WithContext(c), user.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := password.Validate(user.Password, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
+	now := time.Now()
 	user.Password = string(hashedPassword)
-	if err := uc.DB.Create(&user).Error; err != nil {
+	user.PasswordChangedAt = &now
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	user.MustChangePassword = false
+	if err := uc.DB.WithContext(c).Create(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully", "user": user})
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully", "user": user.UserToMember()})
 }
 
 // Login authenticates a user and generates a JWT token.
@@ -75,38 +102,328 @@ func (uc *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	if err := uc.DB.Where("username = ?", loginData.Username).First(&user).Error; err != nil {
-		log.Println(err)
+	id, err := identity.Authenticate(c.Request.Context(), identity.PasswordProviders(uc.DB.WithContext(c)), loginData.Username, loginData.Password)
+	var locked *identity.LockedError
+	switch {
+	case errors.As(err, &locked):
+		c.JSON(http.StatusLocked, gin.H{"error": "Account is locked after too many failed logins", "lockedUntil": locked.Until})
+		return
+	case errors.Is(err, identity.ErrUnknownUser):
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
+	case errors.Is(err, identity.ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	case err != nil:
+		log.Println(err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	user := id.User
+	if user == nil {
+		if user, err = uc.provisionUser(id); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tokens, err := startSession(uc.DB.WithContext(c), c, user)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error creating token"})
+		return
+	}
+	tokens["mustChangePassword"] = user.MustChangePassword
+	c.JSON(http.StatusOK, tokens)
+}
+
+// GetProviders lists the enabled identity providers for the login page.
+func (uc *AuthController) GetProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": identity.Names(uc.DB.WithContext(c))})
+}
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcNonceCookie = "oidc_nonce"
+)
+
+// OIDCLogin redirects to the identity provider, the state and nonce wait in short-lived cookies.
+func (uc *AuthController) OIDCLogin(c *gin.Context) {
+	provider := identity.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginData.Password)); err != nil {
+	state, _, err := token.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nonce, _, err := token.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	url, err := provider.AuthCodeURL(c.Request.Context(), state, nonce)
+	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
 		return
 	}
+	secure := c.Request.TLS != nil
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/", "", secure, true)
+	c.SetCookie(oidcNonceCookie, nonce, 600, "/", "", secure, true)
+	c.Redirect(http.StatusFound, url)
+}
 
-	tokenString, err := token.GenerateToken(user)
+// OIDCCallback finishes the authorization-code flow and starts a session. With
+// OIDC_FRONTEND_URL set the tokens go back to the frontend in the URL fragment.
+func (uc *AuthController) OIDCCallback(c *gin.Context) {
+	provider := identity.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": e, "description": c.Query("error_description")})
+		return
+	}
+	state, err := c.Cookie(oidcStateCookie)
+	if err != nil || state == "" || state != c.Query("state") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid OIDC state"})
+		return
+	}
+	nonce, err := c.Cookie(oidcNonceCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid OIDC state"})
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.SetCookie(oidcNonceCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+
+	id, err := provider.Exchange(c.Request.Context(), c.Query("code"), nonce)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "OIDC login failed"})
+		return
+	}
+	user, err := uc.provisionUser(id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := startSession(uc.DB.WithContext(c), c, user)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error creating token"})
 		return
 	}
+	if frontend := os.Getenv("OIDC_FRONTEND_URL"); frontend != "" {
+		fragment := url.Values{}
+		for key, value := range tokens {
+			fragment.Set(key, fmt.Sprint(value))
+		}
+		c.Redirect(http.StatusFound, frontend+"#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
 
-	// tk := models.Tokens{
-	// 	AccessToken: tokenString,
-	// }
-	// data := models.ResponseToken{
-	// 	Tokens: models.Tokens{
-	// 	},
-	// }
+// provisionUser creates or updates the account of an external identity on each login, the
+// role follows the groups. A username taken by an account of another provider is refused.
+func (uc *AuthController) provisionUser(id *identity.Identity) (*models.User, error) {
+	role, err := identity.RoleForGroups(id.Groups)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRoleExists(uc.DB, role); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("username = ?", id.Username).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			user = models.User{
+				Username:     id.Username,
+				FullName:     id.FullName,
+				Role:         role,
+				AuthProvider: id.Provider,
+			}
+			if err := uc.RequestSlug(&user.Slug, tx, "users"); err != nil {
+				return err
+			}
+			return tx.Create(&user).Error
+		}
+		if err != nil {
+			return err
+		}
+		if user.AuthProvider != id.Provider {
+			return fmt.Errorf("username %s belongs to another account", id.Username)
+		}
+		previousRole := user.Role
+		user.FullName = id.FullName
+		user.Role = role
+		if err := tx.Model(&user).Updates(map[string]interface{}{"full_name": user.FullName, "role": user.Role}).Error; err != nil {
+			return err
+		}
+		// access tokens carry the role
+		if previousRole != role {
+			return revokeUserSessions(tx, user.ID, models.SessionRevokedReason_UserChanged)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangePassword sets a new password of the signed in user, who must give the current one.
+// Other sessions of the user are signed out, the current one gets a new access token.
+func (uc *AuthController) ChangePassword(c *gin.Context) {
+	var request struct {
+		OldPassword string `json:"oldPassword" binding:"required"`
+		NewPassword string `json:"newPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var uid uint
+	if err := uc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := uc.DB.WithContext(c).First(&user, uid).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.IsLocal() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The password is managed by the identity provider"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.OldPassword)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is wrong"})
+		return
+	}
+	if request.NewPassword == request.OldPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current one"})
+		return
+	}
+	if err := password.Validate(request.NewPassword, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	sid := c.GetUint("sid")
+	if err := uc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		user.Password = string(hashedPassword)
+		user.MustChangePassword = false
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"password":             user.Password,
+			"must_change_password": false,
+			"password_changed_at":  time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.
+			Model(&models.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user.ID, sid).
+			Updates(map[string]interface{}{
+				"revoked_at":     time.Now(),
+				"revoked_reason": models.SessionRevokedReason_UserChanged,
+			}).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	accessToken, err := token.GenerateToken(user, sid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully", "token": accessToken})
+}
+
+// Refresh trades a refresh token for a new access token and a new refresh token. A refresh
+// token that was already traded revokes its session, someone else may hold it.
+func (uc *AuthController) Refresh(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash := token.HashRefreshToken(request.RefreshToken)
+
+	var session models.Session
+	if err := uc.DB.WithContext(c).Where("refresh_token_hash = ?", hash).First(&session).Error; err != nil {
+		if err := uc.DB.WithContext(c).Where("previous_token_hash = ?", hash).First(&session).Error; err == nil {
+			revokeSession(uc.DB.WithContext(c), &session, models.SessionRevokedReason_TokenReuse)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if !session.IsActive() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+		return
+	}
+	var user models.User
+	if err := uc.DB.WithContext(c).First(&user, session.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	refreshToken, refreshHash, err := token.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating token"})
+		return
+	}
+	accessToken, err := token.GenerateToken(user, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating token"})
+		return
+	}
+	// only the holder of the current token may rotate it
+	result := uc.DB.WithContext(c).
+		Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  refreshHash,
+			"previous_token_hash": hash,
+			"last_used_at":        time.Now(),
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
+		"token":        accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(token.AccessTokenTTL().Seconds()),
 	})
 }
 
-// Logout revokes the user's JWT token (optional depending on your requirements).
+// Logout revokes the session of the access token, its refresh token stops working.
 func (uc *AuthController) Logout(c *gin.Context) {
-	// Perform any necessary logout actions, if applicable (optional)
+	if sid, ok := c.Get("sid"); ok {
+		var session models.Session
+		if err := uc.DB.WithContext(c).First(&session, sid).Error; err == nil {
+			if err := revokeSession(uc.DB.WithContext(c), &session, models.SessionRevokedReason_Logout); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
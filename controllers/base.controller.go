@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"bytes"
+	"daijai/documents"
 	"daijai/models"
 	"daijai/token"
 	"encoding/json"
@@ -8,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -18,6 +21,11 @@ type BaseController struct {
 }
 
 func (bc *BaseController) GetUserID(c *gin.Context, userID *uint) error {
+	// set by the auth middleware, for API keys too
+	if uid := c.GetUint("uid"); uid != 0 {
+		*userID = uid
+		return nil
+	}
 	uid, err := token.ExtractTokenID(c)
 	if err != nil {
 		return err
@@ -121,6 +129,30 @@ func (bc *BaseController) LogErrorAndSendBadRequest(c *gin.Context, errorMessage
 	c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
 }
 
+// RenderDocument sends a printable document as "pdf" or "html".
+func (bc *BaseController) RenderDocument(c *gin.Context, doc *documents.Document, format string) {
+	var buf bytes.Buffer
+	switch format {
+	case "html":
+		if err := doc.HTML(&buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render document", "detail": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+	case "pdf":
+		if err := doc.PDF(&buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render document", "detail": err.Error()})
+			return
+		}
+		// slugs like "Bill 001/0001" are not valid file names
+		fileName := strings.NewReplacer("/", "-", " ", "_").Replace(doc.Slug) + ".pdf"
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", fileName))
+		c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported document format"})
+	}
+}
+
 type DebugController struct {
 }
 
@@ -30,7 +30,7 @@ func (mc *CategoryController) CreateCategory(c *gin.Context) {
 		return
 	}
 
-	if err := mc.DB.Create(&category).Error; err != nil {
+	if err := mc.DB.WithContext(c).Create(&category).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
 		return
 	}
@@ -42,7 +42,7 @@ func (mc *CategoryController) GetCategories(c *gin.Context) {
 	var categories []models.Category
 
 	isFg := c.Query(models.MaterialType_Param) == models.MaterialType_FinishedGood
-	if err := mc.DB.
+	if err := mc.DB.WithContext(c).
 		Where("is_fg = ?", isFg).
 		Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve categories"})
@@ -61,7 +61,7 @@ func (mc *CategoryController) GetCategoryByID(c *gin.Context) {
 	}
 
 	var category models.Category
-	if err := mc.DB.First(&category, categoryID).Error; err != nil {
+	if err := mc.DB.WithContext(c).First(&category, categoryID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
 		return
 	}
@@ -77,7 +77,7 @@ func (mc *CategoryController) UpdateCategory(c *gin.Context) {
 	}
 
 	var existingCategory models.Category
-	if err := mc.DB.First(&existingCategory, categoryID).Error; err != nil {
+	if err := mc.DB.WithContext(c).First(&existingCategory, categoryID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
 		return
 	}
@@ -93,7 +93,7 @@ func (mc *CategoryController) UpdateCategory(c *gin.Context) {
 	existingCategory.Title = updatedCategory.Title
 	existingCategory.Subtitle = updatedCategory.Subtitle
 
-	if err := mc.DB.Save(&existingCategory).Error; err != nil {
+	if err := mc.DB.WithContext(c).Save(&existingCategory).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
 		return
 	}
@@ -109,7 +109,7 @@ func (mc *CategoryController) DeleteCategory(c *gin.Context) {
 		return
 	}
 
-	if err := mc.DB.Delete(&models.Category{}, categoryID).Error; err != nil {
+	if err := mc.DB.WithContext(c).Delete(&models.Category{}, categoryID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
 		return
 	}
@@ -125,7 +125,7 @@ func (mc *CategoryController) GetCategoryMaterials(c *gin.Context) {
 		return
 	}
 	var materials []models.Material
-	if err := mc.DB.
+	if err := mc.DB.WithContext(c).
 		Where("category_id = ?", categoryID).
 		Preload("Sums").
 		Order("id ASC").
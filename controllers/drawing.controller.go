@@ -32,7 +32,7 @@ func (dc *DrawingController) GetNewDrawingInfo(c *gin.Context) {
 	}
 
 	var categories []models.Category
-	if err := dc.DB.
+	if err := dc.DB.WithContext(c).
 		Preload("Materials.Sums").
 		Where("is_fg = ?", isFg).
 		Find(&categories).Error; err != nil {
@@ -41,7 +41,7 @@ func (dc *DrawingController) GetNewDrawingInfo(c *gin.Context) {
 	}
 
 	// get slug
-	if err := dc.RequestSlug(&response.Slug, dc.DB, "drawings"); err != nil {
+	if err := dc.RequestSlug(&response.Slug, dc.DB.WithContext(c), "drawings"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Slug"})
 		return
 	}
@@ -57,7 +57,7 @@ func (dc *DrawingController) CreateDrawing(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := dc.getUserDataByUserID(dc.DB, uid, &member); err != nil {
+	if err := dc.getUserDataByUserID(dc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -71,7 +71,7 @@ func (dc *DrawingController) CreateDrawing(c *gin.Context) {
 	// create drawing
 	drw.CreatedByID = member.ID
 	drw.CreatedBy = member
-	if err := dc.DB.Create(&drw).Error; err != nil {
+	if err := dc.DB.WithContext(c).Create(&drw).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create drawing"})
 		return
 	}
@@ -90,6 +90,7 @@ func (dc *DrawingController) GetDrawings(c *gin.Context) {
 
 	if err := dc.
 		DB.
+		WithContext(c).
 		Preload("BOMs.Material.Category").
 		Preload("CreatedBy").
 		Where("is_fg = ?", isFG).
@@ -110,7 +111,7 @@ func (dc *DrawingController) GetDrawingByID(c *gin.Context) {
 	}
 
 	var drawing models.Drawing
-	if err := dc.DB.
+	if err := dc.DB.WithContext(c).
 		Preload("BOMs.Material.Category").
 		Preload("BOMs.Material.Sums").
 		Preload("CreatedBy").
@@ -130,7 +131,7 @@ func (dc *DrawingController) UpdateDrawing(c *gin.Context) {
 	}
 
 	var member models.Member
-	if err := dc.getUserDataByUserID(dc.DB, uid, &member); err != nil {
+	if err := dc.getUserDataByUserID(dc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -147,7 +148,7 @@ func (dc *DrawingController) UpdateDrawing(c *gin.Context) {
 		return
 	}
 	var drw models.Drawing
-	if err := dc.DB.Preload("BOMs").First(&drw, drawingID).Error; err != nil {
+	if err := dc.DB.WithContext(c).Preload("BOMs").First(&drw, drawingID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
 		return
 	}
@@ -158,7 +159,7 @@ func (dc *DrawingController) UpdateDrawing(c *gin.Context) {
 	drw.PartNumber = req.PartNumber
 	drw.IsFG = req.IsFG
 
-	if err := dc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := dc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		// DELETE EXISTING BOMs
 		for _, bom := range drw.BOMs {
 			if err := tx.Delete(&bom).Error; err != nil {
@@ -197,7 +198,7 @@ func (dc *DrawingController) DeleteDrawing(c *gin.Context) {
 		return
 	}
 
-	if err := dc.DB.Delete(&models.Drawing{}, drawingID).Error; err != nil {
+	if err := dc.DB.WithContext(c).Delete(&models.Drawing{}, drawingID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete drawing"})
 		return
 	}
@@ -19,7 +19,7 @@ func NewFilterController(db *gorm.DB) *FilterController {
 
 func (ctrl *FilterController) GetCategories(c *gin.Context) {
 	var categories []models.Category
-	if err := ctrl.DB.Find(&categories).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Find(&categories).Error; err != nil {
 		ctrl.LogErrorAndSendBadRequest(c, "Failed to get categories")
 		return
 	}
@@ -33,7 +33,7 @@ func (ctrl *FilterController) GetMaterialsByCategoryID(c *gin.Context) {
 	showSupplier := c.Query("showSupplier")
 
 	var materials []models.Material
-	query := ctrl.DB.Debug().Where("category_id = ?", categoryID)
+	query := ctrl.DB.WithContext(c).Debug().Where("category_id = ?", categoryID)
 
 	allowFields := []string{"id", "slug", "title", "subtitle", "image_path", "category_id", "is_fg"}
 	if showPrice == "true" {
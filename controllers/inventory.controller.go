@@ -2,7 +2,10 @@ package controllers
 
 import (
 	"daijai/models"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -27,7 +30,7 @@ func (mc *InventoryController) CreateInventory(c *gin.Context) {
 	}
 
 	// Create a new Project
-	if err := mc.DB.Create(&request).Error; err != nil {
+	if err := mc.DB.WithContext(c).Create(&request).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create inventory"})
 		return
 	}
@@ -43,12 +46,12 @@ func (mc *InventoryController) UpdateInventory(c *gin.Context) {
 	}
 
 	var inventory models.Inventory
-	if err := mc.DB.First(&inventory, id).Error; err != nil {
+	if err := mc.DB.WithContext(c).First(&inventory, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
 		return
 	}
 
-	if err := mc.DB.Model(&inventory).Updates(&request).Error; err != nil {
+	if err := mc.DB.WithContext(c).Model(&inventory).Updates(&request).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
 		return
 	}
@@ -58,12 +61,12 @@ func (mc *InventoryController) UpdateInventory(c *gin.Context) {
 func (mc *InventoryController) DeleteInventory(c *gin.Context) {
 	id := c.Param("id")
 	var inventory models.Inventory
-	if err := mc.DB.First(&inventory, id).Error; err != nil {
+	if err := mc.DB.WithContext(c).First(&inventory, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
 		return
 	}
 
-	if err := mc.DB.Delete(&inventory).Error; err != nil {
+	if err := mc.DB.WithContext(c).Delete(&inventory).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete inventory"})
 		return
 	}
@@ -73,7 +76,7 @@ func (mc *InventoryController) DeleteInventory(c *gin.Context) {
 // get all inventory
 func (mc *InventoryController) GetInventories(c *gin.Context) {
 	var inventory []models.Inventory
-	if err := mc.DB.Find(&inventory).Error; err != nil {
+	if err := mc.DB.WithContext(c).Where("is_virtual = ?", false).Find(&inventory).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory"})
 		return
 	}
@@ -90,6 +93,7 @@ func (mc *InventoryController) GetInventoryBySlug(c *gin.Context) {
 	var inventory models.Inventory
 	if err := mc.
 		DB.
+		WithContext(c).
 		First(&inventory, "slug = ?", slug).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory"})
 		return
@@ -101,7 +105,7 @@ func (mc *InventoryController) GetInventoryBySlug(c *gin.Context) {
 
 	// get categories
 	var categories []models.Category
-	if err := mc.DB.Find(&categories).Error; err != nil {
+	if err := mc.DB.WithContext(c).Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get categories"})
 		return
 	}
@@ -146,178 +150,102 @@ func (mc *InventoryController) GetInventoryBySlug(c *gin.Context) {
 // 	c.JSON(http.StatusOK, inventory)
 // }
 
-// / transfer material from one inventory to another
+// / request a transfer of material from one inventory to another, it is a direct transfer order
+// of one line and stock moves when it is approved
 func (mc *InventoryController) TransferMaterial(c *gin.Context) {
 
 	var request struct {
-		FromInventoryID uint  `json:"fromInventoryID"`
-		ToInventoryID   uint  `json:"toInventoryID"`
-		MaterialID      uint  `json:"materialID"`
-		Quantity        int64 `json:"quantity"`
+		FromInventoryID uint     `json:"fromInventoryID"`
+		ToInventoryID   uint     `json:"toInventoryID"`
+		MaterialID      uint     `json:"materialID"`
+		Quantity        int64    `json:"quantity"`
+		Serials         []string `json:"serials"`
+		Notes           string   `json:"notes"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// get from inventory
-	var fromInventory models.Inventory
-	if err := mc.DB.First(&fromInventory, request.FromInventoryID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
+	// get user
+	var uid uint
+	if err := mc.GetUserID(c, &uid); err != nil {
+		mc.LogErrorAndSendBadRequest(c, err.Error())
 		return
 	}
-
-	// get to inventory
-	var toInventory models.Inventory
-	if err := mc.DB.First(&toInventory, request.ToInventoryID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
+	var member models.Member
+	if err := mc.getUserDataByUserID(mc.DB.WithContext(c), uid, &member); err != nil {
+		mc.LogErrorAndSendBadRequest(c, err.Error())
 		return
 	}
 
-	// get material
-	var material models.Material
-	if err := mc.DB.First(&material, request.MaterialID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
+	order := models.TransferOrder{
+		FromInventoryID: request.FromInventoryID,
+		ToInventoryID:   request.ToInventoryID,
+		IsDirect:        true,
+		Notes:           request.Notes,
+		CreatedByID:     member.ID,
+		Lines: []models.TransferOrderLine{{
+			MaterialID: request.MaterialID,
+			Quantity:   request.Quantity,
+			Serials:    request.Serials,
+		}},
+	}
+	if err := mc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		return createTransferOrder(tx, &mc.BaseController, &order)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to transfer material", "detail": err.Error()})
 		return
 	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Transfer waits for approval", "transferOrder": order})
+}
+
+// transferSourceLots returns the lots of an inventory that hold the quantity to transfer,
+// the lots of the given serials for serial tracked materials. Expired lots stay where they are.
+func transferSourceLots(db *gorm.DB, fromInventoryID uint, material *models.Material, quantity int64, serials []string) ([]models.InventoryMaterial, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("quantity must be greater than 0")
+	}
 
 	// get inventory material
 	var fromInventoryMaterials []models.InventoryMaterial
-	if err := mc.DB.
-		Where("inventory_id = ?", fromInventory.ID).
+	if err := db.
+		Where("inventory_id = ?", fromInventoryID).
 		Where("material_id = ?", material.ID).
 		Where("is_out_of_stock = ?", false).
 		Where("available_qty != ?", 0).
+		Scopes(models.NotExpired).
+		Order("id asc").
 		Find(&fromInventoryMaterials).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory material not found"})
-		return
+		return nil, err
 	}
 
-	// get user
-	var uid uint
-	if err := mc.GetUserID(c, &uid); err != nil {
-		mc.LogErrorAndSendBadRequest(c, err.Error())
-		return
+	// serial tracked materials are transferred from the lots of the given serials
+	_, plan, err := serialPlan(db, material, serials, quantity, models.MaterialSerialStatus_InStock, inventoryLotOf)
+	if err != nil {
+		return nil, err
 	}
-	var member models.Member
-	if err := mc.getUserDataByUserID(mc.DB, uid, &member); err != nil {
-		mc.LogErrorAndSendBadRequest(c, err.Error())
-		return
-	}
-
-	if err := mc.DB.Transaction(func(tx *gorm.DB) error {
-		// create TransferMaterial
-		transferMaterial := models.TransferMaterial{
-			FromInventoryID: fromInventory.ID,
-			ToInventoryID:   toInventory.ID,
-			MaterialID:      material.ID,
-			Quantity:        request.Quantity,
-			CreatedByID:     member.ID,
-		}
-		if err := tx.Create(&transferMaterial).Error; err != nil {
-			return err
-		}
-
-		// loop through the ifromInventoryMaterials
-		targetQty := request.Quantity
+	if plan != nil {
+		var planned []models.InventoryMaterial
 		for _, iv := range fromInventoryMaterials {
-			// if targetQty is 0, break the loop
-			if targetQty == 0 {
-				break
-			}
-
-			// calculate the transferQty
-			var transferQty int64
-			if iv.AvailableQty >= targetQty {
-				transferQty = targetQty
-			} else {
-				transferQty = iv.AvailableQty
-			}
-
-			// create a new inventory material
-			newInventoryMaterial := models.InventoryMaterial{
-				InventoryID:           toInventory.ID,
-				MaterialID:            material.ID,
-				AdjustmentID:          nil,
-				Quantity:              transferQty,
-				AvailableQty:          transferQty,
-				IsOutOfStock:          false,
-				Price:                 iv.Price,
-				TransferMaterialID:    &transferMaterial.ID,
-				InventoryMaterialType: models.InventoryMaterialType_Transfer,
-				ReceiptID:             iv.ReceiptID,
+			if qty, ok := plan[iv.ID]; ok && iv.AvailableQty >= qty {
+				planned = append(planned, iv)
 			}
-			if err := tx.Create(&newInventoryMaterial).Error; err != nil {
-				return err
-			}
-
-			// calculate the updatedQuantityFromInventory
-			updatedQuantityFromInventory := iv.Quantity - transferQty
-			updatedFromInventoryAvailableQty := iv.AvailableQty - transferQty
-
-			// create transfer-out transaction
-			transferOutTransaction := models.InventoryMaterialTransaction{
-				InventoryMaterialID:      iv.ID,
-				Quantity:                 transferQty,
-				InventoryType:            models.InventoryType_TRANSFER,
-				InventoryTypeDescription: models.InventoryTypeDescription_TRANSFER_OUT,
-				ExistingQuantity:         iv.Quantity,
-				ExistingReserve:          iv.Reserve,
-				UpdatedQuantity:          updatedQuantityFromInventory,
-				UpdatedReserve:           iv.Reserve,
-				ReceiptID:                iv.ReceiptID,
-				TransferMaterialID:       &transferMaterial.ID,
-			}
-			if err := tx.Create(&transferOutTransaction).Error; err != nil {
-				return err
-			}
-
-			// create transfer-in transaction
-			transferInTransaction := models.InventoryMaterialTransaction{
-				InventoryMaterialID:      newInventoryMaterial.ID,
-				Quantity:                 transferQty,
-				InventoryType:            models.InventoryType_TRANSFER,
-				InventoryTypeDescription: models.InventoryTypeDescription_TRANSFER_IN,
-				ExistingQuantity:         0,
-				ExistingReserve:          0,
-				UpdatedQuantity:          transferQty,
-				UpdatedReserve:           0,
-				ReceiptID:                iv.ReceiptID,
-				TransferMaterialID:       &transferMaterial.ID,
-			}
-			if err := tx.Create(&transferInTransaction).Error; err != nil {
-				return err
-			}
-
-			// update the fromInventoryMaterial
-			if updatedFromInventoryAvailableQty == 0 {
-				iv.IsOutOfStock = true
-			}
-			if err := tx.Model(&iv).Updates(models.InventoryMaterial{
-				Quantity:     updatedQuantityFromInventory,
-				AvailableQty: updatedFromInventoryAvailableQty,
-				IsOutOfStock: iv.IsOutOfStock,
-			}).Error; err != nil {
-				return err
-			}
-
-			// update targetQty
-			targetQty -= transferQty
 		}
-		mc.SumMaterial(tx, "transfer", material.ID, fromInventory.ID)
-		mc.SumMaterial(tx, "transfer", material.ID, toInventory.ID)
+		if len(planned) != len(plan) {
+			return nil, fmt.Errorf("serials of %s are not available in the inventory", material.Slug)
+		}
+		return planned, nil
+	}
 
-		return nil
-	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer material", "detail": err.Error()})
-		return
+	var availableQty int64
+	for _, iv := range fromInventoryMaterials {
+		availableQty += iv.AvailableQty
 	}
-	if err := mc.DB.
-		Preload("Sums", "inventory_id = ?", fromInventory.ID).
-		First(&material, request.MaterialID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get material"})
+	if availableQty < quantity {
+		return nil, fmt.Errorf("not enough %s in inventory", material.Slug)
 	}
-	c.JSON(http.StatusOK, material)
+	return fromInventoryMaterials, nil
 }
 
 // / calculate cost of transfer material
@@ -334,21 +262,21 @@ func (mc *InventoryController) CalculateCostOfTransferMaterial(c *gin.Context) {
 
 	// get from inventory
 	var fromInventory models.Inventory
-	if err := mc.DB.First(&fromInventory, request.FromInventoryID).Error; err != nil {
+	if err := mc.DB.WithContext(c).First(&fromInventory, request.FromInventoryID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
 		return
 	}
 
 	// get material
 	var material models.Material
-	if err := mc.DB.First(&material, request.MaterialID).Error; err != nil {
+	if err := mc.DB.WithContext(c).First(&material, request.MaterialID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
 		return
 	}
 
 	// get inventory material
 	var fromInventoryMaterials []models.InventoryMaterial
-	if err := mc.DB.
+	if err := mc.DB.WithContext(c).
 		Where("inventory_id = ?", fromInventory.ID).
 		Where("material_id = ?", material.ID).
 		Where("is_out_of_stock = ?", false).
@@ -373,3 +301,121 @@ func (mc *InventoryController) CalculateCostOfTransferMaterial(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"totalCost": totalCost, "maxTransferQty": maxTransferQty})
 }
+
+// get lots with stock expiring within ?days= (default 30), expired lots included
+func (mc *InventoryController) GetExpiringMaterials(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+		return
+	}
+
+	var inventoryMaterials []models.InventoryMaterial
+	if err := mc.DB.WithContext(c).
+		Preload("Material.Category").
+		Preload("Inventory").
+		Preload("Location").
+		Preload("Receipt").
+		Where("expiry_date IS NOT NULL AND expiry_date <= ?", time.Now().AddDate(0, 0, days)).
+		Where("(available_qty > ? OR reserve > ?)", 0, 0).
+		Order("expiry_date asc").
+		Find(&inventoryMaterials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory materials"})
+		return
+	}
+
+	type expiringMaterial struct {
+		models.InventoryMaterial
+		LotNumber string
+		IsExpired bool
+		DaysLeft  int
+	}
+	resp := []expiringMaterial{}
+	for _, v := range inventoryMaterials {
+		resp = append(resp, expiringMaterial{
+			InventoryMaterial: v,
+			LotNumber:         models.LotNumber(v.ID),
+			IsExpired:         v.IsExpired(),
+			DaysLeft:          int(time.Until(*v.ExpiryDate).Hours() / 24),
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// request the write-off of the available quantity of an expired lot, the stock leaves the lot
+// when the adjustment is approved
+func (mc *InventoryController) WriteOffExpiredMaterial(c *gin.Context) {
+	var uid uint
+	if err := mc.GetUserID(c, &uid); err != nil {
+		mc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := mc.getUserDataByUserID(mc.DB.WithContext(c), uid, &member); err != nil {
+		mc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var request struct {
+		Notes string `json:"Notes"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inventoryMaterial models.InventoryMaterial
+	if err := mc.DB.WithContext(c).Preload("Material").First(&inventoryMaterial, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory material not found"})
+		return
+	}
+	if !inventoryMaterial.IsExpired() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lot is not expired"})
+		return
+	}
+	if inventoryMaterial.Reserve > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lot is still reserved, release the reservation first"})
+		return
+	}
+	if inventoryMaterial.AvailableQty <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lot has no stock to write off"})
+		return
+	}
+	var pending int64
+	if err := mc.DB.WithContext(c).
+		Model(&models.Adjustment{}).
+		Where("inventory_material_id = ? AND status = ?", inventoryMaterial.ID, models.DocumentStatus_Pending).
+		Count(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get adjustments"})
+		return
+	}
+	if pending > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lot already has a pending write-off"})
+		return
+	}
+
+	notes := fmt.Sprintf("expired write-off %s", models.LotNumber(inventoryMaterial.ID))
+	if request.Notes != "" {
+		notes += ": " + request.Notes
+	}
+	adjustment := models.Adjustment{
+		Notes:               notes,
+		Quantity:            -inventoryMaterial.AvailableQty,
+		PricePerUnit:        inventoryMaterial.Price,
+		InventoryID:         inventoryMaterial.InventoryID,
+		MaterialID:          inventoryMaterial.MaterialID,
+		CreatedByID:         member.ID,
+		Status:              models.DocumentStatus_Pending,
+		InventoryMaterialID: &inventoryMaterial.ID,
+	}
+	if err := mc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&adjustment).Error; err != nil {
+			return err
+		}
+		return openApprovalRequest(tx, adjustmentApprovalDocument(&adjustment, inventoryMaterial.Material))
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write off material", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Write-off waits for approval", "adjustment": adjustment})
+}
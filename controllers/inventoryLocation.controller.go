@@ -0,0 +1,429 @@
+package controllers
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type InventoryLocationController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewInventoryLocationController(db *gorm.DB) *InventoryLocationController {
+	return &InventoryLocationController{
+		DB: db,
+	}
+}
+
+// create location
+func (lc *InventoryLocationController) CreateLocation(c *gin.Context) {
+	var request models.InventoryLocation
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inventory models.Inventory
+	if err := lc.DB.WithContext(c).First(&inventory, request.InventoryID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
+		return
+	}
+
+	if request.Slug == "" {
+		request.Slug = fmt.Sprintf("%s-%s-%s", request.Zone, request.Rack, request.Bin)
+	}
+	if err := lc.DB.WithContext(c).Create(&request).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create location", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, request)
+}
+
+// get locations of inventory
+func (lc *InventoryLocationController) GetLocations(c *gin.Context) {
+	slug := c.Param("slug")
+	var inventory models.Inventory
+	if err := lc.
+		DB.
+		WithContext(c).
+		Preload("Locations", func(db *gorm.DB) *gorm.DB {
+			return db.Order("slug asc")
+		}).
+		First(&inventory, "slug = ?", slug).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
+		return
+	}
+	c.JSON(http.StatusOK, inventory.Locations)
+}
+
+// get inventory materials stored in location
+func (lc *InventoryLocationController) GetLocationMaterials(c *gin.Context) {
+	id := c.Param("id")
+	var location models.InventoryLocation
+	if err := lc.DB.WithContext(c).Preload("Inventory").First(&location, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var inventoryMaterials []models.InventoryMaterial
+	if err := lc.
+		DB.
+		WithContext(c).
+		Preload("Material").
+		Preload("Receipt").
+		Where("location_id = ?", location.ID).
+		Where("quantity > ?", 0).
+		Find(&inventoryMaterials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory materials"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"location": location, "inventoryMaterials": inventoryMaterials})
+}
+
+// get inventory materials that are not assigned to any location
+func (lc *InventoryLocationController) GetUnassignedMaterials(c *gin.Context) {
+	slug := c.Param("slug")
+	var inventory models.Inventory
+	if err := lc.DB.WithContext(c).First(&inventory, "slug = ?", slug).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
+		return
+	}
+
+	var inventoryMaterials []models.InventoryMaterial
+	if err := lc.
+		DB.
+		WithContext(c).
+		Preload("Material").
+		Preload("Receipt").
+		Where("inventory_id = ?", inventory.ID).
+		Where("location_id IS NULL").
+		Where("is_out_of_stock = ?", false).
+		Find(&inventoryMaterials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory materials"})
+		return
+	}
+	c.JSON(http.StatusOK, inventoryMaterials)
+}
+
+// update location
+func (lc *InventoryLocationController) UpdateLocation(c *gin.Context) {
+	id := c.Param("id")
+	var location models.InventoryLocation
+	if err := lc.DB.WithContext(c).First(&location, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var request models.InventoryLocation
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	location.Slug = request.Slug
+	location.Zone = request.Zone
+	location.Rack = request.Rack
+	location.Bin = request.Bin
+	location.Title = request.Title
+	if err := lc.DB.WithContext(c).Save(&location).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, location)
+}
+
+// delete location
+func (lc *InventoryLocationController) DeleteLocation(c *gin.Context) {
+	id := c.Param("id")
+	var location models.InventoryLocation
+	if err := lc.DB.WithContext(c).First(&location, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var count int64
+	if err := lc.
+		DB.
+		WithContext(c).
+		Model(&models.InventoryMaterial{}).
+		Where("location_id = ?", location.ID).
+		Where("quantity > ?", 0).
+		Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count inventory materials"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location still holds materials"})
+		return
+	}
+
+	if err := lc.DB.WithContext(c).Delete(&location).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Location deleted successfully"})
+}
+
+// PutAway assigns an inventory material that has no location yet to a bin.
+func (lc *InventoryLocationController) PutAway(c *gin.Context) {
+	var request struct {
+		LocationID uint `json:"LocationID"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inventoryMaterial models.InventoryMaterial
+	if err := lc.DB.WithContext(c).First(&inventoryMaterial, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory material not found"})
+		return
+	}
+	if inventoryMaterial.LocationID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Inventory material already has a location, use move instead"})
+		return
+	}
+
+	var location models.InventoryLocation
+	if err := lc.DB.WithContext(c).First(&location, request.LocationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+	if location.InventoryID != inventoryMaterial.InventoryID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location does not belong to inventory of the material"})
+		return
+	}
+
+	var uid uint
+	if err := lc.GetUserID(c, &uid); err != nil {
+		lc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := lc.getUserDataByUserID(lc.DB.WithContext(c), uid, &member); err != nil {
+		lc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	if err := lc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		move := models.LocationMove{
+			Notes:               "putaway",
+			Quantity:            inventoryMaterial.Quantity,
+			InventoryMaterialID: inventoryMaterial.ID,
+			ToLocationID:        location.ID,
+			CreatedByID:         member.ID,
+		}
+		if err := tx.Create(&move).Error; err != nil {
+			return err
+		}
+
+		inventoryMaterial.LocationID = &location.ID
+		if err := tx.Save(&inventoryMaterial).Error; err != nil {
+			return err
+		}
+
+		matTr := models.InventoryMaterialTransaction{
+			InventoryMaterialID:      inventoryMaterial.ID,
+			Quantity:                 inventoryMaterial.Quantity,
+			InventoryType:            models.InventoryType_MOVE,
+			InventoryTypeDescription: models.InventoryTypeDescription_PUTAWAY,
+			ExistingQuantity:         inventoryMaterial.Quantity,
+			ExistingReserve:          inventoryMaterial.Reserve,
+			UpdatedQuantity:          inventoryMaterial.Quantity,
+			UpdatedReserve:           inventoryMaterial.Reserve,
+			ReceiptID:                inventoryMaterial.ReceiptID,
+			LocationMoveID:           &move.ID,
+		}
+		return tx.Create(&matTr).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to put away material", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, inventoryMaterial)
+}
+
+// MoveMaterial moves quantity of an inventory material from its bin to another bin.
+// Moving part of a lot splits it; only available quantity can be split off.
+func (lc *InventoryLocationController) MoveMaterial(c *gin.Context) {
+	var request struct {
+		InventoryMaterialID uint     `json:"InventoryMaterialID"`
+		ToLocationID        uint     `json:"ToLocationID"`
+		Quantity            int64    `json:"Quantity"`
+		Notes               string   `json:"Notes"`
+		Serials             []string `json:"Serials"` // partial moves of serial tracked materials
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var inventoryMaterial models.InventoryMaterial
+	if err := lc.DB.WithContext(c).First(&inventoryMaterial, request.InventoryMaterialID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory material not found"})
+		return
+	}
+
+	var toLocation models.InventoryLocation
+	if err := lc.DB.WithContext(c).First(&toLocation, request.ToLocationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+	if toLocation.InventoryID != inventoryMaterial.InventoryID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location does not belong to inventory of the material, use transfer instead"})
+		return
+	}
+
+	// no quantity moves all available quantity, reserved and withdrawn quantity stays in the bin
+	if request.Quantity == 0 {
+		request.Quantity = inventoryMaterial.AvailableQty
+	}
+	if request.Quantity <= 0 || request.Quantity > inventoryMaterial.AvailableQty {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds available quantity of inventory material"})
+		return
+	}
+	movesWholeLot := request.Quantity == inventoryMaterial.Quantity && inventoryMaterial.Reserve == 0 && inventoryMaterial.Withdrawed == 0
+
+	// serials of a whole lot move with it, a split needs the serials that go
+	var serials []models.MaterialSerial
+	if !movesWholeLot {
+		var material models.Material
+		if err := lc.DB.WithContext(c).First(&material, inventoryMaterial.MaterialID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
+			return
+		}
+		var plan map[uint]int64
+		var err error
+		serials, plan, err = serialPlan(lc.DB.WithContext(c), &material, request.Serials, request.Quantity, models.MaterialSerialStatus_InStock, inventoryLotOf)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if plan != nil && plan[inventoryMaterial.ID] != request.Quantity {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Serials are not in the inventory material"})
+			return
+		}
+	}
+
+	var uid uint
+	if err := lc.GetUserID(c, &uid); err != nil {
+		lc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := lc.getUserDataByUserID(lc.DB.WithContext(c), uid, &member); err != nil {
+		lc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var move models.LocationMove
+	if err := lc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		move = models.LocationMove{
+			Notes:               request.Notes,
+			Quantity:            request.Quantity,
+			InventoryMaterialID: inventoryMaterial.ID,
+			FromLocationID:      inventoryMaterial.LocationID,
+			ToLocationID:        toLocation.ID,
+			CreatedByID:         member.ID,
+		}
+		if err := tx.Create(&move).Error; err != nil {
+			return err
+		}
+
+		if movesWholeLot {
+			// relocate the whole lot, nothing of it is reserved or withdrawn
+			inventoryMaterial.LocationID = &toLocation.ID
+			if err := tx.Save(&inventoryMaterial).Error; err != nil {
+				return err
+			}
+			matTr := models.InventoryMaterialTransaction{
+				InventoryMaterialID:      inventoryMaterial.ID,
+				Quantity:                 inventoryMaterial.Quantity,
+				InventoryType:            models.InventoryType_MOVE,
+				InventoryTypeDescription: models.InventoryTypeDescription_MOVE_IN,
+				ExistingQuantity:         inventoryMaterial.Quantity,
+				ExistingReserve:          inventoryMaterial.Reserve,
+				UpdatedQuantity:          inventoryMaterial.Quantity,
+				UpdatedReserve:           inventoryMaterial.Reserve,
+				ReceiptID:                inventoryMaterial.ReceiptID,
+				LocationMoveID:           &move.ID,
+			}
+			return tx.Create(&matTr).Error
+		}
+
+		// split available quantity into a new lot in the target bin
+		newInventoryMaterial := models.InventoryMaterial{
+			InventoryID:           inventoryMaterial.InventoryID,
+			MaterialID:            inventoryMaterial.MaterialID,
+			ReceiptID:             inventoryMaterial.ReceiptID,
+			LocationID:            &toLocation.ID,
+			Quantity:              request.Quantity,
+			AvailableQty:          request.Quantity,
+			IsOutOfStock:          false,
+			Price:                 inventoryMaterial.Price,
+			InventoryMaterialType: models.InventoryMaterialType_Move,
+			ExpiryDate:            inventoryMaterial.ExpiryDate,
+			ManufactureDate:       inventoryMaterial.ManufactureDate,
+		}
+		if err := tx.Create(&newInventoryMaterial).Error; err != nil {
+			return err
+		}
+		if len(serials) > 0 {
+			if err := tx.
+				Model(&models.MaterialSerial{}).
+				Where("id IN ?", serialIDsInLot(serials, inventoryLotOf, inventoryMaterial.ID)).
+				Update("inventory_material_id", newInventoryMaterial.ID).Error; err != nil {
+				return err
+			}
+		}
+		move.NewInventoryMaterialID = &newInventoryMaterial.ID
+		if err := tx.Save(&move).Error; err != nil {
+			return err
+		}
+
+		moveOutTransaction := models.InventoryMaterialTransaction{
+			InventoryMaterialID:      inventoryMaterial.ID,
+			Quantity:                 request.Quantity,
+			InventoryType:            models.InventoryType_MOVE,
+			InventoryTypeDescription: models.InventoryTypeDescription_MOVE_OUT,
+			ExistingQuantity:         inventoryMaterial.Quantity,
+			ExistingReserve:          inventoryMaterial.Reserve,
+			UpdatedQuantity:          inventoryMaterial.Quantity - request.Quantity,
+			UpdatedReserve:           inventoryMaterial.Reserve,
+			ReceiptID:                inventoryMaterial.ReceiptID,
+			LocationMoveID:           &move.ID,
+		}
+		if err := tx.Create(&moveOutTransaction).Error; err != nil {
+			return err
+		}
+
+		moveInTransaction := models.InventoryMaterialTransaction{
+			InventoryMaterialID:      newInventoryMaterial.ID,
+			Quantity:                 request.Quantity,
+			InventoryType:            models.InventoryType_MOVE,
+			InventoryTypeDescription: models.InventoryTypeDescription_MOVE_IN,
+			ExistingQuantity:         0,
+			ExistingReserve:          0,
+			UpdatedQuantity:          request.Quantity,
+			UpdatedReserve:           0,
+			ReceiptID:                inventoryMaterial.ReceiptID,
+			LocationMoveID:           &move.ID,
+		}
+		if err := tx.Create(&moveInTransaction).Error; err != nil {
+			return err
+		}
+
+		inventoryMaterial.Quantity -= request.Quantity
+		inventoryMaterial.AvailableQty -= request.Quantity
+		inventoryMaterial.IsOutOfStock = inventoryMaterial.AvailableQty == 0
+		return tx.Save(&inventoryMaterial).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move material", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, move)
+}
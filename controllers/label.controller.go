@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"bytes"
+	"daijai/documents"
+	"daijai/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type LabelController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewLabelController(db *gorm.DB) *LabelController {
+	return &LabelController{
+		DB: db,
+	}
+}
+
+// print material labels, ?slugs=DA0001,DA0002 (all materials when empty)
+func (lc *LabelController) GetMaterialLabels(c *gin.Context) {
+	var materials []models.Material
+	q := lc.DB.WithContext(c).Order("slug asc")
+	if slugs := splitQuery(c.Query("slugs")); len(slugs) > 0 {
+		q = q.Where("slug IN ?", slugs)
+	}
+	if err := q.Find(&materials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get materials"})
+		return
+	}
+
+	var labels []documents.Label
+	for _, m := range materials {
+		labels = append(labels, documents.Label{
+			Code:     m.Slug,
+			Title:    m.Slug,
+			Subtitle: m.Title,
+		})
+	}
+	lc.renderLabels(c, labels, "materials")
+}
+
+// print labels of the lots created by an approved receipt
+func (lc *LabelController) GetReceiptLabels(c *gin.Context) {
+	var receipt models.Receipt
+	if err := lc.DB.WithContext(c).First(&receipt, "slug = ?", c.Param("slug")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	if !receipt.IsApproved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt is not approved"})
+		return
+	}
+
+	var lots []models.InventoryMaterial
+	if err := lc.
+		DB.
+		WithContext(c).
+		Preload("Material").
+		Preload("Receipt").
+		Where("receipt_id = ? AND inventory_material_type = ?", receipt.ID, models.InventoryMaterialType_Receipt).
+		Order("id asc").
+		Find(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lots"})
+		return
+	}
+	lc.renderLabels(c, lotLabels(lots), receipt.Slug)
+}
+
+// print lot labels, ?ids=1,2
+func (lc *LabelController) GetLotLabels(c *gin.Context) {
+	ids := splitQuery(c.Query("ids"))
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids is required"})
+		return
+	}
+
+	var lots []models.InventoryMaterial
+	if err := lc.
+		DB.
+		WithContext(c).
+		Preload("Material").
+		Preload("Receipt").
+		Where("id IN ?", ids).
+		Order("id asc").
+		Find(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lots"})
+		return
+	}
+	lc.renderLabels(c, lotLabels(lots), "lots")
+}
+
+// resolve a scanned code to a lot or a material with current quantities
+func (lc *LabelController) Lookup(c *gin.Context) {
+	code := strings.TrimSpace(c.Query("code"))
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	if lotID, ok := models.ParseLotCode(code); ok {
+		var lot models.InventoryMaterial
+		if err := lc.
+			DB.
+			WithContext(c).
+			Preload("Material.Sums").
+			Preload("Inventory").
+			Preload("Location").
+			Preload("Receipt").
+			First(&lot, lotID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"type":      "lot",
+			"lotNumber": models.LotNumber(lot.ID),
+			"lot":       lot,
+			"material":  lot.Material,
+		})
+		return
+	}
+
+	var material models.Material
+	if err := lc.
+		DB.
+		WithContext(c).
+		Preload("Category").
+		Preload("Sums").
+		First(&material, "slug = ?", code).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
+		return
+	}
+
+	var lots []models.InventoryMaterial
+	if err := lc.
+		DB.
+		WithContext(c).
+		Preload("Inventory").
+		Preload("Location").
+		Where("material_id = ? AND available_qty > 0", material.ID).
+		Order("id asc").
+		Find(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lots"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"type":     "material",
+		"material": material,
+		"lots":     lots,
+	})
+}
+
+// maxLabelCopies caps ?copies= so one request cannot render an endless sheet.
+const maxLabelCopies = 100
+
+// renderLabels sends a pdf label sheet, ?type=code128|qr and ?copies=n
+func (lc *LabelController) renderLabels(c *gin.Context, labels []documents.Label, name string) {
+	if len(labels) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to print"})
+		return
+	}
+	copies, err := strconv.Atoi(c.DefaultQuery("copies", "1"))
+	if err != nil || copies < 1 || copies > maxLabelCopies {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Copies must be between 1 and %d", maxLabelCopies)})
+		return
+	}
+	var sheet []documents.Label
+	for _, label := range labels {
+		for i := 0; i < copies; i++ {
+			sheet = append(sheet, label)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := documents.LabelSheet(&buf, sheet, c.DefaultQuery("type", documents.Symbology_Code128)); errors.Is(err, documents.ErrNoFont) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render labels", "detail": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to render labels", "detail": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", "labels-"+name+".pdf"))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+func lotLabels(lots []models.InventoryMaterial) []documents.Label {
+	var labels []documents.Label
+	for _, lot := range lots {
+		var slug, title, receipt string
+		if lot.Material != nil {
+			slug = lot.Material.Slug
+			title = lot.Material.Title
+		}
+		if lot.Receipt != nil {
+			receipt = lot.Receipt.Slug + " "
+		}
+		labels = append(labels, documents.Label{
+			Code:     models.LotCode(slug, lot.ID),
+			Title:    slug + " " + title,
+			Subtitle: receipt + models.LotNumber(lot.ID) + " qty " + documents.FormatQuantity(lot.Quantity),
+		})
+	}
+	return labels
+}
+
+func splitQuery(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"daijai/models"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -31,12 +32,12 @@ func (mc *MaterialController) CreateMaterial(c *gin.Context) {
 	}
 
 	// Create a new material
-	if err := mc.DB.Create(&material).Error; err != nil {
+	if err := mc.DB.WithContext(c).Create(&material).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := mc.DB.Preload("Category").First(&material, material.ID).Error; err != nil {
+	if err := mc.DB.WithContext(c).Preload("Category").First(&material, material.ID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Material Category"})
 		return
 	}
@@ -52,6 +53,7 @@ func (mc *MaterialController) SearchMaterials(c *gin.Context) {
 
 	query := mc.
 		DB.
+		WithContext(c).
 		Where("LOWER(title) LIKE LOWER(?) OR LOWER(subtitle) LIKE LOWER(?) OR LOWER(supplier) LIKE LOWER(?) OR LOWER(slug) LIKE LOWER(?)", "%"+search+"%", "%"+search+"%", "%"+search+"%", "%"+search+"%")
 
 	both := c.Query(models.MaterialType_Param) == "both"
@@ -110,7 +112,7 @@ func (mc *MaterialController) QueryMaterials(c *gin.Context) {
 	}
 
 	var materials []models.Material
-	if err := mc.DB.
+	if err := mc.DB.WithContext(c).
 		Preload("Sums", "inventory_id IN ?", inventorySlugArr).
 		Where("category_id = ?", categoryID).
 		Where("is_fg = ?", isFg).
@@ -138,7 +140,7 @@ func (mc *MaterialController) GetMaterials(c *gin.Context) {
 	var categories []models.Category
 	isFg := c.Query(models.MaterialType_Param) == models.MaterialType_FinishedGood
 	fmt.Println("isFg", isFg)
-	if err := mc.DB.
+	if err := mc.DB.WithContext(c).
 		Preload("Materials", func(db *gorm.DB) *gorm.DB {
 			return db.Order("materials.id ASC")
 		}).
@@ -151,7 +153,7 @@ func (mc *MaterialController) GetMaterials(c *gin.Context) {
 
 	// get inventories
 	var inventories []models.Inventory
-	if err := mc.DB.Find(&inventories).Error; err != nil {
+	if err := mc.DB.WithContext(c).Find(&inventories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve inventories"})
 		return
 	}
@@ -162,7 +164,7 @@ func (mc *MaterialController) GetMaterials(c *gin.Context) {
 // get deleted materials
 func (mc *MaterialController) GetDeletedMaterials(c *gin.Context) {
 	var materials []models.Material
-	if err := mc.DB.Unscoped().Where("deleted_at IS NOT NULL").Find(&materials).Error; err != nil {
+	if err := mc.DB.WithContext(c).Unscoped().Where("deleted_at IS NOT NULL").Find(&materials).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve materials"})
 		return
 	}
@@ -178,7 +180,7 @@ func (mc *MaterialController) RestoreMaterial(c *gin.Context) {
 		return
 	}
 
-	if err := mc.DB.Unscoped().Model(&models.Material{}).Where("id = ?", materialID).Update("deleted_at", nil).Error; err != nil {
+	if err := mc.DB.WithContext(c).Unscoped().Model(&models.Material{}).Where("id = ?", materialID).Update("deleted_at", nil).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore material"})
 		return
 	}
@@ -196,7 +198,7 @@ func (mc *MaterialController) PermanentlyDeleteMaterial(c *gin.Context) {
 
 	fmt.Println("materialID", materialID)
 
-	if err := mc.DB.Unscoped().Delete(&models.Material{}, materialID).Error; err != nil {
+	if err := mc.DB.WithContext(c).Unscoped().Delete(&models.Material{}, materialID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete material"})
 		return
 	}
@@ -210,6 +212,7 @@ func (mc *MaterialController) GetMaterialBySlug(c *gin.Context) {
 	var material models.Material
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("Category").
 		Where("slug = ?", slug).
 		First(&material).
@@ -222,6 +225,7 @@ func (mc *MaterialController) GetMaterialBySlug(c *gin.Context) {
 	var inventories []models.Inventory
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("Transactions", "material_id = ?", material.ID, func(db *gorm.DB) *gorm.DB {
 			return db.Order("transactions.id DESC")
 		}).
@@ -252,7 +256,7 @@ func (mc *MaterialController) UpdateMaterial(c *gin.Context) {
 	}
 
 	var existingMaterial models.Material
-	if err := mc.DB.Preload("Sums").First(&existingMaterial, materialID).Error; err != nil {
+	if err := mc.DB.WithContext(c).Preload("Sums").First(&existingMaterial, materialID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
 		return
 	}
@@ -266,8 +270,23 @@ func (mc *MaterialController) UpdateMaterial(c *gin.Context) {
 	existingMaterial.DefaultPrice = material.DefaultPrice
 	existingMaterial.Max = material.Max
 	existingMaterial.Min = material.Min
+	existingMaterial.IsReturnable = material.IsReturnable
+
+	// serials are captured on receipt, tracking cannot change while stock exists
+	if existingMaterial.IsSerialTracked != material.IsSerialTracked {
+		var count int64
+		if err := mc.DB.WithContext(c).Model(&models.InventoryMaterial{}).Where("material_id = ? AND quantity > ?", existingMaterial.ID, 0).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update material"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change serial tracking of a material in stock"})
+			return
+		}
+		existingMaterial.IsSerialTracked = material.IsSerialTracked
+	}
 
-	if err := mc.DB.Save(&existingMaterial).Error; err != nil {
+	if err := mc.DB.WithContext(c).Save(&existingMaterial).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update material"})
 		return
 	}
@@ -283,7 +302,7 @@ func (mc *MaterialController) DeleteMaterial(c *gin.Context) {
 		return
 	}
 
-	if err := mc.DB.Delete(&models.Material{}, materialID).Error; err != nil {
+	if err := mc.DB.WithContext(c).Delete(&models.Material{}, materialID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete material"})
 		return
 	}
@@ -291,7 +310,7 @@ func (mc *MaterialController) DeleteMaterial(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Material deleted successfully"})
 }
 
-// AdjustMaterialQuantity adjusts the quantity of a specific material by ID.
+// AdjustMaterialQuantity requests an adjustment of the quantity of a specific material by ID.
 func (mc *MaterialController) AdjustMaterialQuantity(c *gin.Context) {
 	var uid uint
 	if err := mc.GetUserID(c, &uid); err != nil {
@@ -299,14 +318,14 @@ func (mc *MaterialController) AdjustMaterialQuantity(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := mc.getUserDataByUserID(mc.DB, uid, &member); err != nil {
+	if err := mc.getUserDataByUserID(mc.DB.WithContext(c), uid, &member); err != nil {
 		mc.LogErrorAndSendBadRequest(c, err.Error())
 		return
 	}
 
 	materialID := c.Param("id")
 	var material models.Material
-	if err := mc.DB.First(&material, materialID).Error; err != nil {
+	if err := mc.DB.WithContext(c).First(&material, materialID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
 		return
 	}
@@ -322,26 +341,101 @@ func (mc *MaterialController) AdjustMaterialQuantity(c *gin.Context) {
 		return
 	}
 
-	if err := mc.DB.Transaction(func(tx *gorm.DB) error {
-		// Create a new adjustment record
-		adjustment := models.Adjustment{
-			Quantity:     req.Quantity,
-			InventoryID:  req.InventoryID,
-			MaterialID:   material.ID,
-			CreatedByID:  member.ID,
-			PricePerUnit: req.PricePerUnit,
-		}
+	// the adjustment waits for approval before it changes stock
+	adjustment := models.Adjustment{
+		Quantity:     req.Quantity,
+		InventoryID:  req.InventoryID,
+		MaterialID:   material.ID,
+		CreatedByID:  member.ID,
+		PricePerUnit: req.PricePerUnit,
+		Status:       models.DocumentStatus_Pending,
+	}
+	if err := mc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&adjustment).Error; err != nil {
 			return err
 		}
+		return openApprovalRequest(tx, adjustmentApprovalDocument(&adjustment, &material))
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Adjustment waits for approval", "adjustment": adjustment})
+}
+
+// get adjustments, ?status=
+func (mc *MaterialController) GetAdjustments(c *gin.Context) {
+	var adjustments []models.Adjustment
+	q := mc.DB.WithContext(c).
+		Preload("Material").
+		Preload("Inventory").
+		Preload("CreatedBy").
+		Order("id desc")
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if err := q.Find(&adjustments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get adjustments"})
+		return
+	}
+	c.JSON(http.StatusOK, adjustments)
+}
+
+// ApproveAdjustment approves a pending adjustment and puts its quantity into stock
+// once the last approver of its chain approves, a write-off takes it out of its lot.
+func (mc *MaterialController) ApproveAdjustment(c *gin.Context) {
+	var uid uint
+	if err := mc.GetUserID(c, &uid); err != nil {
+		mc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := mc.getUserDataByUserID(mc.DB.WithContext(c), uid, &member); err != nil {
+		mc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var adjustment models.Adjustment
+	if err := mc.DB.WithContext(c).Preload("Material").First(&adjustment, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Adjustment not found"})
+		return
+	}
+	if adjustment.Status != models.DocumentStatus_Pending {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Adjustment is already approved or rejected"})
+		return
+	}
+
+	step := approveOrRespond(c, mc.DB.WithContext(c), adjustmentApprovalDocument(&adjustment, adjustment.Material), &member)
+	if step == nil {
+		return
+	}
+
+	// refusal is the error of a write-off its lot no longer allows, any other error is a failure
+	var refusal error
+	if err := mc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := recordApproval(tx, step, &member, c.Query("comment")); err != nil {
+			return err
+		}
+		if adjustment.InventoryMaterialID != nil {
+			if err := writeOffLot(tx, &adjustment); err != nil {
+				if errors.Is(err, errWriteOffRefused) {
+					refusal = err
+				}
+				return err
+			}
+			if err := tx.Model(&adjustment).Update("status", models.DocumentStatus_Approved).Error; err != nil {
+				return err
+			}
+			return mc.SumMaterial(tx, "writeoff", adjustment.MaterialID, adjustment.InventoryID)
+		}
 
 		// create inventory material
 		inventoryMaterial := models.InventoryMaterial{
-			InventoryID:           req.InventoryID,
-			MaterialID:            material.ID,
+			InventoryID:           adjustment.InventoryID,
+			MaterialID:            adjustment.MaterialID,
 			AdjustmentID:          &adjustment.ID,
-			Quantity:              req.Quantity,
-			AvailableQty:          req.Quantity,
+			Quantity:              adjustment.Quantity,
+			AvailableQty:          adjustment.Quantity,
 			IsOutOfStock:          false,
 			Price:                 adjustment.PricePerUnit,
 			InventoryMaterialType: models.InventoryMaterialType_Adjust,
@@ -350,20 +444,87 @@ func (mc *MaterialController) AdjustMaterialQuantity(c *gin.Context) {
 			return err
 		}
 
-		return nil
+		if err := tx.Model(&adjustment).Update("status", models.DocumentStatus_Approved).Error; err != nil {
+			return err
+		}
+
+		// update sum material inventory
+		return mc.SumMaterial(tx, "adjust", adjustment.MaterialID, adjustment.InventoryID)
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		status := http.StatusInternalServerError
+		if err == refusal {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	// update sum material inventory
-	mc.SumMaterial(mc.DB, "adjust", material.ID, req.InventoryID)
-
 	// reload material
-	if err := mc.DB.Preload("Sums").First(&material, materialID).Error; err != nil {
+	var material models.Material
+	if err := mc.DB.WithContext(c).Preload("Sums").First(&material, adjustment.MaterialID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Material Category"})
 		return
 	}
 
 	c.JSON(http.StatusOK, material)
 }
+
+var errWriteOffRefused = errors.New("write-off refused")
+
+// writeOffLot takes the quantity of an approved write-off out of its lot, the lot must still
+// hold it unreserved.
+func writeOffLot(tx *gorm.DB, adjustment *models.Adjustment) error {
+	var lot models.InventoryMaterial
+	if err := tx.First(&lot, *adjustment.InventoryMaterialID).Error; err != nil {
+		return err
+	}
+	writeOffQty := -adjustment.Quantity
+	if lot.Reserve > 0 || lot.AvailableQty < writeOffQty {
+		return fmt.Errorf("%w: %s no longer holds %d unreserved", errWriteOffRefused, models.LotNumber(lot.ID), writeOffQty)
+	}
+
+	existingQty := lot.Quantity
+	lot.Quantity -= writeOffQty
+	lot.AvailableQty -= writeOffQty
+	lot.IsOutOfStock = lot.AvailableQty == 0
+	if err := tx.Save(&lot).Error; err != nil {
+		return err
+	}
+
+	matTr := models.InventoryMaterialTransaction{
+		InventoryMaterialID:      lot.ID,
+		Quantity:                 writeOffQty,
+		InventoryType:            models.InventoryType_OUTGOING,
+		InventoryTypeDescription: models.InventoryTypeDescription_EXPIRED_WRITEOFF,
+		ExistingQuantity:         existingQty,
+		ExistingReserve:          lot.Reserve,
+		UpdatedQuantity:          lot.Quantity,
+		UpdatedReserve:           lot.Reserve,
+		ReceiptID:                lot.ReceiptID,
+		AdjustmentID:             &adjustment.ID,
+	}
+	if err := tx.Create(&matTr).Error; err != nil {
+		return err
+	}
+
+	return tx.
+		Model(&models.MaterialSerial{}).
+		Where("inventory_material_id = ? AND status = ?", lot.ID, models.MaterialSerialStatus_InStock).
+		Update("status", models.MaterialSerialStatus_WrittenOff).Error
+}
+
+// adjustmentApprovalDocument describes an adjustment for approval rule matching, removals
+// count with their absolute amount.
+func adjustmentApprovalDocument(adjustment *models.Adjustment, material *models.Material) approvalDocument {
+	amount := adjustment.Quantity * adjustment.PricePerUnit / 100
+	if amount < 0 {
+		amount = -amount
+	}
+	return approvalDocument{
+		Type:        models.ApprovalDocumentType_Adjustment,
+		ID:          adjustment.ID,
+		Amount:      amount,
+		CategoryIDs: []uint{material.CategoryID},
+		RequestedBy: &adjustment.CreatedByID,
+	}
+}
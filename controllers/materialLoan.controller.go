@@ -0,0 +1,545 @@
+package controllers
+
+import (
+	"daijai/models"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type MaterialLoanController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewMaterialLoanController(db *gorm.DB) *MaterialLoanController {
+	return &MaterialLoanController{
+		DB: db,
+	}
+}
+
+// check out a returnable material to a user, the loaned quantity is counted as withdrawn
+// from its lots until it is checked in
+func (lc *MaterialLoanController) CheckOut(c *gin.Context) {
+	var uid uint
+	if err := lc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := lc.getUserDataByUserID(lc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		MaterialID  uint      `json:"MaterialID" binding:"required"`
+		InventoryID uint      `json:"InventoryID" binding:"required"`
+		Quantity    int64     `json:"Quantity" binding:"required"`
+		BorrowerID  uint      `json:"BorrowerID" binding:"required"`
+		DueAt       time.Time `json:"DueAt" binding:"required"`
+		Notes       string    `json:"Notes"`
+		Serials     []string  `json:"Serials"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be greater than 0"})
+		return
+	}
+	if !request.DueAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Due date must be in the future"})
+		return
+	}
+
+	var material models.Material
+	if err := lc.DB.WithContext(c).First(&material, request.MaterialID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
+		return
+	}
+	if !material.IsReturnable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Material is not returnable, use a withdrawal instead"})
+		return
+	}
+	var borrower models.Member
+	if err := lc.DB.WithContext(c).First(&borrower, request.BorrowerID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Borrower not found"})
+		return
+	}
+
+	loan := models.MaterialLoan{
+		MaterialID:     material.ID,
+		InventoryID:    request.InventoryID,
+		Quantity:       request.Quantity,
+		Status:         models.MaterialLoanStatus_Out,
+		DueAt:          request.DueAt,
+		Notes:          request.Notes,
+		Serials:        request.Serials,
+		BorrowerID:     borrower.ID,
+		CheckedOutByID: member.ID,
+	}
+	// refusal is the error of a check out that cannot be done, any other error is a failure
+	var refusal error
+	if err := lc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		serials, plan, err := serialPlan(tx, &material, request.Serials, request.Quantity, models.MaterialSerialStatus_InStock, inventoryLotOf)
+		if err != nil {
+			refusal = err
+			return err
+		}
+
+		var invMats []models.InventoryMaterial
+		q := tx.
+			Where("material_id = ? AND inventory_id = ?", material.ID, request.InventoryID).
+			Where("available_qty > ?", 0).
+			Scopes(models.NotExpired).
+			Order("id asc")
+		if plan != nil {
+			lotIDs := make([]uint, 0, len(plan))
+			for lotID := range plan {
+				lotIDs = append(lotIDs, lotID)
+			}
+			q = q.Where("id IN ?", lotIDs)
+		}
+		if err := q.Find(&invMats).Error; err != nil {
+			return err
+		}
+		var availableQty int64
+		for _, invMat := range invMats {
+			if plan != nil && invMat.AvailableQty < plan[invMat.ID] {
+				refusal = fmt.Errorf("serials of %s are not available", models.LotNumber(invMat.ID))
+				return refusal
+			}
+			availableQty += invMat.AvailableQty
+		}
+		if availableQty < request.Quantity || (plan != nil && len(invMats) != len(plan)) {
+			refusal = errors.New("not enough material in inventory")
+			return refusal
+		}
+
+		if err := tx.Create(&loan).Error; err != nil {
+			return err
+		}
+
+		needQty := request.Quantity
+		for _, invMat := range invMats {
+			if needQty == 0 {
+				break
+			}
+			used := invMat.AvailableQty
+			if used > needQty {
+				used = needQty
+			}
+			if plan != nil {
+				used = plan[invMat.ID]
+			}
+
+			existingQty := invMat.AvailableQty
+			invMat.Withdrawed += used
+			invMat.AvailableQty -= used
+			invMat.IsOutOfStock = invMat.AvailableQty == 0
+			if err := tx.Save(&invMat).Error; err != nil {
+				return err
+			}
+
+			loanLot := models.MaterialLoanLot{
+				MaterialLoanID:      loan.ID,
+				InventoryMaterialID: invMat.ID,
+				Quantity:            used,
+			}
+			if err := tx.Create(&loanLot).Error; err != nil {
+				return err
+			}
+
+			matTr := models.InventoryMaterialTransaction{
+				InventoryMaterialID:      invMat.ID,
+				Quantity:                 used,
+				InventoryType:            models.InventoryType_OUTGOING,
+				InventoryTypeDescription: models.InventoryTypeDescription_LOAN_OUT,
+				ExistingQuantity:         existingQty,
+				ExistingReserve:          invMat.Reserve,
+				UpdatedQuantity:          invMat.AvailableQty,
+				UpdatedReserve:           invMat.Reserve,
+				ReceiptID:                invMat.ReceiptID,
+			}
+			if err := tx.Create(&matTr).Error; err != nil {
+				return err
+			}
+			needQty -= used
+		}
+
+		if len(serials) > 0 {
+			ids := make([]uint, 0, len(serials))
+			for _, s := range serials {
+				ids = append(ids, s.ID)
+			}
+			if err := tx.
+				Model(&models.MaterialSerial{}).
+				Where("id IN ?", ids).
+				Updates(map[string]interface{}{
+					"status":           models.MaterialSerialStatus_OnLoan,
+					"material_loan_id": loan.ID,
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		return lc.SumMaterial(tx, "loan", material.ID, request.InventoryID)
+	}); err != nil {
+		status := http.StatusInternalServerError
+		if err == refusal {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": "Failed to check out material", "detail": err.Error()})
+		return
+	}
+	lc.respondLoan(c, http.StatusCreated, loan.ID)
+}
+
+// check in a loan, partly or completely, returning the quantity to its lots
+func (lc *MaterialLoanController) CheckIn(c *gin.Context) {
+	var uid uint
+	if err := lc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := lc.getUserDataByUserID(lc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		Quantity       int64    `json:"Quantity" binding:"required"`
+		Condition      string   `json:"Condition" binding:"required"`
+		ConditionNotes string   `json:"ConditionNotes"`
+		Serials        []string `json:"Serials"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch request.Condition {
+	case models.MaterialLoanCondition_Good, models.MaterialLoanCondition_Worn, models.MaterialLoanCondition_Damaged:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid condition"})
+		return
+	}
+
+	var loan models.MaterialLoan
+	if err := lc.DB.WithContext(c).
+		Preload("Material").
+		Preload("Lots", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id asc")
+		}).
+		First(&loan, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+	if loan.Status != models.MaterialLoanStatus_Out {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Loan is already returned"})
+		return
+	}
+	if request.Quantity <= 0 || request.Quantity > loan.Quantity-loan.ReturnedQty {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds outstanding quantity"})
+		return
+	}
+
+	// refusal is the error of a check in that does not fit the loan, any other error is a failure
+	var refusal error
+	if err := lc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// returned serials must be on this loan
+		plan := map[uint]int64(nil)
+		var serialIDs []uint
+		if loan.Material.IsSerialTracked {
+			if int64(len(request.Serials))*models.SerialQuantity != request.Quantity {
+				refusal = errors.New("one serial per returned unit is required")
+				return refusal
+			}
+			var serials []models.MaterialSerial
+			if err := tx.
+				Where("material_id = ? AND serial_number IN ?", loan.MaterialID, request.Serials).
+				Where("material_loan_id = ? AND status = ?", loan.ID, models.MaterialSerialStatus_OnLoan).
+				Find(&serials).Error; err != nil {
+				return err
+			}
+			if len(serials) != len(request.Serials) {
+				refusal = errors.New("serials are not on this loan")
+				return refusal
+			}
+			plan = make(map[uint]int64)
+			for _, s := range serials {
+				plan[*s.InventoryMaterialID] += models.SerialQuantity
+				serialIDs = append(serialIDs, s.ID)
+			}
+		}
+
+		needQty := request.Quantity
+		for _, loanLot := range loan.Lots {
+			if needQty == 0 {
+				break
+			}
+			returned := loanLot.Quantity - loanLot.ReturnedQty
+			if returned > needQty {
+				returned = needQty
+			}
+			if plan != nil {
+				returned = plan[loanLot.InventoryMaterialID]
+			}
+			if returned == 0 {
+				continue
+			}
+
+			var invMat models.InventoryMaterial
+			if err := tx.First(&invMat, loanLot.InventoryMaterialID).Error; err != nil {
+				return err
+			}
+			existingQty := invMat.AvailableQty
+			invMat.Withdrawed -= returned
+			invMat.AvailableQty += returned
+			invMat.IsOutOfStock = false
+			if err := tx.Save(&invMat).Error; err != nil {
+				return err
+			}
+
+			loanLot.ReturnedQty += returned
+			if err := tx.Save(&loanLot).Error; err != nil {
+				return err
+			}
+
+			matTr := models.InventoryMaterialTransaction{
+				InventoryMaterialID:      invMat.ID,
+				Quantity:                 returned,
+				InventoryType:            models.InventoryType_INCOMING,
+				InventoryTypeDescription: models.InventoryTypeDescription_LOAN_IN,
+				ExistingQuantity:         existingQty,
+				ExistingReserve:          invMat.Reserve,
+				UpdatedQuantity:          invMat.AvailableQty,
+				UpdatedReserve:           invMat.Reserve,
+				ReceiptID:                invMat.ReceiptID,
+			}
+			if err := tx.Create(&matTr).Error; err != nil {
+				return err
+			}
+			needQty -= returned
+		}
+		if needQty != 0 {
+			refusal = errors.New("returned quantity does not match the loaned lots")
+			return refusal
+		}
+
+		if len(serialIDs) > 0 {
+			if err := tx.
+				Model(&models.MaterialSerial{}).
+				Where("id IN ?", serialIDs).
+				Updates(map[string]interface{}{
+					"status":           models.MaterialSerialStatus_InStock,
+					"material_loan_id": nil,
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		checkIn := models.MaterialLoanCheckIn{
+			MaterialLoanID: loan.ID,
+			Quantity:       request.Quantity,
+			Condition:      request.Condition,
+			ConditionNotes: request.ConditionNotes,
+			Serials:        request.Serials,
+			CheckedInByID:  member.ID,
+		}
+		if err := tx.Create(&checkIn).Error; err != nil {
+			return err
+		}
+
+		loan.ReturnedQty += request.Quantity
+		if loan.ReturnedQty == loan.Quantity {
+			loan.Status = models.MaterialLoanStatus_Returned
+		}
+		if err := tx.Omit("Material", "Lots").Save(&loan).Error; err != nil {
+			return err
+		}
+
+		return lc.SumMaterial(tx, "loan", loan.MaterialID, loan.InventoryID)
+	}); err != nil {
+		status := http.StatusInternalServerError
+		if err == refusal {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": "Failed to check in material", "detail": err.Error()})
+		return
+	}
+	lc.respondLoan(c, http.StatusOK, loan.ID)
+}
+
+// get loans, ?status= and ?borrowerID=
+func (lc *MaterialLoanController) GetLoans(c *gin.Context) {
+	var loans []models.MaterialLoan
+	q := lc.preloadLoans(lc.DB.WithContext(c)).Order("due_at asc")
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if borrowerID := c.Query("borrowerID"); borrowerID != "" {
+		q = q.Where("borrower_id = ?", borrowerID)
+	}
+	if err := q.Find(&loans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get loans"})
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+// get outstanding loans of the current user
+func (lc *MaterialLoanController) GetMyLoans(c *gin.Context) {
+	var uid uint
+	if err := lc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lc.respondOutstanding(c, uid)
+}
+
+// get outstanding loans of a user
+func (lc *MaterialLoanController) GetUserLoans(c *gin.Context) {
+	var borrower models.Member
+	if err := lc.DB.WithContext(c).First(&borrower, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	lc.respondOutstanding(c, borrower.ID)
+}
+
+// get loans past their due date
+func (lc *MaterialLoanController) GetOverdueLoans(c *gin.Context) {
+	var loans []models.MaterialLoan
+	if err := lc.
+		preloadLoans(lc.DB.WithContext(c)).
+		Where("status = ? AND due_at < ?", models.MaterialLoanStatus_Out, time.Now()).
+		Order("due_at asc").
+		Find(&loans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get loans"})
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+// notify borrowers of overdue loans, at most once a day per loan
+func (lc *MaterialLoanController) NotifyOverdueLoans(c *gin.Context) {
+	count, err := lc.notifyOverdueLoans(lc.DB.WithContext(c), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to notify overdue loans", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Overdue loans notified", "count": count})
+}
+
+// WatchOverdueLoans notifies overdue loans now and then at every interval, in the background.
+func (lc *MaterialLoanController) WatchOverdueLoans(interval time.Duration) {
+	notify := func() {
+		if _, err := lc.notifyOverdueLoans(lc.DB, time.Now()); err != nil {
+			log.Println("Failed to notify overdue loans:", err)
+		}
+	}
+	go func() {
+		notify()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			notify()
+		}
+	}()
+}
+
+func (lc *MaterialLoanController) notifyOverdueLoans(db *gorm.DB, now time.Time) (int, error) {
+	var loans []models.MaterialLoan
+	if err := db.
+		Preload("Material").
+		Preload("Borrower").
+		Where("status = ? AND due_at < ?", models.MaterialLoanStatus_Out, now).
+		Where("(overdue_notified_at IS NULL OR overdue_notified_at < ?)", now.Add(-24*time.Hour)).
+		Find(&loans).Error; err != nil {
+		return 0, err
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		for _, loan := range loans {
+			notif := models.Notification{
+				Type:      models.NotificationType_USER,
+				BadgeType: models.NotificationBadgeType_WARN,
+				Title:     fmt.Sprintf("%s is overdue", loan.Material.Title),
+				Subtitle:  fmt.Sprintf("please return %s, it was due %s", loan.Material.Slug, loan.DueAt.Format("02/01/2006")),
+				Body:      fmt.Sprint(loan.ID),
+				Action:    models.NotificationAction_OVERDUE_LOAN,
+				Icon:      "https://i.imgur.com/R3uJ7BF.png",
+				Cover:     "https://i.imgur.com/R3uJ7BF.png",
+				IsRead:    false,
+				IsSeen:    false,
+				Topic:     models.NotificationTopic_None,
+				UserID:    &loan.BorrowerID,
+			}
+			if err := tx.Create(&notif).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&loan).Update("overdue_notified_at", now).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(loans) == 0 {
+			return nil
+		}
+		notif := models.Notification{
+			Type:      models.NotificationType_TOPIC,
+			BadgeType: models.NotificationBadgeType_WARN,
+			Title:     fmt.Sprintf("%d loans are overdue", len(loans)),
+			Subtitle:  "please check overdue loans to see more details",
+			Action:    models.NotificationAction_OVERDUE_LOAN,
+			Icon:      "https://i.imgur.com/R3uJ7BF.png",
+			Cover:     "https://i.imgur.com/R3uJ7BF.png",
+			IsRead:    false,
+			IsSeen:    false,
+			Topic:     models.NotificationTopic_ADMIN,
+		}
+		return tx.Create(&notif).Error
+	}); err != nil {
+		return 0, err
+	}
+	return len(loans), nil
+}
+
+func (lc *MaterialLoanController) respondOutstanding(c *gin.Context, borrowerID uint) {
+	var loans []models.MaterialLoan
+	if err := lc.
+		preloadLoans(lc.DB.WithContext(c)).
+		Where("borrower_id = ? AND status = ?", borrowerID, models.MaterialLoanStatus_Out).
+		Order("due_at asc").
+		Find(&loans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get loans"})
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+func (lc *MaterialLoanController) respondLoan(c *gin.Context, status int, id uint) {
+	var loan models.MaterialLoan
+	if err := lc.preloadLoans(lc.DB.WithContext(c)).Preload("Lots").First(&loan, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+	c.JSON(status, loan)
+}
+
+func (lc *MaterialLoanController) preloadLoans(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Material").
+		Preload("Inventory").
+		Preload("Borrower").
+		Preload("CheckedOutBy").
+		Preload("CheckIns.CheckedInBy")
+}
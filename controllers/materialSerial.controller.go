@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type MaterialSerialController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewMaterialSerialController(db *gorm.DB) *MaterialSerialController {
+	return &MaterialSerialController{
+		DB: db,
+	}
+}
+
+// get serials, ?materialID= and ?status=
+func (sc *MaterialSerialController) GetSerials(c *gin.Context) {
+	var serials []models.MaterialSerial
+	q := sc.preloadSerials(sc.DB.WithContext(c)).Order("material_id asc, serial_number asc")
+	if materialID := c.Query("materialID"); materialID != "" {
+		q = q.Where("material_id = ?", materialID)
+	}
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if err := q.Find(&serials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get serials"})
+		return
+	}
+	c.JSON(http.StatusOK, serials)
+}
+
+// get serials of a material by slug
+func (sc *MaterialSerialController) GetMaterialSerials(c *gin.Context) {
+	var material models.Material
+	if err := sc.DB.WithContext(c).First(&material, "slug = ?", c.Param("slug")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
+		return
+	}
+
+	var serials []models.MaterialSerial
+	if err := sc.
+		preloadSerials(sc.DB.WithContext(c)).
+		Where("material_id = ?", material.ID).
+		Order("serial_number asc").
+		Find(&serials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get serials"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"material": material, "serials": serials})
+}
+
+// find where a serial number currently is, serials are unique per material only
+func (sc *MaterialSerialController) LookupSerial(c *gin.Context) {
+	var serials []models.MaterialSerial
+	if err := sc.
+		preloadSerials(sc.DB.WithContext(c)).
+		Where("serial_number = ?", c.Param("serial")).
+		Find(&serials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get serials"})
+		return
+	}
+	if len(serials) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Serial not found"})
+		return
+	}
+	c.JSON(http.StatusOK, serials)
+}
+
+func (sc *MaterialSerialController) preloadSerials(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Material").
+		Preload("Receipt").
+		Preload("Inventory").
+		Preload("InventoryMaterial.Location").
+		Preload("WithdrawalApprovement").
+		Preload("Withdrawal").
+		Preload("ProjectStore.Project")
+}
+
+// serialPlan validates the serials given for a quantity of a serial tracked material
+// and counts the quantity they take from each lot; lotOf picks the lot a serial is in.
+// It returns no plan for materials without serial tracking.
+func serialPlan(tx *gorm.DB, material *models.Material, serialNumbers []string, quantity int64, status string, lotOf func(s *models.MaterialSerial) *uint) ([]models.MaterialSerial, map[uint]int64, error) {
+	if !material.IsSerialTracked {
+		if len(serialNumbers) > 0 {
+			return nil, nil, fmt.Errorf("material %s is not serial tracked", material.Slug)
+		}
+		return nil, nil, nil
+	}
+	if int64(len(serialNumbers))*models.SerialQuantity != quantity {
+		return nil, nil, fmt.Errorf("material %s needs one serial per unit", material.Slug)
+	}
+	seen := make(map[string]bool)
+	for _, sn := range serialNumbers {
+		if seen[sn] {
+			return nil, nil, fmt.Errorf("serial %s is given twice", sn)
+		}
+		seen[sn] = true
+	}
+
+	var serials []models.MaterialSerial
+	if err := tx.
+		Where("material_id = ? AND serial_number IN ?", material.ID, serialNumbers).
+		Find(&serials).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(serials) != len(serialNumbers) {
+		return nil, nil, fmt.Errorf("unknown serial of material %s", material.Slug)
+	}
+
+	plan := make(map[uint]int64)
+	for i := range serials {
+		s := &serials[i]
+		if s.Status != status {
+			return nil, nil, fmt.Errorf("serial %s is %s", s.SerialNumber, s.Status)
+		}
+		if s.WithdrawalApprovementID != nil {
+			return nil, nil, fmt.Errorf("serial %s is assigned to a withdrawal", s.SerialNumber)
+		}
+		lot := lotOf(s)
+		if lot == nil {
+			return nil, nil, fmt.Errorf("serial %s has no lot", s.SerialNumber)
+		}
+		plan[*lot] += models.SerialQuantity
+	}
+	return serials, plan, nil
+}
+
+// serialIDsInLot returns the ids of the serials whose lot is lotID.
+func serialIDsInLot(serials []models.MaterialSerial, lotOf func(s *models.MaterialSerial) *uint, lotID uint) []uint {
+	ids := []uint{}
+	for i := range serials {
+		if lot := lotOf(&serials[i]); lot != nil && *lot == lotID {
+			ids = append(ids, serials[i].ID)
+		}
+	}
+	return ids
+}
+
+func inventoryLotOf(s *models.MaterialSerial) *uint {
+	return s.InventoryMaterialID
+}
+
+func projectStoreLotOf(s *models.MaterialSerial) *uint {
+	return s.ProjectStoreMaterialID
+}
+
+// issueSerials moves withdrawn serials into the project store lot, or marks them
+// issued when the withdrawal has no project store.
+func issueSerials(tx *gorm.DB, serialIDs []uint, storeMaterial *models.ProjectStoreMaterial, withdrawalID uint) error {
+	if len(serialIDs) == 0 {
+		return nil
+	}
+	updates := map[string]interface{}{
+		"status":                    models.MaterialSerialStatus_Issued,
+		"withdrawal_id":             withdrawalID,
+		"withdrawal_approvement_id": nil,
+	}
+	if storeMaterial != nil {
+		updates["status"] = models.MaterialSerialStatus_InProject
+		updates["project_store_id"] = storeMaterial.ProjectStoreID
+		updates["project_store_material_id"] = storeMaterial.ID
+	}
+	return tx.Model(&models.MaterialSerial{}).Where("id IN ?", serialIDs).Updates(updates).Error
+}
+
+// withdrawalSerials checks that every reserved lot of a serial tracked material has one
+// serial assigned per unit and returns the assigned serial ids by lot.
+// The approvement must be preloaded with WithdrawalTransactions.OrderReserving.InventoryMaterial.
+func withdrawalSerials(db *gorm.DB, wapm *models.WithdrawalApprovement) (map[uint][]uint, error) {
+	lotSerials := make(map[uint][]uint)
+	if wapm.WithdrawalTransactions == nil {
+		return lotSerials, nil
+	}
+
+	reservedQty := make(map[uint]int64)
+	var materialIDs []uint
+	for _, wts := range *wapm.WithdrawalTransactions {
+		if wts.OrderReserving == nil || wts.OrderReserving.InventoryMaterial == nil {
+			continue
+		}
+		reservedQty[wts.OrderReserving.InventoryMaterialID] += wts.OrderReserving.Quantity
+		materialIDs = append(materialIDs, wts.OrderReserving.InventoryMaterial.MaterialID)
+	}
+	if len(materialIDs) == 0 {
+		return lotSerials, nil
+	}
+
+	var trackedIDs []uint
+	if err := db.
+		Model(&models.Material{}).
+		Where("id IN ? AND is_serial_tracked = ?", materialIDs, true).
+		Pluck("id", &trackedIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(trackedIDs) == 0 {
+		return lotSerials, nil
+	}
+
+	var serials []models.MaterialSerial
+	if err := db.
+		Where("withdrawal_approvement_id = ?", wapm.ID).
+		Order("serial_number asc").
+		Find(&serials).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range serials {
+		if s.InventoryMaterialID != nil {
+			lotSerials[*s.InventoryMaterialID] = append(lotSerials[*s.InventoryMaterialID], s.ID)
+		}
+	}
+
+	tracked := make(map[uint]bool)
+	for _, id := range trackedIDs {
+		tracked[id] = true
+	}
+	for _, wts := range *wapm.WithdrawalTransactions {
+		if wts.OrderReserving == nil || wts.OrderReserving.InventoryMaterial == nil {
+			continue
+		}
+		invMat := wts.OrderReserving.InventoryMaterial
+		if !tracked[invMat.MaterialID] {
+			continue
+		}
+		if int64(len(lotSerials[invMat.ID]))*models.SerialQuantity != reservedQty[invMat.ID] {
+			return nil, fmt.Errorf("serials of %s are not assigned", models.LotNumber(invMat.ID))
+		}
+	}
+	return lotSerials, nil
+}
@@ -26,7 +26,7 @@ func (nc *NotificaionController) GetNotifications(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := nc.getUserDataByUserID(nc.DB, uid, &member); err != nil {
+	if err := nc.getUserDataByUserID(nc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -34,6 +34,7 @@ func (nc *NotificaionController) GetNotifications(c *gin.Context) {
 	var notifications []models.Notification
 	result := nc.
 		DB.
+		WithContext(c).
 		Find(&notifications)
 	// Where("user_id = ?", member.ID).
 	// Or("topic = ?", member.Role).
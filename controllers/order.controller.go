@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"daijai/documents"
 	"daijai/models"
 	"fmt"
 	"log"
@@ -32,7 +33,7 @@ func (odc *OrderController) CreateOrder(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := odc.getUserDataByUserID(odc.DB, uid, &member); err != nil {
+	if err := odc.getUserDataByUserID(odc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -53,6 +54,7 @@ func (odc *OrderController) CreateOrder(c *gin.Context) {
 	var drawing models.Drawing
 	if err := odc.
 		DB.
+		WithContext(c).
 		Preload("BOMs.Material").
 		First(&drawing, request.DrawingID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Drawing"})
@@ -70,7 +72,7 @@ func (odc *OrderController) CreateOrder(c *gin.Context) {
 	}
 	if len(nullMaterials) > 0 {
 		var mats []models.Material
-		if err := odc.DB.Unscoped().Find(&mats, nullMaterials).Error; err != nil {
+		if err := odc.DB.WithContext(c).Unscoped().Find(&mats, nullMaterials).Error; err != nil {
 			log.Printf("Error: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Drawing has null material", "material_id": nullMaterials})
 			return
@@ -95,7 +97,7 @@ func (odc *OrderController) CreateOrder(c *gin.Context) {
 	order.IsFG = request.IsFG
 	order.CreatedByID = member.ID
 
-	if err := odc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := odc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&order).Error; err != nil {
 			return err
 		}
@@ -150,6 +152,7 @@ func (odc *OrderController) GetOrders(c *gin.Context) {
 	var orders []models.Order
 	query := odc.
 		DB.
+		WithContext(c).
 		Preload("Drawing").
 		Preload("CreatedBy").
 		Preload("OrderBOMs").
@@ -159,6 +162,11 @@ func (odc *OrderController) GetOrders(c *gin.Context) {
 	if projectID != "" {
 		query = query.Where("project_id = ?", projectID)
 	}
+	query, err := scopeToProjects(odc.DB.WithContext(c), c, query, "project_id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project scope"})
+		return
+	}
 
 	if err := query.
 		Find(&orders).
@@ -174,6 +182,7 @@ func (odc *OrderController) GetOrderBySlug(c *gin.Context) {
 	slug := c.Param("slug")
 	if err := odc.
 		DB.
+		WithContext(c).
 		Preload("OrderBOMs.Material").
 		Preload("Drawing").
 		Preload("Project").
@@ -184,9 +193,32 @@ func (odc *OrderController) GetOrderBySlug(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Order"})
 		return
 	}
+	if !canSeeProject(odc.DB.WithContext(c), c, order.ProjectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
 	c.JSON(http.StatusOK, order)
 }
 
+// GetOrderDocument renders an order with its BOM as pdf or html.
+func (odc *OrderController) GetOrderDocument(c *gin.Context) {
+	var order models.Order
+	if err := odc.
+		DB.
+		WithContext(c).
+		Preload("OrderBOMs.Material").
+		Preload("Drawing").
+		Preload("Project").
+		Preload("CreatedBy").
+		Where("slug = ?", c.Param("slug")).
+		First(&order).
+		Error; err != nil || !canSeeProject(odc.DB.WithContext(c), c, order.ProjectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	odc.RenderDocument(c, documents.Order(&order), c.Param("format"))
+}
+
 func (odc *OrderController) GetNewOrderInfo(c *gin.Context) {
 	materialType := c.Query(models.MaterialType_Param)
 	isFG := materialType == models.MaterialType_FinishedGood
@@ -195,6 +227,7 @@ func (odc *OrderController) GetNewOrderInfo(c *gin.Context) {
 	var projects []models.Project
 	if err := odc.
 		DB.
+		WithContext(c).
 		Find(&projects).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Projects"})
 		return
@@ -203,6 +236,7 @@ func (odc *OrderController) GetNewOrderInfo(c *gin.Context) {
 	var drawings []models.Drawing
 	if err := odc.
 		DB.
+		WithContext(c).
 		Where("is_fg = ?", isFG).
 		Preload("BOMs.Material.Sums").
 		Find(&drawings).Error; err != nil {
@@ -212,7 +246,7 @@ func (odc *OrderController) GetNewOrderInfo(c *gin.Context) {
 
 	// get slug
 	var slug string
-	if err := odc.RequestSlug(&slug, odc.DB, "orders"); err != nil {
+	if err := odc.RequestSlug(&slug, odc.DB.WithContext(c), "orders"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Slug", "detail": err.Error()})
 		return
 	}
@@ -235,6 +269,7 @@ func (odc *OrderController) GetOrderInfo(c *gin.Context) {
 	var order models.Order
 	if err := odc.
 		DB.
+		WithContext(c).
 		Preload("OrderBOMs.Material").
 		Preload("OrderReservings.InventoryMaterial.Material").
 		Where("slug = ?", slug).
@@ -242,6 +277,10 @@ func (odc *OrderController) GetOrderInfo(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Order"})
 		return
 	}
+	if !canSeeProject(odc.DB.WithContext(c), c, order.ProjectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
 	c.JSON(http.StatusOK, order)
 
 }
@@ -23,9 +23,13 @@ func NewExtendOrdererController(db *gorm.DB) *ExtendOrdererController {
 }
 
 func (wc *ExtendOrdererController) GetExtendOrders(c *gin.Context) {
+	query, err := scopeToProjects(wc.DB.WithContext(c), c, wc.DB.WithContext(c), "project_id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project scope"})
+		return
+	}
 	var orders []models.ExtendOrder
-	if err := wc.
-		DB.
+	if err := query.
 		Preload("Order").
 		Preload("Project").
 		Preload("ExtendOrderBOMs.Material").
@@ -40,13 +44,13 @@ func (wc *ExtendOrdererController) GetExtendOrders(c *gin.Context) {
 
 func (wc *ExtendOrdererController) GetNewInfo(c *gin.Context) {
 	var projects []models.Project
-	if err := wc.DB.Find(&projects).Error; err != nil {
+	if err := wc.DB.WithContext(c).Find(&projects).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Projects"})
 		return
 	}
 
 	var categories []models.Category
-	if err := wc.DB.Find(&categories).Error; err != nil {
+	if err := wc.DB.WithContext(c).Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Categories"})
 		return
 	}
@@ -54,6 +58,7 @@ func (wc *ExtendOrdererController) GetNewInfo(c *gin.Context) {
 	var materials []models.Material
 	if err := wc.
 		DB.
+		WithContext(c).
 		Preload("Sums").
 		Find(&materials).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Materials"})
@@ -62,7 +67,7 @@ func (wc *ExtendOrdererController) GetNewInfo(c *gin.Context) {
 
 	// get slug
 	var slug string
-	if err := wc.RequestSlug(&slug, wc.DB, "extend_orders"); err != nil {
+	if err := wc.RequestSlug(&slug, wc.DB.WithContext(c), "extend_orders"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Slug", "detail": err.Error()})
 		return
 	}
@@ -85,6 +90,7 @@ func (odc *ExtendOrdererController) GetExtendOrderBySlug(c *gin.Context) {
 	slug := c.Param("slug")
 	if err := odc.
 		DB.
+		WithContext(c).
 		Preload("ExtendOrderBOMs.Material").
 		Preload("Project").
 		Preload("Order").
@@ -95,6 +101,10 @@ func (odc *ExtendOrdererController) GetExtendOrderBySlug(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ExtendOrder"})
 		return
 	}
+	if !canSeeProject(odc.DB.WithContext(c), c, order.ProjectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ExtendOrder not found"})
+		return
+	}
 	c.JSON(http.StatusOK, order)
 }
 
@@ -105,7 +115,7 @@ func (odc *ExtendOrdererController) CreateExtendOrders(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := odc.getUserDataByUserID(odc.DB, uid, &member); err != nil {
+	if err := odc.getUserDataByUserID(odc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -117,7 +127,7 @@ func (odc *ExtendOrdererController) CreateExtendOrders(c *gin.Context) {
 	}
 	order.CreatedByID = member.ID
 
-	if err := odc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := odc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&order).Error; err != nil {
 			return err
 		}
@@ -32,14 +32,14 @@ func (rc *PlannerController) GetNewPlannerInfo(c *gin.Context) {
 	}
 
 	// get all inventories
-	if err := rc.DB.Find(&response.Inventories).Error; err != nil {
+	if err := rc.DB.WithContext(c).Find(&response.Inventories).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to fetch inventories"})
 		return
 	}
 
 	incompletedStatus := []string{models.OrderStatus_Idle, models.OrderStatus_Pending, models.OrderStatus_InProgress}
 	incompletePlanedStatus := []string{models.OrderPlanStatus_None, models.OrderPlanStatus_Partial}
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Preload("OrderBOMs.Material").
 		Where("status IN (?)", incompletedStatus).
 		Where("plan_status IN (?)", incompletePlanedStatus).
@@ -55,7 +55,7 @@ func (rc *PlannerController) GetExtendOrders(c *gin.Context) {
 	incompletedStatus := []string{models.OrderStatus_Idle, models.OrderStatus_Pending, models.OrderStatus_InProgress}
 
 	var response []models.ExtendOrder
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Preload("Order").
 		Preload("Project").
 		Preload("ExtendOrderBOMs.Material").
@@ -88,7 +88,7 @@ func (rc *PlannerController) GetMaterialSumByInventory(c *gin.Context) {
 	}
 
 	var materials []models.Material
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Preload("Sums", "inventory_id IN ?", inventoriesIDsUint).
 		Find(&materials).
 		Error; err != nil {
@@ -119,6 +119,7 @@ func (rc *PlannerController) CreatePlanner(c *gin.Context) {
 	var orders []models.Order
 	if err := rc.
 		DB.
+		WithContext(c).
 		Preload("OrderBOMs", func(db *gorm.DB) *gorm.DB {
 			return db.Order("id DESC")
 		}).
@@ -175,11 +176,12 @@ func (rc *PlannerController) CreatePlanner(c *gin.Context) {
 	}
 
 	var inventoryMaterials []models.InventoryMaterial
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Preload("Material").
 		Where("material_id IN (?)", materialIDs).
 		Where("inventory_id IN ?", req.InventoryIDs).
 		Where("is_out_of_stock = ?", false).
+		Scopes(models.NotExpired).
 		Find(&inventoryMaterials).
 		Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -187,7 +189,7 @@ func (rc *PlannerController) CreatePlanner(c *gin.Context) {
 		})
 	}
 
-	if err := rc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		for i, v := range planOrders {
 			matID := v.MaterialID
 			boms := v.PlanBOMs
@@ -298,7 +300,7 @@ func (rc *PlannerController) CreatePlanner(c *gin.Context) {
 	// 	for _, invID := range req.InventoryIDs {
 	// 		uMatID := uint(matID)
 	// 		uInvID := uint(invID)
-	// 		if err := rc.SumMaterial(rc.DB, "CreatePlanner", uMatID, uInvID); err != nil {
+	// 		if err := rc.SumMaterial(rc.DB.WithContext(c), "CreatePlanner", uMatID, uInvID); err != nil {
 	// 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	// 			return
 	// 		}
@@ -408,6 +410,7 @@ func (rc *PlannerController) InquiryPlan(c *gin.Context) {
 	var orders []models.Order
 	if err := rc.
 		DB.
+		WithContext(c).
 		Preload("OrderBOMs", func(db *gorm.DB) *gorm.DB {
 			return db.Order("id DESC")
 		}).
@@ -465,12 +468,13 @@ func (rc *PlannerController) InquiryPlan(c *gin.Context) {
 
 	var sumMaterials []models.PlanSumMaterial
 
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Model(&models.InventoryMaterial{}).
 		Select("material_id, SUM(quantity) as quantity, SUM(available_qty) as available_qty, SUM(reserve) as reserve").
 		Where("material_id IN ?", materialIDs).
 		Where("inventory_id IN ?", req.InventoryIDs).
 		Where("is_out_of_stock = ?", false).
+		Scopes(models.NotExpired).
 		Group("material_id").
 		Find(&sumMaterials).
 		Error; err != nil {
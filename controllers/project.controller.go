@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"daijai/models"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -30,7 +31,7 @@ func (pc *ProjectController) CreateProject(c *gin.Context) {
 	}
 
 	// Create a new Project
-	if err := pc.DB.Create(&request).Error; err != nil {
+	if err := pc.DB.WithContext(c).Create(&request).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Project"})
 		return
 	}
@@ -48,8 +49,13 @@ func (pc *ProjectController) GetAllProjects(c *gin.Context) {
 	// 	return
 	// }
 
+	query, err := scopeToProjects(pc.DB.WithContext(c), c, pc.DB.WithContext(c), "id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project scope"})
+		return
+	}
 	var projects []models.Project
-	if err := pc.DB.Find(&projects).Error; err != nil {
+	if err := query.Find(&projects).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve projects"})
 		return
 	}
@@ -62,7 +68,7 @@ func (pc *ProjectController) GetProject(c *gin.Context) {
 	id := c.Param("id")
 
 	var project models.Project
-	if err := pc.DB.First(&project, id).Error; err != nil {
+	if err := pc.DB.WithContext(c).First(&project, id).Error; err != nil || !canSeeProject(pc.DB.WithContext(c), c, project.ID) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
@@ -82,15 +88,17 @@ func (pc *ProjectController) GetProjectDetailBySlug(c *gin.Context) {
 	}
 	if err := pc.
 		DB.
+		WithContext(c).
 		Where("slug = ?", slug).
 		Preload("ProjectStores").
-		First(&response.Project).Error; err != nil {
+		First(&response.Project).Error; err != nil || !canSeeProject(pc.DB.WithContext(c), c, response.Project.ID) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
 
 	if err := pc.
 		DB.
+		WithContext(c).
 		Preload("Drawing").
 		Preload("CreatedBy").
 		Where("project_id = ?", response.Project.ID).
@@ -101,6 +109,7 @@ func (pc *ProjectController) GetProjectDetailBySlug(c *gin.Context) {
 
 	if err := pc.
 		DB.
+		WithContext(c).
 		Preload("CreatedBy").
 		Where("project_id = ?", response.Project.ID).
 		Find(&response.ExtendOrders).Error; err != nil {
@@ -121,6 +130,7 @@ func (pc *ProjectController) GetProjectDetailBySlug(c *gin.Context) {
 
 	if err := pc.
 		DB.
+		WithContext(c).
 		Preload("InventoryMaterial.Material").
 		Where("order_id IN (?)", orderIDs).
 		Or("extend_order_id IN (?)", extendOrderIDs).
@@ -143,7 +153,7 @@ func (pc *ProjectController) UpdateProject(c *gin.Context) {
 	}
 
 	var project models.Project
-	if err := pc.DB.First(&project, id).Error; err != nil {
+	if err := pc.DB.WithContext(c).First(&project, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
@@ -154,7 +164,7 @@ func (pc *ProjectController) UpdateProject(c *gin.Context) {
 	project.Subtitle = request.Subtitle
 	project.Description = request.Description
 
-	if err := pc.DB.Save(&project).Error; err != nil {
+	if err := pc.DB.WithContext(c).Save(&project).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update Project"})
 		return
 	}
@@ -167,15 +177,128 @@ func (pc *ProjectController) DeleteProject(c *gin.Context) {
 	id := c.Param("id")
 
 	var project models.Project
-	if err := pc.DB.First(&project, id).Error; err != nil {
+	if err := pc.DB.WithContext(c).First(&project, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
 
-	if err := pc.DB.Delete(&project).Error; err != nil {
+	if err := pc.DB.WithContext(c).Delete(&project).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete Project"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
 }
+
+func (pc *ProjectController) GetProjectMembers(c *gin.Context) {
+	var project models.Project
+	if err := pc.DB.WithContext(c).Preload("Members.User").First(&project, c.Param("id")).Error; err != nil || !canSeeProject(pc.DB.WithContext(c), c, project.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	c.JSON(http.StatusOK, project.Members)
+}
+
+// AddProjectMember assigns a user to a project.
+func (pc *ProjectController) AddProjectMember(c *gin.Context) {
+	var request struct {
+		UserID uint `json:"UserID" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var project models.Project
+	if err := pc.DB.WithContext(c).First(&project, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	var user models.User
+	if err := pc.DB.WithContext(c).First(&user, request.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+		return
+	}
+
+	member := models.ProjectMember{ProjectID: project.ID, UserID: user.ID}
+	result := pc.DB.WithContext(c).Where(&member).FirstOrCreate(&member)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add project member"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User is already a member of the project"})
+		return
+	}
+	pc.DB.WithContext(c).Preload("User").First(&member, member.ID)
+	c.JSON(http.StatusCreated, member)
+}
+
+func (pc *ProjectController) RemoveProjectMember(c *gin.Context) {
+	result := pc.DB.WithContext(c).
+		Unscoped().
+		Where("project_id = ? AND user_id = ?", c.Param("id"), c.Param("userId")).
+		Delete(&models.ProjectMember{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove project member"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project member not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Project member removed successfully"})
+}
+
+// projectScope returns the projects of the signed in user when the user's role is project
+// scoped; scoped is false for global roles, which see every project.
+func projectScope(db *gorm.DB, c *gin.Context) (projectIDs []uint, scoped bool, err error) {
+	role := c.GetString("role")
+	if role == "" {
+		return nil, false, nil
+	}
+	var stored models.Role
+	if err := db.Select("id", "is_project_scoped").Where("name = ?", role).First(&stored).Error; err == nil {
+		scoped = stored.IsProjectScoped
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		scoped = models.IsProjectScopedRole(role)
+	} else {
+		return nil, false, err
+	}
+	if !scoped {
+		return nil, false, nil
+	}
+	projectIDs = []uint{}
+	if err := db.
+		Model(&models.ProjectMember{}).
+		Where("user_id = ?", c.GetUint("uid")).
+		Pluck("project_id", &projectIDs).Error; err != nil {
+		return nil, true, err
+	}
+	return projectIDs, true, nil
+}
+
+// scopeToProjects limits query to rows whose column is one of the caller's projects.
+func scopeToProjects(db *gorm.DB, c *gin.Context, query *gorm.DB, column string) (*gorm.DB, error) {
+	projectIDs, scoped, err := projectScope(db, c)
+	if err != nil || !scoped {
+		return query, err
+	}
+	return query.Where(column+" IN ?", projectIDs), nil
+}
+
+// canSeeProject reports whether the caller may see the documents of a project.
+func canSeeProject(db *gorm.DB, c *gin.Context, projectID uint) bool {
+	projectIDs, scoped, err := projectScope(db, c)
+	if err != nil {
+		return false
+	}
+	if !scoped {
+		return true
+	}
+	for _, id := range projectIDs {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
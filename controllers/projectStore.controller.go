@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"daijai/models"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -27,13 +28,13 @@ func (p *ProjectStoreController) CreateProjectStore(c *gin.Context) {
 
 	// get Project
 	project := models.Project{}
-	err := p.DB.First(&project, projectStore.ProjectID)
+	err := p.DB.WithContext(c).First(&project, projectStore.ProjectID)
 	if err.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error.Error()})
 		return
 	}
 
-	result := p.DB.Create(&projectStore)
+	result := p.DB.WithContext(c).Create(&projectStore)
 	projectStore.Project = &project
 	if result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
@@ -47,6 +48,7 @@ func (p *ProjectStoreController) GetProjectStores(c *gin.Context) {
 	projectStores := []models.ProjectStore{}
 	result := p.
 		DB.
+		WithContext(c).
 		Preload("Project").
 		Find(&projectStores)
 	if result.Error != nil {
@@ -60,7 +62,7 @@ func (p *ProjectStoreController) GetProjectStores(c *gin.Context) {
 func (p *ProjectStoreController) GetProjectStoreByID(c *gin.Context) {
 	projectStore := models.ProjectStore{}
 	id := c.Param("id")
-	result := p.DB.First(&projectStore, id)
+	result := p.DB.WithContext(c).First(&projectStore, id)
 	if result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
 		return
@@ -72,7 +74,7 @@ func (p *ProjectStoreController) GetProjectStoreByID(c *gin.Context) {
 func (p *ProjectStoreController) UpdateProjectStore(c *gin.Context) {
 	var projectStore models.ProjectStore
 	id := c.Param("id")
-	result := p.DB.First(&projectStore, id)
+	result := p.DB.WithContext(c).First(&projectStore, id)
 	if result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
 		return
@@ -82,7 +84,7 @@ func (p *ProjectStoreController) UpdateProjectStore(c *gin.Context) {
 		return
 	}
 
-	result = p.DB.Save(&projectStore)
+	result = p.DB.WithContext(c).Save(&projectStore)
 	if result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
 		return
@@ -94,15 +96,420 @@ func (p *ProjectStoreController) UpdateProjectStore(c *gin.Context) {
 func (p *ProjectStoreController) DeleteProjectStore(c *gin.Context) {
 	projectStore := models.ProjectStore{}
 	id := c.Param("id")
-	result := p.DB.First(&projectStore, id)
+	result := p.DB.WithContext(c).First(&projectStore, id)
 	if result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
 		return
 	}
-	result = p.DB.Delete(&projectStore)
+	result = p.DB.WithContext(c).Delete(&projectStore)
 	if result.Error != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Project Store deleted successfully"})
 }
+
+// get materials held in project store
+func (p *ProjectStoreController) GetProjectStoreMaterials(c *gin.Context) {
+	id := c.Param("id")
+	var projectStore models.ProjectStore
+	if err := p.DB.WithContext(c).Preload("Project").First(&projectStore, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project Store not found"})
+		return
+	}
+
+	var storeMaterials []models.ProjectStoreMaterial
+	if err := p.
+		DB.
+		WithContext(c).
+		Preload("Material").
+		Preload("Withdrawal").
+		Where("project_store_id = ?", projectStore.ID).
+		Order("id asc").
+		Find(&storeMaterials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project store materials"})
+		return
+	}
+
+	// sum available quantity by material
+	type sumStoreMaterial struct {
+		MaterialID   uint
+		Material     *models.Material
+		Quantity     int64
+		ConsumedQty  int64
+		ReturnedQty  int64
+		AvailableQty int64
+	}
+	sums := []sumStoreMaterial{}
+	indexes := make(map[uint]int)
+	for _, sm := range storeMaterials {
+		i, ok := indexes[sm.MaterialID]
+		if !ok {
+			sums = append(sums, sumStoreMaterial{MaterialID: sm.MaterialID, Material: sm.Material})
+			i = len(sums) - 1
+			indexes[sm.MaterialID] = i
+		}
+		sums[i].Quantity += sm.Quantity
+		sums[i].ConsumedQty += sm.ConsumedQty
+		sums[i].ReturnedQty += sm.ReturnedQty
+		sums[i].AvailableQty += sm.AvailableQty
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"projectStore": projectStore,
+		"materials":    storeMaterials,
+		"sums":         sums,
+	})
+}
+
+// get project store transactions
+func (p *ProjectStoreController) GetProjectStoreTransactions(c *gin.Context) {
+	id := c.Param("id")
+	var transactions []models.ProjectStoreTransaction
+	if err := p.
+		DB.
+		WithContext(c).
+		Preload("ProjectStoreMaterial.Material").
+		Preload("Withdrawal").
+		Preload("CreatedBy").
+		Where("project_store_material_id IN (?)", p.DB.WithContext(c).
+			Model(&models.ProjectStoreMaterial{}).
+			Select("id").
+			Where("project_store_id = ?", id)).
+		Order("id desc").
+		Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project store transactions"})
+		return
+	}
+	c.JSON(http.StatusOK, transactions)
+}
+
+// ConsumeProjectStoreMaterial records material used on site from a project store.
+func (p *ProjectStoreController) ConsumeProjectStoreMaterial(c *gin.Context) {
+	var request struct {
+		MaterialID uint     `json:"MaterialID"`
+		Quantity   int64    `json:"Quantity"`
+		Notes      string   `json:"Notes"`
+		Serials    []string `json:"Serials"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be greater than 0"})
+		return
+	}
+
+	var uid uint
+	if err := p.GetUserID(c, &uid); err != nil {
+		p.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := p.getUserDataByUserID(p.DB.WithContext(c), uid, &member); err != nil {
+		p.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var projectStore models.ProjectStore
+	if err := p.DB.WithContext(c).First(&projectStore, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project Store not found"})
+		return
+	}
+
+	if err := p.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		storeMaterials, serials, plan, err := findProjectStoreMaterials(tx, projectStore.ID, request.MaterialID, request.Quantity, request.Serials)
+		if err != nil {
+			return err
+		}
+
+		needQty := request.Quantity
+		for _, sm := range storeMaterials {
+			if needQty == 0 {
+				break
+			}
+			used := sm.AvailableQty
+			if used > needQty {
+				used = needQty
+			}
+			if plan != nil {
+				used = plan[sm.ID]
+			}
+			existingQty := sm.AvailableQty
+			sm.ConsumedQty += used
+			sm.AvailableQty -= used
+			sm.IsOutOfStock = sm.AvailableQty == 0
+			if err := tx.Save(&sm).Error; err != nil {
+				return err
+			}
+
+			storeTr := models.ProjectStoreTransaction{
+				ProjectStoreMaterialID: sm.ID,
+				Quantity:               used,
+				TransactionType:        models.ProjectStoreTransactionType_Consume,
+				ExistingQuantity:       existingQty,
+				UpdatedQuantity:        sm.AvailableQty,
+				Notes:                  request.Notes,
+				CreatedByID:            &member.ID,
+			}
+			if err := tx.Create(&storeTr).Error; err != nil {
+				return err
+			}
+
+			if ids := serialIDsInLot(serials, projectStoreLotOf, sm.ID); len(ids) > 0 {
+				if err := tx.
+					Model(&models.MaterialSerial{}).
+					Where("id IN ?", ids).
+					Update("status", models.MaterialSerialStatus_Consumed).Error; err != nil {
+					return err
+				}
+			}
+			needQty -= used
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to consume material", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Material consumed successfully"})
+}
+
+// ReturnProjectStoreMaterial sends leftover material from a project store back to an inventory.
+func (p *ProjectStoreController) ReturnProjectStoreMaterial(c *gin.Context) {
+	var request struct {
+		MaterialID  uint     `json:"MaterialID"`
+		InventoryID uint     `json:"InventoryID"`
+		Quantity    int64    `json:"Quantity"`
+		Notes       string   `json:"Notes"`
+		Serials     []string `json:"Serials"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be greater than 0"})
+		return
+	}
+
+	var uid uint
+	if err := p.GetUserID(c, &uid); err != nil {
+		p.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := p.getUserDataByUserID(p.DB.WithContext(c), uid, &member); err != nil {
+		p.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var projectStore models.ProjectStore
+	if err := p.DB.WithContext(c).First(&projectStore, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project Store not found"})
+		return
+	}
+
+	var inventory models.Inventory
+	if err := p.DB.WithContext(c).First(&inventory, request.InventoryID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory not found"})
+		return
+	}
+
+	if err := p.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		storeMaterials, serials, plan, err := findProjectStoreMaterials(tx, projectStore.ID, request.MaterialID, request.Quantity, request.Serials)
+		if err != nil {
+			return err
+		}
+
+		needQty := request.Quantity
+		for _, sm := range storeMaterials {
+			if needQty == 0 {
+				break
+			}
+			used := sm.AvailableQty
+			if used > needQty {
+				used = needQty
+			}
+			if plan != nil {
+				used = plan[sm.ID]
+			}
+
+			// create a new inventory material from the returned quantity
+			inventoryMaterial := models.InventoryMaterial{
+				InventoryID:            inventory.ID,
+				MaterialID:             sm.MaterialID,
+				ReceiptID:              sm.ReceiptID,
+				ProjectStoreMaterialID: &sm.ID,
+				Quantity:               used,
+				AvailableQty:           used,
+				IsOutOfStock:           false,
+				Price:                  sm.Price,
+				InventoryMaterialType:  models.InventoryMaterialType_Return,
+				ExpiryDate:             sm.ExpiryDate,
+				ManufactureDate:        sm.ManufactureDate,
+			}
+			if err := tx.Create(&inventoryMaterial).Error; err != nil {
+				return err
+			}
+
+			matTr := models.InventoryMaterialTransaction{
+				InventoryMaterialID:      inventoryMaterial.ID,
+				Quantity:                 used,
+				InventoryType:            models.InventoryType_INCOMING,
+				InventoryTypeDescription: models.InventoryTypeDescription_RETURN,
+				ExistingQuantity:         0,
+				ExistingReserve:          0,
+				UpdatedQuantity:          used,
+				UpdatedReserve:           0,
+				ReceiptID:                sm.ReceiptID,
+				WithdrawalID:             sm.WithdrawalID,
+			}
+			if err := tx.Create(&matTr).Error; err != nil {
+				return err
+			}
+
+			existingQty := sm.AvailableQty
+			sm.ReturnedQty += used
+			sm.AvailableQty -= used
+			sm.IsOutOfStock = sm.AvailableQty == 0
+			if err := tx.Save(&sm).Error; err != nil {
+				return err
+			}
+
+			storeTr := models.ProjectStoreTransaction{
+				ProjectStoreMaterialID: sm.ID,
+				Quantity:               used,
+				TransactionType:        models.ProjectStoreTransactionType_Return,
+				ExistingQuantity:       existingQty,
+				UpdatedQuantity:        sm.AvailableQty,
+				InventoryMaterialID:    &inventoryMaterial.ID,
+				Notes:                  request.Notes,
+				CreatedByID:            &member.ID,
+			}
+			if err := tx.Create(&storeTr).Error; err != nil {
+				return err
+			}
+
+			// returned serials are back in stock in the new lot
+			if ids := serialIDsInLot(serials, projectStoreLotOf, sm.ID); len(ids) > 0 {
+				if err := tx.
+					Model(&models.MaterialSerial{}).
+					Where("id IN ?", ids).
+					Updates(map[string]interface{}{
+						"status":                    models.MaterialSerialStatus_InStock,
+						"inventory_id":              inventory.ID,
+						"inventory_material_id":     inventoryMaterial.ID,
+						"withdrawal_id":             nil,
+						"project_store_id":          nil,
+						"project_store_material_id": nil,
+					}).Error; err != nil {
+					return err
+				}
+			}
+			needQty -= used
+		}
+
+		return p.SumMaterial(tx, "project-store-return", request.MaterialID, inventory.ID)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to return material", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Material returned successfully"})
+}
+
+// findProjectStoreMaterials finds the store lots to take a quantity from: the lots of the
+// given serials for serial tracked materials, otherwise the oldest lots first.
+func findProjectStoreMaterials(tx *gorm.DB, projectStoreID uint, materialID uint, quantity int64, serialNumbers []string) ([]models.ProjectStoreMaterial, []models.MaterialSerial, map[uint]int64, error) {
+	var material models.Material
+	if err := tx.First(&material, materialID).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	serials, plan, err := serialPlan(tx, &material, serialNumbers, quantity, models.MaterialSerialStatus_InProject, projectStoreLotOf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	storeMaterials, err := findAvailableProjectStoreMaterials(tx, projectStoreID, materialID, quantity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if plan == nil {
+		return storeMaterials, nil, nil, nil
+	}
+
+	var planned []models.ProjectStoreMaterial
+	for _, sm := range storeMaterials {
+		if qty, ok := plan[sm.ID]; ok {
+			if sm.AvailableQty < qty {
+				return nil, nil, nil, fmt.Errorf("serials of store lot %d are not available", sm.ID)
+			}
+			planned = append(planned, sm)
+		}
+	}
+	if len(planned) != len(plan) {
+		return nil, nil, nil, fmt.Errorf("serials are not in this project store")
+	}
+	return planned, serials, plan, nil
+}
+
+// find store lots of a material (oldest first) and make sure they cover the quantity
+func findAvailableProjectStoreMaterials(tx *gorm.DB, projectStoreID uint, materialID uint, quantity int64) ([]models.ProjectStoreMaterial, error) {
+	var storeMaterials []models.ProjectStoreMaterial
+	if err := tx.
+		Where("project_store_id = ?", projectStoreID).
+		Where("material_id = ?", materialID).
+		Where("available_qty > ?", 0).
+		Order("id asc").
+		Find(&storeMaterials).Error; err != nil {
+		return nil, err
+	}
+
+	var availableQty int64
+	for _, sm := range storeMaterials {
+		availableQty += sm.AvailableQty
+	}
+	if availableQty < quantity {
+		return nil, fmt.Errorf("not enough material in project store: available %d, requested %d", availableQty, quantity)
+	}
+	return storeMaterials, nil
+}
+
+// stockProjectStore moves withdrawn quantity of an inventory lot into a project store
+// and returns the new store lot, nil when the withdrawal has no project store.
+func stockProjectStore(tx *gorm.DB, projectStoreID uint, invMat *models.InventoryMaterial, quantity int64, withdrawalID uint, memberID *uint) (*models.ProjectStoreMaterial, error) {
+	if projectStoreID == 0 || quantity <= 0 {
+		return nil, nil
+	}
+
+	storeMaterial := models.ProjectStoreMaterial{
+		ProjectStoreID:      projectStoreID,
+		MaterialID:          invMat.MaterialID,
+		InventoryMaterialID: &invMat.ID,
+		WithdrawalID:        &withdrawalID,
+		ReceiptID:           invMat.ReceiptID,
+		Quantity:            quantity,
+		AvailableQty:        quantity,
+		Price:               invMat.Price,
+		IsOutOfStock:        false,
+		ExpiryDate:          invMat.ExpiryDate,
+		ManufactureDate:     invMat.ManufactureDate,
+	}
+	if err := tx.Create(&storeMaterial).Error; err != nil {
+		return nil, err
+	}
+
+	storeTr := models.ProjectStoreTransaction{
+		ProjectStoreMaterialID: storeMaterial.ID,
+		Quantity:               quantity,
+		TransactionType:        models.ProjectStoreTransactionType_Incoming,
+		ExistingQuantity:       0,
+		UpdatedQuantity:        quantity,
+		WithdrawalID:           &withdrawalID,
+		CreatedByID:            memberID,
+	}
+	if err := tx.Create(&storeTr).Error; err != nil {
+		return nil, err
+	}
+	return &storeMaterial, nil
+}
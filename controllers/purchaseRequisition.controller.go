@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"daijai/documents"
 	"daijai/models"
 	"fmt"
 	"net/http"
@@ -25,7 +26,7 @@ func NewPurchaseRequisitionController(db *gorm.DB) *PurchaseRequisitionControlle
 // get list or orderBoms with IsFullFilled = false
 func (prc *PurchaseRequisitionController) GetNewPRInfo(c *gin.Context) {
 	var purchaseSuggestions []models.PurchaseSuggestion
-	if err := prc.DB.
+	if err := prc.DB.WithContext(c).
 		Preload("OrderBOM.Order.Drawing").
 		Preload("OrderBOM.Material").
 		Where("status IN (?)", []string{models.PurchaseSuggestionStatus_Ready, models.PurchaseSuggestionStatus_InProgress}).
@@ -35,13 +36,14 @@ func (prc *PurchaseRequisitionController) GetNewPRInfo(c *gin.Context) {
 	}
 
 	var poRefs []models.PORef
-	if err := prc.DB.Find(&poRefs).Error; err != nil {
+	if err := prc.DB.WithContext(c).Find(&poRefs).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve poRefs"})
 		return
 	}
 	var categories []models.Category
 	if err := prc.
 		DB.
+		WithContext(c).
 		Preload("Materials.Sums").
 		Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve categories"})
@@ -58,7 +60,7 @@ func (prc *PurchaseRequisitionController) GetNewPRInfo(c *gin.Context) {
 	resp.Categories = categories
 	resp.PurchaseSuggestions = purchaseSuggestions
 	resp.PORefs = poRefs
-	if err := prc.RequestSlug(&resp.Slug, prc.DB, "purchases"); err != nil {
+	if err := prc.RequestSlug(&resp.Slug, prc.DB.WithContext(c), "purchases"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Slug", "detail": err.Error()})
 		return
 	}
@@ -73,7 +75,7 @@ func (prc *PurchaseRequisitionController) CreatePurchaseRequisition(c *gin.Conte
 		return
 	}
 	var member models.Member
-	if err := prc.getUserDataByUserID(prc.DB, uid, &member); err != nil {
+	if err := prc.getUserDataByUserID(prc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -92,7 +94,7 @@ func (prc *PurchaseRequisitionController) CreatePurchaseRequisition(c *gin.Conte
 		return
 	}
 
-	if err := prc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := prc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		// create Purchase
 		purchase := models.Purchase{
 			Slug:        req.PR.Slug,
@@ -126,6 +128,14 @@ func (prc *PurchaseRequisitionController) CreatePurchaseRequisition(c *gin.Conte
 			if err := tx.Create(&prm).Error; err != nil {
 				return err
 			}
+			purchase.PurchaseMaterials = append(purchase.PurchaseMaterials, prm)
+		}
+		doc, err := purchaseApprovalDocument(tx, &purchase)
+		if err != nil {
+			return err
+		}
+		if err := openApprovalRequest(tx, doc); err != nil {
+			return err
 		}
 
 		// create notifications
@@ -162,6 +172,7 @@ func (prc *PurchaseRequisitionController) GetPurchaseRequisition(c *gin.Context)
 	var purchaseRequisition models.Purchase
 	if err := prc.
 		DB.
+		WithContext(c).
 		Preload("PurchaseMaterials.Material.Sums").
 		Preload("PORefs").
 		Preload("CreatedBy").
@@ -179,6 +190,7 @@ func (prc *PurchaseRequisitionController) GetPurchaseRequisition(c *gin.Context)
 	var ivtMats []models.InventoryMaterial
 	if err := prc.
 		DB.
+		WithContext(c).
 		Joins("Receipt").
 		Where("po_ref_number IN (?)", poRefs).
 		Find(&ivtMats).Error; err != nil {
@@ -194,6 +206,7 @@ func (prc *PurchaseRequisitionController) GetPurchaseRequisition(c *gin.Context)
 	var transactions []models.InventoryMaterialTransaction
 	if err := prc.
 		DB.
+		WithContext(c).
 		Preload("InventoryMaterial.Receipt").
 		Where("inventory_material_id IN ?", ivtIDs).
 		Find(&transactions).Error; err != nil {
@@ -204,6 +217,7 @@ func (prc *PurchaseRequisitionController) GetPurchaseRequisition(c *gin.Context)
 	var categories []models.Category
 	if err := prc.
 		DB.
+		WithContext(c).
 		Preload("Materials.Sums").
 		Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve categories"})
@@ -217,6 +231,23 @@ func (prc *PurchaseRequisitionController) GetPurchaseRequisition(c *gin.Context)
 	})
 }
 
+// GetPurchaseRequisitionDocument renders a PurchaseRequisition as pdf or html.
+func (prc *PurchaseRequisitionController) GetPurchaseRequisitionDocument(c *gin.Context) {
+	var purchaseRequisition models.Purchase
+	if err := prc.
+		DB.
+		WithContext(c).
+		Preload("PurchaseMaterials.Material").
+		Preload("PORefs").
+		Preload("CreatedBy").
+		First(&purchaseRequisition, "slug = ?", c.Param("slug")).
+		Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "PurchaseRequisition not found"})
+		return
+	}
+	prc.RenderDocument(c, documents.Purchase(&purchaseRequisition), c.Param("format"))
+}
+
 func (pc *PurchaseRequisitionController) GetAllPurchaseRequisition(c *gin.Context) {
 	var uid uint
 	if err := pc.GetUserID(c, &uid); err != nil {
@@ -224,14 +255,14 @@ func (pc *PurchaseRequisitionController) GetAllPurchaseRequisition(c *gin.Contex
 		return
 	}
 	var member models.Member
-	if err := pc.getUserDataByUserID(pc.DB, uid, &member); err != nil {
+	if err := pc.getUserDataByUserID(pc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	canFindAll := member.Role == models.ROLE_Admin || member.Role == models.ROLE_Manager
 	var ps []models.Purchase
-	q := pc.DB.
+	q := pc.DB.WithContext(c).
 		Preload("PurchaseMaterials.Material.Category").
 		Preload("CreatedBy")
 	if canFindAll {
@@ -260,15 +291,15 @@ func (prc *PurchaseRequisitionController) UpdatePurchaseRequisition(c *gin.Conte
 	}
 
 	var purchaseRequisition models.Purchase
-	if err := prc.DB.Preload("PurchaseMaterials.Material.Category").First(&purchaseRequisition, prID).Error; err != nil {
+	if err := prc.DB.WithContext(c).Preload("PurchaseMaterials.Material.Category").First(&purchaseRequisition, prID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "PurchaseRequisition not found"})
 		return
 	}
 
 	purchaseRequisition.Notes = request.Notes
 
-	if err := prc.DB.Transaction(func(tx *gorm.DB) error {
-		if err := prc.DB.Save(&purchaseRequisition).Error; err != nil {
+	if err := prc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := prc.DB.WithContext(c).Save(&purchaseRequisition).Error; err != nil {
 			return err
 		}
 
@@ -304,12 +335,17 @@ func (prc *PurchaseRequisitionController) DeletePurchaseRequisition(c *gin.Conte
 	id := c.Param("id")
 
 	var purchaseRequisition models.Purchase
-	if err := prc.DB.First(&purchaseRequisition, id).Error; err != nil {
+	if err := prc.DB.WithContext(c).First(&purchaseRequisition, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "PurchaseRequisition not found"})
 		return
 	}
 
-	if err := prc.DB.Delete(&purchaseRequisition).Error; err != nil {
+	if err := prc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := dropApprovalRequests(tx, models.ApprovalDocumentType_Purchase, purchaseRequisition.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&purchaseRequisition).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete PurchaseRequisition"})
 		return
 	}
@@ -322,7 +358,7 @@ func (prc *PurchaseRequisitionController) ApprovePurchaseRequisition(c *gin.Cont
 	slug := c.Param("slug")
 	mainInventoryID := uint(1)
 	var purchaseRequisition models.Purchase
-	if err := prc.DB.
+	if err := prc.DB.WithContext(c).
 		Preload("PurchaseMaterials.Material.Sums", "inventory_id = ?", mainInventoryID).
 		Preload("PORefs").
 		Preload("CreatedBy").
@@ -330,10 +366,145 @@ func (prc *PurchaseRequisitionController) ApprovePurchaseRequisition(c *gin.Cont
 		c.JSON(http.StatusNotFound, gin.H{"error": "PurchaseRequisition not found"})
 		return
 	}
-	purchaseRequisition.IsApprove = true
-	if err := prc.DB.Save(&purchaseRequisition).Error; err != nil {
+	if purchaseRequisition.IsApprove {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PurchaseRequisition already approved"})
+		return
+	}
+	if purchaseRequisition.IsRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PurchaseRequisition was rejected"})
+		return
+	}
+
+	var uid uint
+	if err := prc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := prc.getUserDataByUserID(prc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, err := purchaseApprovalDocument(prc.DB.WithContext(c), &purchaseRequisition)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get materials"})
+		return
+	}
+	step := approveOrRespond(c, prc.DB.WithContext(c), doc, &member)
+	if step == nil {
+		return
+	}
+
+	if err := prc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := recordApproval(tx, step, &member, c.Query("comment")); err != nil {
+			return err
+		}
+		purchaseRequisition.IsApprove = true
+		return tx.Omit("PurchaseMaterials", "PORefs", "CreatedBy").Save(&purchaseRequisition).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve PurchaseRequisition"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "PurchaseRequisition approved successfully"})
 }
+
+// adjustPOReceivedQty adds delta to the received quantity of a material on the purchases of a PO,
+// filling purchases in order and taking returns back from the latest one.
+func adjustPOReceivedQty(tx *gorm.DB, poRefNumber string, materialID uint, delta int64) error {
+	if poRefNumber == "" || delta == 0 {
+		return nil
+	}
+	var purchaseMaterials []models.PurchaseMaterial
+	if err := tx.
+		Where("material_id = ?", materialID).
+		Where("purchase_id IN (?)", tx.
+			Table("purchase_po_refs").
+			Select("purchase_id").
+			Where("po_ref_id IN (?)", tx.
+				Model(&models.PORef{}).
+				Select("id").
+				Where("slug = ?", poRefNumber))).
+		Order("id asc").
+		Find(&purchaseMaterials).Error; err != nil {
+		return err
+	}
+	if len(purchaseMaterials) == 0 {
+		return nil
+	}
+
+	changed := make(map[int]bool)
+	if delta > 0 {
+		for i := range purchaseMaterials {
+			pm := &purchaseMaterials[i]
+			open := pm.Quantity - pm.ReceivedQty
+			if i == len(purchaseMaterials)-1 || open >= delta {
+				open = delta
+			}
+			if open <= 0 {
+				continue
+			}
+			pm.ReceivedQty += open
+			delta -= open
+			changed[i] = true
+			if delta == 0 {
+				break
+			}
+		}
+	} else {
+		for i := len(purchaseMaterials) - 1; i >= 0 && delta < 0; i-- {
+			pm := &purchaseMaterials[i]
+			back := -delta
+			if back > pm.ReceivedQty {
+				back = pm.ReceivedQty
+			}
+			if back == 0 {
+				continue
+			}
+			pm.ReceivedQty -= back
+			delta += back
+			changed[i] = true
+		}
+	}
+
+	for i := range changed {
+		pm := purchaseMaterials[i]
+		if err := tx.Model(&pm).Update("received_qty", pm.ReceivedQty).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purchaseApprovalDocument describes a purchase requisition and its lines for approval rule
+// matching, lines are priced at the default price of their material.
+func purchaseApprovalDocument(db *gorm.DB, purchase *models.Purchase) (approvalDocument, error) {
+	doc := approvalDocument{
+		Type:        models.ApprovalDocumentType_Purchase,
+		ID:          purchase.ID,
+		RequestedBy: &purchase.CreatedByID,
+	}
+	var materialIDs []uint
+	for _, pm := range purchase.PurchaseMaterials {
+		materialIDs = append(materialIDs, pm.MaterialID)
+	}
+	if len(materialIDs) == 0 {
+		return doc, nil
+	}
+	var materials []models.Material
+	if err := db.Where("id IN ?", materialIDs).Find(&materials).Error; err != nil {
+		return doc, err
+	}
+	byID := make(map[uint]models.Material, len(materials))
+	for _, m := range materials {
+		byID[m.ID] = m
+	}
+	for _, pm := range purchase.PurchaseMaterials {
+		material := byID[pm.MaterialID]
+		doc.Amount += pm.Quantity * material.DefaultPrice / 100
+		if !containsUint(doc.CategoryIDs, material.CategoryID) {
+			doc.CategoryIDs = append(doc.CategoryIDs, material.CategoryID)
+		}
+	}
+	return doc, nil
+}
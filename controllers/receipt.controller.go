@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"daijai/documents"
 	"daijai/models"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -30,7 +32,7 @@ func (rc *ReceiptController) GetNewReceiptInfo(c *gin.Context) {
 	}
 
 	var categories []models.Category
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Preload("Materials.Sums").
 		Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Categories"})
@@ -43,6 +45,7 @@ func (rc *ReceiptController) GetNewReceiptInfo(c *gin.Context) {
 	var prs []models.Purchase
 	if err := rc.
 		DB.
+		WithContext(c).
 		Where("is_approve = ?", true).
 		Find(&prs).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve purchase requisitions"})
@@ -52,14 +55,14 @@ func (rc *ReceiptController) GetNewReceiptInfo(c *gin.Context) {
 
 	// get all inventories
 	var inventories []models.Inventory
-	if err := rc.DB.Find(&inventories).Error; err != nil {
+	if err := rc.DB.WithContext(c).Find(&inventories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve inventories"})
 		return
 	}
 	response.Inventories = inventories
 
 	var slug string
-	if err := rc.RequestSlug(&slug, rc.DB, "receipts"); err != nil {
+	if err := rc.RequestSlug(&slug, rc.DB.WithContext(c), "receipts"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Slug", "detail": err.Error()})
 		return
 	}
@@ -75,7 +78,7 @@ func (rc *ReceiptController) GetEditReceiptInfo(c *gin.Context) {
 	}
 
 	var categories []models.Category
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Preload("Materials.Sums").
 		Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Categories"})
@@ -86,7 +89,7 @@ func (rc *ReceiptController) GetEditReceiptInfo(c *gin.Context) {
 
 	slug := c.Param("slug")
 	var receipt models.Receipt
-	if err := rc.DB.
+	if err := rc.DB.WithContext(c).
 		Preload("ReceiptMaterials.Material").
 		Preload("ReceiptMaterials.Material.Category").
 		Where("slug = ?", slug).
@@ -108,7 +111,7 @@ func (rc *ReceiptController) CreateReceipt(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := rc.getUserDataByUserID(rc.DB, uid, &member); err != nil {
+	if err := rc.getUserDataByUserID(rc.DB.WithContext(c), uid, &member); err != nil {
 		rc.LogErrorAndSendBadRequest(c, err.Error())
 		return
 	}
@@ -120,10 +123,17 @@ func (rc *ReceiptController) CreateReceipt(c *gin.Context) {
 	}
 
 	request.CreatedByID = member.ID
-	if err := rc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&request).Error; err != nil {
 			return err
 		}
+		doc, err := receiptApprovalDocument(tx, &request)
+		if err != nil {
+			return err
+		}
+		if err := openApprovalRequest(tx, doc); err != nil {
+			return err
+		}
 
 		title := fmt.Sprintf("Receipt was create by %s", member.FullName)
 		subtitle := fmt.Sprintf("please check PR %s to see more details", request.Slug)
@@ -162,7 +172,7 @@ func (rc *ReceiptController) ApproveReceipt(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := rc.getUserDataByUserID(rc.DB, uid, &member); err != nil {
+	if err := rc.getUserDataByUserID(rc.DB.WithContext(c), uid, &member); err != nil {
 		rc.LogErrorAndSendBadRequest(c, err.Error())
 		return
 	}
@@ -171,6 +181,7 @@ func (rc *ReceiptController) ApproveReceipt(c *gin.Context) {
 	var receipt models.Receipt
 	if err := rc.
 		DB.
+		WithContext(c).
 		Preload("ReceiptMaterials").
 		Preload("CreatedBy").
 		First(&receipt, id).Error; err != nil {
@@ -182,8 +193,90 @@ func (rc *ReceiptController) ApproveReceipt(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt already approved"})
 		return
 	}
+	if receipt.IsRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt was rejected"})
+		return
+	}
+	if err := checkScanSession(rc.DB.WithContext(c), "receipt_id", receipt.ID, receiptScanLines(&receipt)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// put-away bins must belong to the receiving inventory
+	for _, v := range receipt.ReceiptMaterials {
+		if v.LocationID == nil {
+			continue
+		}
+		var location models.InventoryLocation
+		if err := rc.DB.WithContext(c).First(&location, *v.LocationID).Error; err != nil || location.InventoryID != receipt.InventoryID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid put-away location", "receiptMaterialID": v.ID})
+			return
+		}
+	}
+
+	// serial tracked lines need one new serial per unit
+	var materialIDs []uint
+	for _, v := range receipt.ReceiptMaterials {
+		materialIDs = append(materialIDs, v.MaterialID)
+	}
+	var trackedIDs []uint
+	if err := rc.DB.WithContext(c).Model(&models.Material{}).Where("id IN ? AND is_serial_tracked = ?", materialIDs, true).Pluck("id", &trackedIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get materials"})
+		return
+	}
+	tracked := make(map[uint]bool)
+	for _, id := range trackedIDs {
+		tracked[id] = true
+	}
+	receivedSerials := make(map[uint]map[string]bool)
+	for _, v := range receipt.ReceiptMaterials {
+		if !tracked[v.MaterialID] {
+			if len(v.Serials) > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Material is not serial tracked", "receiptMaterialID": v.ID})
+				return
+			}
+			continue
+		}
+		if int64(len(v.Serials))*models.SerialQuantity != v.Quantity {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "One serial per unit is required", "receiptMaterialID": v.ID})
+			return
+		}
+		if receivedSerials[v.MaterialID] == nil {
+			receivedSerials[v.MaterialID] = make(map[string]bool)
+		}
+		for _, sn := range v.Serials {
+			if sn == "" || receivedSerials[v.MaterialID][sn] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or duplicated serial", "serial": sn})
+				return
+			}
+			receivedSerials[v.MaterialID][sn] = true
+		}
+		var count int64
+		if err := rc.DB.WithContext(c).Model(&models.MaterialSerial{}).Where("material_id = ? AND serial_number IN ?", v.MaterialID, v.Serials).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check serials"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Serial already exists", "receiptMaterialID": v.ID})
+			return
+		}
+	}
+
+	doc, err := receiptApprovalDocument(rc.DB.WithContext(c), &receipt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get materials"})
+		return
+	}
+	step := approveOrRespond(c, rc.DB.WithContext(c), doc, &member)
+	if step == nil {
+		return
+	}
+
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := recordApproval(tx, step, &member, c.Query("comment")); err != nil {
+			return err
+		}
 
-	if err := rc.DB.Transaction(func(tx *gorm.DB) error {
 		// create PORef
 		poRef := models.PORef{
 			Slug: receipt.PORefNumber,
@@ -194,27 +287,66 @@ func (rc *ReceiptController) ApproveReceipt(c *gin.Context) {
 
 		matQuantity := make(map[uint]int64)
 		matInventoryId := make(map[uint]uint)
+		var rejected []models.SupplierReturnMaterial
 		for _, v := range receipt.ReceiptMaterials {
+			// lines approved without inspection are accepted in full
+			if !v.IsInspected() {
+				v.InspectionStatus = models.ReceiptInspectionStatus_Accepted
+				v.AcceptedQty = v.Quantity
+			}
+			if v.RejectedQty > 0 {
+				receiptMaterialID := v.ID
+				rejected = append(rejected, models.SupplierReturnMaterial{
+					ReceiptMaterialID: &receiptMaterialID,
+					MaterialID:        v.MaterialID,
+					Quantity:          v.RejectedQty,
+					Reason:            v.RejectReason,
+					Serials:           v.RejectedSerials,
+				})
+			}
+
 			// update receipt material
 			v.IsApproved = true
 			if err := tx.Save(&v).Error; err != nil {
 				return err
 			}
+			if v.AcceptedQty == 0 {
+				continue
+			}
 
 			// create inventory material
 			var inventoryMaterial models.InventoryMaterial
 			inventoryMaterial.MaterialID = v.MaterialID
 			inventoryMaterial.InventoryID = receipt.InventoryID
 			inventoryMaterial.ReceiptID = &receipt.ID
-			inventoryMaterial.Quantity = v.Quantity
+			inventoryMaterial.Quantity = v.AcceptedQty
 			inventoryMaterial.Reserve = 0
-			inventoryMaterial.AvailableQty = v.Quantity
+			inventoryMaterial.AvailableQty = v.AcceptedQty
 			inventoryMaterial.IsOutOfStock = false
 			inventoryMaterial.Price = v.Price
+			inventoryMaterial.InventoryMaterialType = models.InventoryMaterialType_Receipt
+			// put away into the bin chosen on the receipt line
+			inventoryMaterial.LocationID = v.LocationID
+			inventoryMaterial.ExpiryDate = v.ExpiryDate
+			inventoryMaterial.ManufactureDate = v.ManufactureDate
 			if err := tx.Save(&inventoryMaterial).Error; err != nil {
 				return err
 			}
 
+			for _, sn := range v.AcceptedSerials() {
+				serial := models.MaterialSerial{
+					MaterialID:          v.MaterialID,
+					SerialNumber:        sn,
+					Status:              models.MaterialSerialStatus_InStock,
+					ReceiptID:           &receipt.ID,
+					InventoryID:         &receipt.InventoryID,
+					InventoryMaterialID: &inventoryMaterial.ID,
+				}
+				if err := tx.Create(&serial).Error; err != nil {
+					return err
+				}
+			}
+
 			// count
 			var counter struct {
 				Quantity   int64
@@ -234,8 +366,12 @@ func (rc *ReceiptController) ApproveReceipt(c *gin.Context) {
 			// update sum material inventory
 			rc.SumMaterial(tx, "receipt", v.MaterialID, receipt.InventoryID)
 
+			if err := adjustPOReceivedQty(tx, receipt.PORefNumber, v.MaterialID, v.AcceptedQty); err != nil {
+				return err
+			}
+
 			// assign hashmap
-			matQuantity[v.MaterialID] = v.Quantity
+			matQuantity[v.MaterialID] = v.AcceptedQty
 			matInventoryId[v.MaterialID] = inventoryMaterial.ID
 
 			// create inventory materail transaction
@@ -255,6 +391,21 @@ func (rc *ReceiptController) ApproveReceipt(c *gin.Context) {
 			}
 		}
 
+		// rejected quantity goes back to the supplier
+		if len(rejected) > 0 {
+			supplierReturn := models.SupplierReturn{
+				Source:                  models.SupplierReturnSource_Inspection,
+				ReceiptID:               receipt.ID,
+				PORefNumber:             receipt.PORefNumber,
+				Notes:                   "rejected on inspection",
+				CreatedByID:             member.ID,
+				SupplierReturnMaterials: rejected,
+			}
+			if err := createSupplierReturn(tx, &rc.BaseController, &supplierReturn); err != nil {
+				return err
+			}
+		}
+
 		// update receipt status and approved by
 		receipt.IsApproved = true
 		receipt.ApprovedByID = &member.ID
@@ -429,6 +580,122 @@ func (rc *ReceiptController) ApproveReceipt(c *gin.Context) {
 // }
 // }
 
+// InspectReceipt records the accepted and rejected quantity of receipt lines before approval,
+// only the accepted quantity is put into stock and the rest is returned to the supplier.
+func (rc *ReceiptController) InspectReceipt(c *gin.Context) {
+	var uid uint
+	if err := rc.GetUserID(c, &uid); err != nil {
+		rc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := rc.getUserDataByUserID(rc.DB.WithContext(c), uid, &member); err != nil {
+		rc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var request struct {
+		Lines []struct {
+			ReceiptMaterialID uint     `json:"ReceiptMaterialID" binding:"required"`
+			AcceptedQty       int64    `json:"AcceptedQty"`
+			RejectReason      string   `json:"RejectReason"`
+			RejectedSerials   []string `json:"RejectedSerials"`
+		} `json:"Lines" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		rc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var receipt models.Receipt
+	if err := rc.
+		DB.
+		WithContext(c).
+		Preload("ReceiptMaterials.Material").
+		First(&receipt, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	if receipt.IsApproved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt already approved"})
+		return
+	}
+
+	lines := make(map[uint]*models.ReceiptMaterial)
+	for i := range receipt.ReceiptMaterials {
+		lines[receipt.ReceiptMaterials[i].ID] = &receipt.ReceiptMaterials[i]
+	}
+
+	now := time.Now()
+	for _, l := range request.Lines {
+		v, ok := lines[l.ReceiptMaterialID]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt material not in receipt", "receiptMaterialID": l.ReceiptMaterialID})
+			return
+		}
+		if l.AcceptedQty < 0 || l.AcceptedQty > v.Quantity {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Accepted quantity is out of range", "receiptMaterialID": v.ID})
+			return
+		}
+		rejectedQty := v.Quantity - l.AcceptedQty
+		if rejectedQty > 0 && l.RejectReason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reject reason is required", "receiptMaterialID": v.ID})
+			return
+		}
+
+		// serial tracked lines name the rejected units
+		if v.Material.IsSerialTracked {
+			if int64(len(l.RejectedSerials))*models.SerialQuantity != rejectedQty {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "One rejected serial per rejected unit is required", "receiptMaterialID": v.ID})
+				return
+			}
+			received := make(map[string]bool)
+			for _, sn := range v.Serials {
+				received[sn] = true
+			}
+			for _, sn := range l.RejectedSerials {
+				if !received[sn] {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Rejected serial is not on the receipt line", "serial": sn})
+					return
+				}
+				delete(received, sn)
+			}
+		} else if len(l.RejectedSerials) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Material is not serial tracked", "receiptMaterialID": v.ID})
+			return
+		}
+
+		v.AcceptedQty = l.AcceptedQty
+		v.RejectedQty = rejectedQty
+		v.RejectReason = l.RejectReason
+		v.RejectedSerials = l.RejectedSerials
+		switch {
+		case rejectedQty == 0:
+			v.InspectionStatus = models.ReceiptInspectionStatus_Accepted
+			v.RejectReason = ""
+		case l.AcceptedQty == 0:
+			v.InspectionStatus = models.ReceiptInspectionStatus_Rejected
+		default:
+			v.InspectionStatus = models.ReceiptInspectionStatus_Partial
+		}
+		v.InspectedByID = &member.ID
+		v.InspectedAt = &now
+	}
+
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		for _, l := range request.Lines {
+			if err := tx.Omit("Material").Save(lines[l.ReceiptMaterialID]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to inspect Receipt"})
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
 func (rc *ReceiptController) GetAllReceipts(c *gin.Context) {
 	var uid uint
 	if err := rc.GetUserID(c, &uid); err != nil {
@@ -436,13 +703,13 @@ func (rc *ReceiptController) GetAllReceipts(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := rc.getUserDataByUserID(rc.DB, uid, &member); err != nil {
+	if err := rc.getUserDataByUserID(rc.DB.WithContext(c), uid, &member); err != nil {
 		rc.LogErrorAndSendBadRequest(c, err.Error())
 		return
 	}
 
 	var receipts []models.Receipt
-	q := rc.DB.
+	q := rc.DB.WithContext(c).
 		Preload("ReceiptMaterials.Material").
 		Preload("CreatedBy").
 		Preload("ApprovedBy")
@@ -468,6 +735,7 @@ func (rc *ReceiptController) GetReceiptBySlug(c *gin.Context) {
 	var receipt models.Receipt
 	if err := rc.
 		DB.
+		WithContext(c).
 		Preload("ReceiptMaterials.Material.Category").
 		Preload("CreatedBy").
 		Preload("ApprovedBy").
@@ -480,6 +748,7 @@ func (rc *ReceiptController) GetReceiptBySlug(c *gin.Context) {
 	var inventoryMaterials []models.InventoryMaterial
 	if err := rc.
 		DB.
+		WithContext(c).
 		Preload("Material").
 		Preload("Inventory").
 		Preload("Transactions.Order.Drawing").
@@ -493,6 +762,24 @@ func (rc *ReceiptController) GetReceiptBySlug(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"receipt": receipt, "inventoryMaterials": inventoryMaterials})
 }
 
+// GetReceiptDocument renders a receipt as pdf or html.
+func (rc *ReceiptController) GetReceiptDocument(c *gin.Context) {
+	var receipt models.Receipt
+	if err := rc.
+		DB.
+		WithContext(c).
+		Preload("ReceiptMaterials.Material").
+		Preload("Inventory").
+		Preload("Recipient").
+		Preload("CreatedBy").
+		Preload("ApprovedBy").
+		First(&receipt, "slug = ?", c.Param("slug")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	rc.RenderDocument(c, documents.Receipt(&receipt), c.Param("format"))
+}
+
 // UpdateReceipt updates a Receipt by ID.
 func (rc *ReceiptController) UpdateReceipt(c *gin.Context) {
 	slug := c.Param("slug")
@@ -500,6 +787,7 @@ func (rc *ReceiptController) UpdateReceipt(c *gin.Context) {
 	var receipt models.Receipt
 	if err := rc.
 		DB.
+		WithContext(c).
 		Where("slug = ?", slug).
 		Preload("ReceiptMaterials").
 		First(&receipt).Error; err != nil {
@@ -518,15 +806,15 @@ func (rc *ReceiptController) UpdateReceipt(c *gin.Context) {
 		return
 	}
 
-	if err := rc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		receipt.Notes = request.Notes
 		receipt.PORefNumber = request.PORefNumber
-		if err := rc.DB.Save(&receipt).Error; err != nil {
+		if err := rc.DB.WithContext(c).Save(&receipt).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update Receipt"})
 			return err
 		}
 		for _, v := range receipt.ReceiptMaterials {
-			if err := rc.DB.Delete(&v).Error; err != nil {
+			if err := rc.DB.WithContext(c).Delete(&v).Error; err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update Receipt"})
 				return err
 			}
@@ -534,13 +822,17 @@ func (rc *ReceiptController) UpdateReceipt(c *gin.Context) {
 
 		for _, v := range request.ReceiptMaterials {
 			receiptMaterial := models.ReceiptMaterial{
-				ReceiptID:  receipt.ID,
-				MaterialID: v.MaterialID,
-				Quantity:   v.Quantity,
-				Price:      v.Price,
-				IsApproved: v.IsApproved,
+				ReceiptID:       receipt.ID,
+				MaterialID:      v.MaterialID,
+				Quantity:        v.Quantity,
+				Price:           v.Price,
+				IsApproved:      v.IsApproved,
+				LocationID:      v.LocationID,
+				ExpiryDate:      v.ExpiryDate,
+				ManufactureDate: v.ManufactureDate,
+				Serials:         v.Serials,
 			}
-			if err := rc.DB.Save(&receiptMaterial).Error; err != nil {
+			if err := rc.DB.WithContext(c).Save(&receiptMaterial).Error; err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update Receipt"})
 				return err
 			}
@@ -556,20 +848,213 @@ func (rc *ReceiptController) UpdateReceipt(c *gin.Context) {
 	c.JSON(http.StatusOK, request)
 }
 
+// ReverseReceipt undoes an approved receipt by taking the remaining stock of its lots out
+// with compensating transactions. Lots that are reserved, withdrawn or loaned block the reversal,
+// quantity already returned to the supplier or written off stays as it is.
+func (rc *ReceiptController) ReverseReceipt(c *gin.Context) {
+	var uid uint
+	if err := rc.GetUserID(c, &uid); err != nil {
+		rc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+	var member models.Member
+	if err := rc.getUserDataByUserID(rc.DB.WithContext(c), uid, &member); err != nil {
+		rc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var request struct {
+		Reason string `json:"Reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		rc.LogErrorAndSendBadRequest(c, err.Error())
+		return
+	}
+
+	var receipt models.Receipt
+	if err := rc.DB.WithContext(c).First(&receipt, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	if !receipt.IsApproved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt is not approved"})
+		return
+	}
+	if receipt.IsReversed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt already reversed"})
+		return
+	}
+
+	var lots []models.InventoryMaterial
+	if err := rc.DB.WithContext(c).Where("receipt_id = ?", receipt.ID).Order("id asc").Find(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lots"})
+		return
+	}
+	if len(lots) > 0 {
+		// shipped stock keeps the receipt but is never available, its transfer order settles it first
+		var virtualIDs []uint
+		if err := rc.DB.WithContext(c).Model(&models.Inventory{}).Where("is_virtual = ?", true).Pluck("id", &virtualIDs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventories"})
+			return
+		}
+		var lotIDs []uint
+		for _, lot := range lots {
+			lotIDs = append(lotIDs, lot.ID)
+			for _, id := range virtualIDs {
+				if lot.InventoryID == id && lot.Quantity > 0 {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"error":     "Receipt stock is in transit, receive or close the transfer order before reversing",
+						"lotNumber": models.LotNumber(lot.ID),
+					})
+					return
+				}
+			}
+		}
+		var openTransfers int64
+		if err := rc.
+			DB.
+			WithContext(c).
+			Model(&models.TransferOrderLot{}).
+			Where("source_lot_id IN ? OR transit_lot_id IN ?", lotIDs, lotIDs).
+			Where("quantity > received_qty + discrepancy_qty").
+			Count(&openTransfers).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer orders"})
+			return
+		}
+		if openTransfers > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt stock is on an open transfer order, receive or close it before reversing"})
+			return
+		}
+	}
+	// withdrawn stock stays issued, only what is left on the shelf is reversed
+	var remaining int64
+	for _, lot := range lots {
+		if lot.Reserve > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":     "Receipt stock is reserved, release it before reversing",
+				"lotNumber": models.LotNumber(lot.ID),
+				"reserve":   lot.Reserve,
+			})
+			return
+		}
+		remaining += lot.AvailableQty
+	}
+	if remaining == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing of the receipt is left to reverse"})
+		return
+	}
+
+	now := time.Now()
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		reversedQty := make(map[uint]int64)
+		sums := make(map[[2]uint]bool)
+		for _, lot := range lots {
+			if lot.AvailableQty == 0 {
+				continue
+			}
+			qty := lot.AvailableQty
+			existingQty := lot.Quantity
+			lot.Quantity -= qty
+			lot.AvailableQty = 0
+			lot.IsOutOfStock = true
+			if err := tx.Save(&lot).Error; err != nil {
+				return err
+			}
+
+			matTr := models.InventoryMaterialTransaction{
+				InventoryMaterialID:      lot.ID,
+				Quantity:                 qty,
+				InventoryType:            models.InventoryType_OUTGOING,
+				InventoryTypeDescription: models.InventoryTypeDescription_RECEIPT_REVERSAL,
+				ExistingQuantity:         existingQty,
+				ExistingReserve:          lot.Reserve,
+				UpdatedQuantity:          lot.Quantity,
+				UpdatedReserve:           lot.Reserve,
+				ReceiptID:                &receipt.ID,
+			}
+			if err := tx.Create(&matTr).Error; err != nil {
+				return err
+			}
+
+			// transferred and moved lots keep the receipt and are reversed where they are
+			reversedQty[lot.MaterialID] += qty
+			sums[[2]uint{lot.MaterialID, lot.InventoryID}] = true
+		}
+
+		// serials of the receipt were never received, they can be received again
+		if err := tx.
+			Unscoped().
+			Where("receipt_id = ? AND status = ?", receipt.ID, models.MaterialSerialStatus_InStock).
+			Delete(&models.MaterialSerial{}).Error; err != nil {
+			return err
+		}
+
+		for key := range sums {
+			if err := rc.SumMaterial(tx, "receipt-reversal", key[0], key[1]); err != nil {
+				return err
+			}
+		}
+		for matID, qty := range reversedQty {
+			if err := adjustPOReceivedQty(tx, receipt.PORefNumber, matID, -qty); err != nil {
+				return err
+			}
+		}
+
+		receipt.IsReversed = true
+		receipt.ReversedAt = &now
+		receipt.ReversedByID = &member.ID
+		receipt.ReverseReason = request.Reason
+		return tx.Save(&receipt).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reverse Receipt", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
 // DeleteReceipt deletes a Receipt by ID.
 func (rc *ReceiptController) DeleteReceipt(c *gin.Context) {
 	id := c.Param("id")
 
 	var receipt models.Receipt
-	if err := rc.DB.First(&receipt, id).Error; err != nil {
+	if err := rc.DB.WithContext(c).First(&receipt, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
 		return
 	}
 
-	if err := rc.DB.Delete(&receipt).Error; err != nil {
+	if receipt.IsApproved && !receipt.IsReversed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt is approved, reverse it before deleting"})
+		return
+	}
+
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := dropApprovalRequests(tx, models.ApprovalDocumentType_Receipt, receipt.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&receipt).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete Receipt"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Receipt deleted successfully"})
 }
+
+// receiptApprovalDocument describes a receipt and its lines for approval rule matching.
+func receiptApprovalDocument(db *gorm.DB, receipt *models.Receipt) (approvalDocument, error) {
+	doc := approvalDocument{
+		Type:        models.ApprovalDocumentType_Receipt,
+		ID:          receipt.ID,
+		RequestedBy: &receipt.CreatedByID,
+	}
+	var materialIDs []uint
+	for _, v := range receipt.ReceiptMaterials {
+		doc.Amount += v.Quantity * v.Price / 100
+		materialIDs = append(materialIDs, v.MaterialID)
+	}
+	if len(materialIDs) == 0 {
+		return doc, nil
+	}
+	err := db.Model(&models.Material{}).Where("id IN ?", materialIDs).Distinct().Pluck("category_id", &doc.CategoryIDs).Error
+	return doc, err
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"daijai/middlewares"
+	"daijai/models"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RoleController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewRoleController(db *gorm.DB) *RoleController {
+	return &RoleController{
+		DB: db,
+	}
+}
+
+type roleRequest struct {
+	Name            string                   `json:"Name" binding:"required"`
+	Description     string                   `json:"Description"`
+	IsProjectScoped *bool                    `json:"IsProjectScoped"` // left out keeps the scope of the role
+	Permissions     []middlewares.Permission `json:"Permissions"`
+}
+
+func (rc *RoleController) GetRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := rc.DB.WithContext(c).Preload("Permissions").Order("name asc").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func (rc *RoleController) GetRole(c *gin.Context) {
+	var role models.Role
+	if err := rc.DB.WithContext(c).Preload("Permissions").First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// get every permission that can be assigned to a role
+func (rc *RoleController) GetPermissionCatalog(c *gin.Context) {
+	c.JSON(http.StatusOK, middlewares.PermissionCatalog())
+}
+
+func (rc *RoleController) CreateRole(c *gin.Context) {
+	var request roleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role := models.Role{Name: request.Name, Description: request.Description}
+	if request.IsProjectScoped != nil {
+		role.IsProjectScoped = *request.IsProjectScoped
+	}
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Role{}).Where("name = ?", role.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("role %s already exists", role.Name)
+		}
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+		return setRolePermissions(tx, &role, request.Permissions)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create role", "detail": err.Error()})
+		return
+	}
+	middlewares.InvalidatePermissions()
+
+	rc.DB.WithContext(c).Preload("Permissions").First(&role, role.ID)
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole replaces the description and permissions of a role. Renaming a custom role
+// renames it on its users too, system roles keep their names and built-in permissions.
+func (rc *RoleController) UpdateRole(c *gin.Context) {
+	var request roleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var role models.Role
+	if err := rc.DB.WithContext(c).First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if role.Name == models.ROLE_Admin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The admin role keeps every permission"})
+		return
+	}
+	if role.IsSystem && request.Name != role.Name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "System roles cannot be renamed"})
+		return
+	}
+	// the migrator gives system roles every permission of the built-in matrix
+	if role.IsSystem && request.Permissions != nil && !isMatrixPermissions(role.Name, request.Permissions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "System roles keep the built-in permissions, create a custom role to change them"})
+		return
+	}
+
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if request.Name != role.Name {
+			var count int64
+			if err := tx.Model(&models.Role{}).Where("name = ?", request.Name).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("role %s already exists", request.Name)
+			}
+			if err := tx.Model(&models.User{}).Where("role = ?", role.Name).Update("role", request.Name).Error; err != nil {
+				return err
+			}
+		}
+		updates := map[string]interface{}{
+			"name":        request.Name,
+			"description": request.Description,
+		}
+		if request.IsProjectScoped != nil {
+			updates["is_project_scoped"] = *request.IsProjectScoped
+		}
+		if err := tx.Model(&role).Updates(updates).Error; err != nil {
+			return err
+		}
+		if role.IsSystem {
+			return nil
+		}
+		return setRolePermissions(tx, &role, request.Permissions)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update role", "detail": err.Error()})
+		return
+	}
+	middlewares.InvalidatePermissions()
+
+	rc.DB.WithContext(c).Preload("Permissions").First(&role, role.ID)
+	c.JSON(http.StatusOK, role)
+}
+
+func (rc *RoleController) DeleteRole(c *gin.Context) {
+	var role models.Role
+	if err := rc.DB.WithContext(c).First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if role.IsSystem {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "System roles cannot be deleted"})
+		return
+	}
+	var count int64
+	if err := rc.DB.WithContext(c).Model(&models.User{}).Where("role = ?", role.Name).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count users of role"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Role is assigned to %d users", count)})
+		return
+	}
+
+	if err := rc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		// names are unique, a deleted role must not keep its name
+		return tx.Unscoped().Delete(&role).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	middlewares.InvalidatePermissions()
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// setRolePermissions replaces the permissions of a role.
+func setRolePermissions(tx *gorm.DB, role *models.Role, permissions []middlewares.Permission) error {
+	seen := make(map[middlewares.Permission]bool)
+	for _, p := range permissions {
+		if !middlewares.IsKnownPermission(p) {
+			return fmt.Errorf("unknown permission %s/%s", p.Resource, p.Action)
+		}
+		if seen[p] {
+			return fmt.Errorf("permission %s/%s is given twice", p.Resource, p.Action)
+		}
+		seen[p] = true
+	}
+
+	if err := tx.Unscoped().Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	for p := range seen {
+		if err := tx.Create(&models.RolePermission{RoleID: role.ID, Resource: p.Resource, Action: p.Action}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMatrixPermissions reports whether permissions are the ones the built-in matrix gives a role.
+func isMatrixPermissions(role string, permissions []middlewares.Permission) bool {
+	given := make(map[middlewares.Permission]bool)
+	for _, p := range permissions {
+		given[p] = true
+	}
+	matrix := 0
+	for p, roles := range middlewares.PermissionMatrix {
+		for _, r := range roles {
+			if r == role {
+				if !given[p] {
+					return false
+				}
+				matrix++
+				break
+			}
+		}
+	}
+	return matrix == len(given)
+}
+
+// checkRoleExists fails for a role name that is neither stored nor built in.
+func checkRoleExists(db *gorm.DB, name string) error {
+	for _, r := range models.SystemRoles {
+		if r == name {
+			return nil
+		}
+	}
+	var role models.Role
+	if err := db.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("unknown role %q", name)
+		}
+		return err
+	}
+	return nil
+}
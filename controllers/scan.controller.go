@@ -0,0 +1,437 @@
+package controllers
+
+import (
+	"daijai/models"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ScanController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewScanController(db *gorm.DB) *ScanController {
+	return &ScanController{
+		DB: db,
+	}
+}
+
+// start a scan session from a scanned receipt or withdrawal slug,
+// an open session of the same document is resumed
+func (sc *ScanController) StartSession(c *gin.Context) {
+	var uid uint
+	if err := sc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := sc.getUserDataByUserID(sc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		SessionType string `json:"SessionType" binding:"required"`
+		Slug        string `json:"Slug" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session := models.ScanSession{
+		SessionType: request.SessionType,
+		Status:      models.ScanSessionStatus_Open,
+		CreatedByID: member.ID,
+	}
+	var lines []models.ScanLine
+	switch request.SessionType {
+	case models.ScanSessionType_Receipt:
+		var receipt models.Receipt
+		if err := sc.DB.WithContext(c).Preload("ReceiptMaterials.Material").First(&receipt, "slug = ?", request.Slug).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+			return
+		}
+		if receipt.IsApproved {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt already approved"})
+			return
+		}
+		session.ReceiptID = &receipt.ID
+		lines = receiptScanLines(&receipt)
+	case models.ScanSessionType_Withdrawal:
+		var withdrawal models.Withdrawal
+		if err := sc.DB.WithContext(c).First(&withdrawal, "slug = ?", request.Slug).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found"})
+			return
+		}
+		var wapm models.WithdrawalApprovement
+		if err := sc.DB.WithContext(c).
+			Where("withdrawal_id = ? AND withdrawal_approvement_status = ?", withdrawal.ID, models.WithdrawalApprovementStatus_Pending).
+			Order("id desc").
+			First(&wapm).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal has no pending approvement"})
+			return
+		}
+		session.WithdrawalApprovementID = &wapm.ID
+		var err error
+		if lines, err = withdrawalScanLines(sc.DB.WithContext(c), &wapm); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build pick list", "detail": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session type"})
+		return
+	}
+
+	// resume the open session of the document
+	var existing models.ScanSession
+	q := sc.DB.WithContext(c).Where("status = ?", models.ScanSessionStatus_Open)
+	if session.ReceiptID != nil {
+		q = q.Where("receipt_id = ?", *session.ReceiptID)
+	} else {
+		q = q.Where("withdrawal_approvement_id = ?", *session.WithdrawalApprovementID)
+	}
+	if err := q.First(&existing).Error; err == nil {
+		sc.respondSession(c, http.StatusOK, existing.ID)
+		return
+	}
+
+	if len(lines) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to scan"})
+		return
+	}
+	session.Lines = lines
+	if err := sc.DB.WithContext(c).Create(&session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create scan session", "detail": err.Error()})
+		return
+	}
+	sc.respondSession(c, http.StatusCreated, session.ID)
+}
+
+// get scan session with lines and mismatches
+func (sc *ScanController) GetSession(c *gin.Context) {
+	var session models.ScanSession
+	if err := sc.DB.WithContext(c).First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan session not found"})
+		return
+	}
+	sc.respondSession(c, http.StatusOK, session.ID)
+}
+
+// scan a material slug (receipt) or lot code (withdrawal),
+// Quantity 0 takes the remaining quantity of the line
+func (sc *ScanController) Scan(c *gin.Context) {
+	var uid uint
+	if err := sc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := sc.getUserDataByUserID(sc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		Code     string `json:"Code" binding:"required"`
+		Quantity int64  `json:"Quantity"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request.Code = strings.TrimSpace(request.Code)
+	if request.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quantity"})
+		return
+	}
+
+	var session models.ScanSession
+	if err := sc.DB.WithContext(c).Preload("Lines").First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan session not found"})
+		return
+	}
+	if session.Status != models.ScanSessionStatus_Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scan session is " + session.Status})
+		return
+	}
+
+	var line *models.ScanLine
+	reason := ""
+	if session.SessionType == models.ScanSessionType_Receipt {
+		var material models.Material
+		if err := sc.DB.WithContext(c).First(&material, "slug = ?", request.Code).Error; err != nil {
+			reason = models.ScanMismatchReason_UnknownCode
+		} else {
+			for i := range session.Lines {
+				if session.Lines[i].MaterialID == material.ID {
+					line = &session.Lines[i]
+				}
+			}
+		}
+	} else {
+		lotID, ok := models.ParseLotCode(request.Code)
+		if !ok {
+			var material models.Material
+			if err := sc.DB.WithContext(c).First(&material, "slug = ?", request.Code).Error; err == nil {
+				reason = models.ScanMismatchReason_LotRequired
+			} else {
+				reason = models.ScanMismatchReason_UnknownCode
+			}
+		} else {
+			for i := range session.Lines {
+				if id := session.Lines[i].InventoryMaterialID; id != nil && *id == lotID {
+					line = &session.Lines[i]
+				}
+			}
+		}
+	}
+	if reason == "" && line == nil {
+		reason = models.ScanMismatchReason_NotExpected
+	}
+	if line != nil {
+		if request.Quantity == 0 {
+			request.Quantity = line.ExpectedQty - line.ScannedQty
+		}
+		if request.Quantity == 0 || line.ScannedQty+request.Quantity > line.ExpectedQty {
+			reason = models.ScanMismatchReason_OverQuantity
+		}
+	}
+
+	if reason != "" {
+		mismatch := models.ScanMismatch{
+			ScanSessionID: session.ID,
+			Code:          request.Code,
+			Quantity:      request.Quantity,
+			Reason:        reason,
+			CreatedByID:   member.ID,
+		}
+		if err := sc.DB.WithContext(c).Create(&mismatch).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log mismatch", "detail": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Scan rejected", "reason": reason, "code": request.Code})
+		return
+	}
+
+	// scanners of the same session add up, the line never goes over its expected quantity
+	result := sc.DB.WithContext(c).
+		Model(&models.ScanLine{}).
+		Where("id = ? AND scanned_qty + ? <= expected_qty", line.ID, request.Quantity).
+		Update("scanned_qty", gorm.Expr("scanned_qty + ?", request.Quantity))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save scan", "detail": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		mismatch := models.ScanMismatch{
+			ScanSessionID: session.ID,
+			Code:          request.Code,
+			Quantity:      request.Quantity,
+			Reason:        models.ScanMismatchReason_OverQuantity,
+			CreatedByID:   member.ID,
+		}
+		if err := sc.DB.WithContext(c).Create(&mismatch).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log mismatch", "detail": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Scan rejected", "reason": mismatch.Reason, "code": request.Code})
+		return
+	}
+	if err := sc.DB.WithContext(c).First(line, line.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scan line", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, line)
+}
+
+// confirm a session once every line is fully scanned
+func (sc *ScanController) ConfirmSession(c *gin.Context) {
+	var session models.ScanSession
+	if err := sc.DB.WithContext(c).Preload("Lines.Material").First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan session not found"})
+		return
+	}
+	if session.Status != models.ScanSessionStatus_Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scan session is " + session.Status})
+		return
+	}
+
+	var shortLines []models.ScanLine
+	for _, line := range session.Lines {
+		if line.ScannedQty != line.ExpectedQty {
+			shortLines = append(shortLines, line)
+		}
+	}
+	if len(shortLines) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not all quantities are scanned", "lines": shortLines})
+		return
+	}
+
+	now := time.Now()
+	session.Status = models.ScanSessionStatus_Confirmed
+	session.ConfirmedAt = &now
+	if err := sc.DB.WithContext(c).Omit("Lines").Save(&session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm scan session", "detail": err.Error()})
+		return
+	}
+	sc.respondSession(c, http.StatusOK, session.ID)
+}
+
+// cancel an open session, a new one starts the scan of the document over
+func (sc *ScanController) CancelSession(c *gin.Context) {
+	var session models.ScanSession
+	if err := sc.DB.WithContext(c).First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan session not found"})
+		return
+	}
+	if session.Status != models.ScanSessionStatus_Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scan session is " + session.Status})
+		return
+	}
+	session.Status = models.ScanSessionStatus_Cancelled
+	if err := sc.DB.WithContext(c).Save(&session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel scan session", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Scan session cancelled"})
+}
+
+// get mismatch log, ?type=receipt|withdrawal
+func (sc *ScanController) GetMismatches(c *gin.Context) {
+	var mismatches []models.ScanMismatch
+	q := sc.DB.WithContext(c).
+		Preload("CreatedBy").
+		Order("id desc")
+	if sessionType := c.Query("type"); sessionType != "" {
+		q = q.Where("scan_session_id IN (?)", sc.DB.WithContext(c).Model(&models.ScanSession{}).Select("id").Where("session_type = ?", sessionType))
+	}
+	if err := q.Find(&mismatches).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get mismatches"})
+		return
+	}
+	c.JSON(http.StatusOK, mismatches)
+}
+
+func (sc *ScanController) respondSession(c *gin.Context, status int, id uint) {
+	var session models.ScanSession
+	if err := sc.DB.WithContext(c).
+		Preload("Lines.Material").
+		Preload("Mismatches").
+		Preload("Receipt").
+		Preload("WithdrawalApprovement.Withdrawal").
+		Preload("CreatedBy").
+		First(&session, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan session not found"})
+		return
+	}
+	c.JSON(status, session)
+}
+
+// receiptScanLines returns one line per material of a receipt, lots do not exist before approval.
+func receiptScanLines(receipt *models.Receipt) []models.ScanLine {
+	var lines []models.ScanLine
+	index := make(map[uint]int)
+	for _, rm := range receipt.ReceiptMaterials {
+		if i, ok := index[rm.MaterialID]; ok {
+			lines[i].ExpectedQty += rm.Quantity
+			continue
+		}
+		index[rm.MaterialID] = len(lines)
+		lines = append(lines, models.ScanLine{
+			MaterialID:  rm.MaterialID,
+			ExpectedQty: rm.Quantity,
+		})
+	}
+	return lines
+}
+
+// withdrawalScanLines returns one line per picked lot of a withdrawal approvement.
+func withdrawalScanLines(db *gorm.DB, wapm *models.WithdrawalApprovement) ([]models.ScanLine, error) {
+	pickList, err := buildPickList(db, wapm)
+	if err != nil {
+		return nil, err
+	}
+	var lines []models.ScanLine
+	index := make(map[uint]int)
+	for _, pl := range pickList {
+		if i, ok := index[pl.InventoryMaterialID]; ok {
+			lines[i].ExpectedQty += pl.Quantity
+			continue
+		}
+		invMatID := pl.InventoryMaterialID
+		index[invMatID] = len(lines)
+		lines = append(lines, models.ScanLine{
+			MaterialID:          pl.MaterialID,
+			InventoryMaterialID: &invMatID,
+			LotNumber:           pl.LotNumber,
+			ExpectedQty:         pl.Quantity,
+		})
+	}
+	return lines, nil
+}
+
+// scanConfirmationRequired makes a confirmed scan mandatory for every receipt and withdrawal
+// approval, sites without scanners leave SCAN_CONFIRMATION_REQUIRED unset.
+func scanConfirmationRequired() bool {
+	return os.Getenv("SCAN_CONFIRMATION_REQUIRED") == "true"
+}
+
+// checkScanSession refuses approval of a document that is being scanned until its last scan
+// session is confirmed and its lines are the ones the document expects now. Documents nobody
+// scanned are approved as before unless scans are required. column is receipt_id or
+// withdrawal_approvement_id.
+func checkScanSession(db *gorm.DB, column string, id uint, expected []models.ScanLine) error {
+	var session models.ScanSession
+	err := db.
+		Preload("Lines").
+		Where(column+" = ? AND status <> ?", id, models.ScanSessionStatus_Cancelled).
+		Order("id desc").
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if scanConfirmationRequired() {
+			return errors.New("scan the document before approval")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if session.Status != models.ScanSessionStatus_Confirmed {
+		return errors.New("scan session is not confirmed")
+	}
+
+	type lineKey struct {
+		MaterialID          uint
+		InventoryMaterialID uint
+	}
+	key := func(l models.ScanLine) lineKey {
+		k := lineKey{MaterialID: l.MaterialID}
+		if l.InventoryMaterialID != nil {
+			k.InventoryMaterialID = *l.InventoryMaterialID
+		}
+		return k
+	}
+	scanned := make(map[lineKey]int64)
+	for _, l := range session.Lines {
+		scanned[key(l)] = l.ScannedQty
+	}
+	matched := 0
+	for _, l := range expected {
+		if qty, ok := scanned[key(l)]; !ok || qty != l.ExpectedQty {
+			return errors.New("document changed after it was scanned, scan it again")
+		}
+		matched++
+	}
+	if matched != len(scanned) {
+		return errors.New("document changed after it was scanned, scan it again")
+	}
+	return nil
+}
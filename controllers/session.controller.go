@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"daijai/models"
+	"daijai/token"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SessionController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewSessionController(db *gorm.DB) *SessionController {
+	return &SessionController{
+		DB: db,
+	}
+}
+
+// get the active sessions of the signed in user
+func (sc *SessionController) GetMySessions(c *gin.Context) {
+	var uid uint
+	if err := sc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sessions, err := activeSessions(sc.DB.WithContext(c), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"current": c.GetUint("sid"), "sessions": sessions})
+}
+
+// revoke one of the signed in user's sessions
+func (sc *SessionController) RevokeMySession(c *gin.Context) {
+	var uid uint
+	if err := sc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var session models.Session
+	if err := sc.DB.WithContext(c).Where("user_id = ?", uid).First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err := revokeSession(sc.DB.WithContext(c), &session, models.SessionRevokedReason_Revoked); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// get the active sessions of a user
+func (sc *SessionController) GetUserSessions(c *gin.Context) {
+	var user models.User
+	if err := sc.DB.WithContext(c).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	sessions, err := activeSessions(sc.DB.WithContext(c), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// ForceLogout revokes every session of a user, or one with ?sessionID=.
+func (sc *SessionController) ForceLogout(c *gin.Context) {
+	var user models.User
+	if err := sc.DB.WithContext(c).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	q := sc.DB.WithContext(c).Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID)
+	if sessionID := c.Query("sessionID"); sessionID != "" {
+		q = q.Where("id = ?", sessionID)
+	}
+	result := q.Updates(map[string]interface{}{
+		"revoked_at":     time.Now(),
+		"revoked_reason": models.SessionRevokedReason_ForcedOut,
+	})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out", "revoked": result.RowsAffected})
+}
+
+// startSession opens a session for a user who signed in and returns its tokens.
+func startSession(db *gorm.DB, c *gin.Context, user *models.User) (gin.H, error) {
+	refreshToken, refreshHash, err := token.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        c.Request.UserAgent(),
+		IP:               c.ClientIP(),
+		ExpiresAt:        now.Add(token.RefreshTokenTTL()),
+		LastUsedAt:       now,
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	accessToken, err := token.GenerateToken(*user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":        accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(token.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+func revokeSession(db *gorm.DB, session *models.Session, reason string) error {
+	if session.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	session.RevokedReason = reason
+	return db.Model(session).Updates(map[string]interface{}{
+		"revoked_at":     now,
+		"revoked_reason": reason,
+	}).Error
+}
+
+// revokeUserSessions ends every session of a user, after changes that should sign them out.
+func revokeUserSessions(db *gorm.DB, userID uint, reason string) error {
+	return db.
+		Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		}).Error
+}
+
+func activeSessions(db *gorm.DB, userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := db.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
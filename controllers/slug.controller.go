@@ -25,6 +25,7 @@ func (slc *SlugController) GetSlug(c *gin.Context) {
 	id := c.Param("slug")
 	if err := slc.
 		DB.
+		WithContext(c).
 		Where("table_name = ?", id).
 		First(&slug).
 		Error; err != nil {
@@ -36,7 +37,7 @@ func (slc *SlugController) GetSlug(c *gin.Context) {
 
 func (slc *SlugController) GetAllSluggers(c *gin.Context) {
 	var sluggers []models.Slugger
-	if err := slc.DB.Find(&sluggers).Error; err != nil {
+	if err := slc.DB.WithContext(c).Find(&sluggers).Error; err != nil {
 		c.JSON(500, gin.H{"error": "Failed to get sluggers"})
 		return
 	}
@@ -48,6 +49,7 @@ func (slc *SlugController) RequestSlug(c *gin.Context) {
 	id := c.Param("slug")
 	if err := slc.
 		DB.
+		WithContext(c).
 		Where("table_name = ?", id).
 		First(&slug).
 		Error; err != nil {
@@ -57,7 +59,7 @@ func (slc *SlugController) RequestSlug(c *gin.Context) {
 
 	// Update the slug value
 	slug.Value++
-	if err := slc.DB.Save(&slug).Error; err != nil {
+	if err := slc.DB.WithContext(c).Save(&slug).Error; err != nil {
 		c.JSON(500, gin.H{"error": "Failed to update slug value"})
 		return
 	}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"daijai/models"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SupplierReturnController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewSupplierReturnController(db *gorm.DB) *SupplierReturnController {
+	return &SupplierReturnController{
+		DB: db,
+	}
+}
+
+// get supplier returns, ?receiptID= and ?source=
+func (src *SupplierReturnController) GetSupplierReturns(c *gin.Context) {
+	var returns []models.SupplierReturn
+	q := src.preloadSupplierReturns(src.DB.WithContext(c)).Order("id desc")
+	if receiptID := c.Query("receiptID"); receiptID != "" {
+		q = q.Where("receipt_id = ?", receiptID)
+	}
+	if source := c.Query("source"); source != "" {
+		q = q.Where("source = ?", source)
+	}
+	if err := q.Find(&returns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get supplier returns"})
+		return
+	}
+	c.JSON(http.StatusOK, returns)
+}
+
+func (src *SupplierReturnController) GetSupplierReturnBySlug(c *gin.Context) {
+	var supplierReturn models.SupplierReturn
+	if err := src.
+		preloadSupplierReturns(src.DB.WithContext(c)).
+		First(&supplierReturn, "slug = ?", c.Param("slug")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier return not found"})
+		return
+	}
+	c.JSON(http.StatusOK, supplierReturn)
+}
+
+// CreateSupplierReturn sends stock of a receipt back to the supplier, taking the quantity
+// from the lots of that receipt and reducing the received quantity of its PO.
+func (src *SupplierReturnController) CreateSupplierReturn(c *gin.Context) {
+	var uid uint
+	if err := src.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := src.getUserDataByUserID(src.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		ReceiptID uint   `json:"ReceiptID" binding:"required"`
+		Notes     string `json:"Notes"`
+		Lines     []struct {
+			MaterialID          uint     `json:"MaterialID" binding:"required"`
+			InventoryMaterialID *uint    `json:"InventoryMaterialID"`
+			Quantity            int64    `json:"Quantity" binding:"required"`
+			Reason              string   `json:"Reason" binding:"required"`
+			Serials             []string `json:"Serials"`
+		} `json:"Lines" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var receipt models.Receipt
+	if err := src.DB.WithContext(c).First(&receipt, request.ReceiptID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	if !receipt.IsApproved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt is not approved, reject the quantity on inspection instead"})
+		return
+	}
+
+	supplierReturn := models.SupplierReturn{
+		Source:      models.SupplierReturnSource_Stock,
+		ReceiptID:   receipt.ID,
+		PORefNumber: receipt.PORefNumber,
+		Notes:       request.Notes,
+		CreatedByID: member.ID,
+	}
+	if err := src.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := createSupplierReturn(tx, &src.BaseController, &supplierReturn); err != nil {
+			return err
+		}
+
+		sums := make(map[[2]uint]bool)
+		for _, l := range request.Lines {
+			if l.Quantity <= 0 {
+				return errors.New("quantity must be greater than 0")
+			}
+			var material models.Material
+			if err := tx.First(&material, l.MaterialID).Error; err != nil {
+				return err
+			}
+			serials, plan, err := serialPlan(tx, &material, l.Serials, l.Quantity, models.MaterialSerialStatus_InStock, inventoryLotOf)
+			if err != nil {
+				return err
+			}
+
+			// reserved quantity stays with its orders
+			var lots []models.InventoryMaterial
+			q := tx.
+				Where("receipt_id = ? AND material_id = ?", receipt.ID, material.ID).
+				Where("available_qty > ?", 0).
+				Order("id asc")
+			if l.InventoryMaterialID != nil {
+				q = q.Where("id = ?", *l.InventoryMaterialID)
+			}
+			if plan != nil {
+				lotIDs := make([]uint, 0, len(plan))
+				for lotID := range plan {
+					lotIDs = append(lotIDs, lotID)
+				}
+				q = q.Where("id IN ?", lotIDs)
+			}
+			if err := q.Find(&lots).Error; err != nil {
+				return err
+			}
+			var availableQty int64
+			for _, lot := range lots {
+				if plan != nil && lot.AvailableQty < plan[lot.ID] {
+					return fmt.Errorf("serials of %s are not available", models.LotNumber(lot.ID))
+				}
+				availableQty += lot.AvailableQty
+			}
+			if availableQty < l.Quantity || (plan != nil && len(lots) != len(plan)) {
+				return fmt.Errorf("not enough unreserved stock of %s from receipt %s", material.Slug, receipt.Slug)
+			}
+
+			needQty := l.Quantity
+			for _, lot := range lots {
+				if needQty == 0 {
+					break
+				}
+				used := lot.AvailableQty
+				if used > needQty {
+					used = needQty
+				}
+				if plan != nil {
+					used = plan[lot.ID]
+				}
+
+				existingQty := lot.Quantity
+				lot.Quantity -= used
+				lot.AvailableQty -= used
+				lot.IsOutOfStock = lot.AvailableQty == 0
+				if err := tx.Save(&lot).Error; err != nil {
+					return err
+				}
+
+				matTr := models.InventoryMaterialTransaction{
+					InventoryMaterialID:      lot.ID,
+					Quantity:                 used,
+					InventoryType:            models.InventoryType_OUTGOING,
+					InventoryTypeDescription: models.InventoryTypeDescription_SUPPLIER_RETURN,
+					ExistingQuantity:         existingQty,
+					ExistingReserve:          lot.Reserve,
+					UpdatedQuantity:          lot.Quantity,
+					UpdatedReserve:           lot.Reserve,
+					ReceiptID:                &receipt.ID,
+					SupplierReturnID:         &supplierReturn.ID,
+				}
+				if err := tx.Create(&matTr).Error; err != nil {
+					return err
+				}
+
+				lotSerials := []string{}
+				var lotSerialIDs []uint
+				for _, s := range serials {
+					if s.InventoryMaterialID != nil && *s.InventoryMaterialID == lot.ID {
+						lotSerials = append(lotSerials, s.SerialNumber)
+						lotSerialIDs = append(lotSerialIDs, s.ID)
+					}
+				}
+				if len(lotSerialIDs) > 0 {
+					if err := tx.
+						Model(&models.MaterialSerial{}).
+						Where("id IN ?", lotSerialIDs).
+						Update("status", models.MaterialSerialStatus_Returned).Error; err != nil {
+						return err
+					}
+				}
+
+				lotID := lot.ID
+				returnMaterial := models.SupplierReturnMaterial{
+					SupplierReturnID:    supplierReturn.ID,
+					InventoryMaterialID: &lotID,
+					MaterialID:          material.ID,
+					Quantity:            used,
+					Reason:              l.Reason,
+					Serials:             lotSerials,
+				}
+				if err := tx.Create(&returnMaterial).Error; err != nil {
+					return err
+				}
+				sums[[2]uint{material.ID, lot.InventoryID}] = true
+				needQty -= used
+			}
+
+			if err := adjustPOReceivedQty(tx, receipt.PORefNumber, material.ID, -l.Quantity); err != nil {
+				return err
+			}
+		}
+
+		for key := range sums {
+			if err := src.SumMaterial(tx, "supplier-return", key[0], key[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create supplier return", "detail": err.Error()})
+		return
+	}
+
+	if err := src.preloadSupplierReturns(src.DB.WithContext(c)).First(&supplierReturn, supplierReturn.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get supplier return"})
+		return
+	}
+	c.JSON(http.StatusCreated, supplierReturn)
+}
+
+func (src *SupplierReturnController) preloadSupplierReturns(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Receipt.Inventory").
+		Preload("CreatedBy").
+		Preload("SupplierReturnMaterials.Material")
+}
+
+// createSupplierReturn stores a supplier return with a new slug, the slugger row is
+// created on first use for databases seeded before supplier returns existed.
+func createSupplierReturn(tx *gorm.DB, bc *BaseController, supplierReturn *models.SupplierReturn) error {
+	slugger := models.SupplierReturn{}.GenerateSlug()
+	if err := tx.
+		Where(models.Slugger{TableName: slugger.TableName}).
+		Attrs(slugger).
+		FirstOrCreate(&models.Slugger{}).Error; err != nil {
+		return err
+	}
+	if err := bc.RequestSlug(&supplierReturn.Slug, tx, slugger.TableName); err != nil {
+		return err
+	}
+	return tx.Create(supplierReturn).Error
+}
@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"daijai/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TraceController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewTraceController(db *gorm.DB) *TraceController {
+	return &TraceController{
+		DB: db,
+	}
+}
+
+// forward trace of a receipt
+func (tc *TraceController) TraceReceipt(c *gin.Context) {
+	var receipt models.Receipt
+	if err := tc.DB.WithContext(c).First(&receipt, "slug = ?", c.Param("slug")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	tc.traceReceipts(c, []uint{receipt.ID})
+}
+
+// forward trace of every receipt of a PO number
+func (tc *TraceController) TracePO(c *gin.Context) {
+	var receiptIDs []uint
+	if err := tc.
+		DB.
+		WithContext(c).
+		Model(&models.Receipt{}).
+		Where("po_ref_number = ?", c.Param("poNumber")).
+		Pluck("id", &receiptIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get receipts"})
+		return
+	}
+	if len(receiptIDs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No receipt for PO number"})
+		return
+	}
+	tc.traceReceipts(c, receiptIDs)
+}
+
+// backward trace of a withdrawal to its receipts and suppliers, the slug is the rest of the path
+func (tc *TraceController) TraceWithdrawal(c *gin.Context) {
+	var withdrawal models.Withdrawal
+	if err := tc.DB.WithContext(c).First(&withdrawal, "slug = ?", strings.TrimPrefix(c.Param("slug"), "/")).Error; err != nil || !canSeeWithdrawal(tc.DB.WithContext(c), c, &withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found"})
+		return
+	}
+
+	// lots issued by approved withdrawals
+	var lotIDs []uint
+	if err := tc.
+		DB.
+		WithContext(c).
+		Model(&models.InventoryMaterialTransaction{}).
+		Where("withdrawal_id = ? AND inventory_type = ?", withdrawal.ID, models.InventoryType_OUTGOING).
+		Distinct().
+		Pluck("inventory_material_id", &lotIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get withdrawal transactions"})
+		return
+	}
+
+	// lots still reserved by pending approvements
+	var reservedIDs []uint
+	if err := tc.
+		DB.
+		WithContext(c).
+		Model(&models.OrderReserving{}).
+		Where("id IN (?)", tc.DB.WithContext(c).
+			Model(&models.WithdrawalTransaction{}).
+			Select("order_reserving_id").
+			Where("withdrawal_approvement_id IN (?)", tc.DB.WithContext(c).
+				Model(&models.WithdrawalApprovement{}).
+				Select("id").
+				Where("withdrawal_id = ?", withdrawal.ID))).
+		Pluck("inventory_material_id", &reservedIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get withdrawal reservings"})
+		return
+	}
+	lotIDs = append(lotIDs, reservedIDs...)
+
+	var lots []models.InventoryMaterial
+	if len(lotIDs) > 0 {
+		if err := tc.preloadLots(tc.DB.WithContext(c)).Where("id IN ?", lotIDs).Order("id asc").Find(&lots).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lots"})
+			return
+		}
+	}
+
+	report, err := tc.buildTrace(c, lots)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build trace", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"withdrawal": withdrawal, "trace": report})
+}
+
+func (tc *TraceController) traceReceipts(c *gin.Context, receiptIDs []uint) {
+	var lots []models.InventoryMaterial
+	if err := tc.
+		preloadLots(tc.DB.WithContext(c)).
+		Where("receipt_id IN ?", receiptIDs).
+		Order("id asc").
+		Find(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lots"})
+		return
+	}
+
+	report, err := tc.buildTrace(c, lots)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build trace", "detail": err.Error()})
+		return
+	}
+
+	// include receipts that are not approved yet and have no lots
+	if len(report.Receipts) < len(receiptIDs) {
+		var receipts []models.Receipt
+		if err := tc.DB.WithContext(c).Preload("Inventory").Preload("ReceiptMaterials.Material").Find(&receipts, receiptIDs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get receipts"})
+			return
+		}
+		report.Receipts = nil
+		for _, r := range receipts {
+			report.Receipts = append(report.Receipts, traceReceipt(&r))
+		}
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// buildTrace builds the trace of lots as the user may see it, reservations, withdrawals and
+// project stores of projects out of the user's scope are left out.
+func (tc *TraceController) buildTrace(c *gin.Context, lots []models.InventoryMaterial) (models.TraceReport, error) {
+	projectIDs, scoped, err := projectScope(tc.DB.WithContext(c), c)
+	if err != nil {
+		return models.TraceReport{}, err
+	}
+	if !scoped {
+		projectIDs = nil
+	}
+	return buildTrace(tc.DB.WithContext(c), lots, projectIDs)
+}
+
+func (tc *TraceController) preloadLots(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Material").
+		Preload("Inventory").
+		Preload("Receipt.Inventory").
+		Preload("Receipt.ReceiptMaterials.Material").
+		Preload("TransferMaterial.FromInventory").
+		Preload("TransferOrder.FromInventory")
+}
+
+// buildTrace collects the reservations, withdrawals and project stores of lots, limited to
+// the projects of projectIDs unless it is nil.
+// Lots must be preloaded with Material, Inventory, Receipt, TransferMaterial and TransferOrder.
+func buildTrace(db *gorm.DB, lots []models.InventoryMaterial, projectIDs []uint) (models.TraceReport, error) {
+	report := models.TraceReport{
+		Receipts: []models.TraceReceipt{},
+		Lots:     []models.TraceLot{},
+		Projects: []models.TraceProject{},
+	}
+	if len(lots) == 0 {
+		return report, nil
+	}
+
+	var lotIDs []uint
+	for _, lot := range lots {
+		lotIDs = append(lotIDs, lot.ID)
+	}
+
+	// inProjects limits a query to the documents of the projects in scope
+	inProjects := func(column string, model interface{}) func(*gorm.DB) *gorm.DB {
+		return func(q *gorm.DB) *gorm.DB {
+			if projectIDs == nil {
+				return q
+			}
+			return q.Where(column+" IN (?)", db.Model(model).Select("id").Where("project_id IN ?", projectIDs))
+		}
+	}
+
+	var reservings []models.OrderReserving
+	if err := db.
+		Preload("Order.Project").
+		Where("inventory_material_id IN ?", lotIDs).
+		Scopes(inProjects("order_id", &models.Order{})).
+		Find(&reservings).Error; err != nil {
+		return report, err
+	}
+	var extendReservings []models.ExtendOrderReserving
+	if err := db.
+		Preload("ExtendOrder.Project").
+		Where("inventory_material_id IN ?", lotIDs).
+		Scopes(inProjects("extend_order_id", &models.ExtendOrder{})).
+		Find(&extendReservings).Error; err != nil {
+		return report, err
+	}
+	var matTrs []models.InventoryMaterialTransaction
+	if err := db.
+		Preload("Withdrawal.Project").
+		Where("inventory_material_id IN ?", lotIDs).
+		Where("inventory_type = ? AND withdrawal_id IS NOT NULL", models.InventoryType_OUTGOING).
+		Scopes(inProjects("withdrawal_id", &models.Withdrawal{})).
+		Order("id asc").
+		Find(&matTrs).Error; err != nil {
+		return report, err
+	}
+	var storeMats []models.ProjectStoreMaterial
+	if err := db.
+		Preload("ProjectStore.Project").
+		Where("inventory_material_id IN ?", lotIDs).
+		Scopes(inProjects("project_store_id", &models.ProjectStore{})).
+		Find(&storeMats).Error; err != nil {
+		return report, err
+	}
+
+	projectSeen := make(map[uint]bool)
+	addProject := func(p *models.Project) string {
+		if p == nil {
+			return ""
+		}
+		if !projectSeen[p.ID] {
+			projectSeen[p.ID] = true
+			report.Projects = append(report.Projects, models.TraceProject{ProjectID: p.ID, Slug: p.Slug, Title: p.Title})
+		}
+		return p.Slug
+	}
+	receiptSeen := make(map[uint]bool)
+
+	for _, lot := range lots {
+		tl := models.TraceLot{
+			InventoryMaterialID:   lot.ID,
+			LotNumber:             models.LotNumber(lot.ID),
+			InventoryMaterialType: lot.InventoryMaterialType,
+			MaterialID:            lot.MaterialID,
+			InventoryID:           lot.InventoryID,
+			ReceiptID:             lot.ReceiptID,
+			Quantity:              lot.Quantity,
+			AvailableQty:          lot.AvailableQty,
+			Reserve:               lot.Reserve,
+			Withdrawed:            lot.Withdrawed,
+			TransferMaterialID:    lot.TransferMaterialID,
+			TransferOrderID:       lot.TransferOrderID,
+			Reservations:          []models.TraceReservation{},
+			Withdrawals:           []models.TraceWithdrawal{},
+			ProjectStores:         []models.TraceProjectStore{},
+		}
+		if lot.Material != nil {
+			tl.MaterialSlug = lot.Material.Slug
+			tl.MaterialTitle = lot.Material.Title
+			tl.Supplier = lot.Material.Supplier
+		}
+		if lot.Inventory != nil {
+			tl.Inventory = lot.Inventory.Title
+		}
+		if lot.Receipt != nil {
+			tl.ReceiptSlug = lot.Receipt.Slug
+			tl.PORefNumber = lot.Receipt.PORefNumber
+			if !receiptSeen[lot.Receipt.ID] {
+				receiptSeen[lot.Receipt.ID] = true
+				report.Receipts = append(report.Receipts, traceReceipt(lot.Receipt))
+			}
+		}
+		if lot.TransferMaterial != nil && lot.TransferMaterial.FromInventory != nil {
+			tl.TransferredFrom = lot.TransferMaterial.FromInventory.Title
+		}
+		if lot.TransferOrder != nil && lot.TransferOrder.FromInventory != nil {
+			tl.TransferredFrom = lot.TransferOrder.FromInventory.Title
+		}
+
+		for _, r := range reservings {
+			if r.InventoryMaterialID != lot.ID || r.Order == nil {
+				continue
+			}
+			tl.Reservations = append(tl.Reservations, models.TraceReservation{
+				Type:        models.TraceReservationType_Order,
+				OrderID:     r.OrderID,
+				OrderSlug:   r.Order.Slug,
+				ProjectSlug: addProject(r.Order.Project),
+				Quantity:    r.Quantity,
+				Status:      r.Status,
+			})
+		}
+		for _, r := range extendReservings {
+			if r.InventoryMaterialID != lot.ID || r.ExtendOrder == nil {
+				continue
+			}
+			tl.Reservations = append(tl.Reservations, models.TraceReservation{
+				Type:        models.TraceReservationType_ExtendOrder,
+				OrderID:     r.ExtendOrderID,
+				OrderSlug:   r.ExtendOrder.Slug,
+				ProjectSlug: addProject(r.ExtendOrder.Project),
+				Quantity:    r.Quantity,
+				Status:      r.Status,
+			})
+		}
+		for _, tr := range matTrs {
+			if tr.InventoryMaterialID != lot.ID || tr.Withdrawal == nil {
+				continue
+			}
+			tl.Withdrawals = append(tl.Withdrawals, models.TraceWithdrawal{
+				WithdrawalID:   tr.Withdrawal.ID,
+				WithdrawalSlug: tr.Withdrawal.Slug,
+				ProjectSlug:    addProject(tr.Withdrawal.Project),
+				Quantity:       tr.ExistingQuantity - tr.UpdatedQuantity,
+				CreatedAt:      tr.CreatedAt,
+			})
+		}
+		for _, sm := range storeMats {
+			if sm.InventoryMaterialID == nil || *sm.InventoryMaterialID != lot.ID || sm.ProjectStore == nil {
+				continue
+			}
+			tl.ProjectStores = append(tl.ProjectStores, models.TraceProjectStore{
+				ProjectStoreID:   sm.ProjectStoreID,
+				ProjectStoreSlug: sm.ProjectStore.Slug,
+				ProjectSlug:      addProject(sm.ProjectStore.Project),
+				Quantity:         sm.Quantity,
+				ConsumedQty:      sm.ConsumedQty,
+				ReturnedQty:      sm.ReturnedQty,
+				AvailableQty:     sm.AvailableQty,
+			})
+		}
+		report.Lots = append(report.Lots, tl)
+	}
+	return report, nil
+}
+
+func traceReceipt(receipt *models.Receipt) models.TraceReceipt {
+	tr := models.TraceReceipt{
+		ReceiptID:   receipt.ID,
+		Slug:        receipt.Slug,
+		PORefNumber: receipt.PORefNumber,
+		Inventory:   receipt.Inventory.Title,
+		IsApproved:  receipt.IsApproved,
+		Suppliers:   []string{},
+		CreatedAt:   receipt.CreatedAt,
+	}
+	seen := make(map[string]bool)
+	for _, rm := range receipt.ReceiptMaterials {
+		if s := rm.Material.Supplier; s != "" && !seen[s] {
+			seen[s] = true
+			tr.Suppliers = append(tr.Suppliers, s)
+		}
+	}
+	return tr
+}
@@ -22,6 +22,7 @@ func (mc *TransactionController) GetTransactions(c *gin.Context) {
 	var transaction []models.AppLog
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("Inventory").
 		Preload("Material").
 		Find(&transaction).Error; err != nil {
@@ -35,6 +36,7 @@ func (mc *TransactionController) GetTransactionsGroupByInventory(c *gin.Context)
 	var inventories []models.Inventory
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("Transactions").
 		Preload("Transactions.Material").
 		Find(&inventories).Error; err != nil {
@@ -50,6 +52,7 @@ func (mc *TransactionController) GetInventoryMaterialTransactionsByPONumber(c *g
 	var ivtMats []models.InventoryMaterial
 	if err := mc.
 		DB.
+		WithContext(c).
 		Joins("Receipt").
 		Where("po_number = ?", ponumber).
 		Find(&ivtMats).Error; err != nil {
@@ -65,6 +68,7 @@ func (mc *TransactionController) GetInventoryMaterialTransactionsByPONumber(c *g
 	var transactions []models.InventoryMaterialTransaction
 	if err := mc.
 		DB.
+		WithContext(c).
 		Where("inventory_material_id IN ?", ivtIDs).
 		Find(&transactions).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory material transactions"})
@@ -0,0 +1,919 @@
+package controllers
+
+import (
+	"daijai/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TransferOrderController struct {
+	DB *gorm.DB
+	BaseController
+}
+
+func NewTransferOrderController(db *gorm.DB) *TransferOrderController {
+	return &TransferOrderController{
+		DB: db,
+	}
+}
+
+// get transfer orders, ?status= and ?inventoryID= (either side)
+func (toc *TransferOrderController) GetTransferOrders(c *gin.Context) {
+	var orders []models.TransferOrder
+	q := toc.preloadTransferOrders(toc.DB.WithContext(c)).Order("id desc")
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if inventoryID := c.Query("inventoryID"); inventoryID != "" {
+		q = q.Where("from_inventory_id = ? OR to_inventory_id = ?", inventoryID, inventoryID)
+	}
+	if err := q.Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer orders"})
+		return
+	}
+	c.JSON(http.StatusOK, orders)
+}
+
+func (toc *TransferOrderController) GetTransferOrderByID(c *gin.Context) {
+	var order models.TransferOrder
+	if err := toc.preloadTransferOrders(toc.DB.WithContext(c)).First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer order not found"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// CreateTransferOrder opens a transfer of one or more materials, it waits for approval and
+// stock stays in the source inventory until the order is shipped.
+func (toc *TransferOrderController) CreateTransferOrder(c *gin.Context) {
+	var uid uint
+	if err := toc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := toc.getUserDataByUserID(toc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		FromInventoryID uint   `json:"FromInventoryID" binding:"required"`
+		ToInventoryID   uint   `json:"ToInventoryID" binding:"required"`
+		IsDirect        bool   `json:"IsDirect"`
+		Notes           string `json:"Notes"`
+		Lines           []struct {
+			MaterialID uint     `json:"MaterialID" binding:"required"`
+			Quantity   int64    `json:"Quantity" binding:"required"`
+			Serials    []string `json:"Serials"`
+		} `json:"Lines" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order := models.TransferOrder{
+		FromInventoryID: request.FromInventoryID,
+		ToInventoryID:   request.ToInventoryID,
+		IsDirect:        request.IsDirect,
+		Notes:           request.Notes,
+		CreatedByID:     member.ID,
+	}
+	for _, l := range request.Lines {
+		order.Lines = append(order.Lines, models.TransferOrderLine{
+			MaterialID: l.MaterialID,
+			Quantity:   l.Quantity,
+			Serials:    l.Serials,
+		})
+	}
+	if err := toc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		return createTransferOrder(tx, &toc.BaseController, &order)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create transfer order", "detail": err.Error()})
+		return
+	}
+
+	if err := toc.preloadTransferOrders(toc.DB.WithContext(c)).First(&order, order.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer order"})
+		return
+	}
+	c.JSON(http.StatusCreated, order)
+}
+
+// CreateBulkTransferOrder opens a transfer of all unreserved stock of an inventory, limited
+// to a category or a list of materials when given.
+func (toc *TransferOrderController) CreateBulkTransferOrder(c *gin.Context) {
+	var uid uint
+	if err := toc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := toc.getUserDataByUserID(toc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		FromInventoryID uint   `json:"FromInventoryID" binding:"required"`
+		ToInventoryID   uint   `json:"ToInventoryID" binding:"required"`
+		CategoryID      *uint  `json:"CategoryID"`
+		MaterialIDs     []uint `json:"MaterialIDs"`
+		IsDirect        bool   `json:"IsDirect"`
+		Notes           string `json:"Notes"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order := models.TransferOrder{
+		FromInventoryID: request.FromInventoryID,
+		ToInventoryID:   request.ToInventoryID,
+		IsDirect:        request.IsDirect,
+		Notes:           request.Notes,
+		CreatedByID:     member.ID,
+	}
+	if err := toc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		lines, err := bulkTransferLines(tx, request.FromInventoryID, request.CategoryID, request.MaterialIDs)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return errors.New("no unreserved stock to transfer")
+		}
+		order.Lines = lines
+		return createTransferOrder(tx, &toc.BaseController, &order)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create transfer order", "detail": err.Error()})
+		return
+	}
+
+	if err := toc.preloadTransferOrders(toc.DB.WithContext(c)).First(&order, order.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer order"})
+		return
+	}
+	c.JSON(http.StatusCreated, order)
+}
+
+// ApproveTransferOrder approves a pending transfer order, direct orders move all lines
+// into the receiving inventory once the last approver of the chain approves.
+func (toc *TransferOrderController) ApproveTransferOrder(c *gin.Context) {
+	var uid uint
+	if err := toc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := toc.getUserDataByUserID(toc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.TransferOrder
+	if err := toc.DB.WithContext(c).Preload("Lines.Material").First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer order not found"})
+		return
+	}
+	if order.Status != models.TransferOrderStatus_Pending {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer order is already approved or rejected"})
+		return
+	}
+
+	doc, err := transferOrderApprovalDocument(toc.DB.WithContext(c), &order)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	step := approveOrRespond(c, toc.DB.WithContext(c), doc, &member)
+	if step == nil {
+		return
+	}
+
+	if err := toc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := recordApproval(tx, step, &member, c.Query("comment")); err != nil {
+			return err
+		}
+		order.Status = models.TransferOrderStatus_Open
+		if err := tx.Model(&order).Update("status", order.Status).Error; err != nil {
+			return err
+		}
+		if !order.IsDirect {
+			return nil
+		}
+
+		// direct orders move every line or none
+		if err := toc.shipTransferOrder(tx, &order, &member); err != nil {
+			return err
+		}
+		id := order.ID
+		order = models.TransferOrder{}
+		if err := toc.preloadTransferLots(tx).First(&order, id).Error; err != nil {
+			return err
+		}
+		for i := range order.Lines {
+			line := &order.Lines[i]
+			if err := toc.receiveTransferLine(tx, &order, line, line.ShippedQty, line.Serials); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&order).Update("status", models.TransferOrderStatus_Received).Error
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to approve transfer order", "detail": err.Error()})
+		return
+	}
+
+	if err := toc.preloadTransferOrders(toc.DB.WithContext(c)).First(&order, order.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer order"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// ShipTransferOrder takes the quantity of every line out of the source inventory into
+// lots of the in-transit inventory.
+func (toc *TransferOrderController) ShipTransferOrder(c *gin.Context) {
+	var uid uint
+	if err := toc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := toc.getUserDataByUserID(toc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.TransferOrder
+	if err := toc.DB.WithContext(c).Preload("Lines.Material").First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer order not found"})
+		return
+	}
+	if order.Status != models.TransferOrderStatus_Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only approved transfer orders that are not shipped can be shipped"})
+		return
+	}
+
+	if err := toc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		return toc.shipTransferOrder(tx, &order, &member)
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to ship transfer order", "detail": err.Error()})
+		return
+	}
+
+	if err := toc.preloadTransferOrders(toc.DB.WithContext(c)).First(&order, order.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer order"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// ReceiveTransferOrder takes shipped quantity into the receiving inventory, lines may be
+// received in parts.
+func (toc *TransferOrderController) ReceiveTransferOrder(c *gin.Context) {
+	var request struct {
+		Lines []struct {
+			LineID   uint     `json:"LineID" binding:"required"`
+			Quantity int64    `json:"Quantity" binding:"required"`
+			Serials  []string `json:"Serials"`
+		} `json:"Lines" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.TransferOrder
+	if err := toc.preloadTransferLots(toc.DB.WithContext(c)).First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer order not found"})
+		return
+	}
+	if order.Status != models.TransferOrderStatus_Shipped && order.Status != models.TransferOrderStatus_PartiallyReceived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer order is not in transit"})
+		return
+	}
+
+	if err := toc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		for _, r := range request.Lines {
+			line := transferOrderLine(&order, r.LineID)
+			if line == nil {
+				return fmt.Errorf("line %d is not part of the transfer order", r.LineID)
+			}
+			if err := toc.receiveTransferLine(tx, &order, line, r.Quantity, r.Serials); err != nil {
+				return err
+			}
+		}
+
+		status := models.TransferOrderStatus_Received
+		for _, line := range order.Lines {
+			if line.Outstanding() > 0 {
+				status = models.TransferOrderStatus_PartiallyReceived
+			}
+		}
+		return tx.Model(&order).Update("status", status).Error
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to receive transfer order", "detail": err.Error()})
+		return
+	}
+
+	if err := toc.preloadTransferOrders(toc.DB.WithContext(c)).First(&order, order.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer order"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// CloseTransferOrder settles the quantity that never arrived, it is written off as lost
+// or returned to the lots it was shipped from. Open orders are closed without stock moves.
+func (toc *TransferOrderController) CloseTransferOrder(c *gin.Context) {
+	var request struct {
+		Resolution string `json:"Resolution"`
+		Reason     string `json:"Reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.TransferOrder
+	if err := toc.preloadTransferLots(toc.DB.WithContext(c)).First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer order not found"})
+		return
+	}
+	switch order.Status {
+	case models.TransferOrderStatus_Open:
+	case models.TransferOrderStatus_Shipped, models.TransferOrderStatus_PartiallyReceived:
+		if request.Resolution != models.TransferDiscrepancy_Lost && request.Resolution != models.TransferDiscrepancy_Return {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Resolution must be lost or return"})
+			return
+		}
+		if request.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is required"})
+			return
+		}
+	case models.TransferOrderStatus_Pending:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer order waits for approval, reject it instead"})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer order is already " + order.Status})
+		return
+	}
+
+	if err := toc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		for i := range order.Lines {
+			line := &order.Lines[i]
+			lineQty := line.Outstanding()
+			if lineQty == 0 {
+				continue
+			}
+			for j := range line.Lots {
+				if err := toc.settleTransitLot(tx, &order, line, &line.Lots[j], request.Resolution); err != nil {
+					return err
+				}
+			}
+			line.DiscrepancyQty += lineQty
+			if err := tx.Model(line).Updates(map[string]interface{}{
+				"discrepancy_qty":    line.DiscrepancyQty,
+				"discrepancy_reason": request.Reason,
+			}).Error; err != nil {
+				return err
+			}
+			if request.Resolution == models.TransferDiscrepancy_Return {
+				if err := toc.SumMaterial(tx, "transfer-order", line.MaterialID, order.FromInventoryID); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Model(&order).Updates(map[string]interface{}{
+			"status":    models.TransferOrderStatus_Closed,
+			"closed_at": time.Now(),
+		}).Error
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to close transfer order", "detail": err.Error()})
+		return
+	}
+
+	if err := toc.preloadTransferOrders(toc.DB.WithContext(c)).First(&order, order.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer order"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// shipTransferOrder moves the lines of an open order into lots of the in-transit inventory.
+// Lines need their Material loaded.
+func (toc *TransferOrderController) shipTransferOrder(tx *gorm.DB, order *models.TransferOrder, member *models.Member) error {
+	transit, err := inTransitInventory(tx)
+	if err != nil {
+		return err
+	}
+
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		lots, err := transferSourceLots(tx, order.FromInventoryID, line.Material, line.Quantity, line.Serials)
+		if err != nil {
+			return err
+		}
+		serials, plan, err := serialPlan(tx, line.Material, line.Serials, line.Quantity, models.MaterialSerialStatus_InStock, inventoryLotOf)
+		if err != nil {
+			return err
+		}
+
+		needQty := line.Quantity
+		for _, iv := range lots {
+			if needQty == 0 {
+				break
+			}
+			used := iv.AvailableQty
+			if used > needQty {
+				used = needQty
+			}
+			if plan != nil {
+				used = plan[iv.ID]
+			}
+
+			existingQty := iv.Quantity
+			iv.Quantity -= used
+			iv.AvailableQty -= used
+			iv.IsOutOfStock = iv.AvailableQty == 0
+			if err := tx.Save(&iv).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.InventoryMaterialTransaction{
+				InventoryMaterialID:      iv.ID,
+				Quantity:                 used,
+				InventoryType:            models.InventoryType_TRANSFER,
+				InventoryTypeDescription: models.InventoryTypeDescription_TRANSFER_OUT,
+				ExistingQuantity:         existingQty,
+				ExistingReserve:          iv.Reserve,
+				UpdatedQuantity:          iv.Quantity,
+				UpdatedReserve:           iv.Reserve,
+				ReceiptID:                iv.ReceiptID,
+				TransferOrderID:          &order.ID,
+			}).Error; err != nil {
+				return err
+			}
+
+			// in-transit lots are never available, picking and sums skip them
+			transitLot := models.InventoryMaterial{
+				InventoryID:           transit.ID,
+				MaterialID:            line.MaterialID,
+				Quantity:              used,
+				IsOutOfStock:          true,
+				Price:                 iv.Price,
+				TransferOrderID:       &order.ID,
+				InventoryMaterialType: models.InventoryMaterialType_Transfer,
+				ReceiptID:             iv.ReceiptID,
+				ExpiryDate:            iv.ExpiryDate,
+				ManufactureDate:       iv.ManufactureDate,
+			}
+			if err := tx.Create(&transitLot).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.InventoryMaterialTransaction{
+				InventoryMaterialID:      transitLot.ID,
+				Quantity:                 used,
+				InventoryType:            models.InventoryType_TRANSFER,
+				InventoryTypeDescription: models.InventoryTypeDescription_TRANSIT_IN,
+				UpdatedQuantity:          used,
+				ReceiptID:                iv.ReceiptID,
+				TransferOrderID:          &order.ID,
+			}).Error; err != nil {
+				return err
+			}
+
+			if ids := serialIDsInLot(serials, inventoryLotOf, iv.ID); len(ids) > 0 {
+				if err := tx.
+					Model(&models.MaterialSerial{}).
+					Where("id IN ?", ids).
+					Updates(map[string]interface{}{
+						"status":                models.MaterialSerialStatus_InTransit,
+						"inventory_id":          transit.ID,
+						"inventory_material_id": transitLot.ID,
+					}).Error; err != nil {
+					return err
+				}
+			}
+
+			if err := tx.Create(&models.TransferOrderLot{
+				TransferOrderLineID: line.ID,
+				SourceLotID:         iv.ID,
+				TransitLotID:        transitLot.ID,
+				Quantity:            used,
+			}).Error; err != nil {
+				return err
+			}
+			needQty -= used
+		}
+
+		line.ShippedQty = line.Quantity
+		if err := tx.Model(line).Update("shipped_qty", line.ShippedQty).Error; err != nil {
+			return err
+		}
+		if err := toc.SumMaterial(tx, "transfer-order", line.MaterialID, order.FromInventoryID); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	order.Status = models.TransferOrderStatus_Shipped
+	order.ShippedByID = &member.ID
+	order.ShippedAt = &now
+	return tx.Model(order).Updates(map[string]interface{}{
+		"status":        order.Status,
+		"shipped_by_id": member.ID,
+		"shipped_at":    now,
+	}).Error
+}
+
+// receiveTransferLine takes shipped quantity of a line into the receiving inventory.
+// Lines need their Material and Lots with TransitLot loaded.
+func (toc *TransferOrderController) receiveTransferLine(tx *gorm.DB, order *models.TransferOrder, line *models.TransferOrderLine, quantity int64, serialNumbers []string) error {
+	if quantity <= 0 {
+		return errors.New("quantity must be greater than 0")
+	}
+	if quantity > line.Outstanding() {
+		return fmt.Errorf("only %d of %s is in transit", line.Outstanding(), line.Material.Slug)
+	}
+	serials, plan, err := serialPlan(tx, line.Material, serialNumbers, quantity, models.MaterialSerialStatus_InTransit, inventoryLotOf)
+	if err != nil {
+		return err
+	}
+
+	needQty := quantity
+	for i := range line.Lots {
+		ol := &line.Lots[i]
+		if needQty == 0 {
+			break
+		}
+		used := ol.Outstanding()
+		if used > needQty {
+			used = needQty
+		}
+		if plan != nil {
+			used = plan[ol.TransitLotID]
+			if used > ol.Outstanding() {
+				return fmt.Errorf("serials of %s are not in transit on this line", line.Material.Slug)
+			}
+			delete(plan, ol.TransitLotID)
+		}
+		if used == 0 {
+			continue
+		}
+
+		transitLot := ol.TransitLot
+		existingQty := transitLot.Quantity
+		transitLot.Quantity -= used
+		if err := tx.Model(transitLot).Update("quantity", transitLot.Quantity).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.InventoryMaterialTransaction{
+			InventoryMaterialID:      transitLot.ID,
+			Quantity:                 used,
+			InventoryType:            models.InventoryType_TRANSFER,
+			InventoryTypeDescription: models.InventoryTypeDescription_TRANSIT_OUT,
+			ExistingQuantity:         existingQty,
+			UpdatedQuantity:          transitLot.Quantity,
+			ReceiptID:                transitLot.ReceiptID,
+			TransferOrderID:          &order.ID,
+		}).Error; err != nil {
+			return err
+		}
+
+		lot := models.InventoryMaterial{
+			InventoryID:           order.ToInventoryID,
+			MaterialID:            line.MaterialID,
+			Quantity:              used,
+			AvailableQty:          used,
+			Price:                 transitLot.Price,
+			TransferOrderID:       &order.ID,
+			InventoryMaterialType: models.InventoryMaterialType_Transfer,
+			ReceiptID:             transitLot.ReceiptID,
+			ExpiryDate:            transitLot.ExpiryDate,
+			ManufactureDate:       transitLot.ManufactureDate,
+		}
+		if err := tx.Create(&lot).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.InventoryMaterialTransaction{
+			InventoryMaterialID:      lot.ID,
+			Quantity:                 used,
+			InventoryType:            models.InventoryType_TRANSFER,
+			InventoryTypeDescription: models.InventoryTypeDescription_TRANSFER_IN,
+			UpdatedQuantity:          used,
+			ReceiptID:                transitLot.ReceiptID,
+			TransferOrderID:          &order.ID,
+		}).Error; err != nil {
+			return err
+		}
+
+		if ids := serialIDsInLot(serials, inventoryLotOf, transitLot.ID); len(ids) > 0 {
+			if err := tx.
+				Model(&models.MaterialSerial{}).
+				Where("id IN ?", ids).
+				Updates(map[string]interface{}{
+					"status":                models.MaterialSerialStatus_InStock,
+					"inventory_id":          order.ToInventoryID,
+					"inventory_material_id": lot.ID,
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		ol.ReceivedQty += used
+		if err := tx.Model(ol).Update("received_qty", ol.ReceivedQty).Error; err != nil {
+			return err
+		}
+		needQty -= used
+	}
+	if needQty != 0 || len(plan) > 0 {
+		return fmt.Errorf("serials of %s are not in transit on this line", line.Material.Slug)
+	}
+
+	line.ReceivedQty += quantity
+	if err := tx.Model(line).Update("received_qty", line.ReceivedQty).Error; err != nil {
+		return err
+	}
+	if err := toc.SumMaterial(tx, "transfer-order", line.MaterialID, order.ToInventoryID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// settleTransitLot takes the outstanding quantity out of an in-transit lot, as a loss or
+// back into the lot it was shipped from.
+func (toc *TransferOrderController) settleTransitLot(tx *gorm.DB, order *models.TransferOrder, line *models.TransferOrderLine, ol *models.TransferOrderLot, resolution string) error {
+	qty := ol.Outstanding()
+	if qty == 0 {
+		return nil
+	}
+	transitLot := ol.TransitLot
+
+	transitTr := models.InventoryMaterialTransaction{
+		InventoryMaterialID:      transitLot.ID,
+		Quantity:                 qty,
+		InventoryType:            models.InventoryType_OUTGOING,
+		InventoryTypeDescription: models.InventoryTypeDescription_TRANSIT_LOSS,
+		ExistingQuantity:         transitLot.Quantity,
+		UpdatedQuantity:          transitLot.Quantity - qty,
+		ReceiptID:                transitLot.ReceiptID,
+		TransferOrderID:          &order.ID,
+	}
+	serialUpdates := map[string]interface{}{
+		"status": models.MaterialSerialStatus_WrittenOff,
+	}
+
+	if resolution == models.TransferDiscrepancy_Return {
+		var source models.InventoryMaterial
+		if err := tx.First(&source, ol.SourceLotID).Error; err != nil {
+			return err
+		}
+		existingQty := source.Quantity
+		source.Quantity += qty
+		source.AvailableQty += qty
+		source.IsOutOfStock = false
+		if err := tx.Save(&source).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.InventoryMaterialTransaction{
+			InventoryMaterialID:      source.ID,
+			Quantity:                 qty,
+			InventoryType:            models.InventoryType_TRANSFER,
+			InventoryTypeDescription: models.InventoryTypeDescription_TRANSIT_RETURN,
+			ExistingQuantity:         existingQty,
+			ExistingReserve:          source.Reserve,
+			UpdatedQuantity:          source.Quantity,
+			UpdatedReserve:           source.Reserve,
+			ReceiptID:                source.ReceiptID,
+			TransferOrderID:          &order.ID,
+		}).Error; err != nil {
+			return err
+		}
+
+		transitTr.InventoryType = models.InventoryType_TRANSFER
+		transitTr.InventoryTypeDescription = models.InventoryTypeDescription_TRANSIT_RETURN
+		serialUpdates = map[string]interface{}{
+			"status":                models.MaterialSerialStatus_InStock,
+			"inventory_id":          source.InventoryID,
+			"inventory_material_id": source.ID,
+		}
+	}
+
+	if err := tx.Create(&transitTr).Error; err != nil {
+		return err
+	}
+	transitLot.Quantity -= qty
+	if err := tx.Model(transitLot).Update("quantity", transitLot.Quantity).Error; err != nil {
+		return err
+	}
+	if line.Material.IsSerialTracked {
+		if err := tx.
+			Model(&models.MaterialSerial{}).
+			Where("inventory_material_id = ? AND status = ?", transitLot.ID, models.MaterialSerialStatus_InTransit).
+			Updates(serialUpdates).Error; err != nil {
+			return err
+		}
+	}
+
+	ol.DiscrepancyQty += qty
+	return tx.Model(ol).Update("discrepancy_qty", ol.DiscrepancyQty).Error
+}
+
+func (toc *TransferOrderController) preloadTransferOrders(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("FromInventory").
+		Preload("ToInventory").
+		Preload("CreatedBy").
+		Preload("ShippedBy").
+		Preload("Lines.Material").
+		Preload("Lines.Lots")
+}
+
+func (toc *TransferOrderController) preloadTransferLots(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Lines.Material").
+		Preload("Lines.Lots", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id asc")
+		}).
+		Preload("Lines.Lots.TransitLot")
+}
+
+func transferOrderLine(order *models.TransferOrder, lineID uint) *models.TransferOrderLine {
+	for i := range order.Lines {
+		if order.Lines[i].ID == lineID {
+			return &order.Lines[i]
+		}
+	}
+	return nil
+}
+
+// inTransitInventory returns the virtual inventory holding shipped transfer orders,
+// it is created on first use.
+func inTransitInventory(tx *gorm.DB) (*models.Inventory, error) {
+	var inventory models.Inventory
+	if err := tx.
+		Where(models.Inventory{Slug: models.InTransitInventorySlug}).
+		Attrs(models.Inventory{Title: "In transit", IsVirtual: true}).
+		FirstOrCreate(&inventory).Error; err != nil {
+		return nil, err
+	}
+	return &inventory, nil
+}
+
+// createTransferOrder checks the inventories and the stock of every line and stores a pending
+// order with a new slug. The slugger row is created on first use for databases seeded before
+// transfer orders existed.
+func createTransferOrder(tx *gorm.DB, bc *BaseController, order *models.TransferOrder) error {
+	if order.FromInventoryID == order.ToInventoryID {
+		return errors.New("cannot transfer to the same inventory")
+	}
+	for _, id := range []uint{order.FromInventoryID, order.ToInventoryID} {
+		var inventory models.Inventory
+		if err := tx.First(&inventory, id).Error; err != nil {
+			return fmt.Errorf("inventory %d not found", id)
+		}
+		if inventory.IsVirtual {
+			return errors.New("cannot transfer from or to the in-transit inventory")
+		}
+	}
+
+	seen := make(map[uint]bool)
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		if seen[line.MaterialID] {
+			return fmt.Errorf("material %d is given twice", line.MaterialID)
+		}
+		seen[line.MaterialID] = true
+
+		var material models.Material
+		if err := tx.First(&material, line.MaterialID).Error; err != nil {
+			return fmt.Errorf("material %d not found", line.MaterialID)
+		}
+		if _, err := transferSourceLots(tx, order.FromInventoryID, &material, line.Quantity, line.Serials); err != nil {
+			return err
+		}
+	}
+
+	slugger := models.TransferOrder{}.GenerateSlug()
+	if err := tx.
+		Where(models.Slugger{TableName: slugger.TableName}).
+		Attrs(slugger).
+		FirstOrCreate(&models.Slugger{}).Error; err != nil {
+		return err
+	}
+	if err := bc.RequestSlug(&order.Slug, tx, slugger.TableName); err != nil {
+		return err
+	}
+	order.Status = models.TransferOrderStatus_Pending
+	if err := tx.Create(order).Error; err != nil {
+		return err
+	}
+	doc, err := transferOrderApprovalDocument(tx, order)
+	if err != nil {
+		return err
+	}
+	return openApprovalRequest(tx, doc)
+}
+
+// transferOrderApprovalDocument describes a transfer order for approval rule matching, at the
+// price of the lots its lines would be shipped from now.
+func transferOrderApprovalDocument(db *gorm.DB, order *models.TransferOrder) (approvalDocument, error) {
+	doc := approvalDocument{
+		Type:        models.ApprovalDocumentType_TransferOrder,
+		ID:          order.ID,
+		RequestedBy: &order.CreatedByID,
+	}
+	for _, line := range order.Lines {
+		material := line.Material
+		if material == nil {
+			material = &models.Material{}
+			if err := db.First(material, line.MaterialID).Error; err != nil {
+				return doc, err
+			}
+		}
+		lots, err := transferSourceLots(db, order.FromInventoryID, material, line.Quantity, line.Serials)
+		if err != nil {
+			return doc, err
+		}
+		targetQty := line.Quantity
+		for _, iv := range lots {
+			qty := iv.AvailableQty
+			if qty > targetQty {
+				qty = targetQty
+			}
+			doc.Amount += qty * iv.Price / 100
+			targetQty -= qty
+		}
+		doc.CategoryIDs = append(doc.CategoryIDs, material.CategoryID)
+	}
+	return doc, nil
+}
+
+// bulkTransferLines returns one line per material holding all unreserved stock of an
+// inventory that is not expired, serial tracked materials list their in-stock serials.
+func bulkTransferLines(tx *gorm.DB, inventoryID uint, categoryID *uint, materialIDs []uint) ([]models.TransferOrderLine, error) {
+	var lots []models.InventoryMaterial
+	q := tx.
+		Preload("Material").
+		Joins("JOIN materials ON materials.id = inventory_materials.material_id").
+		Where("inventory_materials.inventory_id = ?", inventoryID).
+		Where("inventory_materials.is_out_of_stock = ?", false).
+		Where("inventory_materials.available_qty > ?", 0).
+		Scopes(models.NotExpired).
+		Order("inventory_materials.material_id asc, inventory_materials.id asc")
+	if categoryID != nil {
+		q = q.Where("materials.category_id = ?", *categoryID)
+	}
+	if len(materialIDs) > 0 {
+		q = q.Where("inventory_materials.material_id IN ?", materialIDs)
+	}
+	if err := q.Find(&lots).Error; err != nil {
+		return nil, err
+	}
+
+	var lines []models.TransferOrderLine
+	index := make(map[uint]int)
+	for _, lot := range lots {
+		i, ok := index[lot.MaterialID]
+		if !ok {
+			i = len(lines)
+			index[lot.MaterialID] = i
+			lines = append(lines, models.TransferOrderLine{MaterialID: lot.MaterialID})
+		}
+		line := &lines[i]
+		if lot.Material == nil || !lot.Material.IsSerialTracked {
+			line.Quantity += lot.AvailableQty
+			continue
+		}
+
+		// reserved units of a lot stay, whichever serials they turn out to be
+		var serials []models.MaterialSerial
+		if err := tx.
+			Where("inventory_material_id = ? AND status = ?", lot.ID, models.MaterialSerialStatus_InStock).
+			Where("withdrawal_approvement_id IS NULL").
+			Order("id asc").
+			Limit(int(lot.AvailableQty / models.SerialQuantity)).
+			Find(&serials).Error; err != nil {
+			return nil, err
+		}
+		for _, s := range serials {
+			line.Serials = append(line.Serials, s.SerialNumber)
+		}
+		line.Quantity += int64(len(serials)) * models.SerialQuantity
+	}
+
+	// serial tracked lots may hold no free serial
+	filtered := lines[:0]
+	for _, line := range lines {
+		if line.Quantity > 0 {
+			filtered = append(filtered, line)
+		}
+	}
+	return filtered, nil
+}
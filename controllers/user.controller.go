@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"daijai/models"
+	"daijai/utils/password"
 	"errors"
 	"log"
 	"net/http"
@@ -37,8 +38,16 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := checkRoleExists(uc.DB.WithContext(c), user.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// the default password has to be changed on first login
 	user.Password = string(hashedPassword)
-	if err := uc.DB.Create(&user).Error; err != nil {
+	user.MustChangePassword = true
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	if err := uc.DB.WithContext(c).Create(&user).Error; err != nil {
 		var duplicateEntryError = &pgconn.PgError{Code: "23505"}
 		if errors.As(err, &duplicateEntryError) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Duplicate Username"})
@@ -48,13 +57,13 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully", "user": user})
+	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully", "user": user.UserToMember()})
 }
 
 // GetAllUsers gets all users.
 func (uc *UserController) GetAllUsers(c *gin.Context) {
 	var users []models.User
-	if err := uc.DB.Find(&users).Error; err != nil {
+	if err := uc.DB.WithContext(c).Find(&users).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
 		return
 	}
@@ -72,12 +81,12 @@ func (uc *UserController) GetUser(c *gin.Context) {
 	id := c.Param("id")
 
 	var user models.User
-	if err := uc.DB.First(&user, id).Error; err != nil {
+	if err := uc.DB.WithContext(c).First(&user, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, user.UserToMember())
 }
 
 // Update a user by ID
@@ -89,7 +98,7 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 	}
 
 	var user models.User
-	if err := uc.DB.First(&user, id).Error; err != nil {
+	if err := uc.DB.WithContext(c).First(&user, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -97,8 +106,13 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 	user.Slug = c.Request.FormValue("Slug")
 	user.Username = c.Request.FormValue("Username")
 	user.FullName = c.Request.FormValue("FullName")
+	previousRole := user.Role
 	user.Role = c.Request.FormValue("Role")
 	user.Tel = c.Request.FormValue("Tel")
+	if err := checkRoleExists(uc.DB.WithContext(c), user.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	_, header, err := c.Request.FormFile("image")
 	if err != nil {
@@ -116,16 +130,28 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 
 	user.ImagePath = path
 
-	if err := uc.DB.Save(&user).Error; err != nil {
+	if err := uc.DB.WithContext(c).Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
+	// access tokens carry the role
+	if user.Role != previousRole {
+		if err := revokeUserSessions(uc.DB.WithContext(c), user.ID, models.SessionRevokedReason_UserChanged); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully", "user": user})
+	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully", "user": user.UserToMember()})
 }
 
 func (uc *UserController) ResetPassword(c *gin.Context) {
-	var user models.User
+	// the body names the user it resets, keys match the user json
+	var user struct {
+		ID       uint
+		Username string
+		Password string
+	}
 
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -134,7 +160,7 @@ func (uc *UserController) ResetPassword(c *gin.Context) {
 
 	id := c.Param("id")
 	var qUser models.User
-	if err := uc.DB.First(&qUser, id).Error; err != nil {
+	if err := uc.DB.WithContext(c).First(&qUser, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -143,36 +169,71 @@ func (uc *UserController) ResetPassword(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User data not match"})
 		return
 	}
+	if !qUser.IsLocal() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The password is managed by the identity provider"})
+		return
+	}
 
+	if err := password.Validate(user.Password, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
-	user.Password = string(hashedPassword)
-	if err := uc.DB.Save(&user).Error; err != nil {
+	// the user picks an own password on next login
+	if err := uc.DB.WithContext(c).Model(&qUser).Updates(map[string]interface{}{
+		"password":             string(hashedPassword),
+		"must_change_password": true,
+		"failed_logins":        0,
+		"locked_until":         nil,
+	}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
+	if err := revokeUserSessions(uc.DB.WithContext(c), qUser.ID, models.SessionRevokedReason_UserChanged); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Reset user password successfully"})
 }
 
+// unlock an account locked by failed logins
+func (uc *UserController) UnlockUser(c *gin.Context) {
+	var user models.User
+	if err := uc.DB.WithContext(c).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := uc.DB.WithContext(c).Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
 // Delete a user by ID
 func (uc *UserController) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
 
 	var user models.User
-	if err := uc.DB.First(&user, id).Error; err != nil {
+	if err := uc.DB.WithContext(c).First(&user, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	if err := uc.DB.Delete(&user).Error; err != nil {
+	if err := uc.DB.WithContext(c).Delete(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
+	if err := revokeUserSessions(uc.DB.WithContext(c), user.ID, models.SessionRevokedReason_UserChanged); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
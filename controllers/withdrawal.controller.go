@@ -1,11 +1,14 @@
 package controllers
 
 import (
+	"daijai/documents"
 	"daijai/models"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -28,7 +31,7 @@ func NewWithdrawalController(db *gorm.DB) *WithdrawalController {
 func (wc *WithdrawalController) GetWithdrawalBySlug(c *gin.Context) {
 	slug := c.Param("slug")
 	var withdrawal models.Withdrawal
-	if err := wc.DB.
+	if err := wc.DB.WithContext(c).
 		Preload("Project").
 		Preload("Order.Drawing").
 		Preload("WithdrawalApprovements.WithdrawalTransactions.OrderReserving.OrderBOM.Material").
@@ -37,7 +40,7 @@ func (wc *WithdrawalController) GetWithdrawalBySlug(c *gin.Context) {
 		Preload("WithdrawalApprovements.ProjectStore").
 		Preload("Order.OrderBOMs.Material").
 		Preload("CreatedBy").
-		First(&withdrawal, "slug = ?", slug).Error; err != nil {
+		First(&withdrawal, "slug = ?", slug).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, &withdrawal) {
 		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Withdrawal not found"})
 		return
 	}
@@ -51,23 +54,22 @@ func (wc *WithdrawalController) GetAllWithdrawals(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var member models.Member
-	if err := wc.getUserDataByUserID(wc.DB, uid, &member); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	projectIDs, scoped, err := projectScope(wc.DB.WithContext(c), c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project scope"})
 		return
 	}
 	var withdrawals []models.Withdrawal
-	q := wc.DB.
+	q := wc.DB.WithContext(c).
 		Preload("Project").
 		Preload("Order.Drawing").
 		Preload("CreatedBy")
-	if member.Role == models.ROLE_Tech {
-		q.Find(&withdrawals, "created_by_id = ?", member.ID)
-	} else {
-		q.Find(&withdrawals)
+	// project scoped users see the withdrawals of their projects and their own
+	if scoped {
+		q = q.Where("project_id IN ? OR created_by_id = ?", projectIDs, uid)
 	}
 
-	if err := q.Error; err != nil {
+	if err := q.Find(&withdrawals).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve withdrawals"})
 		return
 	}
@@ -94,14 +96,18 @@ func (wc *WithdrawalController) CreateNonSpecificOrderWithdrawal(c *gin.Context)
 		return
 	}
 	var member models.Member
-	if err := wc.getUserDataByUserID(wc.DB, uid, &member); err != nil {
+	if err := wc.getUserDataByUserID(wc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !canSeeProject(wc.DB.WithContext(c), c, uint(request.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
 
 	var withdrawal models.Withdrawal
 	var withdrawalApprovement models.WithdrawalApprovement
-	if err := wc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		withdrawal.Slug = request.Slug
 		withdrawal.ProjectID = uint(request.ProjectID)
 		withdrawal.Notes = request.Notes
@@ -120,7 +126,7 @@ func (wc *WithdrawalController) CreateNonSpecificOrderWithdrawal(c *gin.Context)
 		if err := tx.Create(&withdrawalApprovement).Error; err != nil {
 			return err
 		}
-		return nil
+		return openWithdrawalApproval(tx, withdrawalApprovement.ID)
 	}); err != nil {
 		message := fmt.Sprintf("Failed to create Withdraw: %s", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
@@ -152,21 +158,43 @@ func (wc *WithdrawalController) CreateWithdrawalAdmin(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := wc.getUserDataByUserID(wc.DB, uid, &member); err != nil {
+	if err := wc.getUserDataByUserID(wc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// check role is belong to admin or manager
-	canFindAll := member.Role == models.ROLE_Admin
-	if !canFindAll {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Permission Denied"})
+	if !canSeeProject(wc.DB.WithContext(c), c, uint(request.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// admin withdrawals are approved when created, so the creator must be able to
+	// give every approval the withdrawal needs
+	projectID := uint(request.ProjectID)
+	doc := approvalDocument{
+		Type:      models.ApprovalDocumentType_Withdrawal,
+		ProjectID: &projectID,
+	}
+	var materialIDs []uint
+	for _, wm := range request.WithdrawMaterials {
+		var material models.Material
+		if err := wc.DB.WithContext(c).First(&material, wm.MaterialID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Material not found", "materialID": wm.MaterialID})
+			return
+		}
+		doc.Amount += wm.Quantity * material.DefaultPrice / 100
+		materialIDs = append(materialIDs, material.ID)
+	}
+	if err := withdrawalCategoryIDs(wc.DB.WithContext(c), materialIDs, &doc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get materials"})
 		return
 	}
 
 	var withdrawal models.Withdrawal
 	var withdrawalApprovement models.WithdrawalApprovement
-	if err := wc.DB.Transaction(func(tx *gorm.DB) error {
+	// refusal is the error of a withdrawal the stock cannot cover, any other error is a failure
+	var refusal error
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 
 		withdrawal.Slug = request.Slug
 		withdrawal.ProjectID = uint(request.ProjectID)
@@ -189,6 +217,19 @@ func (wc *WithdrawalController) CreateWithdrawalAdmin(c *gin.Context) {
 			return err
 		}
 
+		doc.ID = withdrawalApprovement.ID
+		doc.RequestedBy = &member.ID
+		step, err := nextApproval(tx, doc, &member)
+		if err != nil {
+			return err
+		}
+		if !step.IsFinal {
+			return fmt.Errorf("withdrawal needs %d approvals, create a regular withdrawal instead", step.Request.StepCount)
+		}
+		if err := recordApproval(tx, step, &member, request.Notes); err != nil {
+			return err
+		}
+
 		// create admin withdrawal transaction
 		for _, wm := range request.WithdrawMaterials {
 			log.Println("--------wm-------")
@@ -202,16 +243,43 @@ func (wc *WithdrawalController) CreateWithdrawalAdmin(c *gin.Context) {
 				return err
 			}
 
+			// serial tracked materials are issued from the lots of the given serials
+			var material models.Material
+			if err := tx.First(&material, wm.MaterialID).Error; err != nil {
+				return err
+			}
+			serials, plan, err := serialPlan(tx, &material, wm.Serials, wm.Quantity, models.MaterialSerialStatus_InStock, inventoryLotOf)
+			if err != nil {
+				return err
+			}
+
 			// get InventoryMaterial by id
 			var invMats []models.InventoryMaterial
-			if err := tx.
+			q := tx.
 				Where("material_id = ?", wm.MaterialID).
 				Where("available_qty > ?", 0).
 				Where("is_out_of_stock = ?", false).
-				Find(&invMats).
-				Error; err != nil {
+				Scopes(models.NotExpired)
+			if plan != nil {
+				lotIDs := make([]uint, 0, len(plan))
+				for lotID := range plan {
+					lotIDs = append(lotIDs, lotID)
+				}
+				q = q.Where("id IN ?", lotIDs)
+			}
+			if err := q.Find(&invMats).Error; err != nil {
 				return err
 			}
+			if plan != nil {
+				for _, invMat := range invMats {
+					if invMat.AvailableQty < plan[invMat.ID] {
+						return fmt.Errorf("serials of %s are not available", models.LotNumber(invMat.ID))
+					}
+				}
+				if len(invMats) != len(plan) {
+					return fmt.Errorf("serials of material %s are not available", material.Slug)
+				}
+			}
 
 			needQty := wm.Quantity
 			log.Println("--------BEGIN:needQty: ", needQty, "-------")
@@ -224,11 +292,15 @@ func (wc *WithdrawalController) CreateWithdrawalAdmin(c *gin.Context) {
 					break
 				}
 
+				lotNeedQty := needQty
+				if plan != nil {
+					lotNeedQty = plan[invMat.ID]
+				}
 				existingQty := invMat.AvailableQty
-				if existingQty >= needQty {
-					invMat.Withdrawed += needQty
-					invMat.AvailableQty = invMat.AvailableQty - needQty
-					needQty = 0
+				if existingQty >= lotNeedQty {
+					invMat.Withdrawed += lotNeedQty
+					invMat.AvailableQty = invMat.AvailableQty - lotNeedQty
+					needQty -= lotNeedQty
 				} else {
 					invMat.Withdrawed += existingQty
 					invMat.AvailableQty = 0
@@ -244,6 +316,16 @@ func (wc *WithdrawalController) CreateWithdrawalAdmin(c *gin.Context) {
 					return err
 				}
 
+				// move withdrawn quantity into project store
+				withdrawedQty := existingQty - invMat.AvailableQty
+				storeMaterial, err := stockProjectStore(tx, withdrawalApprovement.ProjectStoreID, &invMat, withdrawedQty, withdrawal.ID, &member.ID)
+				if err != nil {
+					return err
+				}
+				if err := issueSerials(tx, serialIDsInLot(serials, inventoryLotOf, invMat.ID), storeMaterial, withdrawal.ID); err != nil {
+					return err
+				}
+
 				// sum material
 				matID := invMat.MaterialID
 				invID := invMat.InventoryID
@@ -265,11 +347,20 @@ func (wc *WithdrawalController) CreateWithdrawalAdmin(c *gin.Context) {
 					return err
 				}
 			}
+			// expired lots are not issued, they may leave the rest short
+			if needQty > 0 {
+				refusal = fmt.Errorf("not enough %s in inventory", material.Slug)
+				return refusal
+			}
 		}
 		return nil
 	}); err != nil {
+		status := http.StatusInternalServerError
+		if err == refusal {
+			status = http.StatusBadRequest
+		}
 		message := fmt.Sprintf("Failed to create Withdraw: %s", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+		c.JSON(status, gin.H{"error": message})
 		return
 
 	}
@@ -297,13 +388,13 @@ func (wc *WithdrawalController) CreateWithdrawal(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := wc.getUserDataByUserID(wc.DB, uid, &member); err != nil {
+	if err := wc.getUserDataByUserID(wc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	var order models.Order
-	if err := wc.DB.
+	if err := wc.DB.WithContext(c).
 		Preload("Drawing").
 		Preload("OrderBOMs").
 		Preload("OrderReservings").
@@ -312,12 +403,20 @@ func (wc *WithdrawalController) CreateWithdrawal(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Order"})
 		return
 	}
+	if !canSeeProject(wc.DB.WithContext(c), c, order.ProjectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if !canSeeProject(wc.DB.WithContext(c), c, uint(request.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
 
 	var withdrawal models.Withdrawal
 	var withdrawalApprovement models.WithdrawalApprovement
 	orderID := uint(request.OrderID)
 
-	if err := wc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		withdrawal.Slug = request.Slug
 		withdrawal.OrderID = &orderID
 		withdrawal.ProjectID = uint(request.ProjectID)
@@ -348,6 +447,9 @@ func (wc *WithdrawalController) CreateWithdrawal(c *gin.Context) {
 				return err
 			}
 		}
+		if err := openWithdrawalApproval(tx, withdrawalApprovement.ID); err != nil {
+			return err
+		}
 
 		// update order status
 		order.Status = models.OrderStatus_InProgress
@@ -385,12 +487,16 @@ func (wc *WithdrawalController) UpdateWithdrawal(c *gin.Context) {
 		return
 	}
 	var withdrawal models.Withdrawal
-	if err := wc.DB.Preload("Project").Preload("CreatedBy").First(&withdrawal, withdrawalID).Error; err != nil {
+	if err := wc.DB.WithContext(c).Preload("Project").Preload("CreatedBy").First(&withdrawal, withdrawalID).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, &withdrawal) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found", "id": withdrawalID})
 		return
 	}
+	if !canSeeProject(wc.DB.WithContext(c), c, request.Withdrawal.ProjectID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
 
-	if err := wc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		pID := request.Withdrawal.ProjectID
 		withdrawal.ProjectID = pID
 		withdrawal.Project.ID = pID
@@ -416,17 +522,11 @@ func (wc *WithdrawalController) ApproveWithdrawal(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := wc.getUserDataByUserID(wc.DB, uid, &member); err != nil {
+	if err := wc.getUserDataByUserID(wc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	canFindAll := member.Role == models.ROLE_Admin || member.Role == models.ROLE_Manager
-	if !canFindAll {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Permission Denied"})
-		return
-	}
-
 	withdrawalApprovementID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid withdrawal ID"})
@@ -434,11 +534,11 @@ func (wc *WithdrawalController) ApproveWithdrawal(c *gin.Context) {
 	}
 
 	var wapm models.WithdrawalApprovement
-	if err := wc.DB.
+	if err := wc.DB.WithContext(c).
 		Preload("Withdrawal.Order.OrderBOMs").
 		Preload("WithdrawalTransactions.OrderReserving.InventoryMaterial").
 		Preload("WithdrawalTransactions.OrderReserving.OrderBOM").
-		First(&wapm, withdrawalApprovementID).Error; err != nil {
+		First(&wapm, withdrawalApprovementID).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, wapm.Withdrawal) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found", "id": withdrawalApprovementID})
 		return
 	}
@@ -447,8 +547,43 @@ func (wc *WithdrawalController) ApproveWithdrawal(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal is already approved or rejected"})
 		return
 	}
+	scanLines, err := withdrawalScanLines(wc.DB.WithContext(c), &wapm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build pick list", "detail": err.Error()})
+		return
+	}
+	if err := checkScanSession(wc.DB.WithContext(c), "withdrawal_approvement_id", wapm.ID, scanLines); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// expired lots must be written off, not issued
+	for _, wts := range *wapm.WithdrawalTransactions {
+		if wts.OrderReserving.InventoryMaterial.IsExpired() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reserved lot is expired", "lotNumber": models.LotNumber(wts.OrderReserving.InventoryMaterialID)})
+			return
+		}
+	}
+	lotSerials, err := withdrawalSerials(wc.DB.WithContext(c), &wapm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, err := withdrawalApprovalDocument(wc.DB.WithContext(c), &wapm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get materials"})
+		return
+	}
+	step := approveOrRespond(c, wc.DB.WithContext(c), doc, &member)
+	if step == nil {
+		return
+	}
+
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := recordApproval(tx, step, &member, c.Query("comment")); err != nil {
+			return err
+		}
 
-	if err := wc.DB.Transaction(func(tx *gorm.DB) error {
 		// update withdraw transactions
 		wapm.WithdrawalApprovementStatus = models.WithdrawalApprovementStatus_Approved
 		wapm.ApprovedByID = &member.ID
@@ -478,6 +613,19 @@ func (wc *WithdrawalController) ApproveWithdrawal(c *gin.Context) {
 				return err
 			}
 
+			// move withdrawn quantity into project store
+			storeMaterial, err := stockProjectStore(tx, wapm.ProjectStoreID, reserve.InventoryMaterial, reserve.Quantity, wapm.WithdrawalID, &member.ID)
+			if err != nil {
+				return err
+			}
+			if queue := lotSerials[reserve.InventoryMaterialID]; len(queue) > 0 {
+				n := int(reserve.Quantity / models.SerialQuantity)
+				if err := issueSerials(tx, queue[:n], storeMaterial, wapm.WithdrawalID); err != nil {
+					return err
+				}
+				lotSerials[reserve.InventoryMaterialID] = queue[n:]
+			}
+
 			// sum material
 			matID := reserve.InventoryMaterial.MaterialID
 			invID := reserve.InventoryMaterial.InventoryID
@@ -495,7 +643,7 @@ func (wc *WithdrawalController) ApproveWithdrawal(c *gin.Context) {
 				UpdatedReserve:           reserve.InventoryMaterial.Reserve,
 				WithdrawalID:             &wapm.WithdrawalID,
 			}
-			if err := wc.DB.Create(&matTr).Error; err != nil {
+			if err := tx.Create(&matTr).Error; err != nil {
 				return err
 			}
 		}
@@ -559,7 +707,7 @@ func (wc *WithdrawalController) ApproveWithdrawal(c *gin.Context) {
 	}
 
 	var withdrawal models.Withdrawal
-	if err := wc.DB.
+	if err := wc.DB.WithContext(c).
 		Preload("Project").
 		Preload("Order.Drawing").
 		Preload("WithdrawalApprovements.WithdrawalTransactions.OrderReserving.OrderBOM.Material").
@@ -577,7 +725,8 @@ func (wc *WithdrawalController) ApproveWithdrawal(c *gin.Context) {
 // CreatePartialWithdrawal handles the creation of a partial withdrawal transaction.
 func (wc *WithdrawalController) CreatePartialWithdrawal(c *gin.Context) {
 	var request struct {
-		WithdrawalID int `json:"WithdrawalID"`
+		WithdrawalID   int `json:"WithdrawalID"`
+		ProjectStoreID int `json:"ProjectStoreID"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -591,16 +740,17 @@ func (wc *WithdrawalController) CreatePartialWithdrawal(c *gin.Context) {
 		return
 	}
 	var member models.Member
-	if err := wc.getUserDataByUserID(wc.DB, uid, &member); err != nil {
+	if err := wc.getUserDataByUserID(wc.DB.WithContext(c), uid, &member); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	var withdrawal models.Withdrawal
-	if err := wc.DB.
+	if err := wc.DB.WithContext(c).
 		Preload("Project").
 		Preload("Order.OrderReservings").
-		First(&withdrawal, request.WithdrawalID).Error; err != nil {
+		Preload("WithdrawalApprovements").
+		First(&withdrawal, request.WithdrawalID).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, &withdrawal) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found"})
 		return
 	}
@@ -615,12 +765,23 @@ func (wc *WithdrawalController) CreatePartialWithdrawal(c *gin.Context) {
 		return
 	}
 
+	// use project store of previous approvement when not specified
+	projectStoreID := uint(request.ProjectStoreID)
+	if projectStoreID == 0 && withdrawal.WithdrawalApprovements != nil {
+		for _, wa := range *withdrawal.WithdrawalApprovements {
+			if wa.ProjectStoreID != 0 {
+				projectStoreID = wa.ProjectStoreID
+			}
+		}
+	}
+
 	// create withdrawal approvement
 	var withdrawalApprovement models.WithdrawalApprovement
-	if err := wc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		withdrawalApprovement = models.WithdrawalApprovement{
 			WithdrawalID:                withdrawal.ID,
 			WithdrawalApprovementStatus: models.WithdrawalApprovementStatus_Pending,
+			ProjectStoreID:              projectStoreID,
 		}
 
 		if err := tx.Create(&withdrawalApprovement).Error; err != nil {
@@ -639,7 +800,7 @@ func (wc *WithdrawalController) CreatePartialWithdrawal(c *gin.Context) {
 				}
 			}
 		}
-		return nil
+		return openWithdrawalApproval(tx, withdrawalApprovement.ID)
 	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Withdraw"})
 		return
@@ -649,15 +810,52 @@ func (wc *WithdrawalController) CreatePartialWithdrawal(c *gin.Context) {
 
 }
 
-// DeleteMaterial deletes a specific material by ID.
+// DeleteWithdraw deletes a withdrawal that has nothing approved yet, approved withdrawals
+// are reversed instead.
 func (mc *WithdrawalController) DeleteWithdraw(c *gin.Context) {
-	materialID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	withdrawalID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid material ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid withdrawal ID"})
 		return
 	}
 
-	if err := mc.DB.Delete(&models.Withdrawal{}, materialID).Error; err != nil {
+	var withdrawal models.Withdrawal
+	if err := mc.DB.WithContext(c).Preload("WithdrawalApprovements").First(&withdrawal, withdrawalID).Error; err != nil || !canSeeWithdrawal(mc.DB.WithContext(c), c, &withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found"})
+		return
+	}
+	var approvementIDs []uint
+	if withdrawal.WithdrawalApprovements != nil {
+		for _, wa := range *withdrawal.WithdrawalApprovements {
+			if wa.WithdrawalApprovementStatus == models.WithdrawalApprovementStatus_Approved {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal is approved, reverse it instead"})
+				return
+			}
+			approvementIDs = append(approvementIDs, wa.ID)
+		}
+	}
+
+	if err := mc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if len(approvementIDs) > 0 {
+			if err := tx.
+				Model(&models.WithdrawalApprovement{}).
+				Where("id IN ? AND withdrawal_approvement_status = ?", approvementIDs, models.WithdrawalApprovementStatus_Pending).
+				Update("withdrawal_approvement_status", models.WithdrawalApprovementStatus_Rejected).Error; err != nil {
+				return err
+			}
+			// release serials assigned to the pending approvements
+			if err := tx.
+				Model(&models.MaterialSerial{}).
+				Where("withdrawal_approvement_id IN ?", approvementIDs).
+				Update("withdrawal_approvement_id", nil).Error; err != nil {
+				return err
+			}
+			if err := dropApprovalRequests(tx, models.ApprovalDocumentType_Withdrawal, approvementIDs...); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&withdrawal).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete withdrawal"})
 		return
 	}
@@ -665,11 +863,279 @@ func (mc *WithdrawalController) DeleteWithdraw(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Withdrawal deleted successfully"})
 }
 
+// ReverseWithdrawal undoes the approved approvements of a withdrawal. Order withdrawals get
+// their reservations back, other withdrawals return the quantity to the lots it came from.
+// The stock must still be untouched in the project store.
+func (wc *WithdrawalController) ReverseWithdrawal(c *gin.Context) {
+	var uid uint
+	if err := wc.GetUserID(c, &uid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var member models.Member
+	if err := wc.getUserDataByUserID(wc.DB.WithContext(c), uid, &member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		Reason string `json:"Reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var withdrawal models.Withdrawal
+	if err := wc.DB.WithContext(c).
+		Preload("Order").
+		Preload("WithdrawalApprovements.WithdrawalTransactions.OrderReserving.InventoryMaterial").
+		Preload("WithdrawalApprovements.WithdrawalTransactions.OrderReserving.OrderBOM").
+		First(&withdrawal, c.Param("id")).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, &withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found"})
+		return
+	}
+	if withdrawal.WithdrawalStatus == models.WithdrawalStatus_Reversed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal already reversed"})
+		return
+	}
+	var approved []models.WithdrawalApprovement
+	var pendingIDs []uint
+	if withdrawal.WithdrawalApprovements != nil {
+		for _, wa := range *withdrawal.WithdrawalApprovements {
+			switch wa.WithdrawalApprovementStatus {
+			case models.WithdrawalApprovementStatus_Approved:
+				approved = append(approved, wa)
+			case models.WithdrawalApprovementStatus_Pending:
+				pendingIDs = append(pendingIDs, wa.ID)
+			}
+		}
+	}
+	if len(approved) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal is not approved, delete it instead"})
+		return
+	}
+
+	// withdrawn stock must still be in the project store
+	var storeMats []models.ProjectStoreMaterial
+	if err := wc.DB.WithContext(c).Where("withdrawal_id = ?", withdrawal.ID).Find(&storeMats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get project store materials"})
+		return
+	}
+	for _, sm := range storeMats {
+		if sm.ConsumedQty > 0 || sm.ReturnedQty > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawn stock was consumed or returned from the project store", "projectStoreMaterialID": sm.ID})
+			return
+		}
+	}
+
+	now := time.Now()
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		sums := make(map[[2]uint]bool)
+		for _, wa := range approved {
+			if wa.WithdrawalTransactions == nil {
+				continue
+			}
+			for _, wts := range *wa.WithdrawalTransactions {
+				reserve := wts.OrderReserving
+				if reserve == nil || reserve.InventoryMaterial == nil {
+					continue
+				}
+
+				// the quantity goes back to its reservation
+				invMat := reserve.InventoryMaterial
+				existingReserve := invMat.Reserve
+				invMat.Withdrawed -= reserve.Quantity
+				invMat.Reserve += reserve.Quantity
+				if err := tx.Save(invMat).Error; err != nil {
+					return err
+				}
+
+				odb := reserve.OrderBOM
+				odb.WithdrawedQty -= reserve.Quantity
+				odb.ReservedQty += reserve.Quantity
+				odb.IsCompletelyWithdraw = false
+				if err := tx.Save(&odb).Error; err != nil {
+					return err
+				}
+
+				reserve.Status = models.OrderReservingStatus_Reserved
+				if err := tx.Omit("InventoryMaterial", "OrderBOM").Save(reserve).Error; err != nil {
+					return err
+				}
+
+				matTr := models.InventoryMaterialTransaction{
+					InventoryMaterialID:      invMat.ID,
+					Quantity:                 reserve.Quantity,
+					InventoryType:            models.InventoryType_INCOMING,
+					InventoryTypeDescription: models.InventoryTypeDescription_WITHDRAWAL_REVERSAL,
+					ExistingQuantity:         invMat.AvailableQty,
+					ExistingReserve:          existingReserve,
+					UpdatedQuantity:          invMat.AvailableQty,
+					UpdatedReserve:           invMat.Reserve,
+					ReceiptID:                invMat.ReceiptID,
+					OrderID:                  withdrawal.OrderID,
+					WithdrawalID:             &withdrawal.ID,
+				}
+				if err := tx.Create(&matTr).Error; err != nil {
+					return err
+				}
+				sums[[2]uint{invMat.MaterialID, invMat.InventoryID}] = true
+			}
+		}
+
+		// withdrawals without an order took the quantity straight from the lots
+		if withdrawal.OrderID == nil {
+			var matTrs []models.InventoryMaterialTransaction
+			if err := tx.
+				Where("withdrawal_id = ? AND inventory_type = ?", withdrawal.ID, models.InventoryType_OUTGOING).
+				Where("inventory_type_description = ?", models.InventoryTypeDescription_WITHDRAWAL).
+				Order("id asc").
+				Find(&matTrs).Error; err != nil {
+				return err
+			}
+			for _, tr := range matTrs {
+				qty := tr.ExistingQuantity - tr.UpdatedQuantity
+				if qty <= 0 {
+					continue
+				}
+				var invMat models.InventoryMaterial
+				if err := tx.First(&invMat, tr.InventoryMaterialID).Error; err != nil {
+					return err
+				}
+				existingQty := invMat.AvailableQty
+				invMat.Withdrawed -= qty
+				invMat.AvailableQty += qty
+				invMat.IsOutOfStock = false
+				if err := tx.Save(&invMat).Error; err != nil {
+					return err
+				}
+
+				reverseTr := models.InventoryMaterialTransaction{
+					InventoryMaterialID:      invMat.ID,
+					Quantity:                 qty,
+					InventoryType:            models.InventoryType_INCOMING,
+					InventoryTypeDescription: models.InventoryTypeDescription_WITHDRAWAL_REVERSAL,
+					ExistingQuantity:         existingQty,
+					ExistingReserve:          invMat.Reserve,
+					UpdatedQuantity:          invMat.AvailableQty,
+					UpdatedReserve:           invMat.Reserve,
+					ReceiptID:                invMat.ReceiptID,
+					WithdrawalID:             &withdrawal.ID,
+				}
+				if err := tx.Create(&reverseTr).Error; err != nil {
+					return err
+				}
+				sums[[2]uint{invMat.MaterialID, invMat.InventoryID}] = true
+			}
+		}
+
+		// take the stock out of the project store
+		for _, sm := range storeMats {
+			if sm.AvailableQty == 0 {
+				continue
+			}
+			storeTr := models.ProjectStoreTransaction{
+				ProjectStoreMaterialID: sm.ID,
+				Quantity:               sm.AvailableQty,
+				TransactionType:        models.ProjectStoreTransactionType_Reversal,
+				ExistingQuantity:       sm.AvailableQty,
+				UpdatedQuantity:        0,
+				WithdrawalID:           &withdrawal.ID,
+				Notes:                  request.Reason,
+				CreatedByID:            &member.ID,
+			}
+			if err := tx.Create(&storeTr).Error; err != nil {
+				return err
+			}
+			sm.Quantity -= sm.AvailableQty
+			sm.AvailableQty = 0
+			sm.IsOutOfStock = true
+			if err := tx.Save(&sm).Error; err != nil {
+				return err
+			}
+		}
+
+		// issued serials are back in stock in their lots
+		if err := tx.
+			Model(&models.MaterialSerial{}).
+			Where("withdrawal_id = ?", withdrawal.ID).
+			Where("status IN ?", []string{models.MaterialSerialStatus_Issued, models.MaterialSerialStatus_InProject}).
+			Updates(map[string]interface{}{
+				"status":                    models.MaterialSerialStatus_InStock,
+				"withdrawal_id":             nil,
+				"project_store_id":          nil,
+				"project_store_material_id": nil,
+			}).Error; err != nil {
+			return err
+		}
+
+		for key := range sums {
+			if err := wc.SumMaterial(tx, "withdrawal-reversal", key[0], key[1]); err != nil {
+				return err
+			}
+		}
+
+		for _, wa := range approved {
+			if err := tx.
+				Model(&models.WithdrawalApprovement{}).
+				Where("id = ?", wa.ID).
+				Update("withdrawal_approvement_status", models.WithdrawalApprovementStatus_Reversed).Error; err != nil {
+				return err
+			}
+		}
+		if len(pendingIDs) > 0 {
+			if err := tx.
+				Model(&models.WithdrawalApprovement{}).
+				Where("id IN ?", pendingIDs).
+				Update("withdrawal_approvement_status", models.WithdrawalApprovementStatus_Rejected).Error; err != nil {
+				return err
+			}
+			if err := tx.
+				Model(&models.MaterialSerial{}).
+				Where("withdrawal_approvement_id IN ?", pendingIDs).
+				Update("withdrawal_approvement_id", nil).Error; err != nil {
+				return err
+			}
+			if err := dropApprovalRequests(tx, models.ApprovalDocumentType_Withdrawal, pendingIDs...); err != nil {
+				return err
+			}
+		}
+
+		// the order is fully reserved again and waits for a new withdrawal
+		if order := withdrawal.Order; order != nil {
+			order.Status = models.OrderStatus_InProgress
+			if order.PlanStatus == models.OrderPlanStatus_Complete {
+				order.PlanStatus = models.OrderPlanStatus_Staged
+			}
+			if err := tx.Save(order).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.
+			Model(&models.Withdrawal{}).
+			Where("id = ?", withdrawal.ID).
+			Updates(map[string]interface{}{
+				"withdrawal_status": models.WithdrawalStatus_Reversed,
+				"reversed_at":       now,
+				"reversed_by_id":    member.ID,
+				"reverse_reason":    request.Reason,
+			}).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reverse withdrawal", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Withdrawal reversed successfully"})
+}
+
 func (mc *WithdrawalController) GetNewWithdrawAdminInfo(c *gin.Context) {
 	// get projects
 	var projects []models.Project
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("ProjectStores").
 		Find(&projects).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Projects"})
@@ -678,7 +1144,7 @@ func (mc *WithdrawalController) GetNewWithdrawAdminInfo(c *gin.Context) {
 
 	// get categories
 	var categories []models.Category
-	if err := mc.DB.
+	if err := mc.DB.WithContext(c).
 		Preload("Materials", func(db *gorm.DB) *gorm.DB {
 			db = db.Order("id asc")
 			return db
@@ -692,7 +1158,7 @@ func (mc *WithdrawalController) GetNewWithdrawAdminInfo(c *gin.Context) {
 
 	// get slug
 	var slug string
-	if err := mc.RequestSlug(&slug, mc.DB, "withdrawals"); err != nil {
+	if err := mc.RequestSlug(&slug, mc.DB.WithContext(c), "withdrawals"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Slug", "detail": err.Error()})
 		return
 	}
@@ -713,6 +1179,7 @@ func (mc *WithdrawalController) GetNewWithdrawInfo(c *gin.Context) {
 	var projects []models.Project
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("ProjectStores").
 		Find(&projects).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Projects"})
@@ -721,6 +1188,7 @@ func (mc *WithdrawalController) GetNewWithdrawInfo(c *gin.Context) {
 	var orders []models.Order
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("Drawing").
 		Where("status IN (?)", models.OrderStatus_Pending).
 		Find(&orders).
@@ -731,7 +1199,7 @@ func (mc *WithdrawalController) GetNewWithdrawInfo(c *gin.Context) {
 
 	// get slug
 	var slug string
-	if err := mc.RequestSlug(&slug, mc.DB, "withdrawals"); err != nil {
+	if err := mc.RequestSlug(&slug, mc.DB.WithContext(c), "withdrawals"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Slug", "detail": err.Error()})
 		return
 	}
@@ -767,6 +1235,7 @@ func (mc *WithdrawalController) AdjustOrderReserving(c *gin.Context) {
 	var orderReserving models.OrderReserving
 	if err := mc.
 		DB.
+		WithContext(c).
 		Preload("InventoryMaterial").
 		First(&orderReserving, orderReservingID).
 		Error; err != nil {
@@ -780,10 +1249,10 @@ func (mc *WithdrawalController) AdjustOrderReserving(c *gin.Context) {
 	}
 
 	// create db transaction
-	if err := mc.DB.Transaction(func(tx *gorm.DB) error {
+	if err := mc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
 		diff := request.AdjustedQuantity - orderReserving.Quantity
 		needQty := diff
-		if orderReserving.InventoryMaterial.AvailableQty > 0 {
+		if orderReserving.InventoryMaterial.AvailableQty > 0 && !orderReserving.InventoryMaterial.IsExpired() {
 			ivtm := orderReserving.InventoryMaterial
 			avialableQty := ivtm.AvailableQty
 			var used int64
@@ -817,6 +1286,7 @@ func (mc *WithdrawalController) AdjustOrderReserving(c *gin.Context) {
 				Where("material_id = ?", orderReserving.InventoryMaterial.MaterialID).
 				Where("available_qty > ?", 0).
 				Where("is_out_of_stock = ?", false).
+				Scopes(models.NotExpired).
 				Find(&invMats).
 				Error; err != nil {
 				return err
@@ -887,3 +1357,326 @@ func createMaterialTransaction(invMat *models.InventoryMaterial, reserveQty int6
 		return nil
 	}
 }
+
+// GetPickList lists which lot and bin to pick for a withdrawal approvement.
+func (wc *WithdrawalController) GetPickList(c *gin.Context) {
+	var wapm models.WithdrawalApprovement
+	if err := wc.
+		DB.
+		WithContext(c).
+		Preload("Withdrawal.Project").
+		Preload("ProjectStore").
+		First(&wapm, c.Param("id")).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, wapm.Withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal approvement not found"})
+		return
+	}
+
+	lines, err := buildPickList(wc.DB.WithContext(c), &wapm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build pick list", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"withdrawalApprovement": wapm, "lines": lines})
+}
+
+// buildPickList collects the lots of a withdrawal approvement, from its order reservings
+// or, for withdrawals without order, from the outgoing ledger entries.
+func buildPickList(db *gorm.DB, wapm *models.WithdrawalApprovement) ([]models.PickListLine, error) {
+	lines := []models.PickListLine{}
+	appendLine := func(invMat *models.InventoryMaterial, quantity int64) {
+		line := models.PickListLine{
+			MaterialID:          invMat.MaterialID,
+			InventoryMaterialID: invMat.ID,
+			LotNumber:           models.LotNumber(invMat.ID),
+			ReceiptID:           invMat.ReceiptID,
+			InventoryID:         invMat.InventoryID,
+			LocationID:          invMat.LocationID,
+			Quantity:            quantity,
+		}
+		if invMat.Material != nil {
+			line.MaterialSlug = invMat.Material.Slug
+			line.MaterialTitle = invMat.Material.Title
+		}
+		if invMat.Inventory != nil {
+			line.InventoryTitle = invMat.Inventory.Title
+		}
+		if invMat.Location != nil {
+			line.LocationSlug = invMat.Location.Slug
+		}
+		lines = append(lines, line)
+	}
+
+	var transactions []models.WithdrawalTransaction
+	if err := db.
+		Preload("OrderReserving.InventoryMaterial.Material").
+		Preload("OrderReserving.InventoryMaterial.Inventory").
+		Preload("OrderReserving.InventoryMaterial.Location").
+		Where("withdrawal_approvement_id = ?", wapm.ID).
+		Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	for _, wt := range transactions {
+		if wt.OrderReserving == nil || wt.OrderReserving.InventoryMaterial == nil {
+			continue
+		}
+		appendLine(wt.OrderReserving.InventoryMaterial, wt.OrderReserving.Quantity)
+	}
+	if len(transactions) > 0 {
+		return lines, nil
+	}
+
+	var matTrs []models.InventoryMaterialTransaction
+	if err := db.
+		Preload("InventoryMaterial.Material").
+		Preload("InventoryMaterial.Inventory").
+		Preload("InventoryMaterial.Location").
+		Where("withdrawal_id = ?", wapm.WithdrawalID).
+		Where("inventory_type = ?", models.InventoryType_OUTGOING).
+		Where("inventory_type_description = ?", models.InventoryTypeDescription_WITHDRAWAL).
+		Order("id asc").
+		Find(&matTrs).Error; err != nil {
+		return nil, err
+	}
+	for _, tr := range matTrs {
+		if tr.InventoryMaterial == nil {
+			continue
+		}
+		appendLine(tr.InventoryMaterial, tr.ExistingQuantity-tr.UpdatedQuantity)
+	}
+	return lines, nil
+}
+
+// GetPickListDocument renders the pick list of a withdrawal approvement as pdf or html.
+func (wc *WithdrawalController) GetPickListDocument(c *gin.Context) {
+	var wapm models.WithdrawalApprovement
+	if err := wc.
+		DB.
+		WithContext(c).
+		Preload("Withdrawal.Project").
+		Preload("Withdrawal.Order").
+		Preload("Withdrawal.CreatedBy").
+		Preload("ProjectStore").
+		First(&wapm, c.Param("id")).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, wapm.Withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal approvement not found"})
+		return
+	}
+
+	lines, err := buildPickList(wc.DB.WithContext(c), &wapm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build pick list", "detail": err.Error()})
+		return
+	}
+	wc.RenderDocument(c, documents.PickList(&wapm, lines), c.Param("format"))
+}
+
+// GetIssueSlipDocument renders the slip the technician signs when receiving approved material.
+func (wc *WithdrawalController) GetIssueSlipDocument(c *gin.Context) {
+	var wapm models.WithdrawalApprovement
+	if err := wc.
+		DB.
+		WithContext(c).
+		Preload("Withdrawal.Project").
+		Preload("Withdrawal.Order").
+		Preload("Withdrawal.CreatedBy").
+		Preload("ApprovedBy").
+		Preload("ProjectStore").
+		First(&wapm, c.Param("id")).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, wapm.Withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal approvement not found"})
+		return
+	}
+	if wapm.WithdrawalApprovementStatus != models.WithdrawalApprovementStatus_Approved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal is not approved"})
+		return
+	}
+
+	lines, err := buildPickList(wc.DB.WithContext(c), &wapm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build issue slip", "detail": err.Error()})
+		return
+	}
+	wc.RenderDocument(c, documents.IssueSlip(&wapm, lines), c.Param("format"))
+}
+
+// GetWithdrawalDocument renders a withdrawal with all its approvements as pdf or html. The
+// format is the last segment of the path, the segments before it belong to the slug.
+func (wc *WithdrawalController) GetWithdrawalDocument(c *gin.Context) {
+	slug, format := c.Param("slug"), strings.TrimPrefix(c.Param("format"), "/")
+	if i := strings.LastIndex(format, "/"); i >= 0 {
+		slug, format = slug+"/"+format[:i], format[i+1:]
+	}
+
+	var withdrawal models.Withdrawal
+	if err := wc.DB.WithContext(c).
+		Preload("Project").
+		Preload("Order").
+		Preload("WithdrawalApprovements.ApprovedBy").
+		Preload("CreatedBy").
+		First(&withdrawal, "slug = ?", slug).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, &withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found"})
+		return
+	}
+
+	lines := make(map[uint][]models.PickListLine)
+	if withdrawal.WithdrawalApprovements != nil {
+		for _, wapm := range *withdrawal.WithdrawalApprovements {
+			wapmLines, err := buildPickList(wc.DB.WithContext(c), &wapm)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build withdrawal lines", "detail": err.Error()})
+				return
+			}
+			lines[wapm.ID] = wapmLines
+		}
+	}
+	wc.RenderDocument(c, documents.Withdrawal(&withdrawal, lines), format)
+}
+
+// AssignWithdrawalSerials picks the serials of a serial tracked material issued by a pending
+// approvement, they must cover its reserved lots exactly.
+func (wc *WithdrawalController) AssignWithdrawalSerials(c *gin.Context) {
+	var request struct {
+		MaterialID uint     `json:"MaterialID" binding:"required"`
+		Serials    []string `json:"Serials"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var wapm models.WithdrawalApprovement
+	if err := wc.DB.WithContext(c).
+		Preload("Withdrawal").
+		Preload("WithdrawalTransactions.OrderReserving.InventoryMaterial").
+		First(&wapm, c.Param("id")).Error; err != nil || !canSeeWithdrawal(wc.DB.WithContext(c), c, wapm.Withdrawal) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal approvement not found"})
+		return
+	}
+	if wapm.WithdrawalApprovementStatus != models.WithdrawalApprovementStatus_Pending {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal is already approved or rejected"})
+		return
+	}
+
+	var material models.Material
+	if err := wc.DB.WithContext(c).First(&material, request.MaterialID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Material not found"})
+		return
+	}
+	if !material.IsSerialTracked {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Material is not serial tracked"})
+		return
+	}
+
+	// reserved quantity of the material by lot
+	reservedQty := make(map[uint]int64)
+	var totalQty int64
+	if wapm.WithdrawalTransactions != nil {
+		for _, wts := range *wapm.WithdrawalTransactions {
+			if wts.OrderReserving == nil || wts.OrderReserving.InventoryMaterial == nil || wts.OrderReserving.InventoryMaterial.MaterialID != material.ID {
+				continue
+			}
+			reservedQty[wts.OrderReserving.InventoryMaterialID] += wts.OrderReserving.Quantity
+			totalQty += wts.OrderReserving.Quantity
+		}
+	}
+	if totalQty == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Material is not reserved by this withdrawal"})
+		return
+	}
+
+	var serials []models.MaterialSerial
+	if err := wc.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// replace the previous assignment of the material
+		if err := tx.
+			Model(&models.MaterialSerial{}).
+			Where("withdrawal_approvement_id = ? AND material_id = ?", wapm.ID, material.ID).
+			Update("withdrawal_approvement_id", nil).Error; err != nil {
+			return err
+		}
+
+		var plan map[uint]int64
+		var err error
+		serials, plan, err = serialPlan(tx, &material, request.Serials, totalQty, models.MaterialSerialStatus_InStock, inventoryLotOf)
+		if err != nil {
+			return err
+		}
+		for lotID, qty := range plan {
+			if reservedQty[lotID] != qty {
+				return fmt.Errorf("serials do not match the reserved quantity of %s", models.LotNumber(lotID))
+			}
+		}
+
+		ids := make([]uint, 0, len(serials))
+		for _, s := range serials {
+			ids = append(ids, s.ID)
+		}
+		return tx.
+			Model(&models.MaterialSerial{}).
+			Where("id IN ?", ids).
+			Update("withdrawal_approvement_id", wapm.ID).Error
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to assign serials", "detail": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Serials assigned successfully", "serials": serials})
+}
+
+// withdrawalCategoryIDs fills the categories of the withdrawn materials for approval rules.
+// withdrawalApprovalDocument describes a withdrawal approvement for approval rule matching,
+// at the price of its reserved lots. It needs the Withdrawal and the lots of the
+// WithdrawalTransactions loaded.
+func withdrawalApprovalDocument(db *gorm.DB, wapm *models.WithdrawalApprovement) (approvalDocument, error) {
+	doc := approvalDocument{
+		Type:        models.ApprovalDocumentType_Withdrawal,
+		ID:          wapm.ID,
+		ProjectID:   &wapm.Withdrawal.ProjectID,
+		RequestedBy: &wapm.Withdrawal.CreatedByID,
+	}
+	if wapm.WithdrawalTransactions == nil {
+		return doc, nil
+	}
+	var materialIDs []uint
+	for _, wts := range *wapm.WithdrawalTransactions {
+		if wts.OrderReserving == nil || wts.OrderReserving.InventoryMaterial == nil {
+			continue
+		}
+		invMat := wts.OrderReserving.InventoryMaterial
+		doc.Amount += wts.OrderReserving.Quantity * invMat.Price / 100
+		materialIDs = append(materialIDs, invMat.MaterialID)
+	}
+	err := withdrawalCategoryIDs(db, materialIDs, &doc)
+	return doc, err
+}
+
+// openWithdrawalApproval opens the approval request of a new withdrawal approvement.
+func openWithdrawalApproval(tx *gorm.DB, withdrawalApprovementID uint) error {
+	var wapm models.WithdrawalApprovement
+	if err := tx.
+		Preload("Withdrawal").
+		Preload("WithdrawalTransactions.OrderReserving.InventoryMaterial").
+		First(&wapm, withdrawalApprovementID).Error; err != nil {
+		return err
+	}
+	doc, err := withdrawalApprovalDocument(tx, &wapm)
+	if err != nil {
+		return err
+	}
+	return openApprovalRequest(tx, doc)
+}
+
+func withdrawalCategoryIDs(db *gorm.DB, materialIDs []uint, doc *approvalDocument) error {
+	if len(materialIDs) == 0 {
+		return nil
+	}
+	return db.
+		Model(&models.Material{}).
+		Where("id IN ?", materialIDs).
+		Distinct().
+		Pluck("category_id", &doc.CategoryIDs).Error
+}
+
+// canSeeWithdrawal reports whether the caller may see a withdrawal, its creator always may.
+func canSeeWithdrawal(db *gorm.DB, c *gin.Context, withdrawal *models.Withdrawal) bool {
+	if withdrawal == nil {
+		return false
+	}
+	return withdrawal.CreatedByID == c.GetUint("uid") || canSeeProject(db, c, withdrawal.ProjectID)
+}
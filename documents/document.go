@@ -0,0 +1,88 @@
+// Package documents renders printable paper documents (pick lists, slips,
+// receipts, ...) as HTML or PDF from a single tabular description.
+package documents
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var htmlTemplate = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+type Field struct {
+	Label string
+	Value string
+}
+
+type Column struct {
+	Title string
+	Width float64 // mm, used by PDF only
+	Align string  // "L", "C" or "R"
+}
+
+type Signature struct {
+	Label string // e.g. "Received by"
+	Name  string
+	Date  string
+}
+
+// Document is a generic printable document: header fields, one table and signature boxes.
+type Document struct {
+	Title      string
+	Subtitle   string
+	Slug       string
+	Fields     []Field
+	Columns    []Column
+	Rows       [][]string
+	Footer     []Field // totals printed under the table
+	Notes      string
+	Signatures []Signature
+	PrintedAt  time.Time
+}
+
+// HTML writes the document as a standalone printable HTML page.
+func (d *Document) HTML(w io.Writer) error {
+	if d.PrintedAt.IsZero() {
+		d.PrintedAt = time.Now()
+	}
+	return htmlTemplate.ExecuteTemplate(w, "document.html", d)
+}
+
+// FormatQuantity prints quantities stored with two implied decimals (x100).
+func FormatQuantity(v int64) string {
+	return formatFixed(v, false)
+}
+
+// FormatMoney prints prices stored in satang with thousands separators.
+func FormatMoney(v int64) string {
+	return formatFixed(v, true)
+}
+
+func formatFixed(v int64, grouping bool) string {
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	whole := fmt.Sprintf("%d", v/100)
+	if grouping {
+		for i := len(whole) - 3; i > 0; i -= 3 {
+			whole = whole[:i] + "," + whole[i:]
+		}
+	}
+	return fmt.Sprintf("%s%s.%02d", sign, whole, v%100)
+}
+
+// FormatDate prints dates the way the office writes them (dd/mm/yyyy).
+func FormatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("02/01/2006")
+}
@@ -0,0 +1,114 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"io"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	Symbology_Code128 = "code128"
+	Symbology_QR      = "qr"
+)
+
+// A4 sheet of 3 x 8 labels (70 x 37 mm)
+const (
+	labelColumns = 3
+	labelRows    = 8
+	labelWidth   = 70.0
+	labelHeight  = 37.0
+	labelPadding = 3.0
+)
+
+// Label is one sticker on a label sheet, Code is what the barcode encodes.
+type Label struct {
+	Code     string
+	Title    string
+	Subtitle string
+}
+
+// LabelSheet writes labels as an A4 PDF label sheet using code128 or qr barcodes.
+func LabelSheet(w io.Writer, labels []Label, symbology string) error {
+	if symbology != Symbology_Code128 && symbology != Symbology_QR {
+		return fmt.Errorf("unsupported symbology %q", symbology)
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	family, sizeOffset, err := setupFont(pdf)
+	if err != nil {
+		return err
+	}
+	pageWidth, pageHeight := pdf.GetPageSize()
+	marginX := (pageWidth - labelColumns*labelWidth) / 2
+	marginY := (pageHeight - labelRows*labelHeight) / 2
+
+	for i, label := range labels {
+		slot := i % (labelColumns * labelRows)
+		if slot == 0 {
+			pdf.AddPage()
+		}
+		x := marginX + float64(slot%labelColumns)*labelWidth + labelPadding
+		y := marginY + float64(slot/labelColumns)*labelHeight + labelPadding
+		width := labelWidth - 2*labelPadding
+
+		img, err := encodeBarcode(label.Code, symbology)
+		if err != nil {
+			return fmt.Errorf("label %s: %w", label.Code, err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("label-%d", i)
+		opt := fpdf.ImageOptions{ImageType: "PNG"}
+		pdf.RegisterImageOptionsReader(name, opt, &buf)
+
+		textX, textWidth := x, width
+		if symbology == Symbology_QR {
+			size := labelHeight - 2*labelPadding
+			pdf.ImageOptions(name, x, y, size, size, false, opt, 0, "")
+			textX, textWidth = x+size+2, width-size-2
+		} else {
+			pdf.ImageOptions(name, x, y+14, width, 12, false, opt, 0, "")
+		}
+
+		pdf.SetXY(textX, y)
+		pdf.SetFont(family, "B", 9+sizeOffset)
+		pdf.CellFormat(textWidth, 4.5, fitText(pdf, label.Title, textWidth), "", 2, "L", false, 0, "")
+		pdf.SetFont(family, "", 8+sizeOffset)
+		pdf.CellFormat(textWidth, 4, fitText(pdf, label.Subtitle, textWidth), "", 2, "L", false, 0, "")
+		if symbology == Symbology_QR {
+			pdf.CellFormat(textWidth, 4, fitText(pdf, label.Code, textWidth), "", 2, "L", false, 0, "")
+		} else {
+			pdf.SetXY(x, y+26.5)
+			pdf.CellFormat(width, 4, fitText(pdf, label.Code, width), "", 0, "C", false, 0, "")
+		}
+	}
+	if len(labels) == 0 {
+		pdf.AddPage()
+	}
+	return pdf.Output(w)
+}
+
+func encodeBarcode(code, symbology string) (barcode.Barcode, error) {
+	if symbology == Symbology_QR {
+		bc, err := qr.Encode(code, qr.M, qr.Auto)
+		if err != nil {
+			return nil, err
+		}
+		return barcode.Scale(bc, 256, 256)
+	}
+	bc, err := code128.Encode(code)
+	if err != nil {
+		return nil, err
+	}
+	return barcode.Scale(bc, bc.Bounds().Dx()*4, 80)
+}
@@ -0,0 +1,59 @@
+package documents
+
+import (
+	"daijai/models"
+	"strconv"
+)
+
+// Order prints a production order with its BOM progress.
+func Order(order *models.Order) *Document {
+	doc := &Document{
+		Title:    "Production Order",
+		Subtitle: "ใบสั่งผลิต",
+		Slug:     order.Slug,
+		Notes:    order.Notes,
+		Fields: []Field{
+			{Label: "Drawing", Value: order.Drawing.Slug + " " + order.Drawing.PartNumber},
+			{Label: "Produced quantity", Value: strconv.FormatInt(order.ProducedQuantity, 10)},
+			{Label: "Ordered by", Value: order.CreatedBy.FullName},
+			{Label: "Ordered at", Value: FormatDate(order.CreatedAt)},
+			{Label: "Status", Value: order.Status},
+			{Label: "Plan status", Value: order.PlanStatus},
+		},
+		Columns: []Column{
+			{Title: "#", Width: 8, Align: "C"},
+			{Title: "Material", Width: 25},
+			{Title: "Title"},
+			{Title: "Target", Width: 22, Align: "R"},
+			{Title: "Reserved", Width: 22, Align: "R"},
+			{Title: "Withdrawn", Width: 22, Align: "R"},
+		},
+	}
+	if order.Project != nil {
+		doc.Fields = append([]Field{{Label: "Project", Value: order.Project.Slug + " " + order.Project.Title}}, doc.Fields...)
+	}
+
+	if order.OrderBOMs != nil {
+		for i, ob := range *order.OrderBOMs {
+			var slug, title string
+			if ob.Material != nil {
+				slug = ob.Material.Slug
+				title = ob.Material.Title
+			}
+			doc.Rows = append(doc.Rows, []string{
+				strconv.Itoa(i + 1),
+				slug,
+				title,
+				FormatQuantity(ob.TargetQty),
+				FormatQuantity(ob.ReservedQty),
+				FormatQuantity(ob.WithdrawedQty),
+			})
+		}
+	}
+	doc.Signatures = []Signature{
+		{Label: "Ordered by", Name: order.CreatedBy.FullName, Date: FormatDate(order.CreatedAt)},
+		{Label: "Planned by"},
+		{Label: "Produced by"},
+	}
+	return doc
+}
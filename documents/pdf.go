@@ -0,0 +1,221 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	pageMargin = 10.0
+	lineHeight = 6.0
+	thaiFont   = "thai"
+)
+
+// PDF writes the document as an A4 PDF. Thai text needs a TTF font given by
+// DOCUMENT_FONT_PATH (and optionally DOCUMENT_FONT_BOLD_PATH), e.g. THSarabunNew.ttf;
+// without it rendering fails with ErrNoFont.
+func (d *Document) PDF(w io.Writer) error {
+	if d.PrintedAt.IsZero() {
+		d.PrintedAt = time.Now()
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin+5)
+	family, sizeOffset, err := setupFont(pdf)
+	if err != nil {
+		return err
+	}
+	setFont := func(style string, size float64) {
+		pdf.SetFont(family, style, size+sizeOffset)
+	}
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pageMargin - 2)
+		setFont("", 8)
+		pdf.CellFormat(0, 4, fmt.Sprintf("%s %s - printed %s - page %d/{nb}", d.Title, d.Slug, d.PrintedAt.Format("02/01/2006 15:04"), pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AliasNbPages("")
+	pdf.AddPage()
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 2*pageMargin
+
+	// header
+	setFont("B", 16)
+	pdf.CellFormat(contentWidth/2, 8, d.Title, "", 0, "L", false, 0, "")
+	pdf.CellFormat(contentWidth/2, 8, d.Slug, "", 1, "R", false, 0, "")
+	if d.Subtitle != "" {
+		setFont("", 10)
+		pdf.CellFormat(contentWidth, 5, d.Subtitle, "", 1, "L", false, 0, "")
+	}
+	pdf.Line(pageMargin, pdf.GetY()+1, pageWidth-pageMargin, pdf.GetY()+1)
+	pdf.Ln(3)
+
+	// fields in two columns
+	setFont("", 10)
+	for i, f := range d.Fields {
+		ln := 0
+		if i%2 == 1 || i == len(d.Fields)-1 {
+			ln = 1
+		}
+		pdf.CellFormat(contentWidth/2, 5, fitText(pdf, f.Label+": "+f.Value, contentWidth/2), "", ln, "L", false, 0, "")
+	}
+	pdf.Ln(3)
+
+	// table
+	widths := columnWidths(d.Columns, contentWidth)
+	drawHeader := func() {
+		setFont("B", 10)
+		pdf.SetFillColor(230, 230, 230)
+		for i, col := range d.Columns {
+			pdf.CellFormat(widths[i], lineHeight+1, fitText(pdf, col.Title, widths[i]), "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+		setFont("", 10)
+	}
+	drawHeader()
+	_, pageHeight := pdf.GetPageSize()
+	for _, row := range d.Rows {
+		if pdf.GetY()+lineHeight > pageHeight-pageMargin-5 {
+			pdf.AddPage()
+			drawHeader()
+		}
+		for i := range d.Columns {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			align := d.Columns[i].Align
+			if align == "" {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], lineHeight, fitText(pdf, cell, widths[i]), "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	for _, f := range d.Footer {
+		setFont("B", 10)
+		pdf.CellFormat(contentWidth, lineHeight, f.Label+": "+f.Value, "", 1, "R", false, 0, "")
+	}
+	if d.Notes != "" {
+		pdf.Ln(2)
+		setFont("", 10)
+		pdf.MultiCell(contentWidth, 5, "Notes: "+d.Notes, "", "L", false)
+	}
+
+	// signature boxes
+	if n := len(d.Signatures); n > 0 {
+		if pdf.GetY()+40 > pageHeight-pageMargin-5 {
+			pdf.AddPage()
+		}
+		pdf.Ln(18)
+		boxWidth := contentWidth / float64(n)
+		y := pdf.GetY()
+		setFont("", 10)
+		for i, s := range d.Signatures {
+			x := pageMargin + float64(i)*boxWidth
+			pdf.Line(x+8, y, x+boxWidth-8, y)
+			name := s.Name
+			if name == "" {
+				name = "                              "
+			}
+			date := s.Date
+			if date == "" {
+				date = "____/____/______"
+			}
+			pdf.SetXY(x, y+1)
+			pdf.CellFormat(boxWidth, 5, "("+name+")", "", 2, "C", false, 0, "")
+			pdf.CellFormat(boxWidth, 5, s.Label, "", 2, "C", false, 0, "")
+			pdf.CellFormat(boxWidth, 5, "Date "+date, "", 0, "C", false, 0, "")
+		}
+	}
+
+	return pdf.Output(w)
+}
+
+// ErrNoFont is returned when no Thai font is configured, Helvetica cannot print Thai text.
+var ErrNoFont = errors.New("no document font, set DOCUMENT_FONT_PATH to a Thai TTF font such as THSarabunNew.ttf")
+
+// CheckFont reports whether PDFs can be rendered, call it at startup to warn early.
+func CheckFont() error {
+	_, _, err := loadFont()
+	return err
+}
+
+// loadFont reads the regular and bold Thai fonts, the regular one is used for bold without
+// DOCUMENT_FONT_BOLD_PATH.
+func loadFont() (regular, bold []byte, err error) {
+	path := os.Getenv("DOCUMENT_FONT_PATH")
+	if path == "" {
+		return nil, nil, ErrNoFont
+	}
+	regular, err = os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrNoFont, err)
+	}
+	bold = regular
+	if boldPath := os.Getenv("DOCUMENT_FONT_BOLD_PATH"); boldPath != "" {
+		if bold, err = os.ReadFile(boldPath); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrNoFont, err)
+		}
+	}
+	return regular, bold, nil
+}
+
+// setupFont registers the Thai font and returns the family to use with a size offset
+// (Thai TTF fonts like Sarabun render smaller than Helvetica).
+func setupFont(pdf *fpdf.Fpdf) (string, float64, error) {
+	regular, bold, err := loadFont()
+	if err != nil {
+		return "", 0, err
+	}
+	pdf.AddUTF8FontFromBytes(thaiFont, "", regular)
+	pdf.AddUTF8FontFromBytes(thaiFont, "B", bold)
+	if err := pdf.Error(); err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrNoFont, err)
+	}
+	return thaiFont, 4, nil
+}
+
+// columnWidths uses the configured widths and shares the remaining space between
+// columns without width.
+func columnWidths(columns []Column, total float64) []float64 {
+	widths := make([]float64, len(columns))
+	var fixed float64
+	var flexible int
+	for i, col := range columns {
+		widths[i] = col.Width
+		fixed += col.Width
+		if col.Width == 0 {
+			flexible++
+		}
+	}
+	if flexible > 0 {
+		rest := (total - fixed) / float64(flexible)
+		for i := range widths {
+			if widths[i] == 0 {
+				widths[i] = rest
+			}
+		}
+	}
+	return widths
+}
+
+// fitText cuts text that does not fit into a cell.
+func fitText(pdf *fpdf.Fpdf, text string, width float64) string {
+	max := width - 2
+	if pdf.GetStringWidth(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"...") > max {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -0,0 +1,61 @@
+package documents
+
+import (
+	"daijai/models"
+	"strconv"
+	"strings"
+)
+
+// Purchase prints a purchase requisition priced with the materials' default price.
+func Purchase(purchase *models.Purchase) *Document {
+	var poRefs []string
+	for _, ref := range purchase.PORefs {
+		poRefs = append(poRefs, ref.Slug)
+	}
+	status := "pending"
+	if purchase.IsApprove {
+		status = "approved"
+	}
+	doc := &Document{
+		Title:    "Purchase Requisition",
+		Subtitle: "ใบขอซื้อ",
+		Slug:     purchase.Slug,
+		Notes:    purchase.Notes,
+		Fields: []Field{
+			{Label: "PO Number", Value: strings.Join(poRefs, ", ")},
+			{Label: "Requested by", Value: purchase.CreatedBy.FullName},
+			{Label: "Requested at", Value: FormatDate(purchase.CreatedAt)},
+			{Label: "Status", Value: status},
+		},
+		Columns: []Column{
+			{Title: "#", Width: 8, Align: "C"},
+			{Title: "Material", Width: 25},
+			{Title: "Title"},
+			{Title: "Supplier", Width: 30},
+			{Title: "Qty", Width: 20, Align: "R"},
+			{Title: "Est. Price", Width: 22, Align: "R"},
+			{Title: "Amount", Width: 25, Align: "R"},
+		},
+	}
+
+	var total int64
+	for i, pm := range purchase.PurchaseMaterials {
+		amount := pm.Quantity * pm.Material.DefaultPrice / 100
+		doc.Rows = append(doc.Rows, []string{
+			strconv.Itoa(i + 1),
+			pm.Material.Slug,
+			pm.Material.Title,
+			pm.Material.Supplier,
+			FormatQuantity(pm.Quantity),
+			FormatMoney(pm.Material.DefaultPrice),
+			FormatMoney(amount),
+		})
+		total += amount
+	}
+	doc.Footer = []Field{{Label: "Estimated total", Value: FormatMoney(total)}}
+	doc.Signatures = []Signature{
+		{Label: "Requested by", Name: purchase.CreatedBy.FullName, Date: FormatDate(purchase.CreatedAt)},
+		{Label: "Approved by"},
+	}
+	return doc
+}
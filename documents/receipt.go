@@ -0,0 +1,64 @@
+package documents
+
+import (
+	"daijai/models"
+	"strconv"
+)
+
+// Receipt prints a goods receipt with line amounts.
+func Receipt(receipt *models.Receipt) *Document {
+	status := "pending"
+	if receipt.IsApproved {
+		status = "approved"
+	}
+	doc := &Document{
+		Title:    "Goods Receipt",
+		Subtitle: "ใบรับสินค้า",
+		Slug:     receipt.Slug,
+		Notes:    receipt.Notes,
+		Fields: []Field{
+			{Label: "PO Number", Value: receipt.PORefNumber},
+			{Label: "Inventory", Value: receipt.Inventory.Title},
+			{Label: "Received at", Value: FormatDate(receipt.CreatedAt)},
+			{Label: "Status", Value: status},
+		},
+		Columns: []Column{
+			{Title: "#", Width: 8, Align: "C"},
+			{Title: "Material", Width: 25},
+			{Title: "Title"},
+			{Title: "Qty", Width: 22, Align: "R"},
+			{Title: "Unit Price", Width: 25, Align: "R"},
+			{Title: "Amount", Width: 30, Align: "R"},
+		},
+	}
+
+	var total int64
+	for i, rm := range receipt.ReceiptMaterials {
+		amount := rm.Quantity * rm.Price / 100
+		doc.Rows = append(doc.Rows, []string{
+			strconv.Itoa(i + 1),
+			rm.Material.Slug,
+			rm.Material.Title,
+			FormatQuantity(rm.Quantity),
+			FormatMoney(rm.Price),
+			FormatMoney(amount),
+		})
+		total += amount
+	}
+	doc.Footer = []Field{{Label: "Total amount", Value: FormatMoney(total)}}
+
+	var receivedBy, approvedBy string
+	if receipt.Recipient != nil {
+		receivedBy = receipt.Recipient.FullName
+	} else {
+		receivedBy = receipt.CreatedBy.FullName
+	}
+	if receipt.ApprovedBy != nil {
+		approvedBy = receipt.ApprovedBy.FullName
+	}
+	doc.Signatures = []Signature{
+		{Label: "Received by", Name: receivedBy, Date: FormatDate(receipt.CreatedAt)},
+		{Label: "Approved by", Name: approvedBy},
+	}
+	return doc
+}
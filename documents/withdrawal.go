@@ -0,0 +1,148 @@
+package documents
+
+import (
+	"daijai/models"
+	"strconv"
+)
+
+// PickList lists the lots and bins the storekeeper picks for a withdrawal approvement.
+func PickList(wapm *models.WithdrawalApprovement, lines []models.PickListLine) *Document {
+	doc := withdrawalApprovementDocument(wapm, lines)
+	doc.Title = "Pick List"
+	doc.Subtitle = "ใบจัดเตรียมวัสดุ"
+	doc.Signatures = []Signature{
+		{Label: "Picked by"},
+		{Label: "Checked by"},
+	}
+	return doc
+}
+
+// IssueSlip is signed by the technician when receiving approved material.
+func IssueSlip(wapm *models.WithdrawalApprovement, lines []models.PickListLine) *Document {
+	doc := withdrawalApprovementDocument(wapm, lines)
+	doc.Title = "Material Issue Slip"
+	doc.Subtitle = "ใบจ่ายวัสดุ"
+	var issuedBy, receivedBy string
+	if wapm.ApprovedBy != nil {
+		issuedBy = wapm.ApprovedBy.FullName
+	}
+	if wapm.Withdrawal != nil && wapm.Withdrawal.CreatedBy != nil {
+		receivedBy = wapm.Withdrawal.CreatedBy.FullName
+	}
+	doc.Signatures = []Signature{
+		{Label: "Issued by (storekeeper)", Name: issuedBy, Date: FormatDate(wapm.UpdatedAt)},
+		{Label: "Received by (technician)", Name: receivedBy},
+	}
+	return doc
+}
+
+// Withdrawal prints a withdrawal with the lines of every approvement.
+func Withdrawal(withdrawal *models.Withdrawal, lines map[uint][]models.PickListLine) *Document {
+	doc := &Document{
+		Title:    "Material Withdrawal",
+		Subtitle: "ใบเบิกวัสดุ",
+		Slug:     withdrawal.Slug,
+		Notes:    withdrawal.Notes,
+		Fields:   withdrawalFields(withdrawal),
+		Columns: []Column{
+			{Title: "#", Width: 8, Align: "C"},
+			{Title: "Material", Width: 25},
+			{Title: "Title"},
+			{Title: "Lot", Width: 25},
+			{Title: "Status", Width: 25},
+			{Title: "Qty", Width: 22, Align: "R"},
+		},
+	}
+	doc.Fields = append(doc.Fields, Field{Label: "Status", Value: withdrawal.WithdrawalStatus})
+
+	var total int64
+	var approvedBy string
+	if withdrawal.WithdrawalApprovements != nil {
+		for _, wapm := range *withdrawal.WithdrawalApprovements {
+			if wapm.ApprovedBy != nil {
+				approvedBy = wapm.ApprovedBy.FullName
+			}
+			for _, line := range lines[wapm.ID] {
+				doc.Rows = append(doc.Rows, []string{
+					strconv.Itoa(len(doc.Rows) + 1),
+					line.MaterialSlug,
+					line.MaterialTitle,
+					line.LotNumber,
+					wapm.WithdrawalApprovementStatus,
+					FormatQuantity(line.Quantity),
+				})
+				total += line.Quantity
+			}
+		}
+	}
+	doc.Footer = []Field{{Label: "Total quantity", Value: FormatQuantity(total)}}
+
+	var requestedBy string
+	if withdrawal.CreatedBy != nil {
+		requestedBy = withdrawal.CreatedBy.FullName
+	}
+	doc.Signatures = []Signature{
+		{Label: "Requested by", Name: requestedBy, Date: FormatDate(withdrawal.CreatedAt)},
+		{Label: "Approved by", Name: approvedBy},
+		{Label: "Received by"},
+	}
+	return doc
+}
+
+func withdrawalApprovementDocument(wapm *models.WithdrawalApprovement, lines []models.PickListLine) *Document {
+	doc := &Document{
+		Columns: []Column{
+			{Title: "#", Width: 8, Align: "C"},
+			{Title: "Material", Width: 25},
+			{Title: "Title"},
+			{Title: "Lot", Width: 25},
+			{Title: "Location", Width: 25},
+			{Title: "Inventory", Width: 30},
+			{Title: "Qty", Width: 20, Align: "R"},
+		},
+	}
+	if wapm.Withdrawal != nil {
+		doc.Slug = wapm.Withdrawal.Slug
+		doc.Notes = wapm.Withdrawal.Notes
+		doc.Fields = withdrawalFields(wapm.Withdrawal)
+	}
+	if wapm.ProjectStore != nil {
+		doc.Fields = append(doc.Fields, Field{Label: "Project Store", Value: wapm.ProjectStore.Title})
+	}
+	doc.Fields = append(doc.Fields, Field{Label: "Status", Value: wapm.WithdrawalApprovementStatus})
+
+	var total int64
+	for i, line := range lines {
+		location := line.LocationSlug
+		if location == "" {
+			location = "-"
+		}
+		doc.Rows = append(doc.Rows, []string{
+			strconv.Itoa(i + 1),
+			line.MaterialSlug,
+			line.MaterialTitle,
+			line.LotNumber,
+			location,
+			line.InventoryTitle,
+			FormatQuantity(line.Quantity),
+		})
+		total += line.Quantity
+	}
+	doc.Footer = []Field{{Label: "Total quantity", Value: FormatQuantity(total)}}
+	return doc
+}
+
+func withdrawalFields(withdrawal *models.Withdrawal) []Field {
+	var fields []Field
+	if withdrawal.Project != nil {
+		fields = append(fields, Field{Label: "Project", Value: withdrawal.Project.Slug + " " + withdrawal.Project.Title})
+	}
+	if withdrawal.Order != nil {
+		fields = append(fields, Field{Label: "Order", Value: withdrawal.Order.Slug})
+	}
+	if withdrawal.CreatedBy != nil {
+		fields = append(fields, Field{Label: "Requested by", Value: withdrawal.CreatedBy.FullName})
+	}
+	fields = append(fields, Field{Label: "Requested at", Value: FormatDate(withdrawal.CreatedAt)})
+	return fields
+}
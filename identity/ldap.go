@@ -0,0 +1,148 @@
+package identity
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPProvider binds as the user found under BaseDN. The search runs as BindDN when set,
+// anonymously otherwise. Groups come from the GroupAttribute of the user entry (memberOf)
+// or, with GroupFilter, from a search of GroupBaseDN for groups listing the user's DN.
+type LDAPProvider struct {
+	URL            string
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string // %s is the escaped username
+	NameAttribute  string
+	GroupAttribute string
+	GroupBaseDN    string
+	GroupFilter    string // %s is the escaped user DN
+	StartTLS       bool
+	SkipVerify     bool
+}
+
+// LDAPFromEnv reads the LDAP_* settings.
+func LDAPFromEnv() *LDAPProvider {
+	return &LDAPProvider{
+		URL:            envOr("LDAP_URL", "ldap://localhost:389"),
+		BindDN:         os.Getenv("LDAP_BIND_DN"),
+		BindPassword:   os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:         os.Getenv("LDAP_BASE_DN"),
+		UserFilter:     envOr("LDAP_USER_FILTER", "(uid=%s)"),
+		NameAttribute:  envOr("LDAP_NAME_ATTRIBUTE", "cn"),
+		GroupAttribute: envOr("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		GroupBaseDN:    envOr("LDAP_GROUP_BASE_DN", os.Getenv("LDAP_BASE_DN")),
+		GroupFilter:    os.Getenv("LDAP_GROUP_FILTER"),
+		StartTLS:       os.Getenv("LDAP_START_TLS") == "true",
+		SkipVerify:     os.Getenv("LDAP_TLS_SKIP_VERIFY") == "true",
+	}
+}
+
+func (p *LDAPProvider) Name() string {
+	return Provider_LDAP
+}
+
+func (p *LDAPProvider) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	// an empty password is an unauthenticated bind, which servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if p.BindDN != "" {
+		if err := conn.Bind(p.BindDN, p.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(p.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", p.NameAttribute, p.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, fmt.Errorf("ldap: username %q is not unique", username)
+		}
+		return nil, err
+	}
+	if len(result.Entries) == 0 {
+		return nil, ErrUnknownUser
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("ldap: username %q is not unique", username)
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	groups := entry.GetAttributeValues(p.GroupAttribute)
+	if p.GroupFilter != "" {
+		// search the groups with the service account again, the user may not read them
+		if p.BindDN != "" {
+			if err := conn.Bind(p.BindDN, p.BindPassword); err != nil {
+				return nil, fmt.Errorf("ldap service bind: %w", err)
+			}
+		}
+		found, err := conn.Search(ldap.NewSearchRequest(
+			p.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(p.GroupFilter, ldap.EscapeFilter(entry.DN)),
+			[]string{"dn"},
+			nil,
+		))
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range found.Entries {
+			groups = append(groups, group.DN)
+		}
+	}
+
+	fullName := entry.GetAttributeValue(p.NameAttribute)
+	if fullName == "" {
+		fullName = username
+	}
+	return &Identity{Username: username, FullName: fullName, Groups: groups}, nil
+}
+
+func (p *LDAPProvider) dial() (*ldap.Conn, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: p.SkipVerify}
+	conn, err := ldap.DialURL(p.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	if p.StartTLS && !strings.HasPrefix(p.URL, "ldaps://") {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,66 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"daijai/models"
+	"daijai/utils/password"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// LocalProvider checks the bcrypt password of accounts kept in the users table and locks
+// them after too many failed logins.
+type LocalProvider struct {
+	DB *gorm.DB
+}
+
+func (p *LocalProvider) Name() string {
+	return Provider_Local
+}
+
+func (p *LocalProvider) Authenticate(ctx context.Context, username, pw string) (*Identity, error) {
+	var user models.User
+	if err := p.DB.WithContext(ctx).Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownUser
+		}
+		return nil, err
+	}
+	if !user.IsLocal() {
+		return nil, ErrUnknownUser
+	}
+	if user.IsLocked() {
+		return nil, &LockedError{Until: *user.LockedUntil}
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(pw)); err != nil {
+		if err := recordFailedLogin(p.DB, &user); err != nil {
+			log.Println(err)
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := p.DB.Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error; err != nil {
+			log.Println(err)
+		}
+	}
+	return &Identity{Username: user.Username, FullName: user.FullName, User: &user}, nil
+}
+
+// recordFailedLogin counts a wrong password, the account locks when the count reaches the limit.
+func recordFailedLogin(db *gorm.DB, user *models.User) error {
+	user.FailedLogins++
+	updates := map[string]interface{}{"failed_logins": user.FailedLogins}
+	if user.FailedLogins >= password.MaxFailedLogins() {
+		lockedUntil := time.Now().Add(password.LockoutDuration())
+		user.LockedUntil = &lockedUntil
+		user.FailedLogins = 0
+		updates["locked_until"] = lockedUntil
+		updates["failed_logins"] = 0
+	}
+	return db.Model(user).Updates(updates).Error
+}
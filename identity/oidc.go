@@ -0,0 +1,159 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCProvider signs users in with the authorization-code flow. The issuer is discovered on
+// first use so the API starts while the identity provider is down.
+type OIDCProvider struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+
+	mu       sync.Mutex
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcOnce     sync.Once
+	oidcProvider *OIDCProvider
+)
+
+// OIDC returns the provider configured by the OIDC_* settings, nil when OIDC_ISSUER is unset.
+func OIDC() *OIDCProvider {
+	oidcOnce.Do(func() {
+		issuer := os.Getenv("OIDC_ISSUER")
+		if issuer == "" {
+			return
+		}
+		scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+		if extra := os.Getenv("OIDC_SCOPES"); extra != "" {
+			scopes = append([]string{oidc.ScopeOpenID}, strings.Fields(strings.ReplaceAll(extra, ",", " "))...)
+		}
+		oidcProvider = &OIDCProvider{
+			Issuer:        issuer,
+			ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:        scopes,
+			UsernameClaim: envOr("OIDC_USERNAME_CLAIM", "preferred_username"),
+			GroupsClaim:   envOr("OIDC_GROUPS_CLAIM", "groups"),
+		}
+	})
+	return oidcProvider
+}
+
+func (p *OIDCProvider) Name() string {
+	return Provider_OIDC
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config != nil {
+		return p.config, p.verifier, nil
+	}
+	provider, err := oidc.NewProvider(ctx, p.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	p.config = &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  p.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.Scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.ClientID})
+	return p.config, p.verifier, nil
+}
+
+// AuthCodeURL is the identity provider's login page, it redirects back with a code.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	config, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return config.AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Exchange trades the code for tokens and reads the user from the verified ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce string) (*Identity, error) {
+	config, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oauth2Token, err := config.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("oidc code exchange: %w", err)
+	}
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: no id_token in the token response")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("oidc: nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	username := claimString(claims, p.UsernameClaim)
+	if username == "" {
+		username = claimString(claims, "email")
+	}
+	if username == "" {
+		username = idToken.Subject
+	}
+	fullName := claimString(claims, "name")
+	if fullName == "" {
+		fullName = username
+	}
+	return &Identity{
+		Provider: Provider_OIDC,
+		Username: username,
+		FullName: fullName,
+		Groups:   claimStrings(claims, p.GroupsClaim),
+	}, nil
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimStrings reads a claim given as a list or as one space separated string.
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case string:
+		return strings.Fields(value)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// package for the identity providers that sign users in: local accounts, LDAP and OIDC
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"daijai/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	Provider_Local = "local"
+	Provider_LDAP  = "ldap"
+	Provider_OIDC  = "oidc"
+)
+
+var (
+	// ErrUnknownUser lets the next provider of the chain try the username.
+	ErrUnknownUser        = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrNoRole             = errors.New("no role is mapped to the user's groups")
+)
+
+// LockedError is returned for a local account locked after too many failed logins.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("account is locked until %s", e.Until.Format(time.RFC3339))
+}
+
+// Identity is a user authenticated by a provider. Local logins carry the account in User,
+// external ones carry the groups that map to a role.
+type Identity struct {
+	Provider string
+	Username string
+	FullName string
+	Groups   []string
+	User     *models.User
+}
+
+// PasswordProvider checks a username and a password.
+type PasswordProvider interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+}
+
+// PasswordProviders returns the providers of AUTH_PROVIDERS (comma separated, "local" by default)
+// in the order they are tried.
+func PasswordProviders(db *gorm.DB) []PasswordProvider {
+	names := os.Getenv("AUTH_PROVIDERS")
+	if names == "" {
+		names = Provider_Local
+	}
+	var providers []PasswordProvider
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case Provider_Local:
+			providers = append(providers, &LocalProvider{DB: db})
+		case Provider_LDAP:
+			providers = append(providers, LDAPFromEnv())
+		case Provider_OIDC, "":
+			// OIDC signs in by redirect, see OIDC()
+		default:
+			log.Printf("unknown identity provider %q in AUTH_PROVIDERS\n", name)
+		}
+	}
+	return providers
+}
+
+// Authenticate tries the providers in order until one knows the username.
+func Authenticate(ctx context.Context, providers []PasswordProvider, username, password string) (*Identity, error) {
+	for _, p := range providers {
+		id, err := p.Authenticate(ctx, username, password)
+		if errors.Is(err, ErrUnknownUser) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		id.Provider = p.Name()
+		return id, nil
+	}
+	return nil, ErrUnknownUser
+}
+
+// Names lists the enabled providers, for the login page.
+func Names(db *gorm.DB) []string {
+	var names []string
+	for _, p := range PasswordProviders(db) {
+		names = append(names, p.Name())
+	}
+	if OIDC() != nil {
+		names = append(names, Provider_OIDC)
+	}
+	return names
+}
+
+// RoleForGroups maps external groups to a role with IDP_GROUP_ROLES, a JSON object of
+// group to role, e.g. {"daijai-admins":"admin","cn=stock,ou=groups,dc=example,dc=com":"technician"}.
+// A group DN also matches by its first value ("stock" above). When several groups match the
+// highest system role wins, users without a match get IDP_DEFAULT_ROLE or ErrNoRole.
+func RoleForGroups(groups []string) (string, error) {
+	mapping := map[string]string{}
+	if raw := os.Getenv("IDP_GROUP_ROLES"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			return "", fmt.Errorf("IDP_GROUP_ROLES: %w", err)
+		}
+	}
+	lookup := map[string]string{}
+	for group, role := range mapping {
+		lookup[strings.ToLower(group)] = role
+	}
+
+	var roles []string
+	for _, group := range groups {
+		for _, name := range groupNames(group) {
+			if role, ok := lookup[strings.ToLower(name)]; ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+	if len(roles) == 0 {
+		if role := os.Getenv("IDP_DEFAULT_ROLE"); role != "" {
+			return role, nil
+		}
+		return "", ErrNoRole
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		ri, rj := roleRank(roles[i]), roleRank(roles[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return roles[i] < roles[j]
+	})
+	return roles[0], nil
+}
+
+// groupNames returns the group and, for a DN, the value of its first part.
+func groupNames(group string) []string {
+	names := []string{group}
+	first := strings.SplitN(group, ",", 2)[0]
+	if parts := strings.SplitN(first, "=", 2); len(parts) == 2 && parts[1] != "" {
+		names = append(names, strings.TrimSpace(parts[1]))
+	}
+	return names
+}
+
+// roleRank orders system roles as listed in models.SystemRoles, custom roles after them.
+func roleRank(role string) int {
+	for i, r := range models.SystemRoles {
+		if r == role {
+			return i
+		}
+	}
+	return len(models.SystemRoles)
+}
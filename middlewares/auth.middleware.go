@@ -1,53 +1,27 @@
 package middlewares
 
 import (
+	"daijai/models"
 	"daijai/token"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
+// authDB is where sessions are checked, tokens are not checked against sessions without it.
+var authDB *gorm.DB
+
 func AuthMiddleware(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var tokenString string
-		token.ExtractToken(c, &tokenString)
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization header"})
-			c.Abort()
-			return
-		}
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("SECRET")), nil
-		})
-
-		if err != nil || !token.Valid {
-			log.Printf("Invalid or expired token: %v", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
-		}
-
-		// Check if any of the user's roles match the required roles
-		userRole, ok := claims["role"].(string)
+		userRole, ok := authenticate(c)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid roles in token"})
-			c.Abort()
 			return
 		}
 
@@ -60,7 +34,7 @@ func AuthMiddleware(roles ...string) gin.HandlerFunc {
 		}
 
 		if !roleMatched {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Insufficient role privileges"})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient role privileges"})
 			c.Abort()
 			return
 		}
@@ -69,6 +43,125 @@ func AuthMiddleware(roles ...string) gin.HandlerFunc {
 	}
 }
 
+// authenticate checks the bearer token and returns the role in it, the token's user id and
+// role are set on the context as "uid" and "role". An API key, in the X-API-Key header or as
+// the bearer token, authenticates as its user. On failure it answers 401 and aborts.
+func authenticate(c *gin.Context) (string, bool) {
+	var tokenString string
+	token.ExtractToken(c, &tokenString)
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return authenticateAPIKey(c, key)
+	}
+	if strings.HasPrefix(tokenString, token.APIKeyPrefix) {
+		return authenticateAPIKey(c, tokenString)
+	}
+	if tokenString == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization header"})
+		c.Abort()
+		return "", false
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate the signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("SECRET")), nil
+	})
+
+	if err != nil || !token.Valid {
+		log.Printf("Invalid or expired token: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return "", false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		c.Abort()
+		return "", false
+	}
+
+	userRole, ok := claims["role"].(string)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid roles in token"})
+		c.Abort()
+		return "", false
+	}
+
+	// access tokens outlive a logout unless their session is checked
+	if authDB != nil {
+		sid, ok := claims["sid"].(float64)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, sign in again"})
+			c.Abort()
+			return "", false
+		}
+		var session models.Session
+		if err := authDB.Select("id", "revoked_at", "expires_at").First(&session, uint(sid)).Error; err != nil || !session.IsActive() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+			c.Abort()
+			return "", false
+		}
+		c.Set("sid", session.ID)
+	}
+
+	if uid, ok := claims["uid"].(float64); ok {
+		c.Set("uid", uint(uid))
+	}
+	c.Set("role", userRole)
+	if mcp, _ := claims["mcp"].(bool); mcp {
+		c.Set("mustChangePassword", true)
+	}
+	return userRole, true
+}
+
+// apiKeyUsageInterval limits how often the last use of a key is written.
+const apiKeyUsageInterval = time.Minute
+
+// authenticateAPIKey checks an API key and sets its user's "uid" and "role" on the context,
+// with "apiKeyID" and the key's "scopes".
+func authenticateAPIKey(c *gin.Context, key string) (string, bool) {
+	if authDB == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API keys are not accepted"})
+		c.Abort()
+		return "", false
+	}
+	var apiKey models.APIKey
+	if err := authDB.Preload("Scopes").Where("key_hash = ?", token.HashAPIKey(key)).First(&apiKey).Error; err != nil || !apiKey.IsActive() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		c.Abort()
+		return "", false
+	}
+	var user models.User
+	if err := authDB.Select("id", "role").First(&user, apiKey.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User of API key not found"})
+		c.Abort()
+		return "", false
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyUsageInterval || apiKey.LastUsedIP != c.ClientIP() {
+		if err := authDB.Model(&apiKey).UpdateColumns(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": c.ClientIP(),
+		}).Error; err != nil {
+			log.Println(err)
+		}
+	}
+
+	scopes := make(map[Permission]bool, len(apiKey.Scopes))
+	for _, s := range apiKey.Scopes {
+		scopes[Permission{s.Resource, s.Action}] = true
+	}
+	c.Set("uid", user.ID)
+	c.Set("role", user.Role)
+	c.Set("apiKeyID", apiKey.ID)
+	c.Set("scopes", scopes)
+	return user.Role, true
+}
+
 // func AuthMiddleware() gin.HandlerFunc {
 // 	return func(c *gin.Context) {
 // 		config := config.GetConfig()
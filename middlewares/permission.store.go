@@ -0,0 +1,124 @@
+package middlewares
+
+import (
+	"daijai/models"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// permissionCacheTTL is how long role permissions loaded from the database are used
+// before they are loaded again, role changes through the API invalidate them at once.
+const permissionCacheTTL = time.Minute
+
+// permissionStore holds the permissions of the roles in the database. Roles missing from
+// the database, or every role without a database, use the static PermissionMatrix.
+type permissionStore struct {
+	mu       sync.RWMutex
+	db       *gorm.DB
+	roles    map[string]map[Permission]bool
+	loadedAt time.Time
+}
+
+var permissions = &permissionStore{}
+
+// InvalidatePermissions drops the cached role permissions, call it after changing roles.
+func InvalidatePermissions() {
+	permissions.mu.Lock()
+	permissions.roles = nil
+	permissions.mu.Unlock()
+}
+
+// EffectivePermissions returns the permissions of a role, sorted by resource and action.
+func EffectivePermissions(role string) []Permission {
+	list := []Permission{}
+	for p := range permissions.of(role) {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Resource != list[j].Resource {
+			return list[i].Resource < list[j].Resource
+		}
+		return list[i].Action < list[j].Action
+	})
+	return list
+}
+
+// PermissionCatalog returns every permission a route may need.
+func PermissionCatalog() []Permission {
+	return EffectivePermissions(models.ROLE_Admin)
+}
+
+// IsKnownPermission reports whether a permission is used by the routes.
+func IsKnownPermission(p Permission) bool {
+	_, ok := PermissionMatrix[p]
+	return ok
+}
+
+func (s *permissionStore) use(db *gorm.DB) {
+	s.mu.Lock()
+	s.db = db
+	s.roles = nil
+	s.mu.Unlock()
+}
+
+func (s *permissionStore) of(role string) map[Permission]bool {
+	// admins keep every permission, so no role change locks them out
+	if role == models.ROLE_Admin {
+		return staticPermissions(role)
+	}
+	if roles := s.load(); roles != nil {
+		if set, ok := roles[role]; ok {
+			return set
+		}
+	}
+	return staticPermissions(role)
+}
+
+func (s *permissionStore) load() map[string]map[Permission]bool {
+	s.mu.RLock()
+	db, roles, loadedAt := s.db, s.roles, s.loadedAt
+	s.mu.RUnlock()
+	if db == nil {
+		return nil
+	}
+	if roles != nil && time.Since(loadedAt) < permissionCacheTTL {
+		return roles
+	}
+
+	var rows []models.Role
+	if err := db.Preload("Permissions").Find(&rows).Error; err != nil {
+		log.Printf("Failed to load role permissions: %v", err)
+		return roles
+	}
+	roles = make(map[string]map[Permission]bool, len(rows))
+	for _, r := range rows {
+		set := make(map[Permission]bool, len(r.Permissions))
+		for _, p := range r.Permissions {
+			set[Permission{p.Resource, p.Action}] = true
+		}
+		roles[r.Name] = set
+	}
+
+	s.mu.Lock()
+	s.roles = roles
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return roles
+}
+
+func staticPermissions(role string) map[Permission]bool {
+	set := make(map[Permission]bool)
+	for p, roles := range PermissionMatrix {
+		for _, r := range roles {
+			if r == role {
+				set[p] = true
+				break
+			}
+		}
+	}
+	return set
+}
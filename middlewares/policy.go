@@ -0,0 +1,527 @@
+package middlewares
+
+import (
+	"daijai/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Permission is what a route needs, an action on a resource. Routes of
+// Resource_Public need no token.
+type Permission struct {
+	Resource string
+	Action   string
+}
+
+const (
+	Resource_Public                = "public"
+	Resource_Session               = "session"
+	Resource_Sessions              = "sessions"
+	Resource_Categories            = "categories"
+	Resource_Materials             = "materials"
+	Resource_Adjustments           = "adjustments"
+	Resource_Inventories           = "inventories"
+	Resource_Locations             = "locations"
+	Resource_Transfers             = "transfers"
+	Resource_Transactions          = "transactions"
+	Resource_ProjectStores         = "project-stores"
+	Resource_ProjectStoreMaterials = "project-store-materials"
+	Resource_Approvals             = "approvals"
+	Resource_ApprovalRules         = "approval-rules"
+	Resource_SupplierReturns       = "supplier-returns"
+	Resource_Loans                 = "loans"
+	Resource_Serials               = "serials"
+	Resource_Labels                = "labels"
+	Resource_Scan                  = "scan"
+	Resource_Trace                 = "trace"
+	Resource_Drawings              = "drawings"
+	Resource_Orders                = "orders"
+	Resource_ExtendOrders          = "extend-orders"
+	Resource_Reservations          = "reservations"
+	Resource_Withdrawals           = "withdrawals"
+	Resource_AdminWithdrawals      = "admin-withdrawals"
+	Resource_PurchaseRequisitions  = "purchase-requisitions"
+	Resource_Projects              = "projects"
+	Resource_Receipts              = "receipts"
+	Resource_Users                 = "users"
+	Resource_Roles                 = "roles"
+	Resource_APIKeys               = "api-keys"
+	Resource_Audit                 = "audit"
+	Resource_Slugs                 = "slugs"
+	Resource_Notifications         = "notifications"
+	Resource_Images                = "images"
+	Resource_Planner               = "planner"
+	Resource_Filters               = "filters"
+)
+
+const (
+	Action_Read    = "read"
+	Action_Create  = "create"
+	Action_Update  = "update"
+	Action_Delete  = "delete"
+	Action_Approve = "approve" // who approves is decided by the approval rules
+	Action_Reverse = "reverse"
+)
+
+var (
+	rolesAll      = []string{models.ROLE_Admin, models.ROLE_Manager, models.ROLE_PLANNER, models.ROLE_Tech, models.ROLE_User}
+	rolesAdmin    = []string{models.ROLE_Admin}
+	rolesManagers = []string{models.ROLE_Admin, models.ROLE_Manager}
+	rolesPlanning = []string{models.ROLE_Admin, models.ROLE_Manager, models.ROLE_PLANNER}
+	rolesStock    = []string{models.ROLE_Admin, models.ROLE_Manager, models.ROLE_Tech}
+)
+
+// PermissionMatrix lists the roles allowed to take an action on a resource. It seeds the
+// roles in the database and applies to roles that are not stored there.
+var PermissionMatrix = map[Permission][]string{
+	{Resource_Session, Action_Read}:   rolesAll,
+	{Resource_Session, Action_Update}: rolesAll,
+	{Resource_Session, Action_Delete}: rolesAll,
+
+	{Resource_Sessions, Action_Read}:   rolesAdmin,
+	{Resource_Sessions, Action_Delete}: rolesAdmin,
+
+	{Resource_Categories, Action_Read}:   rolesAll,
+	{Resource_Categories, Action_Create}: rolesManagers,
+	{Resource_Categories, Action_Update}: rolesManagers,
+	{Resource_Categories, Action_Delete}: rolesAdmin,
+
+	{Resource_Materials, Action_Read}:   rolesAll,
+	{Resource_Materials, Action_Create}: rolesPlanning,
+	{Resource_Materials, Action_Update}: rolesPlanning,
+	{Resource_Materials, Action_Delete}: rolesAdmin,
+
+	{Resource_Adjustments, Action_Read}:    rolesAll,
+	{Resource_Adjustments, Action_Create}:  rolesStock,
+	{Resource_Adjustments, Action_Approve}: rolesAll,
+
+	{Resource_Inventories, Action_Read}:   rolesAll,
+	{Resource_Inventories, Action_Create}: rolesAdmin,
+	{Resource_Inventories, Action_Update}: rolesManagers,
+	{Resource_Inventories, Action_Delete}: rolesAdmin,
+
+	{Resource_Locations, Action_Read}:   rolesAll,
+	{Resource_Locations, Action_Create}: rolesManagers,
+	{Resource_Locations, Action_Update}: rolesStock,
+	{Resource_Locations, Action_Delete}: rolesManagers,
+
+	{Resource_Transfers, Action_Read}:    rolesAll,
+	{Resource_Transfers, Action_Create}:  rolesStock,
+	{Resource_Transfers, Action_Update}:  rolesStock,
+	{Resource_Transfers, Action_Approve}: rolesAll,
+
+	{Resource_Transactions, Action_Read}: rolesAll,
+
+	{Resource_ProjectStores, Action_Read}:   rolesAll,
+	{Resource_ProjectStores, Action_Create}: rolesManagers,
+	{Resource_ProjectStores, Action_Update}: rolesManagers,
+	{Resource_ProjectStores, Action_Delete}: rolesAdmin,
+
+	{Resource_ProjectStoreMaterials, Action_Update}: rolesStock,
+
+	{Resource_Approvals, Action_Read}:    rolesAll,
+	{Resource_Approvals, Action_Approve}: rolesAll,
+
+	{Resource_ApprovalRules, Action_Read}:   rolesAll,
+	{Resource_ApprovalRules, Action_Create}: rolesAdmin,
+	{Resource_ApprovalRules, Action_Update}: rolesAdmin,
+	{Resource_ApprovalRules, Action_Delete}: rolesAdmin,
+
+	{Resource_SupplierReturns, Action_Read}:   rolesAll,
+	{Resource_SupplierReturns, Action_Create}: rolesManagers,
+
+	{Resource_Loans, Action_Read}:   rolesAll,
+	{Resource_Loans, Action_Create}: rolesStock,
+	{Resource_Loans, Action_Update}: rolesStock,
+
+	{Resource_Serials, Action_Read}: rolesAll,
+	{Resource_Labels, Action_Read}:  rolesAll,
+	{Resource_Trace, Action_Read}:   rolesAll,
+
+	{Resource_Scan, Action_Read}:   rolesAll,
+	{Resource_Scan, Action_Create}: rolesStock,
+	{Resource_Scan, Action_Update}: rolesStock,
+
+	{Resource_Drawings, Action_Read}:   rolesAll,
+	{Resource_Drawings, Action_Create}: rolesPlanning,
+	{Resource_Drawings, Action_Update}: rolesPlanning,
+	{Resource_Drawings, Action_Delete}: rolesManagers,
+
+	{Resource_Orders, Action_Read}:   rolesAll,
+	{Resource_Orders, Action_Create}: rolesPlanning,
+
+	{Resource_ExtendOrders, Action_Read}:   rolesAll,
+	{Resource_ExtendOrders, Action_Create}: rolesPlanning,
+
+	{Resource_Reservations, Action_Update}: rolesPlanning,
+
+	{Resource_Withdrawals, Action_Read}:    rolesAll,
+	{Resource_Withdrawals, Action_Create}:  rolesAll,
+	{Resource_Withdrawals, Action_Update}:  rolesStock,
+	{Resource_Withdrawals, Action_Approve}: rolesAll,
+	{Resource_Withdrawals, Action_Delete}:  rolesManagers,
+	{Resource_Withdrawals, Action_Reverse}: rolesManagers,
+
+	{Resource_AdminWithdrawals, Action_Read}:   rolesAdmin,
+	{Resource_AdminWithdrawals, Action_Create}: rolesManagers,
+
+	{Resource_PurchaseRequisitions, Action_Read}:    rolesAll,
+	{Resource_PurchaseRequisitions, Action_Create}:  rolesPlanning,
+	{Resource_PurchaseRequisitions, Action_Update}:  rolesPlanning,
+	{Resource_PurchaseRequisitions, Action_Approve}: rolesAll,
+	{Resource_PurchaseRequisitions, Action_Delete}:  rolesManagers,
+
+	{Resource_Projects, Action_Read}:   rolesAll,
+	{Resource_Projects, Action_Create}: rolesPlanning,
+	{Resource_Projects, Action_Update}: rolesPlanning,
+	{Resource_Projects, Action_Delete}: rolesAdmin,
+
+	{Resource_Receipts, Action_Read}:    rolesAll,
+	{Resource_Receipts, Action_Create}:  rolesStock,
+	{Resource_Receipts, Action_Update}:  rolesStock,
+	{Resource_Receipts, Action_Approve}: rolesAll,
+	{Resource_Receipts, Action_Delete}:  rolesManagers,
+	{Resource_Receipts, Action_Reverse}: rolesManagers,
+
+	{Resource_Users, Action_Read}:   rolesAll,
+	{Resource_Users, Action_Create}: rolesAdmin,
+	{Resource_Users, Action_Update}: rolesAdmin,
+	{Resource_Users, Action_Delete}: rolesAdmin,
+
+	{Resource_Roles, Action_Read}:   rolesAll,
+	{Resource_Roles, Action_Create}: rolesAdmin,
+	{Resource_Roles, Action_Update}: rolesAdmin,
+	{Resource_Roles, Action_Delete}: rolesAdmin,
+
+	{Resource_APIKeys, Action_Read}:   rolesAdmin,
+	{Resource_APIKeys, Action_Create}: rolesAdmin,
+	{Resource_APIKeys, Action_Update}: rolesAdmin,
+	{Resource_APIKeys, Action_Delete}: rolesAdmin,
+
+	{Resource_Audit, Action_Read}: rolesManagers,
+
+	{Resource_Slugs, Action_Read}: rolesAll,
+
+	{Resource_Notifications, Action_Read}:   rolesAll,
+	{Resource_Notifications, Action_Create}: rolesManagers,
+
+	{Resource_Images, Action_Create}: rolesAll,
+
+	{Resource_Planner, Action_Read}:   rolesPlanning,
+	{Resource_Planner, Action_Create}: rolesPlanning,
+
+	{Resource_Filters, Action_Read}: rolesAll,
+}
+
+// RoutePolicies maps "METHOD /full/path" of every route to its permission.
+var RoutePolicies = map[string]Permission{
+	"GET /health/":     {Resource: Resource_Public},
+	"GET /health/ping": {Resource: Resource_Public},
+
+	"POST /categories":              {Resource_Categories, Action_Create},
+	"GET /categories":               {Resource_Categories, Action_Read},
+	"GET /categories/:id":           {Resource_Categories, Action_Read},
+	"PUT /categories/:id":           {Resource_Categories, Action_Update},
+	"DELETE /categories/:id":        {Resource_Categories, Action_Delete},
+	"GET /categories/:id/materials": {Resource_Categories, Action_Read},
+
+	"POST /projectStores":                 {Resource_ProjectStores, Action_Create},
+	"GET /projectStores":                  {Resource_ProjectStores, Action_Read},
+	"GET /projectStores/:id":              {Resource_ProjectStores, Action_Read},
+	"PUT /projectStores/:id":              {Resource_ProjectStores, Action_Update},
+	"DELETE /projectStores/:id":           {Resource_ProjectStores, Action_Delete},
+	"GET /projectStores/:id/materials":    {Resource_ProjectStores, Action_Read},
+	"GET /projectStores/:id/transactions": {Resource_ProjectStores, Action_Read},
+	"POST /projectStores/:id/consume":     {Resource_ProjectStoreMaterials, Action_Update},
+	"POST /projectStores/:id/return":      {Resource_ProjectStoreMaterials, Action_Update},
+
+	"POST /inventories":                        {Resource_Inventories, Action_Create},
+	"GET /inventories":                         {Resource_Inventories, Action_Read},
+	"GET /inventories/:slug":                   {Resource_Inventories, Action_Read},
+	"PUT /inventories/:id":                     {Resource_Inventories, Action_Update},
+	"DELETE /inventories/:id":                  {Resource_Inventories, Action_Delete},
+	"POST /inventories/transfer":               {Resource_Transfers, Action_Create},
+	"POST /inventories/transfer/calculateCost": {Resource_Transfers, Action_Read},
+	"GET /inventories/expiring":                {Resource_Inventories, Action_Read},
+	"PUT /inventories/writeoff/:id":            {Resource_Inventories, Action_Update},
+	"POST /inventories/locations":              {Resource_Locations, Action_Create},
+	"GET /inventories/:slug/locations":         {Resource_Locations, Action_Read},
+	"GET /inventories/:slug/unassigned":        {Resource_Locations, Action_Read},
+	"GET /inventories/locations/:id/materials": {Resource_Locations, Action_Read},
+	"PUT /inventories/locations/:id":           {Resource_Locations, Action_Update},
+	"DELETE /inventories/locations/:id":        {Resource_Locations, Action_Delete},
+	"PUT /inventories/putaway/:id":             {Resource_Locations, Action_Update},
+	"POST /inventories/locations/move":         {Resource_Locations, Action_Update},
+
+	"GET /transactions":              {Resource_Transactions, Action_Read},
+	"GET /transactions/inventories":  {Resource_Transactions, Action_Read},
+	"GET /transactions/po/:poNumber": {Resource_Transactions, Action_Read},
+
+	"POST /materials":                   {Resource_Materials, Action_Create},
+	"GET /materials/trash":              {Resource_Materials, Action_Delete},
+	"PUT /materials/restore/:id":        {Resource_Materials, Action_Delete},
+	"DELETE /materials/destroy/:id":     {Resource_Materials, Action_Delete},
+	"GET /materials":                    {Resource_Materials, Action_Read},
+	"GET /materials/query":              {Resource_Materials, Action_Read},
+	"GET /materials/:slug":              {Resource_Materials, Action_Read},
+	"PUT /materials/:id":                {Resource_Materials, Action_Update},
+	"PUT /materials/adjust/:id":         {Resource_Adjustments, Action_Create},
+	"GET /materials/adjustments":        {Resource_Adjustments, Action_Read},
+	"PUT /materials/adjust/approve/:id": {Resource_Adjustments, Action_Approve},
+	"DELETE /materials/:id":             {Resource_Materials, Action_Delete},
+	"GET /materials/search":             {Resource_Materials, Action_Read},
+
+	"GET /approvals/rules":                      {Resource_ApprovalRules, Action_Read},
+	"POST /approvals/rules":                     {Resource_ApprovalRules, Action_Create},
+	"PUT /approvals/rules/:id":                  {Resource_ApprovalRules, Action_Update},
+	"DELETE /approvals/rules/:id":               {Resource_ApprovalRules, Action_Delete},
+	"GET /approvals/pending":                    {Resource_Approvals, Action_Read},
+	"GET /approvals/documents/:type/:id":        {Resource_Approvals, Action_Read},
+	"PUT /approvals/documents/:type/:id/reject": {Resource_Approvals, Action_Approve},
+
+	"POST /supplier-returns":      {Resource_SupplierReturns, Action_Create},
+	"GET /supplier-returns":       {Resource_SupplierReturns, Action_Read},
+	"GET /supplier-returns/:slug": {Resource_SupplierReturns, Action_Read},
+
+	"POST /transfer-orders":            {Resource_Transfers, Action_Create},
+	"GET /transfer-orders":             {Resource_Transfers, Action_Read},
+	"POST /transfer-orders/bulk":       {Resource_Transfers, Action_Create},
+	"GET /transfer-orders/:id":         {Resource_Transfers, Action_Read},
+	"PUT /transfer-orders/approve/:id": {Resource_Transfers, Action_Approve},
+	"PUT /transfer-orders/ship/:id":    {Resource_Transfers, Action_Update},
+	"PUT /transfer-orders/receive/:id": {Resource_Transfers, Action_Update},
+	"PUT /transfer-orders/close/:id":   {Resource_Transfers, Action_Update},
+
+	"POST /loans":                {Resource_Loans, Action_Create},
+	"GET /loans":                 {Resource_Loans, Action_Read},
+	"GET /loans/me":              {Resource_Loans, Action_Read},
+	"GET /loans/user/:id":        {Resource_Loans, Action_Read},
+	"GET /loans/overdue":         {Resource_Loans, Action_Read},
+	"POST /loans/overdue/notify": {Resource_Notifications, Action_Create},
+	"PUT /loans/checkin/:id":     {Resource_Loans, Action_Update},
+
+	"GET /serials":                {Resource_Serials, Action_Read},
+	"GET /serials/material/:slug": {Resource_Serials, Action_Read},
+	"GET /serials/lookup/:serial": {Resource_Serials, Action_Read},
+
+	"GET /labels/materials":      {Resource_Labels, Action_Read},
+	"GET /labels/receipts/:slug": {Resource_Labels, Action_Read},
+	"GET /labels/lots":           {Resource_Labels, Action_Read},
+	"GET /labels/lookup":         {Resource_Labels, Action_Read},
+
+	"POST /scan/sessions":            {Resource_Scan, Action_Create},
+	"GET /scan/sessions/:id":         {Resource_Scan, Action_Read},
+	"POST /scan/sessions/:id/scan":   {Resource_Scan, Action_Update},
+	"PUT /scan/sessions/:id/confirm": {Resource_Scan, Action_Update},
+	"PUT /scan/sessions/:id/cancel":  {Resource_Scan, Action_Update},
+	"GET /scan/mismatches":           {Resource_Scan, Action_Read},
+
+	"GET /trace/receipts/:slug":    {Resource_Trace, Action_Read},
+	"GET /trace/po/:poNumber":      {Resource_Trace, Action_Read},
+	"GET /trace/withdrawals/*slug": {Resource_Trace, Action_Read},
+
+	"GET /drawings/new/info/:type": {Resource_Drawings, Action_Read},
+	"POST /drawings":               {Resource_Drawings, Action_Create},
+	"GET /drawings":                {Resource_Drawings, Action_Read},
+	"GET /drawings/:id":            {Resource_Drawings, Action_Read},
+	"PUT /drawings/:id":            {Resource_Drawings, Action_Update},
+	"DELETE /drawings/:id":         {Resource_Drawings, Action_Delete},
+
+	"POST /orders":                   {Resource_Orders, Action_Create},
+	"GET /orders":                    {Resource_Orders, Action_Read},
+	"GET /orders/:slug":              {Resource_Orders, Action_Read},
+	"GET /orders/:slug/:format":      {Resource_Orders, Action_Read},
+	"GET /orders/info/:slug":         {Resource_Orders, Action_Read},
+	"GET /orders/new/info":           {Resource_Orders, Action_Read},
+	"GET /orders/extenders":          {Resource_ExtendOrders, Action_Read},
+	"GET /orders/extenders/new/info": {Resource_ExtendOrders, Action_Read},
+	"GET /orders/extenders/:slug":    {Resource_ExtendOrders, Action_Read},
+	"POST /orders/extenders":         {Resource_ExtendOrders, Action_Create},
+
+	"POST /withdrawals/orderReserving/adjust/:id": {Resource_Reservations, Action_Update},
+	"GET /withdrawals/new/info":                   {Resource_Withdrawals, Action_Read},
+	"POST /withdrawals/admin":                     {Resource_AdminWithdrawals, Action_Create},
+	"POST /withdrawals":                           {Resource_Withdrawals, Action_Create},
+	"POST /withdrawals/admin/nonspec/withdraw":    {Resource_AdminWithdrawals, Action_Create},
+	"POST /withdrawals/partial":                   {Resource_Withdrawals, Action_Create},
+	"GET /withdrawals":                            {Resource_Withdrawals, Action_Read},
+	"PUT /withdrawals/:id":                        {Resource_Withdrawals, Action_Update},
+	"GET /withdrawals/:slug":                      {Resource_Withdrawals, Action_Read},
+	"GET /withdrawals/:slug/*format":              {Resource_Withdrawals, Action_Read},
+	"GET /withdrawals/picklist/:id":               {Resource_Withdrawals, Action_Read},
+	"GET /withdrawals/picklist/:id/:format":       {Resource_Withdrawals, Action_Read},
+	"GET /withdrawals/issueslip/:id/:format":      {Resource_Withdrawals, Action_Read},
+	"PUT /withdrawals/serials/:id":                {Resource_Withdrawals, Action_Update},
+	"PUT /withdrawals/reverse/:id":                {Resource_Withdrawals, Action_Reverse},
+	"DELETE /withdrawals/:id":                     {Resource_Withdrawals, Action_Delete},
+	"GET /withdrawals/new/admin/info":             {Resource_AdminWithdrawals, Action_Read},
+	"PUT /withdrawals/approve/:id":                {Resource_Withdrawals, Action_Approve},
+
+	"POST /pr":              {Resource_PurchaseRequisitions, Action_Create},
+	"GET /pr":               {Resource_PurchaseRequisitions, Action_Read},
+	"GET /pr/new/info":      {Resource_PurchaseRequisitions, Action_Read},
+	"GET /pr/:slug":         {Resource_PurchaseRequisitions, Action_Read},
+	"GET /pr/:slug/:format": {Resource_PurchaseRequisitions, Action_Read},
+	"PUT /pr/:id":           {Resource_PurchaseRequisitions, Action_Update},
+	"PUT /pr/approve/:slug": {Resource_PurchaseRequisitions, Action_Approve},
+	"DELETE /pr/:id":        {Resource_PurchaseRequisitions, Action_Delete},
+
+	"POST /projects":                       {Resource_Projects, Action_Create},
+	"GET /projects":                        {Resource_Projects, Action_Read},
+	"GET /projects/:id":                    {Resource_Projects, Action_Read},
+	"GET /projects/detail/:slug":           {Resource_Projects, Action_Read},
+	"PUT /projects/:id":                    {Resource_Projects, Action_Update},
+	"DELETE /projects/:id":                 {Resource_Projects, Action_Delete},
+	"GET /projects/:id/members":            {Resource_Projects, Action_Read},
+	"POST /projects/:id/members":           {Resource_Projects, Action_Update},
+	"DELETE /projects/:id/members/:userId": {Resource_Projects, Action_Update},
+
+	"POST /receipts":                {Resource_Receipts, Action_Create},
+	"GET /receipts":                 {Resource_Receipts, Action_Read},
+	"GET /receipts/new/info":        {Resource_Receipts, Action_Read},
+	"GET /receipts/edit/info/:slug": {Resource_Receipts, Action_Read},
+	"GET /receipts/details/:slug":   {Resource_Receipts, Action_Read},
+	"GET /receipts/:slug/:format":   {Resource_Receipts, Action_Read},
+	"PUT /receipts/:slug":           {Resource_Receipts, Action_Update},
+	"DELETE /receipts/:id":          {Resource_Receipts, Action_Delete},
+	"PUT /receipts/approve/:id":     {Resource_Receipts, Action_Approve},
+	"PUT /receipts/inspect/:id":     {Resource_Receipts, Action_Update},
+	"PUT /receipts/reverse/:id":     {Resource_Receipts, Action_Reverse},
+
+	"POST /users":             {Resource_Users, Action_Create},
+	"PUT /users/reset/:id":    {Resource_Users, Action_Update},
+	"PUT /users/:id/unlock":   {Resource_Users, Action_Update},
+	"GET /users":              {Resource_Users, Action_Read},
+	"GET /users/:id":          {Resource_Users, Action_Read},
+	"PUT /users/:id":          {Resource_Users, Action_Update},
+	"DELETE /users/:id":       {Resource_Users, Action_Delete},
+	"GET /users/:id/sessions": {Resource_Sessions, Action_Read},
+	"PUT /users/:id/logout":   {Resource_Sessions, Action_Delete},
+
+	"POST /auth/register":       {Resource_Users, Action_Create},
+	"POST /auth/login":          {Resource: Resource_Public},
+	"GET /auth/providers":       {Resource: Resource_Public},
+	"GET /auth/oidc/login":      {Resource: Resource_Public},
+	"GET /auth/oidc/callback":   {Resource: Resource_Public},
+	"POST /auth/refresh":        {Resource: Resource_Public},
+	"POST /auth/logout":         {Resource_Session, Action_Delete},
+	"GET /auth/session":         {Resource_Session, Action_Read},
+	"GET /auth/permissions":     {Resource_Session, Action_Read},
+	"PUT /auth/password":        {Resource_Session, Action_Update},
+	"GET /auth/sessions":        {Resource_Session, Action_Read},
+	"DELETE /auth/sessions/:id": {Resource_Session, Action_Delete},
+
+	"GET /roles":             {Resource_Roles, Action_Read},
+	"GET /roles/permissions": {Resource_Roles, Action_Read},
+	"GET /roles/:id":         {Resource_Roles, Action_Read},
+	"POST /roles":            {Resource_Roles, Action_Create},
+	"PUT /roles/:id":         {Resource_Roles, Action_Update},
+	"DELETE /roles/:id":      {Resource_Roles, Action_Delete},
+
+	"GET /api-keys":        {Resource_APIKeys, Action_Read},
+	"GET /api-keys/:id":    {Resource_APIKeys, Action_Read},
+	"POST /api-keys":       {Resource_APIKeys, Action_Create},
+	"PUT /api-keys/:id":    {Resource_APIKeys, Action_Update},
+	"DELETE /api-keys/:id": {Resource_APIKeys, Action_Delete},
+
+	"GET /audit/:entity/:id": {Resource_Audit, Action_Read},
+
+	"GET /slugs":               {Resource_Slugs, Action_Read},
+	"GET /slugs/request/:slug": {Resource_Slugs, Action_Read},
+	"GET /slugs/:slug":         {Resource_Slugs, Action_Read},
+
+	"GET /notifications": {Resource_Notifications, Action_Read},
+
+	"POST /images/upload/:directory": {Resource_Images, Action_Create},
+	// images are linked from <img> tags, which send no token
+	"GET /images/:directory/:fileName": {Resource: Resource_Public},
+
+	"GET /planner/new/info":      {Resource_Planner, Action_Read},
+	"GET /planner/extend/orders": {Resource_Planner, Action_Read},
+	"GET /planner/materials":     {Resource_Planner, Action_Read},
+	"POST /planner/confirm":      {Resource_Planner, Action_Create},
+	"POST /planner/inquiry":      {Resource_Planner, Action_Read},
+
+	"GET /filters/categories":               {Resource_Filters, Action_Read},
+	"GET /filters/categories/:id/materials": {Resource_Filters, Action_Read},
+}
+
+// RoutePolicy returns the permission of a route, by method and full path as registered.
+func RoutePolicy(method, fullPath string) (Permission, bool) {
+	p, ok := RoutePolicies[method+" "+fullPath]
+	return p, ok
+}
+
+// Allowed reports whether the role may take the action of the permission.
+func Allowed(p Permission, role string) bool {
+	if p.Resource == Resource_Public {
+		return true
+	}
+	return permissions.of(role)[p]
+}
+
+// Authorize enforces the route policies on every route, routes without a policy are denied.
+// It answers 401 for a missing or invalid token and 403 when the role is not allowed.
+// Role permissions and sessions are read from db; without it the static matrix applies and
+// sessions are not checked.
+func Authorize(db *gorm.DB) gin.HandlerFunc {
+	authDB = db
+	permissions.use(db)
+	return func(c *gin.Context) {
+		// unknown routes fall through to NoRoute
+		if c.FullPath() == "" {
+			c.Next()
+			return
+		}
+		p, ok := RoutePolicy(c.Request.Method, c.FullPath())
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No access policy for route"})
+			c.Abort()
+			return
+		}
+		if p.Resource == Resource_Public {
+			c.Next()
+			return
+		}
+
+		role, ok := authenticate(c)
+		if !ok {
+			return
+		}
+		// after an admin reset only the own session, including the password, is reachable
+		if c.GetBool("mustChangePassword") && p.Resource != Resource_Session {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password change required"})
+			c.Abort()
+			return
+		}
+		set := permissions.of(role)
+		if !set[p] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient role privileges"})
+			c.Abort()
+			return
+		}
+		// an API key may do what its scopes and its user's role both allow
+		if scopes, ok := c.Get("scopes"); ok {
+			if !scopes.(map[Permission]bool)[p] {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API key scope does not allow the route"})
+				c.Abort()
+				return
+			}
+			set = intersect(set, scopes.(map[Permission]bool))
+		}
+		c.Set("permissions", set)
+		c.Next()
+	}
+}
+
+func intersect(a, b map[Permission]bool) map[Permission]bool {
+	both := make(map[Permission]bool)
+	for p := range a {
+		if b[p] {
+			both[p] = true
+		}
+	}
+	return both
+}
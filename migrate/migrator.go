@@ -2,6 +2,7 @@ package main
 
 import (
 	"daijai/config"
+	"daijai/middlewares"
 	"daijai/models"
 	"encoding/csv"
 	"flag"
@@ -39,46 +40,7 @@ func main() {
 	if dba, err := config.DB.DB(); err == nil {
 		defer dba.Close()
 	}
-	tables := []interface{}{
-		// relation tables
-		&models.InventoryMaterial{},
-		&models.InventoryMaterialTransaction{},
-		&models.SumMaterialInventory{},
-		&models.ReceiptMaterial{},
-		&models.OrderBOM{},
-		&models.OrderReserving{},
-		&models.WithdrawalApprovement{},
-		&models.WithdrawalTransaction{},
-		&models.WithdrawalAdminTransaction{},
-		&models.Withdrawal{},
-		&models.PurchaseSuggestion{},
-		&models.PurchaseMaterial{},
-
-		// // main tables
-		&models.Order{},
-		&models.Material{},
-		&models.AppLog{},
-		&models.BOM{},
-		&models.Category{},
-		&models.Drawing{},
-		&models.Inventory{},
-		&models.Project{},
-		&models.PurchasePORefs{},
-		&models.Purchase{},
-		&models.PORef{},
-		&models.Slugger{},
-		&models.Receipt{},
-		&models.User{},
-		&models.Notification{},
-		&models.Adjustment{},
-		&models.TransferMaterial{},
-		&models.ProjectStore{},
-
-		// extend tables
-		&models.ExtendOrderBOM{},
-		&models.ExtendOrder{},
-		&models.ExtendOrderReserving{},
-	}
+	tables := models.Tables()
 	if cleanFlag {
 		log.Println("Dropping all tables...")
 		db.Migrator().DropTable()
@@ -90,11 +52,16 @@ func main() {
 		log.Println("All tables dropped")
 	}
 
+	// roles stored before project scoping get it for the system roles that have it
+	scopeRoles := !db.Migrator().HasColumn(&models.Role{}, "is_project_scoped")
+
 	log.Println("Migrating data...")
 	for _, table := range tables {
 		db.AutoMigrate(&table)
 	}
 	log.Println("Done! Migrating data ")
+	initRoles(db, scopeRoles)
+	migrateTransferMaterials(db)
 
 	if seedFlag {
 		log.Println("Seeding data...")
@@ -121,15 +88,7 @@ func main() {
 }
 
 func initSlugger(db *gorm.DB) {
-	slugables := []models.Slugable{
-		&models.User{},
-		&models.Order{},
-		&models.Withdrawal{},
-		&models.Purchase{},
-		&models.Receipt{},
-		&models.ExtendOrder{},
-		&models.Drawing{},
-	}
+	slugables := models.Slugables()
 	for _, m := range slugables {
 		slug := m.GenerateSlug()
 		s := models.Slugger{
@@ -142,6 +101,119 @@ func initSlugger(db *gorm.DB) {
 	}
 }
 
+// migrateTransferMaterials turns transfers left pending by the removed approval route into
+// pending direct transfer orders, approved or rejected like any other. Approved and rejected
+// transfers stay as the history of the lots they created, their approval rules now pick the
+// chain of transfer orders.
+func migrateTransferMaterials(db *gorm.DB) {
+	if err := db.
+		Model(&models.ApprovalRule{}).
+		Where("document_type = ?", models.ApprovalDocumentType_Transfer).
+		Update("document_type", models.ApprovalDocumentType_TransferOrder).Error; err != nil {
+		log.Println("Failed to move transfer approval rules: ", err)
+	}
+	var pending []models.TransferMaterial
+	if err := db.Where("status = ?", models.DocumentStatus_Pending).Order("id asc").Find(&pending).Error; err != nil {
+		log.Println("Failed to get pending transfers: ", err)
+		return
+	}
+	slug := models.TransferOrder{}.GenerateSlug()
+	for _, tm := range pending {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var slugger models.Slugger
+			if err := tx.Where(models.Slugger{TableName: slug.TableName}).Attrs(slug).FirstOrCreate(&slugger).Error; err != nil {
+				return err
+			}
+			slugger.Value++
+			if err := tx.Save(&slugger).Error; err != nil {
+				return err
+			}
+			order := models.TransferOrder{
+				Slug:            fmt.Sprintf("%s%0*d", slugger.Prefix, slugger.Pad, slugger.Value),
+				IsDirect:        true,
+				FromInventoryID: tm.FromInventoryID,
+				ToInventoryID:   tm.ToInventoryID,
+				Status:          models.TransferOrderStatus_Pending,
+				Notes:           tm.Notes,
+				CreatedByID:     tm.CreatedByID,
+				Lines: []models.TransferOrderLine{{
+					MaterialID: tm.MaterialID,
+					Quantity:   tm.Quantity,
+					Serials:    tm.Serials,
+				}},
+			}
+			order.CreatedAt = tm.CreatedAt
+			if err := tx.Create(&order).Error; err != nil {
+				return err
+			}
+			// an approval chain started on the transfer carries on with the order
+			if err := tx.
+				Model(&models.ApprovalRequest{}).
+				Where("document_type = ? AND document_id = ? AND status = ?", models.ApprovalDocumentType_Transfer, tm.ID, models.ApprovalStatus_Pending).
+				Updates(map[string]interface{}{
+					"document_type": models.ApprovalDocumentType_TransferOrder,
+					"document_id":   order.ID,
+				}).Error; err != nil {
+				return err
+			}
+			// the transfer lives on as the order
+			return tx.Delete(&tm).Error
+		}); err != nil {
+			log.Println("Failed to migrate transfer ", tm.ID, ": ", err)
+			continue
+		}
+		log.Println("Pending transfer ", tm.ID, " is now a transfer order")
+	}
+}
+
+// initRoles stores the system roles and gives them the permissions of the built-in matrix they
+// were never given, so the permissions of new routes reach them on the next migrate while the
+// ones taken away through the roles API stay away. Custom roles keep the permissions they were
+// given.
+func initRoles(db *gorm.DB, scopeRoles bool) {
+	for _, name := range models.SystemRoles {
+		role := models.Role{Name: name, IsSystem: true, IsProjectScoped: models.IsProjectScopedRole(name)}
+		result := db.Where(models.Role{Name: name}).Attrs(role).FirstOrCreate(&role)
+		if result.Error != nil {
+			log.Println("Failed to seed role: ", result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 && scopeRoles && models.IsProjectScopedRole(name) {
+			db.Model(&role).Update("is_project_scoped", true)
+		}
+
+		var seeded []models.RoleSeededPermission
+		if err := db.Where("role_id = ?", role.ID).Find(&seeded).Error; err != nil {
+			log.Println("Failed to get seeded role permissions: ", err)
+			continue
+		}
+		done := make(map[middlewares.Permission]bool)
+		for _, p := range seeded {
+			done[middlewares.Permission{Resource: p.Resource, Action: p.Action}] = true
+		}
+		for p, roles := range middlewares.PermissionMatrix {
+			if done[p] {
+				continue
+			}
+			for _, r := range roles {
+				if r != name {
+					continue
+				}
+				if err := db.Transaction(func(tx *gorm.DB) error {
+					permission := models.RolePermission{RoleID: role.ID, Resource: p.Resource, Action: p.Action}
+					if err := tx.Where(permission).FirstOrCreate(&permission).Error; err != nil {
+						return err
+					}
+					return tx.Create(&models.RoleSeededPermission{RoleID: role.ID, Resource: p.Resource, Action: p.Action}).Error
+				}); err != nil {
+					log.Println("Failed to seed role permission: ", err)
+				}
+				break
+			}
+		}
+	}
+}
+
 func loadUsers(db *gorm.DB, filePath string) error {
 	log.Println("Loading users from CSV file...")
 	file, err := os.Open(filePath)
@@ -13,4 +13,8 @@ type Adjustment struct {
 	Material     *Material  `gorm:"foreignKey:MaterialID"`
 	CreatedByID  uint       `gorm:"not null"`
 	CreatedBy    Member     `gorm:"foreignkey:CreatedByID"`
+	Status       string     `gorm:"default:approved"` // DocumentStatus_*
+	// InventoryMaterialID is the lot a write-off takes its negative quantity from
+	InventoryMaterialID *uint
+	InventoryMaterial   *InventoryMaterial `gorm:"foreignKey:InventoryMaterialID"`
 }
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey lets an integration call the API as its user without signing in. Only the hash of
+// the key is stored, the key is shown once when created. Scopes narrow the permissions of
+// the user's role, a key never gets more than its user.
+type APIKey struct {
+	gorm.Model
+	Name        string `gorm:"not null"`
+	Prefix      string `gorm:"index"` // first characters of the key, to recognise it in lists
+	KeyHash     string `gorm:"uniqueIndex;not null" json:"-"`
+	UserID      uint   `gorm:"not null;index"`
+	User        Member `gorm:"foreignkey:UserID"`
+	CreatedByID uint
+	CreatedBy   Member `gorm:"foreignkey:CreatedByID"`
+	Scopes      []APIKeyScope
+	ExpiresAt   *time.Time
+	LastUsedAt  *time.Time
+	LastUsedIP  string
+	RevokedAt   *time.Time
+	RevokedByID *uint
+	RevokedBy   *Member `gorm:"foreignkey:RevokedByID"`
+}
+
+// IsActive reports whether the key is accepted.
+func (k *APIKey) IsActive() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// APIKeyScope allows a key an action on a resource, see middlewares.PermissionMatrix.
+type APIKeyScope struct {
+	gorm.Model
+	APIKeyID uint   `gorm:"not null;uniqueIndex:idx_api_key_scope"`
+	Resource string `gorm:"not null;uniqueIndex:idx_api_key_scope"`
+	Action   string `gorm:"not null;uniqueIndex:idx_api_key_scope"`
+}
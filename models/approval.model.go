@@ -0,0 +1,87 @@
+package models
+
+import "gorm.io/gorm"
+
+// ApprovalRule picks the approval chain of a document. Conditions left empty match any document,
+// the enabled rule with the highest priority that matches wins.
+type ApprovalRule struct {
+	gorm.Model
+	Name         string
+	DocumentType string `gorm:"not null;index"`
+	Priority     int
+	MinAmount    *int64 // document total, inclusive
+	MaxAmount    *int64 // document total, exclusive
+	CategoryID   *uint
+	Category     *Category
+	ProjectID    *uint
+	Project      *Project
+	IsDisabled   bool
+	Steps        []ApprovalRuleStep
+}
+
+// ApprovalRuleStep is one approver of a chain, steps are approved in sequence.
+// A step names a role, a user, or both.
+type ApprovalRuleStep struct {
+	gorm.Model
+	ApprovalRuleID uint `gorm:"not null"`
+	Sequence       int
+	Name           string
+	Role           string
+	ApproverID     *uint
+	Approver       *Member `gorm:"foreignkey:ApproverID"`
+}
+
+// ApprovalRequest tracks a document through its approval chain, a document has one pending
+// request at most.
+type ApprovalRequest struct {
+	gorm.Model
+	DocumentType   string `gorm:"not null;index:idx_approval_document;uniqueIndex:idx_approval_pending,where:status = 'pending' AND deleted_at IS NULL"`
+	DocumentID     uint   `gorm:"not null;index:idx_approval_document;uniqueIndex:idx_approval_pending,where:status = 'pending' AND deleted_at IS NULL"`
+	ApprovalRuleID *uint
+	ApprovalRule   *ApprovalRule
+	Amount         int64
+	Status         string
+	CurrentStep    int // index of the next step to approve
+	StepCount      int
+	RequestedByID  *uint
+	RequestedBy    *Member `gorm:"foreignkey:RequestedByID"`
+	History        []ApprovalHistory
+}
+
+type ApprovalHistory struct {
+	gorm.Model
+	ApprovalRequestID uint `gorm:"not null"`
+	Step              int
+	StepName          string
+	Action            string
+	Comment           string
+	ActorID           uint
+	Actor             Member `gorm:"foreignkey:ActorID"`
+}
+
+const (
+	ApprovalDocumentType_Purchase      = "purchase"
+	ApprovalDocumentType_Receipt       = "receipt"
+	ApprovalDocumentType_Withdrawal    = "withdrawal"
+	ApprovalDocumentType_Adjustment    = "adjustment"
+	ApprovalDocumentType_Transfer      = "transfer" // transfers of the removed approval route, kept to migrate them
+	ApprovalDocumentType_TransferOrder = "transfer-order"
+)
+
+const (
+	ApprovalStatus_Pending  = "pending"
+	ApprovalStatus_Approved = "approved"
+	ApprovalStatus_Rejected = "rejected"
+)
+
+const (
+	ApprovalAction_Approved = "approved"
+	ApprovalAction_Rejected = "rejected"
+)
+
+// Status of documents that wait for approval before they touch stock.
+const (
+	DocumentStatus_Pending  = "pending"
+	DocumentStatus_Approved = "approved"
+	DocumentStatus_Rejected = "rejected"
+)
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// AuditLog is a create, update or delete of a row, with who made it and the row before and
+// after. Entity is the table name, Changes holds the changed columns of an update.
+type AuditLog struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Entity    string    `gorm:"not null;index:idx_audit_entity"`
+	EntityID  uint      `gorm:"not null;index:idx_audit_entity"`
+	Action    string    `gorm:"not null"`
+	ActorID   *uint     `gorm:"index"`
+	Actor     *Member   `gorm:"foreignkey:ActorID"`
+	APIKeyID  *uint
+	IP        string
+	Route     string
+	Before    map[string]interface{} `gorm:"serializer:json"`
+	After     map[string]interface{} `gorm:"serializer:json"`
+	Changes   map[string]AuditChange `gorm:"serializer:json"`
+}
+
+// AuditChange is the old and new value of a column.
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+const (
+	AuditAction_Create = "create"
+	AuditAction_Update = "update"
+	AuditAction_Delete = "delete"
+)
@@ -6,5 +6,7 @@ type Inventory struct {
 	gorm.Model
 	Slug               string `gorm:"unique"`
 	Title              string
+	IsVirtual          bool // in-transit stock, never picked from
 	InventoryMaterials []InventoryMaterial
+	Locations          []InventoryLocation
 }
@@ -0,0 +1,46 @@
+package models
+
+import "gorm.io/gorm"
+
+// InventoryLocation is a zone/rack/bin inside an inventory.
+type InventoryLocation struct {
+	gorm.Model
+	InventoryID uint   `gorm:"not null;uniqueIndex:idx_inventory_location_slug"`
+	Slug        string `gorm:"not null;uniqueIndex:idx_inventory_location_slug"` // e.g. A-01-03
+	Zone        string
+	Rack        string
+	Bin         string
+	Title       string
+	Inventory   *Inventory `gorm:"foreignKey:InventoryID;references:ID"`
+}
+
+type LocationMove struct {
+	gorm.Model
+	Notes                  string
+	Quantity               int64              `gorm:"not null"`
+	InventoryMaterialID    uint               `gorm:"not null"`
+	InventoryMaterial      *InventoryMaterial `gorm:"foreignKey:InventoryMaterialID"`
+	NewInventoryMaterialID *uint              // set when only part of the lot is moved
+	NewInventoryMaterial   *InventoryMaterial `gorm:"foreignKey:NewInventoryMaterialID"`
+	FromLocationID         *uint
+	FromLocation           *InventoryLocation `gorm:"foreignKey:FromLocationID"`
+	ToLocationID           uint               `gorm:"not null"`
+	ToLocation             *InventoryLocation `gorm:"foreignKey:ToLocationID"`
+	CreatedByID            uint               `gorm:"not null"`
+	CreatedBy              Member             `gorm:"foreignkey:CreatedByID"`
+}
+
+// PickListLine tells the storekeeper which lot and bin to pick from.
+type PickListLine struct {
+	MaterialID          uint
+	MaterialSlug        string
+	MaterialTitle       string
+	InventoryMaterialID uint
+	LotNumber           string
+	ReceiptID           *uint
+	InventoryID         uint
+	InventoryTitle      string
+	LocationID          *uint
+	LocationSlug        string
+	Quantity            int64
+}
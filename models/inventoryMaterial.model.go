@@ -1,31 +1,83 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type InventoryMaterial struct {
 	gorm.Model
-	MaterialID            uint `grom:"not null"`
-	InventoryID           uint `grom:"not null"`
-	ReceiptID             *uint
-	AdjustmentID          *uint
-	TransferMaterialID    *uint
-	Quantity              int64
-	Reserve               int64
-	Withdrawed            int64
-	AvailableQty          int64
-	Price                 int64
-	IsOutOfStock          bool
-	Material              *Material         `gorm:"foreignKey:MaterialID;references:ID"`
-	Inventory             *Inventory        `gorm:"foreignKey:InventoryID;references:ID"`
-	Receipt               *Receipt          `gorm:"foreignKey:ReceiptID;references:ID"`
-	Adjustment            *Adjustment       `gorm:"foreignKey:AdjustmentID;references:ID"`
-	TransferMaterial      *TransferMaterial `gorm:"foreignKey:TransferMaterialID;references:ID"`
-	Transactions          *[]InventoryMaterialTransaction
-	InventoryMaterialType string
+	MaterialID             uint `grom:"not null"`
+	InventoryID            uint `grom:"not null"`
+	ReceiptID              *uint
+	AdjustmentID           *uint
+	TransferMaterialID     *uint
+	TransferOrderID        *uint
+	ProjectStoreMaterialID *uint
+	LocationID             *uint
+	ExpiryDate             *time.Time
+	ManufactureDate        *time.Time
+	Quantity               int64
+	Reserve                int64
+	Withdrawed             int64
+	AvailableQty           int64
+	Price                  int64
+	IsOutOfStock           bool
+	Material               *Material             `gorm:"foreignKey:MaterialID;references:ID"`
+	Inventory              *Inventory            `gorm:"foreignKey:InventoryID;references:ID"`
+	Receipt                *Receipt              `gorm:"foreignKey:ReceiptID;references:ID"`
+	Adjustment             *Adjustment           `gorm:"foreignKey:AdjustmentID;references:ID"`
+	TransferMaterial       *TransferMaterial     `gorm:"foreignKey:TransferMaterialID;references:ID"`
+	TransferOrder          *TransferOrder        `gorm:"foreignKey:TransferOrderID;references:ID"`
+	ProjectStoreMaterial   *ProjectStoreMaterial `gorm:"foreignKey:ProjectStoreMaterialID;references:ID"`
+	Location               *InventoryLocation    `gorm:"foreignKey:LocationID;references:ID"`
+	Transactions           *[]InventoryMaterialTransaction
+	InventoryMaterialType  string
 }
 
 const (
 	InventoryMaterialType_Receipt  = "receipt"
 	InventoryMaterialType_Adjust   = "adjust"
 	InventoryMaterialType_Transfer = "transfer"
+	InventoryMaterialType_Return   = "return" // returned from a project store
+	InventoryMaterialType_Move     = "move"   // split from another lot by a bin move
 )
+
+// LotNumber is the printed identity of an inventory material lot.
+func LotNumber(inventoryMaterialID uint) string {
+	return fmt.Sprintf("LOT-%07d", inventoryMaterialID)
+}
+
+// IsExpired reports whether the lot is past its expiry date.
+func (im *InventoryMaterial) IsExpired() bool {
+	return im.ExpiryDate != nil && !im.ExpiryDate.After(time.Now())
+}
+
+// NotExpired is a query scope excluding expired lots.
+func NotExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("(expiry_date IS NULL OR expiry_date > ?)", time.Now())
+}
+
+// LotCode is the code printed on lot labels, e.g. "DA0001|LOT-0000042".
+func LotCode(materialSlug string, inventoryMaterialID uint) string {
+	return materialSlug + "|" + LotNumber(inventoryMaterialID)
+}
+
+// ParseLotCode returns the lot id of a scanned lot code or bare lot number.
+func ParseLotCode(code string) (uint, bool) {
+	if i := strings.LastIndex(code, "|"); i >= 0 {
+		code = code[i+1:]
+	}
+	if !strings.HasPrefix(code, "LOT-") {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(code, "LOT-"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
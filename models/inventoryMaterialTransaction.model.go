@@ -25,6 +25,12 @@ type InventoryMaterialTransaction struct {
 	Adjustment               *Adjustment `gorm:"foreignKey:AdjustmentID;references:ID"`
 	TransferMaterialID       *uint
 	TransferMaterial         *TransferMaterial `gorm:"foreignKey:TransferMaterialID;references:ID"`
+	LocationMoveID           *uint
+	LocationMove             *LocationMove `gorm:"foreignKey:LocationMoveID;references:ID"`
+	TransferOrderID          *uint
+	TransferOrder            *TransferOrder `gorm:"foreignKey:TransferOrderID;references:ID"`
+	SupplierReturnID         *uint
+	SupplierReturn           *SupplierReturn `gorm:"foreignKey:SupplierReturnID;references:ID"`
 }
 
 const (
@@ -33,16 +39,30 @@ const (
 	InventoryType_RESERVE     = "reserve"
 	InventoryType_RESERVEBACK = "reserveback"
 	InventoryType_TRANSFER    = "transfer"
+	InventoryType_MOVE        = "move"
 )
 
 const (
-	InventoryTypeDescription_INCOMINGRECEIPT = "receipt"
-	InventoryTypeDescription_WITHDRAWAL      = "withdrawal"
-	InventoryTypeDescription_ORDER           = "order"
-	InventoryTypeDescription_EXTEND_ORDER    = "extend-order"
-	InventoryTypeDescription_FillFromReceipt = "fill-order-from-receipt"
-	InventoryTypeDescription_RETURN          = "return"
-	InventoryTypeDescription_ADJUSTMENT      = "adjustment"
-	InventoryTypeDescription_TRANSFER_IN     = "transfer-in"
-	InventoryTypeDescription_TRANSFER_OUT    = "transfer-out"
+	InventoryTypeDescription_INCOMINGRECEIPT     = "receipt"
+	InventoryTypeDescription_WITHDRAWAL          = "withdrawal"
+	InventoryTypeDescription_ORDER               = "order"
+	InventoryTypeDescription_EXTEND_ORDER        = "extend-order"
+	InventoryTypeDescription_FillFromReceipt     = "fill-order-from-receipt"
+	InventoryTypeDescription_RETURN              = "return"
+	InventoryTypeDescription_ADJUSTMENT          = "adjustment"
+	InventoryTypeDescription_TRANSFER_IN         = "transfer-in"
+	InventoryTypeDescription_TRANSFER_OUT        = "transfer-out"
+	InventoryTypeDescription_PUTAWAY             = "putaway"
+	InventoryTypeDescription_MOVE_OUT            = "move-out"
+	InventoryTypeDescription_MOVE_IN             = "move-in"
+	InventoryTypeDescription_EXPIRED_WRITEOFF    = "expired-writeoff"
+	InventoryTypeDescription_LOAN_OUT            = "loan-out"
+	InventoryTypeDescription_LOAN_IN             = "loan-in"
+	InventoryTypeDescription_SUPPLIER_RETURN     = "supplier-return"
+	InventoryTypeDescription_RECEIPT_REVERSAL    = "receipt-reversal"
+	InventoryTypeDescription_WITHDRAWAL_REVERSAL = "withdrawal-reversal"
+	InventoryTypeDescription_TRANSIT_IN          = "transit-in"
+	InventoryTypeDescription_TRANSIT_OUT         = "transit-out"
+	InventoryTypeDescription_TRANSIT_LOSS        = "transit-loss"
+	InventoryTypeDescription_TRANSIT_RETURN      = "transit-return"
 )
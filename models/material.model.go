@@ -15,6 +15,10 @@ type Material struct {
 	CategoryID   uint `json:"CategoryID" form:"CategoryID"`
 	Category     Category
 	IsFG         bool `gorm:"default:false"`
+	// each unit has a MaterialSerial
+	IsSerialTracked bool `gorm:"default:false" form:"IsSerialTracked"`
+	// checked out as MaterialLoan and expected back
+	IsReturnable bool `gorm:"default:false" form:"IsReturnable"`
 	Sums         *[]SumMaterialInventory
 }
 
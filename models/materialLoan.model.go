@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaterialLoan is a returnable material checked out to a user until it is checked in.
+type MaterialLoan struct {
+	gorm.Model
+	MaterialID        uint `gorm:"not null"`
+	Material          *Material
+	InventoryID       uint `gorm:"not null"`
+	Inventory         *Inventory
+	Quantity          int64
+	ReturnedQty       int64
+	Status            string
+	DueAt             time.Time
+	Notes             string
+	Serials           []string `gorm:"serializer:json"`
+	BorrowerID        uint     `gorm:"not null"`
+	Borrower          Member   `gorm:"foreignkey:BorrowerID"`
+	CheckedOutByID    uint     `gorm:"not null"`
+	CheckedOutBy      Member   `gorm:"foreignkey:CheckedOutByID"`
+	OverdueNotifiedAt *time.Time
+	Lots              []MaterialLoanLot
+	CheckIns          []MaterialLoanCheckIn
+}
+
+// MaterialLoanLot is the quantity a loan took from one inventory lot.
+type MaterialLoanLot struct {
+	gorm.Model
+	MaterialLoanID      uint
+	InventoryMaterialID uint
+	InventoryMaterial   *InventoryMaterial
+	Quantity            int64
+	ReturnedQty         int64
+}
+
+// MaterialLoanCheckIn records a return with the condition of the material.
+type MaterialLoanCheckIn struct {
+	gorm.Model
+	MaterialLoanID uint
+	Quantity       int64
+	Condition      string
+	ConditionNotes string
+	Serials        []string `gorm:"serializer:json"`
+	CheckedInByID  uint
+	CheckedInBy    Member `gorm:"foreignkey:CheckedInByID"`
+}
+
+const (
+	MaterialLoanStatus_Out      = "out"
+	MaterialLoanStatus_Returned = "returned"
+)
+
+const (
+	MaterialLoanCondition_Good    = "good"
+	MaterialLoanCondition_Worn    = "worn"
+	MaterialLoanCondition_Damaged = "damaged"
+)
+
+// IsOverdue reports whether an outstanding loan is past its due date.
+func (l *MaterialLoan) IsOverdue() bool {
+	return l.Status == MaterialLoanStatus_Out && l.DueAt.Before(time.Now())
+}
@@ -0,0 +1,42 @@
+package models
+
+import "gorm.io/gorm"
+
+// MaterialSerial is one unit of a serial tracked material. While in stock it
+// points to its inventory lot, once issued to the project store lot holding it.
+type MaterialSerial struct {
+	gorm.Model
+	MaterialID              uint   `gorm:"not null;uniqueIndex:idx_material_serial"`
+	SerialNumber            string `gorm:"not null;uniqueIndex:idx_material_serial"`
+	Status                  string
+	ReceiptID               *uint
+	InventoryID             *uint
+	InventoryMaterialID     *uint
+	WithdrawalApprovementID *uint // assigned to a pending withdrawal
+	WithdrawalID            *uint
+	ProjectStoreID          *uint
+	ProjectStoreMaterialID  *uint
+	MaterialLoanID          *uint
+	Material                *Material              `gorm:"foreignKey:MaterialID;references:ID"`
+	Receipt                 *Receipt               `gorm:"foreignKey:ReceiptID;references:ID"`
+	Inventory               *Inventory             `gorm:"foreignKey:InventoryID;references:ID"`
+	InventoryMaterial       *InventoryMaterial     `gorm:"foreignKey:InventoryMaterialID;references:ID"`
+	WithdrawalApprovement   *WithdrawalApprovement `gorm:"foreignKey:WithdrawalApprovementID;references:ID"`
+	Withdrawal              *Withdrawal            `gorm:"foreignKey:WithdrawalID;references:ID"`
+	ProjectStore            *ProjectStore          `gorm:"foreignKey:ProjectStoreID;references:ID"`
+	ProjectStoreMaterial    *ProjectStoreMaterial  `gorm:"foreignKey:ProjectStoreMaterialID;references:ID"`
+}
+
+// SerialQuantity is the stock quantity of one serialised unit (quantities are x100).
+const SerialQuantity = 100
+
+const (
+	MaterialSerialStatus_InStock    = "in-stock"
+	MaterialSerialStatus_Issued     = "issued"
+	MaterialSerialStatus_InProject  = "in-project"
+	MaterialSerialStatus_Consumed   = "consumed"
+	MaterialSerialStatus_WrittenOff = "written-off"
+	MaterialSerialStatus_OnLoan     = "on-loan"
+	MaterialSerialStatus_Returned   = "returned-to-supplier"
+	MaterialSerialStatus_InTransit  = "in-transit"
+)
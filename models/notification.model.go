@@ -39,6 +39,7 @@ const (
 	NotificationAction_RESTOCK             = "restock"
 	NotificationAction_NEW_WITHDRAWAL      = "new_withdrawal"
 	NotificationAction_APPROVED_WITHDRAWAL = "approved_withdrawal"
+	NotificationAction_OVERDUE_LOAN        = "overdue_loan"
 )
 
 const (
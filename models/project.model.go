@@ -13,6 +13,16 @@ type Project struct {
 	Subtitle      string
 	Description   string
 	ProjectStores []ProjectStore
+	Members       []ProjectMember
+}
+
+// ProjectMember assigns a user to a project. Users of a project scoped role only see the
+// orders, withdrawals and extend orders of their projects.
+type ProjectMember struct {
+	gorm.Model
+	ProjectID uint   `gorm:"not null;uniqueIndex:idx_project_member"`
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_project_member;index"`
+	User      Member `gorm:"foreignkey:UserID"`
 }
 
 func (p *Project) String() string {
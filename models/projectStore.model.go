@@ -8,4 +8,5 @@ type ProjectStore struct {
 	Title     string
 	ProjectID uint
 	Project   *Project
+	Materials []ProjectStoreMaterial
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ProjectStoreMaterial is a lot of material held in a project store after an
+// approved withdrawal moved it out of the main inventory.
+type ProjectStoreMaterial struct {
+	gorm.Model
+	ProjectStoreID      uint  `gorm:"not null"`
+	MaterialID          uint  `gorm:"not null"`
+	InventoryMaterialID *uint // source lot in the inventory
+	WithdrawalID        *uint
+	ReceiptID           *uint
+	Quantity            int64
+	ConsumedQty         int64
+	ReturnedQty         int64
+	AvailableQty        int64
+	Price               int64
+	IsOutOfStock        bool
+	ExpiryDate          *time.Time
+	ManufactureDate     *time.Time
+	ProjectStore        *ProjectStore      `gorm:"foreignKey:ProjectStoreID;references:ID"`
+	Material            *Material          `gorm:"foreignKey:MaterialID;references:ID"`
+	InventoryMaterial   *InventoryMaterial `gorm:"foreignKey:InventoryMaterialID;references:ID"`
+	Withdrawal          *Withdrawal        `gorm:"foreignKey:WithdrawalID;references:ID"`
+	Receipt             *Receipt           `gorm:"foreignKey:ReceiptID;references:ID"`
+	Transactions        *[]ProjectStoreTransaction
+}
@@ -0,0 +1,27 @@
+package models
+
+import "gorm.io/gorm"
+
+type ProjectStoreTransaction struct {
+	gorm.Model
+	ProjectStoreMaterialID uint
+	ProjectStoreMaterial   *ProjectStoreMaterial
+	Quantity               int64
+	TransactionType        string // ProjectStoreTransactionType_*
+	ExistingQuantity       int64
+	UpdatedQuantity        int64
+	WithdrawalID           *uint
+	Withdrawal             *Withdrawal        `gorm:"foreignKey:WithdrawalID;references:ID"`
+	InventoryMaterialID    *uint              // lot created in the inventory by a return
+	InventoryMaterial      *InventoryMaterial `gorm:"foreignKey:InventoryMaterialID;references:ID"`
+	Notes                  string
+	CreatedByID            *uint
+	CreatedBy              *Member `gorm:"foreignkey:CreatedByID"`
+}
+
+const (
+	ProjectStoreTransactionType_Incoming = "incoming" // from approved withdrawal
+	ProjectStoreTransactionType_Consume  = "consume"  // used on site
+	ProjectStoreTransactionType_Return   = "return"   // sent back to inventory
+	ProjectStoreTransactionType_Reversal = "reversal" // withdrawal reversed
+)
@@ -8,6 +8,7 @@ type Purchase struct {
 	PORefs            []PORef `gorm:"many2many:purchase_po_refs;"`
 	Notes             string
 	IsApprove         bool
+	IsRejected        bool
 	PurchaseMaterials []PurchaseMaterial
 	CreatedByID       uint   `gorm:"not null"`
 	CreatedBy         Member `gorm:"foreignkey:CreatedByID"`
@@ -6,8 +6,9 @@ import (
 
 type PurchaseMaterial struct {
 	gorm.Model
-	PurchaseID uint
-	MaterialID uint
-	Quantity   int64
-	Material   Material
+	PurchaseID  uint
+	MaterialID  uint
+	Quantity    int64
+	ReceivedQty int64 // accepted on receipts of the PO less returns to the supplier
+	Material    Material
 }
@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Receipt struct {
 	gorm.Model
@@ -10,6 +14,7 @@ type Receipt struct {
 	RecipientID      *uint
 	Recipient        *Member
 	IsApproved       bool
+	IsRejected       bool
 	Inventory        Inventory
 	InventoryID      uint `gorm:"not null"`
 	ReceiptMaterials []ReceiptMaterial
@@ -17,4 +22,9 @@ type Receipt struct {
 	CreatedBy        Member `gorm:"foreignkey:CreatedByID"`
 	ApprovedByID     *uint
 	ApprovedBy       *Member `gorm:"foreignkey:ApprovedByID"`
+	IsReversed       bool
+	ReversedAt       *time.Time
+	ReversedByID     *uint
+	ReversedBy       *Member `gorm:"foreignkey:ReversedByID"`
+	ReverseReason    string
 }
@@ -1,13 +1,65 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type ReceiptMaterial struct {
 	gorm.Model
-	ReceiptID  uint
-	MaterialID uint
-	Quantity   int64
-	IsApproved bool
-	Material   Material
-	Price      int64
+	ReceiptID        uint
+	MaterialID       uint
+	Quantity         int64
+	IsApproved       bool
+	Material         Material
+	Price            int64
+	LocationID       *uint // put-away bin
+	ExpiryDate       *time.Time
+	ManufactureDate  *time.Time
+	Serials          []string `gorm:"serializer:json"` // serial tracked materials only
+	InspectionStatus string   `gorm:"default:pending"`
+	AcceptedQty      int64
+	RejectedQty      int64
+	RejectReason     string
+	RejectedSerials  []string `gorm:"serializer:json"`
+	InspectedByID    *uint
+	InspectedBy      *Member `gorm:"foreignkey:InspectedByID"`
+	InspectedAt      *time.Time
+}
+
+const (
+	ReceiptInspectionStatus_Pending  = "pending"
+	ReceiptInspectionStatus_Accepted = "accepted"
+	ReceiptInspectionStatus_Partial  = "partial"
+	ReceiptInspectionStatus_Rejected = "rejected"
+)
+
+// IsInspected reports whether the line went through inspection,
+// lines approved without inspection are accepted in full.
+func (rm *ReceiptMaterial) IsInspected() bool {
+	return rm.InspectionStatus != "" && rm.InspectionStatus != ReceiptInspectionStatus_Pending
+}
+
+// AcceptedQuantity is the quantity that goes into stock on approval.
+func (rm *ReceiptMaterial) AcceptedQuantity() int64 {
+	if !rm.IsInspected() {
+		return rm.Quantity
+	}
+	return rm.AcceptedQty
+}
+
+// AcceptedSerials are the serials of the line that were not rejected.
+func (rm *ReceiptMaterial) AcceptedSerials() []string {
+	rejected := make(map[string]bool)
+	for _, sn := range rm.RejectedSerials {
+		rejected[sn] = true
+	}
+	accepted := []string{}
+	for _, sn := range rm.Serials {
+		if !rejected[sn] {
+			accepted = append(accepted, sn)
+		}
+	}
+	return accepted
 }
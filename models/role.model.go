@@ -0,0 +1,48 @@
+package models
+
+import "gorm.io/gorm"
+
+// Role is a named set of permissions, users refer to it by name. System roles are the
+// built-in roles the code knows, they cannot be renamed or deleted.
+type Role struct {
+	gorm.Model
+	Name            string `gorm:"unique;not null"`
+	Description     string
+	IsSystem        bool
+	IsProjectScoped bool // its users only see the documents of their projects
+	Permissions     []RolePermission
+}
+
+// RolePermission allows a role an action on a resource, see middlewares.PermissionMatrix.
+type RolePermission struct {
+	gorm.Model
+	RoleID   uint   `gorm:"not null;uniqueIndex:idx_role_permission"`
+	Resource string `gorm:"not null;uniqueIndex:idx_role_permission"`
+	Action   string `gorm:"not null;uniqueIndex:idx_role_permission"`
+}
+
+// RoleSeededPermission records a permission of the built-in matrix that migrate gave a system
+// role, so a permission taken away from the role afterwards is not given back.
+type RoleSeededPermission struct {
+	gorm.Model
+	RoleID   uint   `gorm:"not null;uniqueIndex:idx_role_seeded_permission"`
+	Resource string `gorm:"not null;uniqueIndex:idx_role_seeded_permission"`
+	Action   string `gorm:"not null;uniqueIndex:idx_role_seeded_permission"`
+}
+
+// SystemRoles are the roles seeded with the built-in permission matrix.
+var SystemRoles = []string{ROLE_Admin, ROLE_Manager, ROLE_PLANNER, ROLE_Tech, ROLE_User}
+
+// ProjectScopedRoles are the system roles limited to their projects.
+var ProjectScopedRoles = []string{ROLE_Tech, ROLE_User}
+
+// IsProjectScopedRole reports whether a system role is limited to its projects, it applies
+// to roles that are not stored.
+func IsProjectScopedRole(name string) bool {
+	for _, r := range ProjectScopedRoles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ScanSession confirms the quantities of a receipt or withdrawal by scanning labels before approval.
+type ScanSession struct {
+	gorm.Model
+	SessionType             string
+	Status                  string
+	ReceiptID               *uint
+	Receipt                 *Receipt `gorm:"foreignKey:ReceiptID;references:ID"`
+	WithdrawalApprovementID *uint
+	WithdrawalApprovement   *WithdrawalApprovement `gorm:"foreignKey:WithdrawalApprovementID;references:ID"`
+	CreatedByID             uint
+	CreatedBy               Member `gorm:"foreignkey:CreatedByID"`
+	ConfirmedAt             *time.Time
+	Lines                   []ScanLine
+	Mismatches              []ScanMismatch
+}
+
+// ScanLine is one expected material (receipt) or lot (withdrawal) of a scan session.
+type ScanLine struct {
+	gorm.Model
+	ScanSessionID       uint
+	MaterialID          uint
+	Material            *Material `gorm:"foreignKey:MaterialID;references:ID"`
+	InventoryMaterialID *uint
+	LotNumber           string
+	ExpectedQty         int64
+	ScannedQty          int64
+}
+
+// ScanMismatch logs a rejected scan.
+type ScanMismatch struct {
+	gorm.Model
+	ScanSessionID uint
+	Code          string
+	Quantity      int64
+	Reason        string
+	CreatedByID   uint
+	CreatedBy     Member `gorm:"foreignkey:CreatedByID"`
+}
+
+const (
+	ScanSessionType_Receipt    = "receipt"
+	ScanSessionType_Withdrawal = "withdrawal"
+)
+
+const (
+	ScanSessionStatus_Open      = "open"
+	ScanSessionStatus_Confirmed = "confirmed"
+	ScanSessionStatus_Cancelled = "cancelled"
+)
+
+const (
+	ScanMismatchReason_UnknownCode  = "unknown-code"
+	ScanMismatchReason_NotExpected  = "not-expected"
+	ScanMismatchReason_OverQuantity = "over-quantity"
+	ScanMismatchReason_LotRequired  = "lot-required"
+)
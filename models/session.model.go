@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is a sign-in of a user. Access tokens carry its id as "sid" and stop working
+// once it is revoked; its refresh token is stored hashed and rotated on every refresh.
+type Session struct {
+	gorm.Model
+	UserID            uint   `gorm:"not null;index"`
+	User              Member `gorm:"foreignkey:UserID"`
+	RefreshTokenHash  string `gorm:"uniqueIndex" json:"-"`
+	PreviousTokenHash string `gorm:"index" json:"-"` // replaced refresh token, reuse revokes the session
+	UserAgent         string
+	IP                string
+	ExpiresAt         time.Time
+	LastUsedAt        time.Time
+	RevokedAt         *time.Time
+	RevokedReason     string
+}
+
+// IsActive reports whether the session can still be used.
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+const (
+	SessionRevokedReason_Logout      = "logout"
+	SessionRevokedReason_Revoked     = "revoked"
+	SessionRevokedReason_ForcedOut   = "forced-logout"
+	SessionRevokedReason_TokenReuse  = "refresh-token-reuse"
+	SessionRevokedReason_UserChanged = "user-changed"
+)
@@ -14,6 +14,21 @@ type Slugable interface {
 	GenerateSlug() Slugger
 }
 
+// Slugables returns a model of every table numbered by a Slugger.
+func Slugables() []Slugable {
+	return []Slugable{
+		&User{},
+		&Order{},
+		&Withdrawal{},
+		&Purchase{},
+		&Receipt{},
+		&ExtendOrder{},
+		&Drawing{},
+		&SupplierReturn{},
+		&TransferOrder{},
+	}
+}
+
 func (User) GenerateSlug() Slugger {
 	return Slugger{
 		TableName: "users",
@@ -75,3 +90,12 @@ func (Drawing) GenerateSlug() Slugger {
 		Value:     0,
 	}
 }
+
+func (SupplierReturn) GenerateSlug() Slugger {
+	return Slugger{
+		TableName: "supplier_returns",
+		Prefix:    "RTS-",
+		Pad:       7,
+		Value:     0,
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import "gorm.io/gorm"
+
+// SupplierReturn is a return-to-supplier document for goods rejected
+// on inspection or sent back from stock.
+type SupplierReturn struct {
+	gorm.Model
+	Slug                    string `gorm:"unique"`
+	Source                  string
+	ReceiptID               uint `gorm:"not null"`
+	Receipt                 *Receipt
+	PORefNumber             string
+	Notes                   string
+	CreatedByID             uint   `gorm:"not null"`
+	CreatedBy               Member `gorm:"foreignkey:CreatedByID"`
+	SupplierReturnMaterials []SupplierReturnMaterial
+}
+
+type SupplierReturnMaterial struct {
+	gorm.Model
+	SupplierReturnID    uint
+	ReceiptMaterialID   *uint
+	InventoryMaterialID *uint // lot the quantity left from, stock returns only
+	MaterialID          uint
+	Material            *Material
+	Quantity            int64
+	Reason              string
+	Serials             []string `gorm:"serializer:json"`
+}
+
+const (
+	SupplierReturnSource_Inspection = "inspection"
+	SupplierReturnSource_Stock      = "stock"
+)
@@ -0,0 +1,73 @@
+package models
+
+// Tables returns a model of every table, in the order migrate creates them.
+func Tables() []interface{} {
+	return []interface{}{
+		// relation tables
+		&InventoryMaterial{},
+		&InventoryMaterialTransaction{},
+		&SumMaterialInventory{},
+		&ReceiptMaterial{},
+		&OrderBOM{},
+		&OrderReserving{},
+		&WithdrawalApprovement{},
+		&WithdrawalTransaction{},
+		&WithdrawalAdminTransaction{},
+		&Withdrawal{},
+		&PurchaseSuggestion{},
+		&PurchaseMaterial{},
+
+		// // main tables
+		&Order{},
+		&Material{},
+		&AppLog{},
+		&BOM{},
+		&Category{},
+		&Drawing{},
+		&Inventory{},
+		&InventoryLocation{},
+		&LocationMove{},
+		&Project{},
+		&PurchasePORefs{},
+		&Purchase{},
+		&PORef{},
+		&Slugger{},
+		&Receipt{},
+		&User{},
+		&Notification{},
+		&Adjustment{},
+		&TransferMaterial{},
+		&ProjectStore{},
+		&ProjectStoreMaterial{},
+		&ProjectStoreTransaction{},
+		&ScanSession{},
+		&ScanLine{},
+		&ScanMismatch{},
+		&MaterialSerial{},
+		&MaterialLoan{},
+		&MaterialLoanLot{},
+		&MaterialLoanCheckIn{},
+		&SupplierReturn{},
+		&SupplierReturnMaterial{},
+		&ApprovalRule{},
+		&ApprovalRuleStep{},
+		&ApprovalRequest{},
+		&ApprovalHistory{},
+		&TransferOrder{},
+		&TransferOrderLine{},
+		&TransferOrderLot{},
+		&Role{},
+		&RolePermission{},
+		&RoleSeededPermission{},
+		&ProjectMember{},
+		&Session{},
+		&APIKey{},
+		&APIKeyScope{},
+		&AuditLog{},
+
+		// extend tables
+		&ExtendOrderBOM{},
+		&ExtendOrder{},
+		&ExtendOrderReserving{},
+	}
+}
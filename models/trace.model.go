@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// TraceReport is the lineage of lots between receipts and projects.
+type TraceReport struct {
+	Receipts []TraceReceipt
+	Lots     []TraceLot
+	Projects []TraceProject
+}
+
+type TraceReceipt struct {
+	ReceiptID   uint
+	Slug        string
+	PORefNumber string
+	Inventory   string
+	IsApproved  bool
+	Suppliers   []string
+	CreatedAt   time.Time
+}
+
+// TraceLot is one InventoryMaterial lot with everything that happened to it.
+type TraceLot struct {
+	InventoryMaterialID   uint
+	LotNumber             string
+	InventoryMaterialType string
+	MaterialID            uint
+	MaterialSlug          string
+	MaterialTitle         string
+	Supplier              string
+	InventoryID           uint
+	Inventory             string
+	ReceiptID             *uint
+	ReceiptSlug           string
+	PORefNumber           string
+	Quantity              int64
+	AvailableQty          int64
+	Reserve               int64
+	Withdrawed            int64
+	TransferMaterialID    *uint
+	TransferOrderID       *uint
+	TransferredFrom       string
+	Reservations          []TraceReservation
+	Withdrawals           []TraceWithdrawal
+	ProjectStores         []TraceProjectStore
+}
+
+type TraceReservation struct {
+	Type        string // TraceReservationType_Order, TraceReservationType_ExtendOrder
+	OrderID     uint
+	OrderSlug   string
+	ProjectSlug string
+	Quantity    int64
+	Status      string
+}
+
+type TraceWithdrawal struct {
+	WithdrawalID   uint
+	WithdrawalSlug string
+	ProjectSlug    string
+	Quantity       int64
+	CreatedAt      time.Time
+}
+
+type TraceProjectStore struct {
+	ProjectStoreID   uint
+	ProjectStoreSlug string
+	ProjectSlug      string
+	Quantity         int64
+	ConsumedQty      int64
+	ReturnedQty      int64
+	AvailableQty     int64
+}
+
+type TraceProject struct {
+	ProjectID uint
+	Slug      string
+	Title     string
+}
+
+const (
+	TraceReservationType_Order       = "order"
+	TraceReservationType_ExtendOrder = "extend-order"
+)
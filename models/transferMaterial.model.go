@@ -2,6 +2,8 @@ package models
 
 import "gorm.io/gorm"
 
+// TransferMaterial is a transfer of the one step flow that transfer orders replaced, it is kept
+// as the history of the lots it created. The migrator turns pending ones into transfer orders.
 type TransferMaterial struct {
 	gorm.Model
 	Notes           string
@@ -14,4 +16,6 @@ type TransferMaterial struct {
 	Material        *Material  `gorm:"foreignKey:MaterialID"`
 	CreatedByID     uint       `gorm:"not null"`
 	CreatedBy       Member     `gorm:"foreignkey:CreatedByID"`
+	Status          string     `gorm:"default:approved"` // DocumentStatus_*
+	Serials         []string   `gorm:"serializer:json"`  // serial tracked materials only
 }
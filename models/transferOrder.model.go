@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransferOrder moves materials between inventories in two steps, shipped stock waits
+// in the in-transit inventory until the receiving inventory takes it in. Direct orders
+// ship and receive all lines at once when they are approved.
+type TransferOrder struct {
+	gorm.Model
+	Slug            string `gorm:"unique"`
+	IsDirect        bool
+	FromInventoryID uint       `gorm:"not null"`
+	FromInventory   *Inventory `gorm:"foreignKey:FromInventoryID"`
+	ToInventoryID   uint       `gorm:"not null"`
+	ToInventory     *Inventory `gorm:"foreignKey:ToInventoryID"`
+	Status          string
+	Notes           string
+	CreatedByID     uint   `gorm:"not null"`
+	CreatedBy       Member `gorm:"foreignkey:CreatedByID"`
+	ShippedByID     *uint
+	ShippedBy       *Member `gorm:"foreignkey:ShippedByID"`
+	ShippedAt       *time.Time
+	ClosedAt        *time.Time
+	Lines           []TransferOrderLine
+}
+
+type TransferOrderLine struct {
+	gorm.Model
+	TransferOrderID   uint `gorm:"not null"`
+	MaterialID        uint `gorm:"not null"`
+	Material          *Material
+	Quantity          int64
+	ShippedQty        int64
+	ReceivedQty       int64
+	DiscrepancyQty    int64 // shipped but never received
+	DiscrepancyReason string
+	Serials           []string `gorm:"serializer:json"` // serial tracked materials only
+	Lots              []TransferOrderLot
+}
+
+// TransferOrderLot is the quantity a line took from one source lot into its in-transit lot.
+type TransferOrderLot struct {
+	gorm.Model
+	TransferOrderLineID uint `gorm:"not null"`
+	SourceLotID         uint
+	TransitLotID        uint
+	TransitLot          *InventoryMaterial `gorm:"foreignKey:TransitLotID"`
+	Quantity            int64
+	ReceivedQty         int64
+	DiscrepancyQty      int64
+}
+
+// Outstanding is the quantity still in transit.
+func (l *TransferOrderLot) Outstanding() int64 {
+	return l.Quantity - l.ReceivedQty - l.DiscrepancyQty
+}
+
+// Outstanding is the quantity of the line still in transit.
+func (l *TransferOrderLine) Outstanding() int64 {
+	return l.ShippedQty - l.ReceivedQty - l.DiscrepancyQty
+}
+
+const (
+	TransferOrderStatus_Pending           = "pending" // waits for approval
+	TransferOrderStatus_Rejected          = "rejected"
+	TransferOrderStatus_Open              = "open"
+	TransferOrderStatus_Shipped           = "shipped"
+	TransferOrderStatus_PartiallyReceived = "partially-received"
+	TransferOrderStatus_Received          = "received"
+	TransferOrderStatus_Closed            = "closed" // closed with a discrepancy
+)
+
+// What happens to quantity that never arrives when a transfer order is closed.
+const (
+	TransferDiscrepancy_Lost   = "lost"
+	TransferDiscrepancy_Return = "return"
+)
+
+// InTransitInventorySlug is the slug of the virtual inventory holding shipped transfers.
+const InTransitInventorySlug = "IN-TRANSIT"
+
+func (TransferOrder) GenerateSlug() Slugger {
+	return Slugger{
+		TableName: "transfer_orders",
+		Prefix:    "TRF-",
+		Pad:       7,
+		Value:     0,
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
@@ -11,6 +15,23 @@ type User struct {
 	Role      string `form:"Role"`
 	Tel       string `form:"Tel"`
 	ImagePath string
+
+	FailedLogins       int
+	LockedUntil        *time.Time
+	MustChangePassword bool // set by an admin reset, other routes refuse until it is changed
+	PasswordChangedAt  *time.Time
+
+	AuthProvider string // identity provider of the account, empty for a local one
+}
+
+// IsLocal reports whether the account signs in with a password kept in daijai.
+func (u *User) IsLocal() bool {
+	return u.AuthProvider == "" || u.AuthProvider == "local"
+}
+
+// IsLocked reports whether too many failed logins locked the account.
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
 }
 
 type Member struct {
@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Withdrawal struct {
 	gorm.Model
@@ -14,14 +18,20 @@ type Withdrawal struct {
 	CreatedBy              *Member `gorm:"foreignkey:CreatedByID"`
 	WithdrawalStatus       string  `gorm:"default:'pending'"`
 	WithdrawalApprovements *[]WithdrawalApprovement
+	ReversedAt             *time.Time
+	ReversedByID           *uint
+	ReversedBy             *Member `gorm:"foreignkey:ReversedByID"`
+	ReverseReason          string
 }
 
 const (
 	WithdrawalStatus_InProgress = "in-progress"
 	WithdrawalStatus_Done       = "done"
+	WithdrawalStatus_Reversed   = "reversed"
 )
 
 type WithdrawalMaterial struct {
 	MaterialID uint
 	Quantity   int64
+	Serials    []string // serial tracked materials only
 }
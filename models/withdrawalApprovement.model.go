@@ -5,7 +5,7 @@ import "gorm.io/gorm"
 type WithdrawalApprovement struct {
 	gorm.Model
 	WithdrawalID                uint
-	WithdrawalApprovementStatus string `gorm:"default:'pending'"` // "pending", "approved", "rejected"
+	WithdrawalApprovementStatus string `gorm:"default:'pending'"` // "pending", "approved", "rejected", "reversed"
 	Withdrawal                  *Withdrawal
 	ApprovedByID                *uint
 	ApprovedBy                  *Member `gorm:"foreignkey:ApprovedByID"`
@@ -19,4 +19,5 @@ const (
 	WithdrawalApprovementStatus_Pending  = "pending"
 	WithdrawalApprovementStatus_Approved = "approved"
 	WithdrawalApprovementStatus_Rejected = "rejected"
+	WithdrawalApprovementStatus_Reversed = "reversed"
 )
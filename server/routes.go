@@ -3,7 +3,6 @@ package server
 import (
 	"daijai/controllers"
 	"daijai/middlewares"
-	"daijai/models"
 	"net/http"
 
 	"github.com/gin-contrib/cors"
@@ -22,6 +21,8 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		ExposeHeaders:    []string{"Content-Length", "*"},
 		AllowCredentials: true,
 	}))
+	// every route needs a policy in middlewares.RoutePolicies
+	router.Use(middlewares.Authorize(db))
 
 	//Routes for healthcheck of api server
 	healthcheck := router.Group("health")
@@ -66,6 +67,10 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		projectStore.GET("/:id", projectStoreController.GetProjectStoreByID)
 		projectStore.PUT("/:id", projectStoreController.UpdateProjectStore)
 		projectStore.DELETE("/:id", projectStoreController.DeleteProjectStore)
+		projectStore.GET("/:id/materials", projectStoreController.GetProjectStoreMaterials)
+		projectStore.GET("/:id/transactions", projectStoreController.GetProjectStoreTransactions)
+		projectStore.POST("/:id/consume", projectStoreController.ConsumeProjectStoreMaterial)
+		projectStore.POST("/:id/return", projectStoreController.ReturnProjectStoreMaterial)
 	}
 
 	inventories := router.Group("inventories")
@@ -78,6 +83,18 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		inventories.DELETE("/:id", inventoryController.DeleteInventory)
 		inventories.POST("/transfer", inventoryController.TransferMaterial)
 		inventories.POST("/transfer/calculateCost", inventoryController.CalculateCostOfTransferMaterial)
+		inventories.GET("/expiring", inventoryController.GetExpiringMaterials)
+		inventories.PUT("/writeoff/:id", inventoryController.WriteOffExpiredMaterial)
+
+		locationController := controllers.NewInventoryLocationController(db)
+		inventories.POST("/locations", locationController.CreateLocation)
+		inventories.GET("/:slug/locations", locationController.GetLocations)
+		inventories.GET("/:slug/unassigned", locationController.GetUnassignedMaterials)
+		inventories.GET("/locations/:id/materials", locationController.GetLocationMaterials)
+		inventories.PUT("/locations/:id", locationController.UpdateLocation)
+		inventories.DELETE("/locations/:id", locationController.DeleteLocation)
+		inventories.PUT("/putaway/:id", locationController.PutAway)
+		inventories.POST("/locations/move", locationController.MoveMaterial)
 		// inventories.GET("/:id/transactions/:material_id", inventoryController.GetInventoryTransaction)
 		// inventories.GET("/transactions", inventoryController.GetAllInventoryTransactions)
 	}
@@ -107,10 +124,94 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		materials.GET("/:slug", materialController.GetMaterialBySlug)
 		materials.PUT("/:id", materialController.UpdateMaterial)
 		materials.PUT("/adjust/:id", materialController.AdjustMaterialQuantity)
+		materials.GET("/adjustments", materialController.GetAdjustments)
+		materials.PUT("/adjust/approve/:id", materialController.ApproveAdjustment)
 		materials.DELETE("/:id", materialController.DeleteMaterial)
 		materials.GET("/search", materialController.SearchMaterials)
 	}
 
+	approvals := router.Group("approvals")
+	{
+		approvalController := controllers.NewApprovalController(db)
+		approvals.GET("/rules", approvalController.GetRules)
+		approvals.POST("/rules", approvalController.CreateRule)
+		approvals.PUT("/rules/:id", approvalController.UpdateRule)
+		approvals.DELETE("/rules/:id", approvalController.DeleteRule)
+		approvals.GET("/pending", approvalController.GetMyPendingApprovals)
+		approvals.GET("/documents/:type/:id", approvalController.GetDocumentApprovals)
+		approvals.PUT("/documents/:type/:id/reject", approvalController.RejectDocument)
+	}
+
+	supplierReturns := router.Group("supplier-returns")
+	{
+		supplierReturnController := controllers.NewSupplierReturnController(db)
+		supplierReturns.POST("", supplierReturnController.CreateSupplierReturn)
+		supplierReturns.GET("", supplierReturnController.GetSupplierReturns)
+		supplierReturns.GET("/:slug", supplierReturnController.GetSupplierReturnBySlug)
+	}
+
+	transferOrders := router.Group("transfer-orders")
+	{
+		transferOrderController := controllers.NewTransferOrderController(db)
+		transferOrders.POST("", transferOrderController.CreateTransferOrder)
+		transferOrders.GET("", transferOrderController.GetTransferOrders)
+		transferOrders.POST("/bulk", transferOrderController.CreateBulkTransferOrder)
+		transferOrders.GET("/:id", transferOrderController.GetTransferOrderByID)
+		transferOrders.PUT("/approve/:id", transferOrderController.ApproveTransferOrder)
+		transferOrders.PUT("/ship/:id", transferOrderController.ShipTransferOrder)
+		transferOrders.PUT("/receive/:id", transferOrderController.ReceiveTransferOrder)
+		transferOrders.PUT("/close/:id", transferOrderController.CloseTransferOrder)
+	}
+
+	loans := router.Group("loans")
+	{
+		loanController := controllers.NewMaterialLoanController(db)
+		loans.POST("", loanController.CheckOut)
+		loans.GET("", loanController.GetLoans)
+		loans.GET("/me", loanController.GetMyLoans)
+		loans.GET("/user/:id", loanController.GetUserLoans)
+		loans.GET("/overdue", loanController.GetOverdueLoans)
+		loans.POST("/overdue/notify", loanController.NotifyOverdueLoans)
+		loans.PUT("/checkin/:id", loanController.CheckIn)
+	}
+
+	serials := router.Group("serials")
+	{
+		serialController := controllers.NewMaterialSerialController(db)
+		serials.GET("", serialController.GetSerials)
+		serials.GET("/material/:slug", serialController.GetMaterialSerials)
+		serials.GET("/lookup/:serial", serialController.LookupSerial)
+	}
+
+	labels := router.Group("labels")
+	{
+		labelController := controllers.NewLabelController(db)
+		labels.GET("/materials", labelController.GetMaterialLabels)
+		labels.GET("/receipts/:slug", labelController.GetReceiptLabels)
+		labels.GET("/lots", labelController.GetLotLabels)
+		labels.GET("/lookup", labelController.Lookup)
+	}
+
+	scan := router.Group("scan")
+	{
+		scanController := controllers.NewScanController(db)
+		scan.POST("/sessions", scanController.StartSession)
+		scan.GET("/sessions/:id", scanController.GetSession)
+		scan.POST("/sessions/:id/scan", scanController.Scan)
+		scan.PUT("/sessions/:id/confirm", scanController.ConfirmSession)
+		scan.PUT("/sessions/:id/cancel", scanController.CancelSession)
+		scan.GET("/mismatches", scanController.GetMismatches)
+	}
+
+	trace := router.Group("trace")
+	{
+		traceController := controllers.NewTraceController(db)
+		trace.GET("/receipts/:slug", traceController.TraceReceipt)
+		trace.GET("/po/:poNumber", traceController.TracePO)
+		// withdrawal slugs like "Bill 001/0001" contain "/"
+		trace.GET("/withdrawals/*slug", traceController.TraceWithdrawal)
+	}
+
 	drawings := router.Group("drawings")
 	{
 		drawingCtrl := controllers.NewDrawingController(db)
@@ -128,6 +229,7 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		orders.POST("", ctrl.CreateOrder)
 		orders.GET("", ctrl.GetOrders)
 		orders.GET("/:slug", ctrl.GetOrderBySlug)
+		orders.GET("/:slug/:format", ctrl.GetOrderDocument)
 		orders.GET("/info/:slug", ctrl.GetOrderInfo)
 		orders.GET("/new/info", ctrl.GetNewOrderInfo)
 
@@ -148,18 +250,21 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		withdrawals.GET("/new/info", withdrawCtrl.GetNewWithdrawInfo)
 		withdrawals.POST("admin", withdrawCtrl.CreateWithdrawalAdmin)
 		withdrawals.POST("", withdrawCtrl.CreateWithdrawal)
-		withdrawals.POST("/admin/nonspec/withdraw",
-			middlewares.AuthMiddleware(models.ROLE_Admin, models.ROLE_Manager),
-			withdrawCtrl.CreateNonSpecificOrderWithdrawal)
+		withdrawals.POST("/admin/nonspec/withdraw", withdrawCtrl.CreateNonSpecificOrderWithdrawal)
 		withdrawals.POST("/partial", withdrawCtrl.CreatePartialWithdrawal)
 		withdrawals.GET("", withdrawCtrl.GetAllWithdrawals)
 		withdrawals.PUT("/:id", withdrawCtrl.UpdateWithdrawal)
 		withdrawals.GET("/:slug", withdrawCtrl.GetWithdrawalBySlug)
+		// withdrawal slugs like "Bill 001/0001" contain "/", the catch-all takes the rest of them
+		withdrawals.GET("/:slug/*format", withdrawCtrl.GetWithdrawalDocument)
+		withdrawals.GET("/picklist/:id", withdrawCtrl.GetPickList)
+		withdrawals.GET("/picklist/:id/:format", withdrawCtrl.GetPickListDocument)
+		withdrawals.GET("/issueslip/:id/:format", withdrawCtrl.GetIssueSlipDocument)
+		withdrawals.PUT("/serials/:id", withdrawCtrl.AssignWithdrawalSerials)
+		withdrawals.PUT("/reverse/:id", withdrawCtrl.ReverseWithdrawal)
 		withdrawals.DELETE("/:id", withdrawCtrl.DeleteWithdraw)
-		withdrawals.GET("/new/admin/info", middlewares.AuthMiddleware(models.ROLE_Admin), withdrawCtrl.GetNewWithdrawAdminInfo)
-		withdrawals.PUT("/approve/:id",
-			middlewares.AuthMiddleware(models.ROLE_Admin, models.ROLE_Manager),
-			withdrawCtrl.ApproveWithdrawal)
+		withdrawals.GET("/new/admin/info", withdrawCtrl.GetNewWithdrawAdminInfo)
+		withdrawals.PUT("/approve/:id", withdrawCtrl.ApproveWithdrawal)
 	}
 
 	pr := router.Group("pr")
@@ -169,6 +274,7 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		pr.GET("", ctrl.GetAllPurchaseRequisition)
 		pr.GET("/new/info", ctrl.GetNewPRInfo)
 		pr.GET("/:slug", ctrl.GetPurchaseRequisition)
+		pr.GET("/:slug/:format", ctrl.GetPurchaseRequisitionDocument)
 		pr.PUT("/:id", ctrl.UpdatePurchaseRequisition)
 		pr.PUT("/approve/:slug", ctrl.ApprovePurchaseRequisition)
 		pr.DELETE("/:id", ctrl.DeletePurchaseRequisition)
@@ -176,7 +282,6 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 
 	projects := router.Group("projects")
 	{
-		// projects.Use(middlewares.AuthMiddleware("technician", "admin", "user"))
 		ctrl := controllers.NewProjectController(db)
 		projects.POST("", ctrl.CreateProject)
 		projects.GET("", ctrl.GetAllProjects)
@@ -184,6 +289,9 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		projects.GET("/detail/:slug", ctrl.GetProjectDetailBySlug)
 		projects.PUT("/:id", ctrl.UpdateProject)
 		projects.DELETE("/:id", ctrl.DeleteProject)
+		projects.GET("/:id/members", ctrl.GetProjectMembers)
+		projects.POST("/:id/members", ctrl.AddProjectMember)
+		projects.DELETE("/:id/members/:userId", ctrl.RemoveProjectMember)
 	}
 
 	receipts := router.Group("receipts")
@@ -194,9 +302,12 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		receipts.GET("/new/info", ctrl.GetNewReceiptInfo)
 		receipts.GET("/edit/info/:slug", ctrl.GetEditReceiptInfo)
 		receipts.GET("/details/:slug", ctrl.GetReceiptBySlug)
+		receipts.GET("/:slug/:format", ctrl.GetReceiptDocument)
 		receipts.PUT("/:slug", ctrl.UpdateReceipt)
 		receipts.DELETE("/:id", ctrl.DeleteReceipt)
 		receipts.PUT("/approve/:id", ctrl.ApproveReceipt)
+		receipts.PUT("/inspect/:id", ctrl.InspectReceipt)
+		receipts.PUT("/reverse/:id", ctrl.ReverseReceipt)
 	}
 
 	users := router.Group("users")
@@ -204,10 +315,15 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		userCtrl := controllers.NewUser(db)
 		users.POST("", userCtrl.CreateUser)
 		users.PUT("/reset/:id", userCtrl.ResetPassword)
+		users.PUT("/:id/unlock", userCtrl.UnlockUser)
 		users.GET("", userCtrl.GetAllUsers)
 		users.GET("/:id", userCtrl.GetUser)
 		users.PUT("/:id", userCtrl.UpdateUser)
 		users.DELETE("/:id", userCtrl.DeleteUser)
+
+		sessionCtrl := controllers.NewSessionController(db)
+		users.GET("/:id/sessions", sessionCtrl.GetUserSessions)
+		users.PUT("/:id/logout", sessionCtrl.ForceLogout)
 	}
 
 	auth := router.Group("auth")
@@ -215,8 +331,45 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		authCtrl := controllers.NewAuth(db)
 		auth.POST("/register", authCtrl.Register)
 		auth.POST("/login", authCtrl.Login)
+		auth.GET("/providers", authCtrl.GetProviders)
+		auth.GET("/oidc/login", authCtrl.OIDCLogin)
+		auth.GET("/oidc/callback", authCtrl.OIDCCallback)
+		auth.POST("/refresh", authCtrl.Refresh)
 		auth.POST("/logout", authCtrl.Logout)
 		auth.GET("/session", authCtrl.Session)
+		auth.GET("/permissions", authCtrl.GetPermissions)
+		auth.PUT("/password", authCtrl.ChangePassword)
+
+		sessionCtrl := controllers.NewSessionController(db)
+		auth.GET("/sessions", sessionCtrl.GetMySessions)
+		auth.DELETE("/sessions/:id", sessionCtrl.RevokeMySession)
+	}
+
+	roles := router.Group("roles")
+	{
+		roleCtrl := controllers.NewRoleController(db)
+		roles.GET("", roleCtrl.GetRoles)
+		roles.GET("/permissions", roleCtrl.GetPermissionCatalog)
+		roles.GET("/:id", roleCtrl.GetRole)
+		roles.POST("", roleCtrl.CreateRole)
+		roles.PUT("/:id", roleCtrl.UpdateRole)
+		roles.DELETE("/:id", roleCtrl.DeleteRole)
+	}
+
+	apiKeys := router.Group("api-keys")
+	{
+		apiKeyCtrl := controllers.NewAPIKeyController(db)
+		apiKeys.GET("", apiKeyCtrl.GetAPIKeys)
+		apiKeys.GET("/:id", apiKeyCtrl.GetAPIKey)
+		apiKeys.POST("", apiKeyCtrl.CreateAPIKey)
+		apiKeys.PUT("/:id", apiKeyCtrl.UpdateAPIKey)
+		apiKeys.DELETE("/:id", apiKeyCtrl.RevokeAPIKey)
+	}
+
+	auditLogs := router.Group("audit")
+	{
+		auditCtrl := controllers.NewAuditController(db)
+		auditLogs.GET("/:entity/:id", auditCtrl.GetEntityAudit)
 	}
 
 	slugs := router.Group("slugs")
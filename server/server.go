@@ -1,13 +1,24 @@
 package server
 
 import (
+	"daijai/audit"
 	"daijai/config"
+	"daijai/controllers"
+	"daijai/documents"
 	"log"
 	"os"
+	"time"
 )
 
 func Init() {
 	db := config.GetDB()
+	if err := audit.Register(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := documents.CheckFont(); err != nil {
+		log.Println("Warning: PDF documents and labels cannot be rendered:", err)
+	}
+	controllers.NewMaterialLoanController(db).WatchOverdueLoans(time.Hour)
 	r := SetupRouter(db)
 	// config := config.GetConfig()
 	// serverAddress := config.GetString("server.port")
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"daijai/middlewares"
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type createdAPIKey struct {
+	Key    string        `json:"key"`
+	APIKey models.APIKey `json:"apiKey"`
+}
+
+// createAPIKey issues a key for the user through the API and returns the answer with its status.
+func (s *testServer) createAPIKey(accessToken string, user models.User, scopes ...middlewares.Permission) (createdAPIKey, int) {
+	var out createdAPIKey
+	body := map[string]interface{}{"Name": "erp sync", "UserID": user.ID, "Scopes": scopes}
+	return out, s.do(http.MethodPost, "/api-keys", accessToken, body, &out)
+}
+
+// withAPIKeyHeader calls the path with the key in the X-API-Key header.
+func (s *testServer) withAPIKeyHeader(path, key string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-API-Key", key)
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAPIKeyActsWithinItsScopes(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	created, code := s.createAPIKey(accessToken, admin, middlewares.Permission{Resource: middlewares.Resource_Materials, Action: middlewares.Action_Read})
+	if code != http.StatusCreated {
+		t.Fatalf("create answered %d", code)
+	}
+
+	if code := s.withAPIKeyHeader("/materials", created.Key); code != http.StatusOK {
+		t.Errorf("X-API-Key answered %d", code)
+	}
+	if code := s.do(http.MethodGet, "/materials", created.Key, nil, nil); code != http.StatusOK {
+		t.Errorf("bearer key answered %d", code)
+	}
+	// the admin may read inventories, the key may not
+	if code := s.do(http.MethodGet, "/inventories", created.Key, nil, nil); code != http.StatusForbidden {
+		t.Errorf("route outside the scopes answered %d", code)
+	}
+	if code := s.do(http.MethodGet, "/inventories", accessToken, nil, nil); code != http.StatusOK {
+		t.Errorf("admin reading inventories answered %d", code)
+	}
+}
+
+func TestRevokedOrExpiredAPIKeyIsRefused(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	scope := middlewares.Permission{Resource: middlewares.Resource_Materials, Action: middlewares.Action_Read}
+
+	revoked, _ := s.createAPIKey(accessToken, admin, scope)
+	if code := s.do(http.MethodDelete, fmt.Sprintf("/api-keys/%d", revoked.APIKey.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("revoke answered %d", code)
+	}
+	if code := s.do(http.MethodGet, "/materials", revoked.Key, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("revoked key answered %d", code)
+	}
+	if code := s.withAPIKeyHeader("/materials", revoked.Key); code != http.StatusUnauthorized {
+		t.Errorf("revoked key in X-API-Key answered %d", code)
+	}
+
+	expired, _ := s.createAPIKey(accessToken, admin, scope)
+	s.DB.Model(&models.APIKey{}).Where("id = ?", expired.APIKey.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if code := s.do(http.MethodGet, "/materials", expired.Key, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("expired key answered %d", code)
+	}
+	if code := s.do(http.MethodGet, "/materials", "dj_unknown", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("unknown key answered %d", code)
+	}
+}
+
+func TestAPIKeyScopeNeedsTheUsersRole(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	tech, _ := s.signIn(models.ROLE_Tech)
+
+	if _, code := s.createAPIKey(accessToken, tech, middlewares.Permission{Resource: middlewares.Resource_APIKeys, Action: middlewares.Action_Read}); code != http.StatusBadRequest {
+		t.Errorf("scope the role lacks answered %d", code)
+	}
+	var count int64
+	s.DB.Model(&models.APIKey{}).Where("user_id = ?", tech.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("refused key was saved")
+	}
+	if _, code := s.createAPIKey(accessToken, tech, middlewares.Permission{Resource: middlewares.Resource_Materials, Action: middlewares.Action_Read}); code != http.StatusCreated {
+		t.Errorf("scope the role has answered %d", code)
+	}
+}
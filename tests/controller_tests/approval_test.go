@@ -0,0 +1,271 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func amount(v int64) *int64 { return &v }
+
+func TestApprovalRuleMatching(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, bolt, _ := s.stock(0)
+	_, nut, _ := s.stock(0)
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+
+	step := func() []models.ApprovalRuleStep {
+		return []models.ApprovalRuleStep{{Sequence: 1, Name: "admin", Role: models.ROLE_Admin}}
+	}
+	receipt := models.ApprovalDocumentType_Receipt
+	for _, rule := range []models.ApprovalRule{
+		{Name: "small", DocumentType: receipt, MaxAmount: amount(5000), Steps: step()},
+		{Name: "big", DocumentType: receipt, Priority: 1, MinAmount: amount(5000), Steps: step()},
+		{Name: "bolts", DocumentType: receipt, Priority: 2, CategoryID: &bolt.CategoryID, Steps: step()},
+		{Name: "project", DocumentType: receipt, Priority: 8, ProjectID: &project.ID, Steps: step()},
+		{Name: "disabled", DocumentType: receipt, Priority: 9, IsDisabled: true, Steps: step()},
+		{Name: "no steps", DocumentType: receipt, Priority: 10},
+		{Name: "purchases", DocumentType: models.ApprovalDocumentType_Purchase, Priority: 10, Steps: step()},
+	} {
+		rule := rule
+		s.create(&rule)
+	}
+
+	cases := []struct {
+		name     string
+		material models.Material
+		quantity int64 // amount of the receipt at a price of 100
+		rule     string
+	}{
+		{"category beats amount", bolt, 100, "bolts"},
+		{"below max amount", nut, 100, "small"},
+		{"min amount is inclusive", nut, 5000, "big"},
+		{"above min amount", nut, 6000, "big"},
+	}
+	for _, tc := range cases {
+		doc := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: tc.material.ID, Quantity: tc.quantity, Price: 100})
+		s.scan(accessToken, models.ScanSessionType_Receipt, doc.Slug)
+		if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", doc.ID), accessToken, nil, nil); code != http.StatusOK {
+			t.Errorf("%s: approve answered %d", tc.name, code)
+			continue
+		}
+		var request models.ApprovalRequest
+		if err := s.DB.Preload("ApprovalRule").Where("document_type = ? AND document_id = ?", receipt, doc.ID).First(&request).Error; err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if request.ApprovalRule == nil || request.ApprovalRule.Name != tc.rule {
+			t.Errorf("%s: matched %+v, want rule %s", tc.name, request.ApprovalRule, tc.rule)
+		}
+		if request.Status != models.ApprovalStatus_Approved {
+			t.Errorf("%s: request is %s", tc.name, request.Status)
+		}
+	}
+}
+
+func TestApprovalChain(t *testing.T) {
+	cases := []struct {
+		name   string
+		steps  []string // role of each step
+		actors []string // who approves, in order
+		status []int    // answer to each approval
+	}{
+		{
+			name:   "steps in sequence",
+			steps:  []string{models.ROLE_Manager, models.ROLE_Admin},
+			actors: []string{"manager", "admin"},
+			status: []int{http.StatusAccepted, http.StatusOK},
+		},
+		{
+			name:   "step of another role",
+			steps:  []string{models.ROLE_Manager, models.ROLE_Admin},
+			actors: []string{"admin", "manager", "manager2", "admin"},
+			status: []int{http.StatusForbidden, http.StatusAccepted, http.StatusForbidden, http.StatusOK},
+		},
+		{
+			name:   "one person per step",
+			steps:  []string{models.ROLE_Admin, models.ROLE_Admin},
+			actors: []string{"admin", "admin", "admin2"},
+			status: []int{http.StatusAccepted, http.StatusBadRequest, http.StatusOK},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			tokens := make(map[string]string)
+			var creator models.User
+			for _, name := range []string{"admin", "admin2", "manager", "manager2"} {
+				role := models.ROLE_Admin
+				if name == "manager" || name == "manager2" {
+					role = models.ROLE_Manager
+				}
+				creator, tokens[name] = s.signIn(role)
+			}
+			rule := models.ApprovalRule{Name: tc.name, DocumentType: models.ApprovalDocumentType_Receipt}
+			for i, role := range tc.steps {
+				rule.Steps = append(rule.Steps, models.ApprovalRuleStep{Sequence: i + 1, Name: role, Role: role})
+			}
+			s.create(&rule)
+			inventory, material, _ := s.stock(0)
+			doc := s.receipt(creator, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 100, Price: 100})
+			s.scan(tokens["admin"], models.ScanSessionType_Receipt, doc.Slug)
+
+			for i, actor := range tc.actors {
+				if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", doc.ID), tokens[actor], nil, nil); code != tc.status[i] {
+					t.Fatalf("approval %d by %s answered %d, want %d", i+1, actor, code, tc.status[i])
+				}
+			}
+
+			var history []models.ApprovalHistory
+			s.DB.Order("id asc").Find(&history)
+			if len(history) != len(tc.steps) {
+				t.Errorf("got %d approvals in the history, want %d", len(history), len(tc.steps))
+			}
+			var requests int64
+			s.DB.Model(&models.ApprovalRequest{}).Count(&requests)
+			if requests != 1 {
+				t.Errorf("got %d approval requests, want 1", requests)
+			}
+			s.DB.First(&doc, doc.ID)
+			if !doc.IsApproved {
+				t.Error("receipt is not approved at the end of the chain")
+			}
+		})
+	}
+}
+
+// documents created before approval requests were opened with them get one at their first approval
+func TestApprovalOfADocumentWithoutARequest(t *testing.T) {
+	s := newTestServer(t)
+	admin, _ := s.signIn(models.ROLE_Admin)
+	_, managerToken := s.signIn(models.ROLE_Manager)
+	s.create(&models.ApprovalRule{
+		Name:         "admins",
+		DocumentType: models.ApprovalDocumentType_Receipt,
+		Steps:        []models.ApprovalRuleStep{{Sequence: 1, Role: models.ROLE_Admin}},
+	})
+	inventory, material, _ := s.stock(0)
+	doc := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 100, Price: 100})
+	s.scan(managerToken, models.ScanSessionType_Receipt, doc.Slug)
+
+	// a refused approval leaves no request behind
+	if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", doc.ID), managerToken, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("approve answered %d", code)
+	}
+	var requests int64
+	s.DB.Model(&models.ApprovalRequest{}).Count(&requests)
+	if requests != 0 {
+		t.Errorf("got %d approval requests for a refused approval", requests)
+	}
+
+	// a document has one pending request at most
+	pending := models.ApprovalRequest{DocumentType: models.ApprovalDocumentType_Receipt, DocumentID: doc.ID, Status: models.ApprovalStatus_Pending}
+	s.create(&pending)
+	twin := pending
+	twin.ID = 0
+	if err := s.DB.Create(&twin).Error; err == nil {
+		t.Error("a second pending request of the document was stored")
+	}
+}
+
+func TestDocumentsWaitForTheirFirstApprover(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	from, material, _ := s.stock(10)
+	to := models.Inventory{Slug: "INV-TO", Title: "Site"}
+	s.create(&to)
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+
+	var receipt struct{ Receipt models.Receipt }
+	var withdrawal struct{ Withdrawal models.Withdrawal }
+	var adjustment struct{ Adjustment models.Adjustment }
+	var order models.TransferOrder
+	cases := []struct {
+		documentType string
+		create       func() uint // creates the document and returns the id approved
+		approve      func(id uint) string
+	}{
+		{
+			models.ApprovalDocumentType_Receipt,
+			func() uint {
+				body := map[string]interface{}{"Slug": "REC-T1", "InventoryID": from.ID, "ReceiptMaterials": []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 100, "Price": 100}}}
+				s.do(http.MethodPost, "/receipts", accessToken, body, &receipt)
+				return receipt.Receipt.ID
+			},
+			func(id uint) string { return fmt.Sprintf("/receipts/approve/%d", id) },
+		},
+		{
+			models.ApprovalDocumentType_Purchase,
+			func() uint {
+				body := map[string]interface{}{"PR": map[string]interface{}{"Slug": "PR-T1", "PurchaseMaterials": []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 100}}}}
+				s.do(http.MethodPost, "/pr", accessToken, body, nil)
+				var purchase models.Purchase
+				s.DB.Where("slug = ?", "PR-T1").First(&purchase)
+				return purchase.ID
+			},
+			func(uint) string { return "/pr/approve/PR-T1" },
+		},
+		{
+			models.ApprovalDocumentType_Adjustment,
+			func() uint {
+				body := map[string]interface{}{"Quantity": 100, "InventoryID": from.ID, "PricePerUnit": 100}
+				s.do(http.MethodPut, fmt.Sprintf("/materials/adjust/%d", material.ID), accessToken, body, &adjustment)
+				return adjustment.Adjustment.ID
+			},
+			func(id uint) string { return fmt.Sprintf("/materials/adjust/approve/%d", id) },
+		},
+		{
+			models.ApprovalDocumentType_TransferOrder,
+			func() uint {
+				body := map[string]interface{}{"FromInventoryID": from.ID, "ToInventoryID": to.ID, "Lines": []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 100}}}
+				s.do(http.MethodPost, "/transfer-orders", accessToken, body, &order)
+				return order.ID
+			},
+			func(id uint) string { return fmt.Sprintf("/transfer-orders/approve/%d", id) },
+		},
+		{
+			models.ApprovalDocumentType_Withdrawal,
+			func() uint {
+				body := map[string]interface{}{"Slug": "WD-T1", "ProjectID": project.ID}
+				s.do(http.MethodPost, "/withdrawals/admin/nonspec/withdraw", accessToken, body, &withdrawal)
+				var wapm models.WithdrawalApprovement
+				s.DB.Where("withdrawal_id = ?", withdrawal.Withdrawal.ID).First(&wapm)
+				return wapm.ID
+			},
+			func(id uint) string { return fmt.Sprintf("/withdrawals/approve/%d", id) },
+		},
+	}
+	for _, tc := range cases {
+		id := tc.create()
+		if id == 0 {
+			t.Errorf("%s: document was not created", tc.documentType)
+			continue
+		}
+		var pending []models.ApprovalRequest
+		s.do(http.MethodGet, "/approvals/pending", accessToken, nil, &pending)
+		found := false
+		for _, r := range pending {
+			found = found || (r.DocumentType == tc.documentType && r.DocumentID == id && r.CurrentStep == 0)
+		}
+		if !found {
+			t.Errorf("%s: document is not in the pending inbox", tc.documentType)
+		}
+
+		reject := fmt.Sprintf("/approvals/documents/%s/%d/reject", tc.documentType, id)
+		if code := s.do(http.MethodPut, reject, accessToken, map[string]string{"Comment": "not needed"}, nil); code != http.StatusOK {
+			t.Errorf("%s: reject answered %d", tc.documentType, code)
+		}
+		if code := s.do(http.MethodPut, tc.approve(id), accessToken, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: approve of a rejected document answered %d", tc.documentType, code)
+		}
+		var requests int64
+		s.DB.Model(&models.ApprovalRequest{}).Where("document_type = ? AND document_id = ?", tc.documentType, id).Count(&requests)
+		if requests != 1 {
+			t.Errorf("%s: got %d approval requests, want the rejected one", tc.documentType, requests)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"daijai/models"
+	"testing"
+)
+
+func TestAuditLogsSerializedColumns(t *testing.T) {
+	s := newTestServer(t)
+	admin, _ := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 200, Price: 100, Serials: []string{"SN-1", "SN-2"}})
+	line := receipt.ReceiptMaterials[0]
+
+	if err := s.DB.Model(&line).Updates(models.ReceiptMaterial{Serials: []string{"SN-1", "SN-3"}}).Error; err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := s.DB.Delete(&line).Error; err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	// a deleted row is not changed by a scoped update
+	if err := s.DB.Model(&models.ReceiptMaterial{}).Where("id = ?", line.ID).Update("price", 200).Error; err != nil {
+		t.Fatalf("update of a deleted row: %v", err)
+	}
+
+	var logs []models.AuditLog
+	s.DB.Where("entity = ? AND entity_id = ?", "receipt_materials", line.ID).Order("id asc").Find(&logs)
+	var actions []string
+	for _, l := range logs {
+		actions = append(actions, l.Action)
+	}
+	want := []string{models.AuditAction_Create, models.AuditAction_Update, models.AuditAction_Delete}
+	if len(actions) != len(want) {
+		t.Fatalf("got actions %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("got actions %v, want %v", actions, want)
+		}
+	}
+	if change, ok := logs[1].Changes["serials"]; !ok || change.To != `["SN-1","SN-3"]` {
+		t.Errorf("serials change is %+v, want the stored JSON", change)
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// page gets a rendered document and returns its status and body.
+func (s *testServer) page(path, accessToken string) (int, string) {
+	s.t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestWithdrawalDocumentOfASlugWithASlash(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+	s.create(&models.Withdrawal{Slug: "Bill 001/0001", ProjectID: project.ID, CreatedByID: admin.ID})
+
+	for _, path := range []string{
+		"/withdrawals/Bill%20001%2F0001/html",
+		"/withdrawals/Bill%20001/0001/html",
+	} {
+		code, body := s.page(path, accessToken)
+		if code != http.StatusOK {
+			t.Errorf("%s: answered %d", path, code)
+			continue
+		}
+		if !strings.Contains(body, "Bill 001/0001") {
+			t.Errorf("%s: document does not show the slug", path)
+		}
+	}
+	if code, _ := s.page("/withdrawals/Bill%20001/0001/doc", accessToken); code != http.StatusBadRequest {
+		t.Errorf("unknown format answered %d", code)
+	}
+	if code, _ := s.page("/withdrawals/Bill%20001/0002/html", accessToken); code != http.StatusNotFound {
+		t.Errorf("unknown withdrawal answered %d", code)
+	}
+}
+
+func TestPickListAndIssueSlipShowTheLots(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, shelved := s.stock(10)
+	location := models.InventoryLocation{InventoryID: inventory.ID, Slug: "A-01-03"}
+	s.create(&location)
+	s.DB.Model(&shelved).Updates(map[string]interface{}{"location_id": location.ID, "reserve": 400, "available_qty": 600})
+	loose := models.InventoryMaterial{InventoryID: inventory.ID, MaterialID: material.ID, Quantity: 500, Reserve: 300, AvailableQty: 200, Price: 100}
+	s.create(&loose)
+
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+	order := models.Order{Slug: "ORD-T1", ProjectID: project.ID, CreatedByID: admin.ID}
+	s.create(&order)
+	bom := models.OrderBOM{OrderID: order.ID, MaterialID: material.ID, TargetQty: 700, ReservedQty: 700, IsFullFilled: true}
+	s.create(&bom)
+	for _, r := range []struct {
+		lot      models.InventoryMaterial
+		quantity int64
+	}{{shelved, 400}, {loose, 300}} {
+		s.create(&models.OrderReserving{OrderID: order.ID, OrderBOMID: bom.ID, InventoryMaterialID: r.lot.ID, Status: models.OrderReservingStatus_Reserved, Quantity: r.quantity})
+	}
+	var created struct{ Withdrawal models.Withdrawal }
+	if code := s.do(http.MethodPost, "/withdrawals", accessToken, map[string]interface{}{"Slug": "WD-T1", "OrderID": order.ID, "ProjectID": project.ID}, &created); code != http.StatusCreated {
+		t.Fatalf("create withdrawal answered %d", code)
+	}
+	var approvement models.WithdrawalApprovement
+	s.DB.Where("withdrawal_id = ?", created.Withdrawal.ID).First(&approvement)
+
+	// html documents need no font
+	t.Setenv("DOCUMENT_FONT_PATH", "")
+	want := []string{material.Slug, models.LotNumber(shelved.ID), models.LotNumber(loose.ID), location.Slug}
+	code, body := s.page(fmt.Sprintf("/withdrawals/picklist/%d/html", approvement.ID), accessToken)
+	if code != http.StatusOK {
+		t.Fatalf("pick list answered %d", code)
+	}
+	for _, w := range want {
+		if !strings.Contains(body, w) {
+			t.Errorf("pick list misses %q", w)
+		}
+	}
+
+	slip := fmt.Sprintf("/withdrawals/issueslip/%d/html", approvement.ID)
+	if code, _ := s.page(slip, accessToken); code != http.StatusBadRequest {
+		t.Errorf("issue slip of a pending withdrawal answered %d", code)
+	}
+	if code := s.do(http.MethodPut, fmt.Sprintf("/withdrawals/approve/%d", approvement.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+	code, body = s.page(slip, accessToken)
+	if code != http.StatusOK {
+		t.Fatalf("issue slip answered %d", code)
+	}
+	for _, w := range want {
+		if !strings.Contains(body, w) {
+			t.Errorf("issue slip misses %q", w)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// expire moves the expiry date of a lot into the past.
+func (s *testServer) expire(lot *models.InventoryMaterial) {
+	s.t.Helper()
+	s.DB.Model(lot).Update("expiry_date", time.Now().Add(-24*time.Hour))
+}
+
+func TestExpiredLotsAreNotIssued(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, lot := s.returnable(10)
+	s.expire(&lot)
+	to := models.Inventory{Slug: "INV-TO", Title: "Site"}
+	s.create(&to)
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+	withdraw := func() int {
+		body := map[string]interface{}{
+			"ProjectID":         project.ID,
+			"WithdrawMaterials": []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 100}},
+		}
+		return s.do(http.MethodPost, "/withdrawals/admin", accessToken, body, nil)
+	}
+
+	cases := []struct {
+		name string
+		call func() int
+	}{
+		{"withdrawal", withdraw},
+		{"loan", func() int {
+			body := map[string]interface{}{
+				"MaterialID":  material.ID,
+				"InventoryID": inventory.ID,
+				"Quantity":    100,
+				"BorrowerID":  admin.ID,
+				"DueAt":       time.Now().Add(24 * time.Hour),
+			}
+			return s.do(http.MethodPost, "/loans", accessToken, body, nil)
+		}},
+		{"transfer", func() int {
+			body := map[string]interface{}{
+				"FromInventoryID": inventory.ID,
+				"ToInventoryID":   to.ID,
+				"Lines":           []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 100}},
+			}
+			return s.do(http.MethodPost, "/transfer-orders", accessToken, body, nil)
+		}},
+	}
+	for _, tc := range cases {
+		if code := tc.call(); code != http.StatusBadRequest {
+			t.Errorf("%s from an expired lot answered %d", tc.name, code)
+		}
+	}
+	s.DB.First(&lot, lot.ID)
+	if lot.AvailableQty != 1000 || lot.Withdrawed != 0 {
+		t.Errorf("expired lot available %d withdrawed %d, want it untouched", lot.AvailableQty, lot.Withdrawed)
+	}
+
+	// a fresh lot of the material is issued, the expired one is left
+	fresh := models.InventoryMaterial{InventoryID: inventory.ID, MaterialID: material.ID, Quantity: 500, AvailableQty: 500, Price: 100}
+	s.create(&fresh)
+	if code := withdraw(); code != http.StatusCreated {
+		t.Fatalf("withdrawal from a fresh lot answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	s.DB.First(&fresh, fresh.ID)
+	if lot.AvailableQty != 1000 || fresh.AvailableQty != 400 {
+		t.Errorf("expired lot keeps %d and fresh lot %d, want 1000 and 400", lot.AvailableQty, fresh.AvailableQty)
+	}
+}
+
+func TestWriteOffWaitsForApproval(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, lot := s.stock(10)
+	writeOff := func(lotID uint) (models.Adjustment, int) {
+		var out struct{ Adjustment models.Adjustment }
+		code := s.do(http.MethodPut, fmt.Sprintf("/inventories/writeoff/%d", lotID), accessToken, map[string]string{"Notes": "shelf life"}, &out)
+		return out.Adjustment, code
+	}
+	approve := func(adjustment models.Adjustment) int {
+		return s.do(http.MethodPut, fmt.Sprintf("/materials/adjust/approve/%d", adjustment.ID), accessToken, nil, nil)
+	}
+
+	if _, code := writeOff(lot.ID); code != http.StatusBadRequest {
+		t.Errorf("write-off of a lot that is not expired answered %d", code)
+	}
+	s.expire(&lot)
+	adjustment, code := writeOff(lot.ID)
+	if code != http.StatusCreated {
+		t.Fatalf("write-off answered %d", code)
+	}
+	if adjustment.Status != models.DocumentStatus_Pending || adjustment.Quantity != -1000 {
+		t.Errorf("write-off is a %s adjustment of %d, want a pending one of -1000", adjustment.Status, adjustment.Quantity)
+	}
+	if _, code := writeOff(lot.ID); code != http.StatusBadRequest {
+		t.Errorf("second write-off of the lot answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	if lot.Quantity != 1000 || lot.AvailableQty != 1000 {
+		t.Errorf("lot quantity %d available %d before approval, want 1000", lot.Quantity, lot.AvailableQty)
+	}
+
+	if code := approve(adjustment); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	if lot.Quantity != 0 || lot.AvailableQty != 0 || !lot.IsOutOfStock {
+		t.Errorf("lot quantity %d available %d out of stock %v after approval", lot.Quantity, lot.AvailableQty, lot.IsOutOfStock)
+	}
+	var ledger []models.InventoryMaterialTransaction
+	s.DB.Where("inventory_material_id = ?", lot.ID).Find(&ledger)
+	if len(ledger) != 1 || ledger[0].Quantity != 1000 || ledger[0].InventoryTypeDescription != models.InventoryTypeDescription_EXPIRED_WRITEOFF {
+		t.Errorf("got ledger %+v, want one write-off of 1000", ledger)
+	}
+	var sum models.SumMaterialInventory
+	s.DB.Where("material_id = ? AND inventory_id = ?", material.ID, inventory.ID).First(&sum)
+	if sum.Quantity != 0 {
+		t.Errorf("inventory sum is %d after the write-off", sum.Quantity)
+	}
+
+	// the lot was reserved after the request, the write-off no longer fits it
+	reserved := models.InventoryMaterial{InventoryID: inventory.ID, MaterialID: material.ID, Quantity: 500, AvailableQty: 500, Price: 100}
+	s.create(&reserved)
+	s.expire(&reserved)
+	adjustment, _ = writeOff(reserved.ID)
+	s.DB.Model(&reserved).Updates(map[string]interface{}{"reserve": 200, "available_qty": 300})
+	if code := approve(adjustment); code != http.StatusBadRequest {
+		t.Errorf("approve of a write-off of a reserved lot answered %d", code)
+	}
+	s.DB.First(&reserved, reserved.ID)
+	if reserved.Quantity != 500 {
+		t.Errorf("reserved lot quantity %d, want 500", reserved.Quantity)
+	}
+}
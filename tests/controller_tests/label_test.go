@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLabelsRejectOversizedOrEmptySheets(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	_, _, lot := s.stock(5)
+
+	cases := []struct {
+		name string
+		path string
+	}{
+		{"too many copies", fmt.Sprintf("/labels/lots?ids=%d&copies=101", lot.ID)},
+		{"no copies", fmt.Sprintf("/labels/lots?ids=%d&copies=0", lot.ID)},
+		{"unknown lots", "/labels/lots?ids=999"},
+		{"unknown materials", "/labels/materials?slugs=NOPE"},
+	}
+	for _, tc := range cases {
+		var body map[string]interface{}
+		if code := s.do(http.MethodGet, tc.path, accessToken, nil, &body); code != http.StatusBadRequest {
+			t.Errorf("%s: answered %d, want %d", tc.name, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestPDFsNeedADocumentFont(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, lot := s.stock(5)
+	receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 500, Price: 100})
+
+	for _, font := range []string{"", "/nonexistent/THSarabunNew.ttf"} {
+		t.Setenv("DOCUMENT_FONT_PATH", font)
+		for _, path := range []string{
+			fmt.Sprintf("/labels/lots?ids=%d", lot.ID),
+			fmt.Sprintf("/receipts/%s/pdf", receipt.Slug),
+		} {
+			var body map[string]interface{}
+			if code := s.do(http.MethodGet, path, accessToken, nil, &body); code != http.StatusInternalServerError {
+				t.Errorf("%s with font %q: answered %d, want %d", path, font, code, http.StatusInternalServerError)
+			}
+			if detail, _ := body["detail"].(string); !strings.Contains(detail, "DOCUMENT_FONT_PATH") {
+				t.Errorf("%s with font %q: detail %q does not name the setting", path, font, detail)
+			}
+		}
+	}
+}
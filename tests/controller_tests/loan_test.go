@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// returnable makes a lot of quantity of a material that can be loaned.
+func (s *testServer) returnable(quantity int64) (models.Inventory, models.Material, models.InventoryMaterial) {
+	s.t.Helper()
+	inventory, material, lot := s.stock(quantity)
+	s.DB.Model(&material).Update("is_returnable", true)
+	return inventory, material, lot
+}
+
+func TestLoanCheckOutAndCheckIn(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	borrower, _ := s.signIn(models.ROLE_User)
+	inventory, material, lot := s.returnable(10)
+
+	var loan models.MaterialLoan
+	body := map[string]interface{}{
+		"MaterialID":  material.ID,
+		"InventoryID": inventory.ID,
+		"Quantity":    400,
+		"BorrowerID":  borrower.ID,
+		"DueAt":       time.Now().Add(24 * time.Hour),
+	}
+	if code := s.do(http.MethodPost, "/loans", accessToken, body, &loan); code != http.StatusCreated {
+		t.Fatalf("check out answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	if lot.Withdrawed != 400 || lot.AvailableQty != 600 {
+		t.Errorf("lot withdrawed %d available %d after check out, want 400 and 600", lot.Withdrawed, lot.AvailableQty)
+	}
+
+	checkIn := func(quantity int64) int {
+		body := map[string]interface{}{"Quantity": quantity, "Condition": models.MaterialLoanCondition_Good}
+		return s.do(http.MethodPut, fmt.Sprintf("/loans/checkin/%d", loan.ID), accessToken, body, nil)
+	}
+	if code := checkIn(500); code != http.StatusBadRequest {
+		t.Errorf("check in of more than the loan answered %d", code)
+	}
+	if code := checkIn(100); code != http.StatusOK {
+		t.Fatalf("partial check in answered %d", code)
+	}
+	if code := checkIn(300); code != http.StatusOK {
+		t.Fatalf("final check in answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	s.DB.First(&loan, loan.ID)
+	if lot.Withdrawed != 0 || lot.AvailableQty != 1000 {
+		t.Errorf("lot withdrawed %d available %d after check in, want 0 and 1000", lot.Withdrawed, lot.AvailableQty)
+	}
+	if loan.Status != models.MaterialLoanStatus_Returned || loan.ReturnedQty != 400 {
+		t.Errorf("loan is %s with %d returned", loan.Status, loan.ReturnedQty)
+	}
+}
+
+func TestCheckOutAnswers(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	borrower, _ := s.signIn(models.ROLE_User)
+	inventory, material, _ := s.returnable(10)
+	checkOut := func(quantity int64) int {
+		body := map[string]interface{}{
+			"MaterialID":  material.ID,
+			"InventoryID": inventory.ID,
+			"Quantity":    quantity,
+			"BorrowerID":  borrower.ID,
+			"DueAt":       time.Now().Add(24 * time.Hour),
+		}
+		return s.do(http.MethodPost, "/loans", accessToken, body, nil)
+	}
+
+	if code := checkOut(1100); code != http.StatusBadRequest {
+		t.Errorf("check out of more than the stock answered %d, want %d", code, http.StatusBadRequest)
+	}
+	// a failing database is not the fault of the request
+	if err := s.DB.Migrator().DropTable(&models.MaterialLoanLot{}); err != nil {
+		t.Fatal(err)
+	}
+	if code := checkOut(100); code != http.StatusInternalServerError {
+		t.Errorf("check out on a failing database answered %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+func TestOverdueLoansAreNotifiedOnceADay(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.returnable(10)
+	for _, due := range []time.Duration{-time.Hour, time.Hour} {
+		s.create(&models.MaterialLoan{
+			MaterialID:     material.ID,
+			InventoryID:    inventory.ID,
+			Quantity:       100,
+			Status:         models.MaterialLoanStatus_Out,
+			DueAt:          time.Now().Add(due),
+			BorrowerID:     admin.ID,
+			CheckedOutByID: admin.ID,
+		})
+	}
+
+	for i, want := range []float64{1, 0} {
+		var body map[string]interface{}
+		if code := s.do(http.MethodPost, "/loans/overdue/notify", accessToken, nil, &body); code != http.StatusOK {
+			t.Fatalf("notify %d answered %d", i+1, code)
+		}
+		if body["count"] != want {
+			t.Errorf("notify %d notified %v loans, want %v", i+1, body["count"], want)
+		}
+	}
+	var notifications int64
+	s.DB.Model(&models.Notification{}).Where("action = ?", models.NotificationAction_OVERDUE_LOAN).Count(&notifications)
+	if notifications != 2 {
+		t.Errorf("got %d notifications, want one to the borrower and one to admins", notifications)
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"daijai/models"
+	"net/http"
+	"testing"
+)
+
+func TestMoveMaterialMovesOnlyAvailableQuantity(t *testing.T) {
+	cases := []struct {
+		name       string
+		reserve    int64
+		withdrawed int64
+		quantity   int64 // requested, 0 moves all available quantity
+		status     int
+		moved      int64
+		split      bool
+	}{
+		{"whole untouched lot", 0, 0, 0, http.StatusOK, 1000, false},
+		{"all of a reserved lot", 300, 0, 0, http.StatusOK, 700, true},
+		{"all of a partly withdrawn lot", 0, 200, 0, http.StatusOK, 800, true},
+		{"quantity of the lot when part is reserved", 300, 0, 1000, http.StatusBadRequest, 0, false},
+		{"part of a used lot", 300, 200, 400, http.StatusOK, 400, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			_, accessToken := s.signIn(models.ROLE_Admin)
+			inventory, _, lot := s.stock(10)
+			from := models.InventoryLocation{InventoryID: inventory.ID, Slug: "A-01"}
+			s.create(&from)
+			to := models.InventoryLocation{InventoryID: inventory.ID, Slug: "B-01"}
+			s.create(&to)
+			s.DB.Model(&lot).Updates(map[string]interface{}{
+				"location_id":   from.ID,
+				"reserve":       tc.reserve,
+				"withdrawed":    tc.withdrawed,
+				"available_qty": lot.Quantity - tc.reserve - tc.withdrawed,
+			})
+
+			var move models.LocationMove
+			body := map[string]interface{}{"InventoryMaterialID": lot.ID, "ToLocationID": to.ID, "Quantity": tc.quantity}
+			if code := s.do(http.MethodPost, "/inventories/locations/move", accessToken, body, &move); code != tc.status {
+				t.Fatalf("move answered %d, want %d", code, tc.status)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if move.Quantity != tc.moved {
+				t.Errorf("moved %d, want %d", move.Quantity, tc.moved)
+			}
+
+			var source models.InventoryMaterial
+			s.DB.First(&source, lot.ID)
+			if !tc.split {
+				if move.NewInventoryMaterialID != nil || source.LocationID == nil || *source.LocationID != to.ID {
+					t.Errorf("lot was not relocated: %+v", source)
+				}
+				return
+			}
+			if source.LocationID == nil || *source.LocationID != from.ID {
+				t.Errorf("source lot left its bin")
+			}
+			if source.Reserve != tc.reserve || source.Withdrawed != tc.withdrawed {
+				t.Errorf("source lot reserve %d withdrawed %d, want %d and %d", source.Reserve, source.Withdrawed, tc.reserve, tc.withdrawed)
+			}
+			if source.Quantity != lot.Quantity-tc.moved || source.AvailableQty != source.Quantity-source.Reserve-source.Withdrawed {
+				t.Errorf("source lot is inconsistent: quantity %d available %d", source.Quantity, source.AvailableQty)
+			}
+			var split models.InventoryMaterial
+			if move.NewInventoryMaterialID == nil {
+				t.Fatal("no lot was split off")
+			}
+			s.DB.First(&split, *move.NewInventoryMaterialID)
+			if split.Quantity != tc.moved || split.AvailableQty != tc.moved || split.LocationID == nil || *split.LocationID != to.ID {
+				t.Errorf("split lot %+v, want %d in bin %d", split, tc.moved, to.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"daijai/identity"
+	"daijai/models"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// issuer is an OpenID provider that signs in anyone with the groups and nonce it is given.
+type issuer struct {
+	*httptest.Server
+	nonce  string
+	groups []string
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := &issuer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                is.URL,
+			"authorization_endpoint":                is.URL + "/authorize",
+			"token_endpoint":                        is.URL + "/token",
+			"jwks_uri":                              is.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": encode(key.N.Bytes()),
+			"e": encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                is.URL,
+			"aud":                "daijai",
+			"sub":                "u-1",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              is.nonce,
+			"preferred_username": "jane",
+			"name":               "Jane Doe",
+			"groups":             is.groups,
+		})
+		idToken.Header["kid"] = "test"
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at", "token_type": "Bearer", "id_token": signed})
+	})
+	is.Server = httptest.NewServer(mux)
+	t.Cleanup(is.Close)
+	return is
+}
+
+// OIDC reads its settings once per process, this is the only test that signs in with it.
+func TestOIDCLogin(t *testing.T) {
+	s := newTestServer(t)
+	is := newIssuer(t)
+	t.Setenv("OIDC_ISSUER", is.URL)
+	t.Setenv("OIDC_CLIENT_ID", "daijai")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_REDIRECT_URL", "http://localhost/auth/oidc/callback")
+	t.Setenv("IDP_GROUP_ROLES", `{"daijai-admins":"admin","cn=stock,ou=groups,dc=example,dc=com":"technician"}`)
+	t.Setenv("IDP_DEFAULT_ROLE", "")
+
+	// login redirects to the issuer and keeps state and nonce in cookies
+	login := func() (state string, cookies []*http.Cookie) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("login answered %d: %s", w.Code, w.Body.String())
+		}
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "oidc_nonce" {
+				is.nonce = cookie.Value
+			}
+		}
+		return location.Query().Get("state"), w.Result().Cookies()
+	}
+	callback := func(state string, cookies []*http.Cookie, out interface{}) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=c-1&state="+url.QueryEscape(state), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	is.groups = []string{"cn=stock,ou=groups,dc=example,dc=com", "everyone"}
+	state, cookies := login()
+	if code := callback("forged", cookies, nil); code != http.StatusBadRequest {
+		t.Errorf("callback with another state answered %d", code)
+	}
+	if code := callback(state, nil, nil); code != http.StatusBadRequest {
+		t.Errorf("callback without the state cookie answered %d", code)
+	}
+
+	cases := []struct {
+		name   string
+		groups []string
+		status int
+		role   string
+	}{
+		{"DN group maps to its role", []string{"cn=stock,ou=groups,dc=example,dc=com", "everyone"}, http.StatusOK, models.ROLE_Tech},
+		{"role follows the groups on the next login", []string{"daijai-admins", "cn=stock,ou=groups,dc=example,dc=com"}, http.StatusOK, models.ROLE_Admin},
+		{"groups without a role are refused", []string{"everyone"}, http.StatusForbidden, models.ROLE_Admin},
+	}
+	for _, tc := range cases {
+		is.groups = tc.groups
+		state, cookies := login()
+		var tokens map[string]interface{}
+		if code := callback(state, cookies, &tokens); code != tc.status {
+			t.Fatalf("%s: callback answered %d, want %d", tc.name, code, tc.status)
+		}
+		if tc.status == http.StatusOK && tokens["token"] == nil {
+			t.Errorf("%s: no access token in %v", tc.name, tokens)
+		}
+		var user models.User
+		if err := s.DB.Where("username = ?", "jane").First(&user).Error; err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if user.Role != tc.role || user.FullName != "Jane Doe" || user.AuthProvider != identity.Provider_OIDC {
+			t.Errorf("%s: user is %s %q of %s, want %s", tc.name, user.Role, user.FullName, user.AuthProvider, tc.role)
+		}
+	}
+	var users int64
+	s.DB.Model(&models.User{}).Where("username = ?", "jane").Count(&users)
+	if users != 1 {
+		t.Errorf("got %d accounts of the identity, want 1", users)
+	}
+}
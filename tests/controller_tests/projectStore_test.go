@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// stockedProjectStore issues 400 of a lot of 1000 to a new project store through an approved
+// order withdrawal.
+func (s *testServer) stockedProjectStore(accessToken string, admin models.User) (models.ProjectStore, models.Inventory, models.Material, models.InventoryMaterial) {
+	s.t.Helper()
+	inventory, material, lot := s.stock(10)
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+	store := models.ProjectStore{Slug: "PS-T1", Title: "Site store", ProjectID: project.ID}
+	s.create(&store)
+	order := models.Order{Slug: "ORD-T1", ProjectID: project.ID, CreatedByID: admin.ID}
+	s.create(&order)
+	bom := models.OrderBOM{OrderID: order.ID, MaterialID: material.ID, TargetQty: 400, ReservedQty: 400, IsFullFilled: true}
+	s.create(&bom)
+	s.create(&models.OrderReserving{OrderID: order.ID, OrderBOMID: bom.ID, InventoryMaterialID: lot.ID, Status: models.OrderReservingStatus_Reserved, Quantity: 400})
+	s.DB.Model(&lot).Updates(map[string]interface{}{"reserve": 400, "available_qty": 600})
+
+	var created struct{ Withdrawal models.Withdrawal }
+	body := map[string]interface{}{"Slug": "WD-T1", "OrderID": order.ID, "ProjectID": project.ID, "ProjectStoreID": store.ID}
+	if code := s.do(http.MethodPost, "/withdrawals", accessToken, body, &created); code != http.StatusCreated {
+		s.t.Fatalf("create withdrawal answered %d", code)
+	}
+	var approvement models.WithdrawalApprovement
+	s.DB.Where("withdrawal_id = ?", created.Withdrawal.ID).First(&approvement)
+	if code := s.do(http.MethodPut, fmt.Sprintf("/withdrawals/approve/%d", approvement.ID), accessToken, nil, nil); code != http.StatusOK {
+		s.t.Fatalf("approve withdrawal answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	return store, inventory, material, lot
+}
+
+// storeMaterials returns the lots of a project store.
+func (s *testServer) storeMaterials(storeID uint) []models.ProjectStoreMaterial {
+	s.t.Helper()
+	var storeMats []models.ProjectStoreMaterial
+	s.DB.Where("project_store_id = ?", storeID).Order("id asc").Find(&storeMats)
+	return storeMats
+}
+
+func TestApprovedWithdrawalStocksTheProjectStore(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	store, _, material, lot := s.stockedProjectStore(accessToken, admin)
+
+	if lot.Withdrawed != 400 || lot.Reserve != 0 {
+		t.Errorf("lot withdrawed %d reserve %d after approval, want 400 and 0", lot.Withdrawed, lot.Reserve)
+	}
+	got := s.storeMaterials(store.ID)
+	if len(got) != 1 {
+		t.Fatalf("got %d project store lots, want 1", len(got))
+	}
+	if got[0].MaterialID != material.ID || got[0].AvailableQty != 400 || got[0].InventoryMaterialID == nil || *got[0].InventoryMaterialID != lot.ID {
+		t.Errorf("got project store lot %+v, want 400 from lot %d", got[0], lot.ID)
+	}
+	var transactions []models.ProjectStoreTransaction
+	s.DB.Where("project_store_material_id = ?", got[0].ID).Find(&transactions)
+	if len(transactions) != 1 || transactions[0].TransactionType != models.ProjectStoreTransactionType_Incoming || transactions[0].Quantity != 400 {
+		t.Errorf("got project store transactions %+v, want one incoming of 400", transactions)
+	}
+}
+
+func TestConsumeFromTheProjectStore(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	store, _, material, _ := s.stockedProjectStore(accessToken, admin)
+	consume := func(quantity int64) int {
+		body := map[string]interface{}{"MaterialID": material.ID, "Quantity": quantity, "Notes": "foundation"}
+		return s.do(http.MethodPost, fmt.Sprintf("/projectStores/%d/consume", store.ID), accessToken, body, nil)
+	}
+
+	if code := consume(500); code != http.StatusBadRequest {
+		t.Errorf("consume of more than the store holds answered %d", code)
+	}
+	if got := s.storeMaterials(store.ID)[0]; got.AvailableQty != 400 || got.ConsumedQty != 0 {
+		t.Errorf("refused consume left the store lot at %d available %d consumed", got.AvailableQty, got.ConsumedQty)
+	}
+	if code := consume(100); code != http.StatusOK {
+		t.Fatalf("consume answered %d", code)
+	}
+	if got := s.storeMaterials(store.ID)[0]; got.AvailableQty != 300 || got.ConsumedQty != 100 {
+		t.Errorf("store lot has %d available %d consumed, want 300 and 100", got.AvailableQty, got.ConsumedQty)
+	}
+}
+
+func TestReturnFromTheProjectStore(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	store, inventory, material, lot := s.stockedProjectStore(accessToken, admin)
+
+	body := map[string]interface{}{"MaterialID": material.ID, "InventoryID": inventory.ID, "Quantity": 250}
+	if code := s.do(http.MethodPost, fmt.Sprintf("/projectStores/%d/return", store.ID), accessToken, body, nil); code != http.StatusOK {
+		t.Fatalf("return answered %d", code)
+	}
+	storeMat := s.storeMaterials(store.ID)[0]
+	if storeMat.AvailableQty != 150 || storeMat.ReturnedQty != 250 {
+		t.Errorf("store lot has %d available %d returned, want 150 and 250", storeMat.AvailableQty, storeMat.ReturnedQty)
+	}
+
+	lots := s.lotsIn(inventory.ID, material.ID)
+	if len(lots) != 2 || lots[0].ID != lot.ID {
+		t.Fatalf("got lots %+v, want the issuing lot and a returned one", lots)
+	}
+	returned := lots[1]
+	if returned.InventoryMaterialType != models.InventoryMaterialType_Return || returned.AvailableQty != 250 {
+		t.Errorf("returned lot is %s with %d available, want a return of 250", returned.InventoryMaterialType, returned.AvailableQty)
+	}
+	var ledger []models.InventoryMaterialTransaction
+	s.DB.Where("inventory_material_id = ?", returned.ID).Find(&ledger)
+	if len(ledger) != 1 || ledger[0].InventoryType != models.InventoryType_INCOMING || ledger[0].InventoryTypeDescription != models.InventoryTypeDescription_RETURN || ledger[0].Quantity != 250 {
+		t.Errorf("got ledger %+v, want one incoming return of 250", ledger)
+	}
+	var sum models.SumMaterialInventory
+	s.DB.Where("material_id = ? AND inventory_id = ?", material.ID, inventory.ID).First(&sum)
+	if sum.Quantity != 850 {
+		t.Errorf("inventory sum is %d, want the 600 left and the 250 returned", sum.Quantity)
+	}
+}
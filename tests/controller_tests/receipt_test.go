@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// receipt creates an unapproved receipt of the lines in the inventory.
+func (s *testServer) receipt(createdBy models.User, inventory models.Inventory, lines ...models.ReceiptMaterial) models.Receipt {
+	s.t.Helper()
+	receipt := models.Receipt{
+		Slug:             fmt.Sprintf("REC-%d", time.Now().UnixNano()),
+		PORefNumber:      "PO-T1",
+		InventoryID:      inventory.ID,
+		CreatedByID:      createdBy.ID,
+		ReceiptMaterials: lines,
+	}
+	s.create(&receipt)
+	return receipt
+}
+
+func TestApproveReceiptReturnsEachRejectedLine(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, bolt, _ := s.stock(0)
+	_, nut, _ := s.stock(0)
+
+	receipt := s.receipt(admin, inventory,
+		models.ReceiptMaterial{MaterialID: bolt.ID, Quantity: 1000, Price: 100, InspectionStatus: models.ReceiptInspectionStatus_Partial, AcceptedQty: 800, RejectedQty: 200, RejectReason: "bent"},
+		models.ReceiptMaterial{MaterialID: nut.ID, Quantity: 500, Price: 100, InspectionStatus: models.ReceiptInspectionStatus_Rejected, RejectedQty: 500, RejectReason: "rusty"},
+	)
+	s.scan(accessToken, models.ScanSessionType_Receipt, receipt.Slug)
+
+	if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", receipt.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+
+	var returned []models.SupplierReturnMaterial
+	if err := s.DB.Order("id asc").Find(&returned).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(returned) != 2 {
+		t.Fatalf("got %d returned lines, want 2", len(returned))
+	}
+	for i, line := range receipt.ReceiptMaterials {
+		var got uint
+		if returned[i].ReceiptMaterialID != nil {
+			got = *returned[i].ReceiptMaterialID
+		}
+		if got != line.ID {
+			t.Errorf("returned line %d points at receipt material %d, want %d", i, got, line.ID)
+		}
+		if returned[i].Quantity != line.RejectedQty {
+			t.Errorf("returned line %d has quantity %d, want %d", i, returned[i].Quantity, line.RejectedQty)
+		}
+	}
+
+	var lots []models.InventoryMaterial
+	if err := s.DB.Where("receipt_id = ?", receipt.ID).Find(&lots).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || lots[0].MaterialID != bolt.ID || lots[0].AvailableQty != 800 {
+		t.Errorf("got lots %+v, want one of the accepted bolts", lots)
+	}
+}
+
+// approvedReceipt receives quantity whole units of the material through an approved receipt
+// and returns it with its lot.
+func (s *testServer) approvedReceipt(accessToken string, createdBy models.User, inventory models.Inventory, material models.Material, quantity int64) (models.Receipt, models.InventoryMaterial) {
+	s.t.Helper()
+	receipt := s.receipt(createdBy, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: quantity * 100, Price: 100})
+	s.scan(accessToken, models.ScanSessionType_Receipt, receipt.Slug)
+	if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", receipt.ID), accessToken, nil, nil); code != http.StatusOK {
+		s.t.Fatalf("approve answered %d", code)
+	}
+	var lot models.InventoryMaterial
+	if err := s.DB.Where("receipt_id = ?", receipt.ID).First(&lot).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return receipt, lot
+}
+
+func TestReverseReceiptRefusesStockInTransit(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	receipt, lot := s.approvedReceipt(accessToken, admin, inventory, material, 10)
+
+	// four units were shipped and wait in the in-transit inventory
+	transit := models.Inventory{Slug: models.InTransitInventorySlug, Title: "In transit", IsVirtual: true}
+	s.create(&transit)
+	s.DB.Model(&lot).Updates(map[string]interface{}{"quantity": 600, "available_qty": 600})
+	transitLot := models.InventoryMaterial{InventoryID: transit.ID, MaterialID: material.ID, Quantity: 400, IsOutOfStock: true, ReceiptID: &receipt.ID}
+	s.create(&transitLot)
+	order := models.TransferOrder{Slug: "TRF-T1", FromInventoryID: inventory.ID, ToInventoryID: inventory.ID + 1, Status: models.TransferOrderStatus_Shipped, CreatedByID: admin.ID}
+	s.create(&order)
+	line := models.TransferOrderLine{TransferOrderID: order.ID, MaterialID: material.ID, Quantity: 400, ShippedQty: 400}
+	s.create(&line)
+	s.create(&models.TransferOrderLot{TransferOrderLineID: line.ID, SourceLotID: lot.ID, TransitLotID: transitLot.ID, Quantity: 400})
+
+	path := fmt.Sprintf("/receipts/reverse/%d", receipt.ID)
+	if code := s.do(http.MethodPut, path, accessToken, map[string]string{"Reason": "wrong supplier"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("reverse answered %d, want %d", code, http.StatusBadRequest)
+	}
+	s.DB.First(&receipt, receipt.ID)
+	if receipt.IsReversed {
+		t.Fatal("receipt was reversed with stock in transit")
+	}
+
+	// once the transfer order is received the rest of the receipt may be reversed
+	s.DB.Model(&transitLot).Update("quantity", 0)
+	s.DB.Model(&models.TransferOrderLot{}).Where("transit_lot_id = ?", transitLot.ID).Update("received_qty", 400)
+	if code := s.do(http.MethodPut, path, accessToken, map[string]string{"Reason": "wrong supplier"}, nil); code != http.StatusOK {
+		t.Fatalf("reverse answered %d after the transfer was received", code)
+	}
+}
+
+func TestReverseReceipt(t *testing.T) {
+	cases := []struct {
+		name         string
+		withdrawn    int64
+		reserved     int64
+		status       int
+		wantQuantity int64 // of the lot after the reversal
+		wantReceived int64 // on the PO after the reversal
+	}{
+		{name: "untouched", status: http.StatusOK, wantQuantity: 0, wantReceived: 0},
+		{name: "partly used", withdrawn: 300, status: http.StatusOK, wantQuantity: 300, wantReceived: 300},
+		{name: "reserved", reserved: 200, status: http.StatusBadRequest, wantQuantity: 1000, wantReceived: 1000},
+		{name: "used up", withdrawn: 1000, status: http.StatusBadRequest, wantQuantity: 1000, wantReceived: 1000},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			admin, accessToken := s.signIn(models.ROLE_Admin)
+			inventory, material, _ := s.stock(0)
+			purchase := models.Purchase{
+				Slug:              "PR-T1",
+				PORefs:            []models.PORef{{Slug: "PO-T1"}},
+				IsApprove:         true,
+				PurchaseMaterials: []models.PurchaseMaterial{{MaterialID: material.ID, Quantity: 1000}},
+				CreatedByID:       admin.ID,
+			}
+			s.create(&purchase)
+			receipt, lot := s.approvedReceipt(accessToken, admin, inventory, material, 10)
+
+			s.DB.Model(&lot).Updates(map[string]interface{}{
+				"withdrawed":    tc.withdrawn,
+				"reserve":       tc.reserved,
+				"available_qty": lot.AvailableQty - tc.withdrawn - tc.reserved,
+			})
+
+			code := s.do(http.MethodPut, fmt.Sprintf("/receipts/reverse/%d", receipt.ID), accessToken, map[string]string{"Reason": "wrong supplier"}, nil)
+			if code != tc.status {
+				t.Fatalf("reverse answered %d, want %d", code, tc.status)
+			}
+
+			s.DB.First(&lot, lot.ID)
+			if lot.Quantity != tc.wantQuantity {
+				t.Errorf("lot has quantity %d, want %d", lot.Quantity, tc.wantQuantity)
+			}
+			if tc.status == http.StatusOK && lot.AvailableQty != 0 {
+				t.Errorf("lot has %d available after the reversal", lot.AvailableQty)
+			}
+			if lot.Withdrawed != tc.withdrawn {
+				t.Errorf("lot has withdrawn %d, want %d", lot.Withdrawed, tc.withdrawn)
+			}
+			var pm models.PurchaseMaterial
+			s.DB.First(&pm, purchase.PurchaseMaterials[0].ID)
+			if pm.ReceivedQty != tc.wantReceived {
+				t.Errorf("PO has received %d, want %d", pm.ReceivedQty, tc.wantReceived)
+			}
+			s.DB.First(&receipt, receipt.ID)
+			if receipt.IsReversed != (tc.status == http.StatusOK) {
+				t.Errorf("receipt reversed %v", receipt.IsReversed)
+			}
+		})
+	}
+}
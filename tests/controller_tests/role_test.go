@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"daijai/middlewares"
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateRoleKeepsSystemPermissions(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	manager := models.Role{Name: models.ROLE_Manager, IsSystem: true}
+	s.create(&manager)
+	custom := models.Role{Name: "auditor"}
+	s.create(&custom)
+
+	var matrix []middlewares.Permission
+	for p, roles := range middlewares.PermissionMatrix {
+		for _, r := range roles {
+			if r == models.ROLE_Manager {
+				matrix = append(matrix, p)
+			}
+		}
+	}
+	audit := []middlewares.Permission{{Resource: middlewares.Resource_Audit, Action: middlewares.Action_Read}}
+
+	cases := []struct {
+		name        string
+		role        models.Role
+		permissions []middlewares.Permission
+		status      int
+	}{
+		{"system role with the matrix", manager, matrix, http.StatusOK},
+		{"system role without the matrix", manager, audit, http.StatusBadRequest},
+		{"custom role", custom, audit, http.StatusOK},
+	}
+	for _, tc := range cases {
+		body := map[string]interface{}{"Name": tc.role.Name, "Description": tc.name, "Permissions": tc.permissions}
+		if code := s.do(http.MethodPut, fmt.Sprintf("/roles/%d", tc.role.ID), accessToken, body, nil); code != tc.status {
+			t.Errorf("%s: update answered %d, want %d", tc.name, code, tc.status)
+		}
+	}
+
+	var count int64
+	s.DB.Model(&models.RolePermission{}).Where("role_id = ?", custom.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("custom role has %d permissions, want 1", count)
+	}
+}
+
+func TestUpdateRoleKeepsProjectScopeWhenLeftOut(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	var role models.Role
+	if code := s.do(http.MethodPost, "/roles", accessToken, map[string]interface{}{"Name": "site-lead", "IsProjectScoped": true}, &role); code != http.StatusCreated {
+		t.Fatalf("create role answered %d", code)
+	}
+
+	path := fmt.Sprintf("/roles/%d", role.ID)
+	for _, tc := range []struct {
+		body map[string]interface{}
+		want bool
+	}{
+		{map[string]interface{}{"Name": "site-lead", "Description": "renamed"}, true},
+		{map[string]interface{}{"Name": "site-lead", "IsProjectScoped": false}, false},
+	} {
+		if code := s.do(http.MethodPut, path, accessToken, tc.body, &role); code != http.StatusOK {
+			t.Fatalf("update %v answered %d", tc.body, code)
+		}
+		if role.IsProjectScoped != tc.want {
+			t.Errorf("update %v: project scoped is %v, want %v", tc.body, role.IsProjectScoped, tc.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestApproveReceiptNeedsAMatchingScan(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 500, Price: 100})
+	approve := func() int {
+		return s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", receipt.ID), accessToken, nil, nil)
+	}
+
+	t.Setenv("SCAN_CONFIRMATION_REQUIRED", "true")
+	if code := approve(); code != http.StatusBadRequest {
+		t.Fatalf("approve without a scan answered %d", code)
+	}
+	t.Setenv("SCAN_CONFIRMATION_REQUIRED", "")
+
+	// an open session does not count
+	var session models.ScanSession
+	if code := s.do(http.MethodPost, "/scan/sessions", accessToken, map[string]string{"SessionType": models.ScanSessionType_Receipt, "Slug": receipt.Slug}, &session); code != http.StatusCreated {
+		t.Fatalf("start scan session answered %d", code)
+	}
+	if code := approve(); code != http.StatusBadRequest {
+		t.Fatalf("approve with an open scan answered %d", code)
+	}
+	s.do(http.MethodPut, fmt.Sprintf("/scan/sessions/%d/cancel", session.ID), accessToken, nil, nil)
+
+	// a confirmed scan of quantities the receipt no longer has does not count either
+	s.scan(accessToken, models.ScanSessionType_Receipt, receipt.Slug)
+	s.DB.Model(&models.ReceiptMaterial{}).Where("receipt_id = ?", receipt.ID).Update("quantity", 600)
+	if code := approve(); code != http.StatusBadRequest {
+		t.Fatalf("approve with a stale scan answered %d", code)
+	}
+
+	s.scan(accessToken, models.ScanSessionType_Receipt, receipt.Slug)
+	if code := approve(); code != http.StatusOK {
+		t.Fatalf("approve with a matching scan answered %d", code)
+	}
+}
+
+func TestApproveDocumentsNobodyScanned(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, lot := s.stock(10)
+
+	receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 500, Price: 100})
+	if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", receipt.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Errorf("approve of an unscanned receipt answered %d", code)
+	}
+
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+	order := models.Order{Slug: "ORD-T1", ProjectID: project.ID, CreatedByID: admin.ID}
+	s.create(&order)
+	bom := models.OrderBOM{OrderID: order.ID, MaterialID: material.ID, TargetQty: 400, ReservedQty: 400, IsFullFilled: true}
+	s.create(&bom)
+	s.create(&models.OrderReserving{OrderID: order.ID, OrderBOMID: bom.ID, InventoryMaterialID: lot.ID, Status: models.OrderReservingStatus_Reserved, Quantity: 400})
+	s.DB.Model(&lot).Updates(map[string]interface{}{"reserve": 400, "available_qty": 600})
+	var created struct{ Withdrawal models.Withdrawal }
+	if code := s.do(http.MethodPost, "/withdrawals", accessToken, map[string]interface{}{"Slug": "WD-T1", "OrderID": order.ID, "ProjectID": project.ID}, &created); code != http.StatusCreated {
+		t.Fatalf("create withdrawal answered %d", code)
+	}
+	var approvement models.WithdrawalApprovement
+	s.DB.Where("withdrawal_id = ?", created.Withdrawal.ID).First(&approvement)
+	if code := s.do(http.MethodPut, fmt.Sprintf("/withdrawals/approve/%d", approvement.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Errorf("approve of an unscanned withdrawal answered %d", code)
+	}
+}
+
+func TestScanNeverGoesOverTheExpectedQuantity(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 500, Price: 100})
+
+	var session models.ScanSession
+	s.do(http.MethodPost, "/scan/sessions", accessToken, map[string]string{"SessionType": models.ScanSessionType_Receipt, "Slug": receipt.Slug}, &session)
+	path := fmt.Sprintf("/scan/sessions/%d/scan", session.ID)
+
+	// another scanner already saved part of the line
+	line := session.Lines[0]
+	s.DB.Model(&line).Update("scanned_qty", 300)
+	if code := s.do(http.MethodPost, path, accessToken, map[string]interface{}{"Code": material.Slug, "Quantity": 300}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("over scan answered %d", code)
+	}
+	var got models.ScanLine
+	if code := s.do(http.MethodPost, path, accessToken, map[string]interface{}{"Code": material.Slug, "Quantity": 200}, &got); code != http.StatusOK {
+		t.Fatalf("scan answered %d", code)
+	}
+	if got.ScannedQty != 500 {
+		t.Errorf("line scanned %d, want 500", got.ScannedQty)
+	}
+
+	var mismatches []models.ScanMismatch
+	s.DB.Find(&mismatches)
+	if len(mismatches) != 1 || mismatches[0].Reason != models.ScanMismatchReason_OverQuantity {
+		t.Errorf("got mismatches %+v, want one over quantity", mismatches)
+	}
+}
@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// serialOf returns the serial of the material by its number.
+func (s *testServer) serialOf(material models.Material, serialNumber string) models.MaterialSerial {
+	s.t.Helper()
+	var serial models.MaterialSerial
+	if err := s.DB.Where("material_id = ? AND serial_number = ?", material.ID, serialNumber).First(&serial).Error; err != nil {
+		s.t.Fatalf("serial %s: %v", serialNumber, err)
+	}
+	return serial
+}
+
+func TestReceiptSerials(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	s.DB.Model(&material).Update("is_serial_tracked", true)
+	existing := models.MaterialSerial{MaterialID: material.ID, SerialNumber: "SN-OLD", Status: models.MaterialSerialStatus_InStock}
+	s.create(&existing)
+
+	cases := []struct {
+		name    string
+		serials []string
+		status  int
+	}{
+		{"one serial per unit", []string{"SN-1", "SN-2"}, http.StatusBadRequest},
+		{"serial given twice", []string{"SN-1", "SN-1", "SN-2"}, http.StatusBadRequest},
+		{"serial already received", []string{"SN-1", "SN-2", "SN-OLD"}, http.StatusBadRequest},
+		{"new serials", []string{"SN-1", "SN-2", "SN-3"}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 300, Price: 100, Serials: tc.serials})
+		s.scan(accessToken, models.ScanSessionType_Receipt, receipt.Slug)
+		if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", receipt.ID), accessToken, nil, nil); code != tc.status {
+			t.Errorf("%s: approve answered %d, want %d", tc.name, code, tc.status)
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var lot models.InventoryMaterial
+		s.DB.Where("receipt_id = ?", receipt.ID).First(&lot)
+		for _, sn := range tc.serials {
+			serial := s.serialOf(material, sn)
+			if serial.Status != models.MaterialSerialStatus_InStock || serial.InventoryMaterialID == nil || *serial.InventoryMaterialID != lot.ID {
+				t.Errorf("%s: serial %s is %s in lot %v, want in stock in lot %d", tc.name, sn, serial.Status, serial.InventoryMaterialID, lot.ID)
+			}
+		}
+	}
+
+	var found []models.MaterialSerial
+	if code := s.do(http.MethodGet, "/serials/lookup/SN-2", accessToken, nil, &found); code != http.StatusOK || len(found) != 1 {
+		t.Errorf("lookup answered %d with %d serials", code, len(found))
+	}
+}
+
+func TestSerialPlanOfALoan(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	s.DB.Model(&material).Updates(map[string]interface{}{"is_serial_tracked": true, "is_returnable": true})
+	receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 300, Price: 100, Serials: []string{"SN-1", "SN-2", "SN-3"}})
+	s.scan(accessToken, models.ScanSessionType_Receipt, receipt.Slug)
+	if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", receipt.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+	checkOut := func(quantity int64, serials ...string) (models.MaterialLoan, int) {
+		var loan models.MaterialLoan
+		body := map[string]interface{}{
+			"MaterialID":  material.ID,
+			"InventoryID": inventory.ID,
+			"Quantity":    quantity,
+			"BorrowerID":  admin.ID,
+			"DueAt":       time.Now().Add(24 * time.Hour),
+			"Serials":     serials,
+		}
+		return loan, s.do(http.MethodPost, "/loans", accessToken, body, &loan)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		quantity int64
+		serials  []string
+	}{
+		{"no serials", 100, nil},
+		{"fewer serials than units", 200, []string{"SN-1"}},
+		{"unknown serial", 100, []string{"SN-9"}},
+		{"serial given twice", 200, []string{"SN-1", "SN-1"}},
+	} {
+		if _, code := checkOut(tc.quantity, tc.serials...); code != http.StatusBadRequest {
+			t.Errorf("%s: check out answered %d, want %d", tc.name, code, http.StatusBadRequest)
+		}
+	}
+
+	loan, code := checkOut(200, "SN-1", "SN-2")
+	if code != http.StatusCreated {
+		t.Fatalf("check out answered %d", code)
+	}
+	for _, sn := range []string{"SN-1", "SN-2"} {
+		serial := s.serialOf(material, sn)
+		if serial.Status != models.MaterialSerialStatus_OnLoan || serial.MaterialLoanID == nil || *serial.MaterialLoanID != loan.ID {
+			t.Errorf("serial %s is %s on loan %v after check out", sn, serial.Status, serial.MaterialLoanID)
+		}
+	}
+	if _, code := checkOut(100, "SN-1"); code != http.StatusBadRequest {
+		t.Errorf("check out of a serial on loan answered %d", code)
+	}
+
+	checkIn := func(serials ...string) int {
+		body := map[string]interface{}{"Quantity": int64(len(serials)) * models.SerialQuantity, "Condition": models.MaterialLoanCondition_Good, "Serials": serials}
+		return s.do(http.MethodPut, fmt.Sprintf("/loans/checkin/%d", loan.ID), accessToken, body, nil)
+	}
+	if code := checkIn("SN-3"); code != http.StatusBadRequest {
+		t.Errorf("check in of a serial of no loan answered %d", code)
+	}
+	if code := checkIn("SN-2"); code != http.StatusOK {
+		t.Fatalf("check in answered %d", code)
+	}
+	if serial := s.serialOf(material, "SN-2"); serial.Status != models.MaterialSerialStatus_InStock || serial.MaterialLoanID != nil {
+		t.Errorf("serial SN-2 is %s on loan %v after check in", serial.Status, serial.MaterialLoanID)
+	}
+	if serial := s.serialOf(material, "SN-1"); serial.Status != models.MaterialSerialStatus_OnLoan {
+		t.Errorf("serial SN-1 is %s, it was not checked in", serial.Status)
+	}
+}
+
+func TestSerialsMoveWithTheirLot(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	s.DB.Model(&material).Update("is_serial_tracked", true)
+	receipt := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 300, Price: 100, Serials: []string{"SN-1", "SN-2", "SN-3"}})
+	s.scan(accessToken, models.ScanSessionType_Receipt, receipt.Slug)
+	if code := s.do(http.MethodPut, fmt.Sprintf("/receipts/approve/%d", receipt.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+	var lot models.InventoryMaterial
+	s.DB.Where("receipt_id = ?", receipt.ID).First(&lot)
+	bin := models.InventoryLocation{InventoryID: inventory.ID, Slug: "B-01"}
+	s.create(&bin)
+
+	move := func(quantity int64, serials ...string) (models.LocationMove, int) {
+		var move models.LocationMove
+		body := map[string]interface{}{"InventoryMaterialID": lot.ID, "ToLocationID": bin.ID, "Quantity": quantity, "Serials": serials}
+		return move, s.do(http.MethodPost, "/inventories/locations/move", accessToken, body, &move)
+	}
+	if _, code := move(100); code != http.StatusBadRequest {
+		t.Errorf("split without serials answered %d", code)
+	}
+	m, code := move(100, "SN-3")
+	if code != http.StatusOK {
+		t.Fatalf("split answered %d", code)
+	}
+	if m.NewInventoryMaterialID == nil {
+		t.Fatal("no lot was split off")
+	}
+	if serial := s.serialOf(material, "SN-3"); serial.InventoryMaterialID == nil || *serial.InventoryMaterialID != *m.NewInventoryMaterialID {
+		t.Errorf("serial SN-3 is in lot %v, want the split lot %d", serial.InventoryMaterialID, *m.NewInventoryMaterialID)
+	}
+	if serial := s.serialOf(material, "SN-1"); serial.InventoryMaterialID == nil || *serial.InventoryMaterialID != lot.ID {
+		t.Errorf("serial SN-1 left its lot")
+	}
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"daijai/models"
+	"daijai/token"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// session opens another session of the user and returns its id with its tokens.
+func (s *testServer) session(user models.User) (uint, string, string) {
+	s.t.Helper()
+	refreshToken, refreshHash, err := token.NewRefreshToken()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: refreshHash,
+		ExpiresAt:        time.Now().Add(time.Hour),
+		LastUsedAt:       time.Now(),
+	}
+	s.create(&session)
+	accessToken, err := token.GenerateToken(user, session.ID)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return session.ID, accessToken, refreshToken
+}
+
+type refreshed struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (s *testServer) refresh(refreshToken string) (refreshed, int) {
+	var out refreshed
+	return out, s.do(http.MethodPost, "/auth/refresh", "", map[string]string{"refreshToken": refreshToken}, &out)
+}
+
+// signedIn reports whether the access token is still accepted.
+func (s *testServer) signedIn(accessToken string) bool {
+	return s.do(http.MethodGet, "/auth/session", accessToken, nil, nil) != http.StatusUnauthorized
+}
+
+func TestRefreshRotatesTheToken(t *testing.T) {
+	s := newTestServer(t)
+	user, _ := s.signIn(models.ROLE_Tech)
+	sessionID, _, first := s.session(user)
+
+	second, code := s.refresh(first)
+	if code != http.StatusOK {
+		t.Fatalf("refresh answered %d", code)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first {
+		t.Fatal("refresh did not rotate the refresh token")
+	}
+	if !s.signedIn(second.Token) {
+		t.Error("new access token is refused")
+	}
+	third, code := s.refresh(second.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh with the rotated token answered %d", code)
+	}
+
+	// the token before the current one is reused, the session is taken over
+	if _, code := s.refresh(second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reuse of the previous token answered %d", code)
+	}
+	var session models.Session
+	s.DB.First(&session, sessionID)
+	if session.RevokedAt == nil || session.RevokedReason != models.SessionRevokedReason_TokenReuse {
+		t.Errorf("session is revoked at %v for %q, want revoked for token reuse", session.RevokedAt, session.RevokedReason)
+	}
+	if _, code := s.refresh(third.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh of a revoked session answered %d", code)
+	}
+	if s.signedIn(third.Token) {
+		t.Error("access token of a revoked session is accepted")
+	}
+}
+
+func TestLogoutRevokesTheSession(t *testing.T) {
+	s := newTestServer(t)
+	user, _ := s.signIn(models.ROLE_Tech)
+	_, accessToken, refreshToken := s.session(user)
+	_, otherToken, _ := s.session(user)
+
+	if code := s.do(http.MethodPost, "/auth/logout", accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("logout answered %d", code)
+	}
+	if s.signedIn(accessToken) {
+		t.Error("access token is accepted after logout")
+	}
+	if _, code := s.refresh(refreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout answered %d", code)
+	}
+	if !s.signedIn(otherToken) {
+		t.Error("logout ended another session")
+	}
+}
+
+func TestForceLogout(t *testing.T) {
+	s := newTestServer(t)
+	_, adminToken := s.signIn(models.ROLE_Admin)
+	user, _ := s.signIn(models.ROLE_Tech)
+	laptopID, laptop, _ := s.session(user)
+	_, phone, _ := s.session(user)
+
+	var out struct{ Revoked int64 }
+	path := fmt.Sprintf("/users/%d/logout", user.ID)
+	if code := s.do(http.MethodPut, fmt.Sprintf("%s?sessionID=%d", path, laptopID), adminToken, nil, &out); code != http.StatusOK || out.Revoked != 1 {
+		t.Fatalf("force logout of one session answered %d revoking %d", code, out.Revoked)
+	}
+	if s.signedIn(laptop) || !s.signedIn(phone) {
+		t.Errorf("laptop signed in %v, phone signed in %v, want only the phone", s.signedIn(laptop), s.signedIn(phone))
+	}
+
+	// without a session every session of the user ends, the signIn one included
+	if code := s.do(http.MethodPut, path, adminToken, nil, &out); code != http.StatusOK || out.Revoked != 2 {
+		t.Fatalf("force logout answered %d revoking %d", code, out.Revoked)
+	}
+	if s.signedIn(phone) {
+		t.Error("phone is still signed in")
+	}
+	if !s.signedIn(adminToken) {
+		t.Error("admin was signed out")
+	}
+}
+
+func TestRevokeMySession(t *testing.T) {
+	s := newTestServer(t)
+	user, accessToken := s.signIn(models.ROLE_Tech)
+	other, _ := s.signIn(models.ROLE_Tech)
+	phoneID, phone, _ := s.session(user)
+	otherID, otherToken, _ := s.session(other)
+
+	if code := s.do(http.MethodDelete, fmt.Sprintf("/auth/sessions/%d", otherID), accessToken, nil, nil); code != http.StatusNotFound {
+		t.Errorf("revoke of another user's session answered %d", code)
+	}
+	if code := s.do(http.MethodDelete, fmt.Sprintf("/auth/sessions/%d", phoneID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("revoke answered %d", code)
+	}
+	if s.signedIn(phone) {
+		t.Error("revoked session is accepted")
+	}
+	if !s.signedIn(accessToken) || !s.signedIn(otherToken) {
+		t.Error("a session that was not revoked is refused")
+	}
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"bytes"
+	"daijai/audit"
+	"daijai/models"
+	"daijai/server"
+	"daijai/token"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testServer is the router on an in-memory database with every table of the migrator.
+//
+// The controller tests are plain test functions rather than a testify suite like
+// health_test.go: each one builds its own server with newTestServer, so no state is
+// shared between tests and t.Setenv and t.Cleanup undo their changes per test.
+type testServer struct {
+	t      *testing.T
+	DB     *gorm.DB
+	Router *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	t.Setenv("SECRET", "test-secret")
+	// the image controller builds a storage client, the emulator host spares it credentials
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Setenv("STORAGE_EMULATOR_HOST", "localhost:0")
+	}
+	gin.SetMode(gin.TestMode)
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_pragma=foreign_keys(0)", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection keeps the shared memory database alive and transactions serial
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(models.Tables()...); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range models.Slugables() {
+		slug := m.GenerateSlug()
+		db.Create(&models.Slugger{TableName: slug.TableName, Prefix: slug.Prefix, Pad: slug.Pad})
+	}
+	if err := audit.Register(db); err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{t: t, DB: db, Router: server.SetupRouter(db)}
+}
+
+// signIn creates a user of the role with a session and returns it with its access token.
+func (s *testServer) signIn(role string) (models.User, string) {
+	s.t.Helper()
+	var count int64
+	s.DB.Model(&models.User{}).Count(&count)
+	user := models.User{
+		Slug:     fmt.Sprintf("USR-T%03d", count+1),
+		Username: fmt.Sprintf("%s%d", role, count+1),
+		FullName: role,
+		Role:     role,
+	}
+	s.create(&user)
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: fmt.Sprintf("hash-%d", user.ID),
+		ExpiresAt:        time.Now().Add(time.Hour),
+		LastUsedAt:       time.Now(),
+	}
+	s.create(&session)
+	accessToken, err := token.GenerateToken(user, session.ID)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return user, accessToken
+}
+
+func (s *testServer) create(value interface{}) {
+	s.t.Helper()
+	if err := s.DB.Create(value).Error; err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// do sends a JSON request and decodes the JSON response into out, when given.
+func (s *testServer) do(method, path, accessToken string, body interface{}, out interface{}) int {
+	s.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: %v: %s", method, path, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+// stock creates an inventory and a material with a lot of quantity in it, quantities are whole units.
+func (s *testServer) stock(quantity int64) (models.Inventory, models.Material, models.InventoryMaterial) {
+	s.t.Helper()
+	inventory := models.Inventory{Slug: fmt.Sprintf("INV-%d", time.Now().UnixNano()), Title: "Main"}
+	s.create(&inventory)
+	category := models.Category{Slug: fmt.Sprintf("CAT-%d", time.Now().UnixNano()), Title: "Parts"}
+	s.create(&category)
+	material := models.Material{Slug: fmt.Sprintf("MAT-%d", time.Now().UnixNano()), Title: "Bolt", CategoryID: category.ID, DefaultPrice: 100}
+	s.create(&material)
+	lot := models.InventoryMaterial{
+		InventoryID:  inventory.ID,
+		MaterialID:   material.ID,
+		Quantity:     quantity * 100,
+		AvailableQty: quantity * 100,
+		Price:        100,
+	}
+	if quantity > 0 {
+		s.create(&lot)
+	}
+	return inventory, material, lot
+}
+
+// scan confirms every line of a receipt or withdrawal approvement through a scan session.
+func (s *testServer) scan(accessToken, sessionType, slug string) models.ScanSession {
+	s.t.Helper()
+	var session models.ScanSession
+	body := map[string]string{"SessionType": sessionType, "Slug": slug}
+	if code := s.do(http.MethodPost, "/scan/sessions", accessToken, body, &session); code != http.StatusCreated && code != http.StatusOK {
+		s.t.Fatalf("start scan session answered %d", code)
+	}
+	for _, line := range session.Lines {
+		code := line.Material.Slug
+		if line.InventoryMaterialID != nil {
+			code = models.LotCode(line.Material.Slug, *line.InventoryMaterialID)
+		}
+		path := fmt.Sprintf("/scan/sessions/%d/scan", session.ID)
+		if status := s.do(http.MethodPost, path, accessToken, map[string]string{"Code": code}, nil); status != http.StatusOK {
+			s.t.Fatalf("scan of %s answered %d", code, status)
+		}
+	}
+	if code := s.do(http.MethodPut, fmt.Sprintf("/scan/sessions/%d/confirm", session.ID), accessToken, nil, &session); code != http.StatusOK {
+		s.t.Fatalf("confirm scan session answered %d", code)
+	}
+	return session
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"daijai/models"
+	"net/http"
+	"testing"
+)
+
+func TestSupplierReturnFromStock(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	inventory, material, _ := s.stock(0)
+	purchase := models.Purchase{
+		Slug:              "PR-T1",
+		PORefs:            []models.PORef{{Slug: "PO-T1"}},
+		IsApprove:         true,
+		PurchaseMaterials: []models.PurchaseMaterial{{MaterialID: material.ID, Quantity: 1000}},
+		CreatedByID:       admin.ID,
+	}
+	s.create(&purchase)
+	receipt, lot := s.approvedReceipt(accessToken, admin, inventory, material, 10)
+	// three units wait for an order
+	s.DB.Model(&lot).Updates(map[string]interface{}{"reserve": 300, "available_qty": 700})
+
+	send := func(receiptID uint, quantity int64) (models.SupplierReturn, int) {
+		var supplierReturn models.SupplierReturn
+		body := map[string]interface{}{
+			"ReceiptID": receiptID,
+			"Lines":     []map[string]interface{}{{"MaterialID": material.ID, "Quantity": quantity, "Reason": "damaged"}},
+		}
+		return supplierReturn, s.do(http.MethodPost, "/supplier-returns", accessToken, body, &supplierReturn)
+	}
+
+	unapproved := s.receipt(admin, inventory, models.ReceiptMaterial{MaterialID: material.ID, Quantity: 100, Price: 100})
+	if _, code := send(unapproved.ID, 100); code != http.StatusBadRequest {
+		t.Errorf("return from an unapproved receipt answered %d", code)
+	}
+	if _, code := send(receipt.ID, 800); code != http.StatusBadRequest {
+		t.Errorf("return of reserved stock answered %d", code)
+	}
+
+	supplierReturn, code := send(receipt.ID, 500)
+	if code != http.StatusCreated {
+		t.Fatalf("return answered %d", code)
+	}
+	if len(supplierReturn.SupplierReturnMaterials) != 1 || supplierReturn.SupplierReturnMaterials[0].Quantity != 500 {
+		t.Errorf("got return lines %+v, want one of 500", supplierReturn.SupplierReturnMaterials)
+	}
+	if supplierReturn.Source != models.SupplierReturnSource_Stock || supplierReturn.PORefNumber != "PO-T1" {
+		t.Errorf("return is from %s of %s", supplierReturn.Source, supplierReturn.PORefNumber)
+	}
+
+	s.DB.First(&lot, lot.ID)
+	if lot.Quantity != 500 || lot.AvailableQty != 200 || lot.Reserve != 300 {
+		t.Errorf("lot quantity %d available %d reserve %d, want 500, 200 and 300", lot.Quantity, lot.AvailableQty, lot.Reserve)
+	}
+	var pm models.PurchaseMaterial
+	s.DB.First(&pm, purchase.PurchaseMaterials[0].ID)
+	if pm.ReceivedQty != 500 {
+		t.Errorf("PO has received %d, want 500", pm.ReceivedQty)
+	}
+	var ledger int64
+	s.DB.Model(&models.InventoryMaterialTransaction{}).
+		Where("supplier_return_id = ? AND inventory_type_description = ?", supplierReturn.ID, models.InventoryTypeDescription_SUPPLIER_RETURN).
+		Count(&ledger)
+	if ledger != 1 {
+		t.Errorf("got %d ledger rows of the return, want 1", ledger)
+	}
+}
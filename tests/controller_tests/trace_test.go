@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestTraceStaysInProjectScope(t *testing.T) {
+	s := newTestServer(t)
+	admin, adminToken := s.signIn(models.ROLE_Admin)
+	tech, techToken := s.signIn(models.ROLE_Tech)
+	inventory, material, _ := s.stock(0)
+	receipt, lot := s.approvedReceipt(adminToken, admin, inventory, material, 10)
+
+	// the lot went to a project of the technician and to another one
+	withdrawals := make(map[string]models.Withdrawal)
+	for _, slug := range []string{"PJ-OWN", "PJ-OTHER"} {
+		project := models.Project{Slug: slug, Title: slug}
+		s.create(&project)
+		withdrawal := models.Withdrawal{Slug: "WD-" + slug, ProjectID: project.ID, CreatedByID: admin.ID}
+		s.create(&withdrawal)
+		s.create(&models.InventoryMaterialTransaction{
+			InventoryMaterialID: lot.ID,
+			Quantity:            100,
+			InventoryType:       models.InventoryType_OUTGOING,
+			ExistingQuantity:    1000,
+			UpdatedQuantity:     900,
+			WithdrawalID:        &withdrawal.ID,
+		})
+		store := models.ProjectStore{Slug: "PS-" + slug, ProjectID: project.ID}
+		s.create(&store)
+		s.create(&models.ProjectStoreMaterial{ProjectStoreID: store.ID, MaterialID: material.ID, InventoryMaterialID: &lot.ID, Quantity: 100, AvailableQty: 100})
+		withdrawals[slug] = withdrawal
+		if slug == "PJ-OWN" {
+			s.create(&models.ProjectMember{ProjectID: project.ID, UserID: tech.ID})
+		}
+	}
+
+	var report models.TraceReport
+	path := fmt.Sprintf("/trace/receipts/%s", receipt.Slug)
+	if code := s.do(http.MethodGet, path, techToken, nil, &report); code != http.StatusOK {
+		t.Fatalf("trace answered %d", code)
+	}
+	if len(report.Lots) != 1 {
+		t.Fatalf("got %d lots, want the lot of the receipt", len(report.Lots))
+	}
+	got := report.Lots[0]
+	if len(got.Withdrawals) != 1 || got.Withdrawals[0].WithdrawalSlug != "WD-PJ-OWN" {
+		t.Errorf("technician sees withdrawals %+v, want the one of the own project", got.Withdrawals)
+	}
+	if len(got.ProjectStores) != 1 || got.ProjectStores[0].ProjectStoreSlug != "PS-PJ-OWN" {
+		t.Errorf("technician sees project stores %+v, want the one of the own project", got.ProjectStores)
+	}
+	if len(report.Projects) != 1 || report.Projects[0].Slug != "PJ-OWN" {
+		t.Errorf("technician sees projects %+v, want the own project", report.Projects)
+	}
+
+	if code := s.do(http.MethodGet, path, adminToken, nil, &report); code != http.StatusOK {
+		t.Fatalf("admin trace answered %d", code)
+	}
+	if len(report.Lots[0].Withdrawals) != 2 || len(report.Projects) != 2 {
+		t.Errorf("admin sees %d withdrawals in %d projects, want 2 of each", len(report.Lots[0].Withdrawals), len(report.Projects))
+	}
+
+	for slug, want := range map[string]int{"PJ-OWN": http.StatusOK, "PJ-OTHER": http.StatusNotFound} {
+		if code := s.do(http.MethodGet, "/trace/withdrawals/"+withdrawals[slug].Slug, techToken, nil, nil); code != want {
+			t.Errorf("trace of the withdrawal of %s answered %d, want %d", slug, code, want)
+		}
+	}
+}
+
+func TestTraceFollowsAReceiptToTheProjectStore(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	warehouse, material, _ := s.stock(0)
+	receipt, _ := s.approvedReceipt(accessToken, admin, warehouse, material, 10)
+	site := models.Inventory{Slug: "INV-SITE", Title: "Site"}
+	s.create(&site)
+
+	// six units go to the site inventory
+	var order models.TransferOrder
+	body := map[string]interface{}{
+		"FromInventoryID": warehouse.ID,
+		"ToInventoryID":   site.ID,
+		"IsDirect":        true,
+		"Lines":           []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 600}},
+	}
+	if code := s.do(http.MethodPost, "/transfer-orders", accessToken, body, &order); code != http.StatusCreated {
+		t.Fatalf("create transfer answered %d", code)
+	}
+	if code := s.do(http.MethodPut, fmt.Sprintf("/transfer-orders/approve/%d", order.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve transfer answered %d", code)
+	}
+	siteLots := s.lotsIn(site.ID, material.ID)
+	if len(siteLots) != 1 {
+		t.Fatalf("got %d site lots, want 1", len(siteLots))
+	}
+	siteLot := siteLots[0]
+
+	// four of them are issued to the project store of a project
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+	store := models.ProjectStore{Slug: "PS-T1", Title: "Site store", ProjectID: project.ID}
+	s.create(&store)
+	projectOrder := models.Order{Slug: "ORD-T1", ProjectID: project.ID, CreatedByID: admin.ID}
+	s.create(&projectOrder)
+	bom := models.OrderBOM{OrderID: projectOrder.ID, MaterialID: material.ID, TargetQty: 400, ReservedQty: 400, IsFullFilled: true}
+	s.create(&bom)
+	s.create(&models.OrderReserving{OrderID: projectOrder.ID, OrderBOMID: bom.ID, InventoryMaterialID: siteLot.ID, Status: models.OrderReservingStatus_Reserved, Quantity: 400})
+	s.DB.Model(&siteLot).Updates(map[string]interface{}{"reserve": 400, "available_qty": 200})
+	var created struct{ Withdrawal models.Withdrawal }
+	body = map[string]interface{}{"Slug": "Bill 001/0001", "OrderID": projectOrder.ID, "ProjectID": project.ID, "ProjectStoreID": store.ID}
+	if code := s.do(http.MethodPost, "/withdrawals", accessToken, body, &created); code != http.StatusCreated {
+		t.Fatalf("create withdrawal answered %d", code)
+	}
+	var approvement models.WithdrawalApprovement
+	s.DB.Where("withdrawal_id = ?", created.Withdrawal.ID).First(&approvement)
+	if code := s.do(http.MethodPut, fmt.Sprintf("/withdrawals/approve/%d", approvement.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve withdrawal answered %d", code)
+	}
+
+	var report models.TraceReport
+	if code := s.do(http.MethodGet, "/trace/receipts/"+receipt.Slug, accessToken, nil, &report); code != http.StatusOK {
+		t.Fatalf("trace answered %d", code)
+	}
+	if len(report.Receipts) != 1 || report.Receipts[0].Slug != receipt.Slug {
+		t.Errorf("got receipts %+v, want the traced one", report.Receipts)
+	}
+	var traced *models.TraceLot
+	for i := range report.Lots {
+		if report.Lots[i].InventoryMaterialID == siteLot.ID {
+			traced = &report.Lots[i]
+		}
+	}
+	if traced == nil {
+		t.Fatalf("trace of lots %+v misses the site lot", report.Lots)
+	}
+	if traced.TransferOrderID == nil || *traced.TransferOrderID != order.ID || traced.TransferredFrom != warehouse.Title {
+		t.Errorf("site lot came from transfer %v of %q, want %d of %q", traced.TransferOrderID, traced.TransferredFrom, order.ID, warehouse.Title)
+	}
+	if len(traced.Withdrawals) != 1 || traced.Withdrawals[0].WithdrawalSlug != "Bill 001/0001" || traced.Withdrawals[0].Quantity != 400 {
+		t.Errorf("got site lot withdrawals %+v, want Bill 001/0001 of 400", traced.Withdrawals)
+	}
+	if len(traced.ProjectStores) != 1 || traced.ProjectStores[0].ProjectStoreSlug != store.Slug || traced.ProjectStores[0].AvailableQty != 400 {
+		t.Errorf("got site lot project stores %+v, want 400 in %s", traced.ProjectStores, store.Slug)
+	}
+	if len(report.Projects) != 1 || report.Projects[0].Slug != project.Slug {
+		t.Errorf("got projects %+v, want %s", report.Projects, project.Slug)
+	}
+
+	// the withdrawal traces back to the receipt
+	var back struct {
+		Trace models.TraceReport `json:"trace"`
+	}
+	if code := s.do(http.MethodGet, "/trace/withdrawals/Bill%20001/0001", accessToken, nil, &back); code != http.StatusOK {
+		t.Fatalf("backward trace answered %d", code)
+	}
+	if len(back.Trace.Lots) != 1 || back.Trace.Lots[0].InventoryMaterialID != siteLot.ID || back.Trace.Lots[0].ReceiptSlug != receipt.Slug {
+		t.Errorf("got backward lots %+v, want the site lot of %s", back.Trace.Lots, receipt.Slug)
+	}
+}
@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// lotsIn returns the lots of a material in an inventory, oldest first.
+func (s *testServer) lotsIn(inventoryID, materialID uint) []models.InventoryMaterial {
+	s.t.Helper()
+	var lots []models.InventoryMaterial
+	s.DB.Where("inventory_id = ? AND material_id = ?", inventoryID, materialID).Order("id asc").Find(&lots)
+	return lots
+}
+
+func TestTransferOrderShipAndReceive(t *testing.T) {
+	cases := []struct {
+		resolution string
+		source     int64 // quantity of the source lot after closing
+	}{
+		{models.TransferDiscrepancy_Lost, 400},
+		{models.TransferDiscrepancy_Return, 800},
+	}
+	for _, tc := range cases {
+		t.Run(tc.resolution, func(t *testing.T) {
+			s := newTestServer(t)
+			_, accessToken := s.signIn(models.ROLE_Admin)
+			from, material, lot := s.stock(10)
+			to := models.Inventory{Slug: "INV-TO", Title: "Site"}
+			s.create(&to)
+
+			var order models.TransferOrder
+			body := map[string]interface{}{
+				"FromInventoryID": from.ID,
+				"ToInventoryID":   to.ID,
+				"Lines":           []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 600}},
+			}
+			if code := s.do(http.MethodPost, "/transfer-orders", accessToken, body, &order); code != http.StatusCreated {
+				t.Fatalf("create answered %d", code)
+			}
+			if order.Slug == "" || order.Status != models.TransferOrderStatus_Pending {
+				t.Errorf("order %q is %s, want a pending order with a slug", order.Slug, order.Status)
+			}
+			path := func(action string) string { return fmt.Sprintf("/transfer-orders/%s/%d", action, order.ID) }
+			if code := s.do(http.MethodPut, path("ship"), accessToken, nil, nil); code != http.StatusBadRequest {
+				t.Errorf("ship before approval answered %d", code)
+			}
+			if code := s.do(http.MethodPut, path("approve"), accessToken, nil, nil); code != http.StatusOK {
+				t.Fatalf("approve answered %d", code)
+			}
+			if code := s.do(http.MethodPut, path("ship"), accessToken, nil, &order); code != http.StatusOK {
+				t.Fatalf("ship answered %d", code)
+			}
+			s.DB.First(&lot, lot.ID)
+			if lot.Quantity != 400 || lot.AvailableQty != 400 {
+				t.Errorf("source lot quantity %d available %d after shipping, want 400", lot.Quantity, lot.AvailableQty)
+			}
+			if got := s.lotsIn(to.ID, material.ID); len(got) != 0 {
+				t.Errorf("receiving inventory has %d lots before receipt", len(got))
+			}
+
+			line := order.Lines[0]
+			receive := func(quantity int64) int {
+				body := map[string]interface{}{"Lines": []map[string]interface{}{{"LineID": line.ID, "Quantity": quantity}}}
+				return s.do(http.MethodPut, path("receive"), accessToken, body, &order)
+			}
+			if code := receive(700); code != http.StatusBadRequest {
+				t.Errorf("receipt of more than shipped answered %d", code)
+			}
+			if code := receive(200); code != http.StatusOK {
+				t.Fatalf("receive answered %d", code)
+			}
+			if order.Status != models.TransferOrderStatus_PartiallyReceived {
+				t.Errorf("order is %s after a partial receipt", order.Status)
+			}
+			if got := s.lotsIn(to.ID, material.ID); len(got) != 1 || got[0].AvailableQty != 200 {
+				t.Errorf("got receiving lots %+v, want one of 200", got)
+			}
+
+			closeOrder := func(body map[string]string) int {
+				return s.do(http.MethodPut, path("close"), accessToken, body, &order)
+			}
+			if code := closeOrder(map[string]string{"Resolution": tc.resolution}); code != http.StatusBadRequest {
+				t.Errorf("close without a reason answered %d", code)
+			}
+			if code := closeOrder(map[string]string{"Resolution": tc.resolution, "Reason": "pallet missing"}); code != http.StatusOK {
+				t.Fatalf("close answered %d", code)
+			}
+			if order.Status != models.TransferOrderStatus_Closed || order.Lines[0].DiscrepancyQty != 400 {
+				t.Errorf("order is %s with a discrepancy of %d, want closed with 400", order.Status, order.Lines[0].DiscrepancyQty)
+			}
+			s.DB.First(&lot, lot.ID)
+			if lot.Quantity != tc.source || lot.AvailableQty != tc.source {
+				t.Errorf("source lot quantity %d available %d after closing, want %d", lot.Quantity, lot.AvailableQty, tc.source)
+			}
+			var transit models.Inventory
+			s.DB.Where("slug = ?", models.InTransitInventorySlug).First(&transit)
+			for _, l := range s.lotsIn(transit.ID, material.ID) {
+				if l.Quantity != 0 {
+					t.Errorf("in-transit lot %d keeps %d", l.ID, l.Quantity)
+				}
+			}
+		})
+	}
+}
+
+func TestDirectTransferMovesEveryLineOrNone(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	from, bolt, boltLot := s.stock(10)
+	_, nut, _ := s.stock(0)
+	nutLot := models.InventoryMaterial{InventoryID: from.ID, MaterialID: nut.ID, Quantity: 500, AvailableQty: 500, Price: 100}
+	s.create(&nutLot)
+	to := models.Inventory{Slug: "INV-TO", Title: "Site"}
+	s.create(&to)
+
+	create := func(nutQty int64) (models.TransferOrder, int) {
+		var order models.TransferOrder
+		body := map[string]interface{}{
+			"FromInventoryID": from.ID,
+			"ToInventoryID":   to.ID,
+			"IsDirect":        true,
+			"Lines": []map[string]interface{}{
+				{"MaterialID": bolt.ID, "Quantity": 400},
+				{"MaterialID": nut.ID, "Quantity": nutQty},
+			},
+		}
+		return order, s.do(http.MethodPost, "/transfer-orders", accessToken, body, &order)
+	}
+	if _, code := create(600); code != http.StatusBadRequest {
+		t.Errorf("create with a short line answered %d", code)
+	}
+
+	// the nuts are used elsewhere between the order and its approval
+	order, code := create(300)
+	if code != http.StatusCreated {
+		t.Fatalf("create answered %d", code)
+	}
+	approve := func() int {
+		return s.do(http.MethodPut, fmt.Sprintf("/transfer-orders/approve/%d", order.ID), accessToken, nil, &order)
+	}
+	s.DB.Model(&nutLot).Updates(map[string]interface{}{"reserve": 300, "available_qty": 200})
+	if code := approve(); code != http.StatusBadRequest {
+		t.Fatalf("approve of a short order answered %d", code)
+	}
+	s.DB.First(&boltLot, boltLot.ID)
+	if boltLot.AvailableQty != 1000 {
+		t.Errorf("bolts were moved without the nuts, %d left", boltLot.AvailableQty)
+	}
+
+	s.DB.Model(&nutLot).Updates(map[string]interface{}{"reserve": 0, "available_qty": 500})
+	if code := approve(); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+	if order.Status != models.TransferOrderStatus_Received {
+		t.Errorf("direct order is %s after approval", order.Status)
+	}
+	for _, tc := range []struct {
+		material models.Material
+		want     int64
+	}{{bolt, 400}, {nut, 300}} {
+		if got := s.lotsIn(to.ID, tc.material.ID); len(got) != 1 || got[0].AvailableQty != tc.want {
+			t.Errorf("got lots %+v of %s, want one of %d", got, tc.material.Slug, tc.want)
+		}
+	}
+}
+
+func TestInventoryTransferOpensADirectOrder(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	from, material, lot := s.stock(10)
+	to := models.Inventory{Slug: "INV-TO", Title: "Site"}
+	s.create(&to)
+
+	var created struct{ TransferOrder models.TransferOrder }
+	body := map[string]interface{}{"fromInventoryID": from.ID, "toInventoryID": to.ID, "materialID": material.ID, "quantity": 300}
+	if code := s.do(http.MethodPost, "/inventories/transfer", accessToken, body, &created); code != http.StatusCreated {
+		t.Fatalf("transfer answered %d", code)
+	}
+	order := created.TransferOrder
+	if !order.IsDirect || order.Status != models.TransferOrderStatus_Pending {
+		t.Errorf("transfer opened an order that is direct %v and %s", order.IsDirect, order.Status)
+	}
+	s.DB.First(&lot, lot.ID)
+	if lot.AvailableQty != 1000 {
+		t.Errorf("stock moved before approval, %d left", lot.AvailableQty)
+	}
+	if code := s.do(http.MethodPut, fmt.Sprintf("/transfer-orders/approve/%d", order.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	if got := s.lotsIn(to.ID, material.ID); lot.AvailableQty != 700 || len(got) != 1 || got[0].AvailableQty != 300 {
+		t.Errorf("source keeps %d and receiving lots are %+v, want 700 and one of 300", lot.AvailableQty, got)
+	}
+}
+
+func TestBulkTransferLeavesReservedStock(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	from, bolt, boltLot := s.stock(10)
+	s.DB.Model(&boltLot).Updates(map[string]interface{}{"reserve": 400, "available_qty": 600})
+	_, nut, _ := s.stock(0)
+	s.create(&models.InventoryMaterial{InventoryID: from.ID, MaterialID: nut.ID, Quantity: 500, AvailableQty: 500, Price: 100})
+	to := models.Inventory{Slug: "INV-TO", Title: "Site"}
+	s.create(&to)
+
+	var order models.TransferOrder
+	body := map[string]interface{}{"FromInventoryID": from.ID, "ToInventoryID": to.ID, "CategoryID": bolt.CategoryID}
+	if code := s.do(http.MethodPost, "/transfer-orders/bulk", accessToken, body, &order); code != http.StatusCreated {
+		t.Fatalf("bulk answered %d", code)
+	}
+	if len(order.Lines) != 1 || order.Lines[0].MaterialID != bolt.ID || order.Lines[0].Quantity != 600 {
+		t.Errorf("got lines %+v, want the 600 unreserved bolts", order.Lines)
+	}
+
+	body = map[string]interface{}{"FromInventoryID": from.ID, "ToInventoryID": to.ID}
+	if code := s.do(http.MethodPost, "/transfer-orders/bulk", accessToken, body, &order); code != http.StatusCreated {
+		t.Fatalf("bulk of the whole inventory answered %d", code)
+	}
+	if len(order.Lines) != 2 {
+		t.Errorf("got %d lines, want one per material", len(order.Lines))
+	}
+}
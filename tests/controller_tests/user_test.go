@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResetPasswordKeepsProfile(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Admin)
+	user, _ := s.signIn(models.ROLE_Tech)
+
+	body := map[string]interface{}{
+		"ID":       user.ID,
+		"Username": user.Username,
+		"Password": "Reset-Passw0rd!",
+		"Role":     models.ROLE_Admin,
+		"FullName": "",
+	}
+	if code := s.do(http.MethodPut, fmt.Sprintf("/users/reset/%d", user.ID), accessToken, body, nil); code != http.StatusOK {
+		t.Fatalf("reset answered %d", code)
+	}
+
+	var reset models.User
+	if err := s.DB.First(&reset, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reset.Role != user.Role || reset.FullName != user.FullName || reset.Slug != user.Slug {
+		t.Errorf("reset changed the profile to %q %q %q", reset.Role, reset.FullName, reset.Slug)
+	}
+	if !reset.MustChangePassword || reset.Password == "" || reset.Password == "Reset-Passw0rd!" {
+		t.Errorf("reset did not store a hashed password to change")
+	}
+
+	var active int64
+	s.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&active)
+	if active != 0 {
+		t.Errorf("reset left %d sessions active", active)
+	}
+}
+
+func TestGetUserHidesPassword(t *testing.T) {
+	s := newTestServer(t)
+	_, accessToken := s.signIn(models.ROLE_Manager)
+	user, _ := s.signIn(models.ROLE_Tech)
+	s.DB.Model(&user).Update("password", "$2a$10$hash")
+
+	var got map[string]interface{}
+	if code := s.do(http.MethodGet, fmt.Sprintf("/users/%d", user.ID), accessToken, nil, &got); code != http.StatusOK {
+		t.Fatalf("get answered %d", code)
+	}
+	if _, ok := got["Password"]; ok {
+		t.Errorf("user response carries the password: %v", got)
+	}
+	if got["Username"] != user.Username {
+		t.Errorf("got user %v, want %s", got["Username"], user.Username)
+	}
+}
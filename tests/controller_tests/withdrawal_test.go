@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"daijai/middlewares"
+	"daijai/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestWithdrawalWritesStayInProjectScope(t *testing.T) {
+	s := newTestServer(t)
+	admin, adminToken := s.signIn(models.ROLE_Admin)
+
+	// a project scoped role allowed every withdrawal write
+	var permissions []middlewares.Permission
+	for _, action := range []string{middlewares.Action_Read, middlewares.Action_Create, middlewares.Action_Update, middlewares.Action_Approve, middlewares.Action_Delete, middlewares.Action_Reverse} {
+		permissions = append(permissions, middlewares.Permission{Resource: middlewares.Resource_Withdrawals, Action: action})
+	}
+	permissions = append(permissions, middlewares.Permission{Resource: middlewares.Resource_AdminWithdrawals, Action: middlewares.Action_Create})
+	role := map[string]interface{}{"Name": "site-lead", "IsProjectScoped": true, "Permissions": permissions}
+	if code := s.do(http.MethodPost, "/roles", adminToken, role, nil); code != http.StatusCreated {
+		t.Fatalf("create role answered %d", code)
+	}
+	lead, leadToken := s.signIn("site-lead")
+
+	own := models.Project{Slug: "PJ-OWN", Title: "Own"}
+	s.create(&own)
+	other := models.Project{Slug: "PJ-OTHER", Title: "Other"}
+	s.create(&other)
+	s.create(&models.ProjectMember{ProjectID: own.ID, UserID: lead.ID})
+	order := models.Order{Slug: "ORD-OTHER", ProjectID: other.ID, CreatedByID: admin.ID}
+	s.create(&order)
+	withdrawal := models.Withdrawal{Slug: "WD-OTHER", ProjectID: other.ID, CreatedByID: admin.ID}
+	s.create(&withdrawal)
+	approvement := models.WithdrawalApprovement{WithdrawalID: withdrawal.ID, WithdrawalApprovementStatus: models.WithdrawalApprovementStatus_Pending}
+	s.create(&approvement)
+	mine := models.Withdrawal{Slug: "WD-OWN", ProjectID: own.ID, CreatedByID: lead.ID}
+	s.create(&mine)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"create for an order of another project", http.MethodPost, "/withdrawals", map[string]interface{}{"OrderID": order.ID, "ProjectID": own.ID}},
+		{"create in another project", http.MethodPost, "/withdrawals/admin/nonspec/withdraw", map[string]interface{}{"ProjectID": other.ID}},
+		{"admin create in another project", http.MethodPost, "/withdrawals/admin", map[string]interface{}{"ProjectID": other.ID}},
+		{"update", http.MethodPut, fmt.Sprintf("/withdrawals/%d", withdrawal.ID), map[string]interface{}{"Withdrawal": map[string]interface{}{"ProjectID": other.ID}}},
+		{"move into another project", http.MethodPut, fmt.Sprintf("/withdrawals/%d", mine.ID), map[string]interface{}{"Withdrawal": map[string]interface{}{"ProjectID": other.ID}}},
+		{"partial", http.MethodPost, "/withdrawals/partial", map[string]interface{}{"WithdrawalID": withdrawal.ID}},
+		{"approve", http.MethodPut, fmt.Sprintf("/withdrawals/approve/%d", approvement.ID), nil},
+		{"assign serials", http.MethodPut, fmt.Sprintf("/withdrawals/serials/%d", approvement.ID), map[string]interface{}{"MaterialID": 1}},
+		{"reverse", http.MethodPut, fmt.Sprintf("/withdrawals/reverse/%d", withdrawal.ID), map[string]interface{}{"Reason": "wrong project"}},
+		{"delete", http.MethodDelete, fmt.Sprintf("/withdrawals/%d", withdrawal.ID), nil},
+	}
+	for _, tc := range cases {
+		if code := s.do(tc.method, tc.path, leadToken, tc.body, nil); code != http.StatusNotFound {
+			t.Errorf("%s: answered %d, want %d", tc.name, code, http.StatusNotFound)
+		}
+	}
+
+	var count int64
+	s.DB.Model(&models.Withdrawal{}).Count(&count)
+	if count != 2 {
+		t.Errorf("got %d withdrawals, want the 2 of the setup", count)
+	}
+	s.DB.First(&mine, mine.ID)
+	if mine.ProjectID != own.ID {
+		t.Error("withdrawal was moved out of the project")
+	}
+
+	// the own project stays writable
+	body := map[string]interface{}{"Withdrawal": map[string]interface{}{"ProjectID": own.ID, "Notes": "checked"}}
+	if code := s.do(http.MethodPut, fmt.Sprintf("/withdrawals/%d", mine.ID), leadToken, body, nil); code != http.StatusCreated {
+		t.Errorf("update of an own withdrawal answered %d", code)
+	}
+}
+
+func TestReverseOrderWithdrawal(t *testing.T) {
+	s := newTestServer(t)
+	admin, accessToken := s.signIn(models.ROLE_Admin)
+	_, material, lot := s.stock(10)
+	project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+	s.create(&project)
+	store := models.ProjectStore{Slug: "PS-T1", Title: "Site", ProjectID: project.ID}
+	s.create(&store)
+
+	// the planner reserved four units of the lot for the order
+	order := models.Order{Slug: "ORD-T1", ProjectID: project.ID, CreatedByID: admin.ID}
+	s.create(&order)
+	bom := models.OrderBOM{OrderID: order.ID, MaterialID: material.ID, TargetQty: 400, ReservedQty: 400, IsFullFilled: true}
+	s.create(&bom)
+	reserving := models.OrderReserving{OrderID: order.ID, OrderBOMID: bom.ID, InventoryMaterialID: lot.ID, Status: models.OrderReservingStatus_Reserved, Quantity: 400}
+	s.create(&reserving)
+	s.DB.Model(&lot).Updates(map[string]interface{}{"reserve": 400, "available_qty": 600})
+
+	var created struct{ Withdrawal models.Withdrawal }
+	body := map[string]interface{}{"Slug": "WD-T1", "OrderID": order.ID, "ProjectID": project.ID, "ProjectStoreID": store.ID}
+	if code := s.do(http.MethodPost, "/withdrawals", accessToken, body, &created); code != http.StatusCreated {
+		t.Fatalf("create answered %d", code)
+	}
+	withdrawal := created.Withdrawal
+	var approvement models.WithdrawalApprovement
+	s.DB.Where("withdrawal_id = ?", withdrawal.ID).First(&approvement)
+	s.scan(accessToken, models.ScanSessionType_Withdrawal, withdrawal.Slug)
+	if code := s.do(http.MethodPut, fmt.Sprintf("/withdrawals/approve/%d", approvement.ID), accessToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("approve answered %d", code)
+	}
+	s.DB.First(&lot, lot.ID)
+	if lot.Reserve != 0 || lot.Withdrawed != 400 {
+		t.Fatalf("lot reserve %d withdrawed %d after approval, want 0 and 400", lot.Reserve, lot.Withdrawed)
+	}
+
+	reverse := func() int {
+		return s.do(http.MethodPut, fmt.Sprintf("/withdrawals/reverse/%d", withdrawal.ID), accessToken, map[string]string{"Reason": "wrong order"}, nil)
+	}
+	if code := reverse(); code != http.StatusOK {
+		t.Fatalf("reverse answered %d", code)
+	}
+	if code := reverse(); code != http.StatusBadRequest {
+		t.Errorf("second reverse answered %d", code)
+	}
+
+	s.DB.First(&lot, lot.ID)
+	if lot.Reserve != 400 || lot.Withdrawed != 0 || lot.AvailableQty != 600 {
+		t.Errorf("lot reserve %d withdrawed %d available %d, want the reservation back", lot.Reserve, lot.Withdrawed, lot.AvailableQty)
+	}
+	s.DB.First(&bom, bom.ID)
+	if bom.ReservedQty != 400 || bom.WithdrawedQty != 0 {
+		t.Errorf("order BOM reserved %d withdrawed %d, want 400 and 0", bom.ReservedQty, bom.WithdrawedQty)
+	}
+	s.DB.First(&reserving, reserving.ID)
+	if reserving.Status != models.OrderReservingStatus_Reserved {
+		t.Errorf("reservation is %s", reserving.Status)
+	}
+	var storeMaterial models.ProjectStoreMaterial
+	s.DB.Where("withdrawal_id = ?", withdrawal.ID).First(&storeMaterial)
+	if storeMaterial.AvailableQty != 0 {
+		t.Errorf("project store keeps %d of the reversed withdrawal", storeMaterial.AvailableQty)
+	}
+	s.DB.First(&withdrawal, withdrawal.ID)
+	if withdrawal.WithdrawalStatus != models.WithdrawalStatus_Reversed {
+		t.Errorf("withdrawal is %s", withdrawal.WithdrawalStatus)
+	}
+}
+
+func TestReverseAdminWithdrawal(t *testing.T) {
+	cases := []struct {
+		name     string
+		consumed int64 // from the project store before the reversal
+		status   int
+		want     int64 // available in the lot at the end
+	}{
+		{"untouched", 0, http.StatusOK, 1000},
+		{"consumed in the project", 100, http.StatusBadRequest, 700},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			_, accessToken := s.signIn(models.ROLE_Admin)
+			_, material, lot := s.stock(10)
+			project := models.Project{Slug: "PJ-T1", Title: "Plant"}
+			s.create(&project)
+			store := models.ProjectStore{Slug: "PS-T1", Title: "Site", ProjectID: project.ID}
+			s.create(&store)
+
+			var created struct{ Withdrawal models.Withdrawal }
+			body := map[string]interface{}{
+				"Slug":              "WD-T1",
+				"ProjectID":         project.ID,
+				"ProjectStoreID":    store.ID,
+				"WithdrawMaterials": []map[string]interface{}{{"MaterialID": material.ID, "Quantity": 300}},
+			}
+			if code := s.do(http.MethodPost, "/withdrawals/admin", accessToken, body, &created); code != http.StatusCreated {
+				t.Fatalf("create answered %d", code)
+			}
+			s.DB.Model(&models.ProjectStoreMaterial{}).Where("withdrawal_id = ?", created.Withdrawal.ID).Update("consumed_qty", tc.consumed)
+
+			path := fmt.Sprintf("/withdrawals/reverse/%d", created.Withdrawal.ID)
+			if code := s.do(http.MethodPut, path, accessToken, map[string]string{"Reason": "not needed"}, nil); code != tc.status {
+				t.Fatalf("reverse answered %d, want %d", code, tc.status)
+			}
+			s.DB.First(&lot, lot.ID)
+			if lot.AvailableQty != tc.want || lot.Withdrawed != 1000-tc.want {
+				t.Errorf("lot available %d withdrawed %d, want %d available", lot.AvailableQty, lot.Withdrawed, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"daijai/identity"
+	"daijai/models"
+	"errors"
+	"testing"
+)
+
+func TestRoleForGroups(t *testing.T) {
+	cases := []struct {
+		name        string
+		mapping     string // IDP_GROUP_ROLES
+		defaultRole string // IDP_DEFAULT_ROLE
+		groups      []string
+		role        string
+		err         error
+	}{
+		{"group name", `{"daijai-admins":"admin"}`, "", []string{"daijai-admins"}, models.ROLE_Admin, nil},
+		{"names ignore case", `{"Daijai-Admins":"admin"}`, "", []string{"DAIJAI-ADMINS"}, models.ROLE_Admin, nil},
+		{"full DN", `{"cn=stock,ou=groups,dc=example,dc=com":"technician"}`, "", []string{"cn=stock,ou=groups,dc=example,dc=com"}, models.ROLE_Tech, nil},
+		{"first value of a DN", `{"stock":"technician"}`, "", []string{"cn=stock,ou=groups,dc=example,dc=com"}, models.ROLE_Tech, nil},
+		{"highest system role wins", `{"stock":"technician","leads":"manager","staff":"user"}`, "", []string{"staff", "stock", "leads"}, models.ROLE_Manager, nil},
+		{"system roles rank above custom roles", `{"audit":"auditor","staff":"user"}`, "", []string{"audit", "staff"}, models.ROLE_User, nil},
+		{"no match takes the default role", `{"daijai-admins":"admin"}`, "user", []string{"others"}, models.ROLE_User, nil},
+		{"no mapping takes the default role", "", "user", []string{"others"}, models.ROLE_User, nil},
+		{"no match without a default role", `{"daijai-admins":"admin"}`, "", []string{"others"}, "", identity.ErrNoRole},
+		{"no groups without a default role", `{"daijai-admins":"admin"}`, "", nil, "", identity.ErrNoRole},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("IDP_GROUP_ROLES", tc.mapping)
+			t.Setenv("IDP_DEFAULT_ROLE", tc.defaultRole)
+			role, err := identity.RoleForGroups(tc.groups)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if role != tc.role {
+				t.Errorf("got role %q, want %q", role, tc.role)
+			}
+		})
+	}
+}
+
+func TestRoleForGroupsRefusesAnInvalidMapping(t *testing.T) {
+	for _, mapping := range []string{`{"daijai-admins":`, `["admin"]`, `{"daijai-admins":1}`} {
+		t.Setenv("IDP_GROUP_ROLES", mapping)
+		t.Setenv("IDP_DEFAULT_ROLE", "user")
+		if role, err := identity.RoleForGroups([]string{"daijai-admins"}); err == nil {
+			t.Errorf("mapping %s gave role %q, want an error", mapping, role)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"daijai/middlewares"
+	"daijai/models"
+	"daijai/server"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func setupRouter(t *testing.T) *gin.Engine {
+	// the image controller builds a storage client, the emulator host spares it credentials
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Setenv("STORAGE_EMULATOR_HOST", "localhost:0")
+	}
+	gin.SetMode(gin.TestMode)
+	return server.SetupRouter(nil)
+}
+
+// every registered route needs an access policy, and every policy a route
+func TestEveryRouteHasPolicy(t *testing.T) {
+	router := setupRouter(t)
+	registered := make(map[string]bool)
+	for _, r := range router.Routes() {
+		registered[r.Method+" "+r.Path] = true
+		p, ok := middlewares.RoutePolicy(r.Method, r.Path)
+		if !ok {
+			t.Errorf("route %s %s has no policy", r.Method, r.Path)
+			continue
+		}
+		if p.Resource != middlewares.Resource_Public && len(middlewares.PermissionMatrix[p]) == 0 {
+			t.Errorf("route %s %s needs %s/%s, no role has it", r.Method, r.Path, p.Resource, p.Action)
+		}
+	}
+	for route := range middlewares.RoutePolicies {
+		if !registered[route] {
+			t.Errorf("policy %s has no route", route)
+		}
+	}
+}
+
+func TestRouteAuthorization(t *testing.T) {
+	t.Setenv("SECRET", "test-secret")
+	router := setupRouter(t)
+
+	signed := func(role string) string {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uid": 1, "role": role}).SignedString([]byte("test-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"missing token", http.MethodDelete, "/materials/1", "", http.StatusUnauthorized},
+		{"invalid token", http.MethodDelete, "/materials/1", "not-a-token", http.StatusUnauthorized},
+		{"insufficient role", http.MethodDelete, "/materials/1", signed(models.ROLE_User), http.StatusForbidden},
+		{"user creation", http.MethodPost, "/users", signed(models.ROLE_Manager), http.StatusForbidden},
+		{"public route", http.MethodGet, "/health/ping", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: %s %s answered %d, want %d", tc.name, tc.method, tc.path, w.Code, tc.status)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package token
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"daijai/models"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
@@ -13,18 +17,76 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateToken(user models.User) (string, error) {
+// GenerateToken issues a short-lived access token of a session.
+func GenerateToken(user models.User, sessionID uint) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"uid":      user.ID,
+		"sid":      sessionID,
 		"username": user.Username,
 		"role":     user.Role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
+		"mcp":      user.MustChangePassword,
+		"exp":      time.Now().Add(AccessTokenTTL()).Unix(),
 	})
 
 	return token.SignedString([]byte(os.Getenv("SECRET")))
 
 }
 
+// AccessTokenTTL is the lifetime of access tokens, ACCESS_TOKEN_TTL as a duration, 15m by default.
+func AccessTokenTTL() time.Duration {
+	return envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// RefreshTokenTTL is the lifetime of sessions, REFRESH_TOKEN_TTL as a duration, 30 days by default.
+func RefreshTokenTTL() time.Duration {
+	return envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using %s", key, v, fallback)
+	}
+	return fallback
+}
+
+// NewRefreshToken returns a random refresh token and the hash to store of it.
+func NewRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(b)
+	return plain, HashRefreshToken(plain), nil
+}
+
+// HashRefreshToken returns the stored form of a refresh token.
+func HashRefreshToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyPrefix starts every API key, bearer tokens with it are API keys rather than JWTs.
+const APIKeyPrefix = "dj_"
+
+// NewAPIKey returns a random API key, its displayable prefix and the hash to store of it.
+func NewAPIKey() (string, string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	plain := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return plain, plain[:len(APIKeyPrefix)+8], HashAPIKey(plain), nil
+}
+
+// HashAPIKey returns the stored form of an API key.
+func HashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
 func TokenValid(c *gin.Context) error {
 	var tokenString string
 	if err := ExtractToken(c, &tokenString); err != nil {
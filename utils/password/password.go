@@ -0,0 +1,76 @@
+// package for the password policy and account lockout settings
+package password
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Validate checks a new password against the policy: at least PASSWORD_MIN_LENGTH characters
+// (10 by default), three of lower case, upper case, digits and symbols, and not the username.
+func Validate(password, username string) error {
+	minLength := envInt("PASSWORD_MIN_LENGTH", 10)
+	if len([]rune(password)) < minLength {
+		return fmt.Errorf("password must be at least %d characters", minLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < 3 {
+		return errors.New("password must mix at least three of lower case, upper case, digits and symbols")
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+	return nil
+}
+
+// MaxFailedLogins is how many wrong passwords in a row lock an account, MAX_FAILED_LOGINS, 5 by default.
+func MaxFailedLogins() int {
+	return envInt("MAX_FAILED_LOGINS", 5)
+}
+
+// LockoutDuration is how long a locked account stays locked, LOCKOUT_DURATION, 15m by default.
+func LockoutDuration() time.Duration {
+	if v := os.Getenv("LOCKOUT_DURATION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid LOCKOUT_DURATION %q", v)
+	}
+	return 15 * time.Minute
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q", key, v)
+	}
+	return fallback
+}